@@ -0,0 +1,96 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // Register pprof handlers to http.DefaultServeMux.
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-truncate/truncate"
+)
+
+// debugShutdownTimeout is the time to wait for requests in flight when the debug server is stopped.
+const debugShutdownTimeout = 5 * time.Second
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// debugHandler returns the handler of pprof, expvar, in-flight statements and control endpoints of the run.
+func debugHandler(controller *truncate.Controller) http.Handler {
+	mux := http.NewServeMux()
+	// pprof and expvar register their handlers to http.DefaultServeMux.
+	mux.Handle("/debug/", http.DefaultServeMux)
+	mux.HandleFunc("/debug/statements", func(w http.ResponseWriter, r *http.Request) {
+		truncate.DumpInflightStatements(w)
+	})
+	mux.HandleFunc("/control/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/control/") {
+		case "pause":
+			controller.Pause()
+		case "resume":
+			controller.Resume()
+		case "abort":
+			controller.Abort()
+		case "status":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s\n", controller.State())
+	})
+	return mux
+}
+
+// startDebugServer starts serving the debug endpoints of the run on the given address.
+// It returns a function to stop the server, which is called when the run ends so that the address is released.
+func startDebugServer(addr string, controller *truncate.Controller) (func(), error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	server := &http.Server{Handler: debugHandler(controller)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := server.Serve(lis); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "WARNING: debug server stopped: %v\n", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), debugShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+		<-done
+	}, nil
+}
@@ -0,0 +1,94 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudspannerecosystem/spanner-truncate/truncate"
+)
+
+func TestDebugHandler(t *testing.T) {
+	handler := debugHandler(truncate.NewController())
+	for _, tt := range []struct {
+		desc     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{desc: "Status", method: http.MethodPost, path: "/control/status", wantCode: http.StatusOK, wantBody: "running\n"},
+		{desc: "Pause", method: http.MethodPost, path: "/control/pause", wantCode: http.StatusOK, wantBody: "paused\n"},
+		{desc: "Resume", method: http.MethodPost, path: "/control/resume", wantCode: http.StatusOK, wantBody: "running\n"},
+		{desc: "Control by GET", method: http.MethodGet, path: "/control/abort", wantCode: http.StatusMethodNotAllowed},
+		{desc: "Unknown control", method: http.MethodPost, path: "/control/restart", wantCode: http.StatusNotFound},
+		{desc: "Abort", method: http.MethodPost, path: "/control/abort", wantCode: http.StatusOK, wantBody: "aborted\n"},
+		{desc: "Statements", method: http.MethodGet, path: "/debug/statements", wantCode: http.StatusOK},
+		{desc: "Expvar", method: http.MethodGet, path: "/debug/vars", wantCode: http.StatusOK, wantBody: `"goroutines"`},
+		{desc: "Pprof", method: http.MethodGet, path: "/debug/pprof/", wantCode: http.StatusOK, wantBody: "goroutine"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("%s %s returned %d, but want %d", tt.method, tt.path, w.Code, tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("%s %s returned %q, but want it to contain %q", tt.method, tt.path, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestStartDebugServer(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	stop, err := startDebugServer(addr, truncate.NewController())
+	if err != nil {
+		t.Fatalf("startDebugServer() = %v", err)
+	}
+	if _, err := startDebugServer(addr, truncate.NewController()); err == nil {
+		t.Errorf("startDebugServer() on the address in use succeeded, but want an error")
+	}
+	resp, err := http.Post("http://"+addr+"/control/status", "", nil)
+	if err != nil {
+		t.Fatalf("failed to request the debug server: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "running\n" {
+		t.Errorf("status = %q, but want %q", body, "running\n")
+	}
+
+	// The address is released when the run ends.
+	stop()
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("the address is not released after the debug server is stopped: %v", err)
+	}
+	lis.Close()
+}
@@ -3,12 +3,18 @@ module github.com/cloudspannerecosystem/spanner-truncate
 go 1.14
 
 require (
-	cloud.google.com/go/spanner v1.10.0
-	github.com/google/go-cmp v0.5.2
+	cloud.google.com/go/spanner v1.25.0
+	github.com/BurntSushi/toml v1.2.1
+	github.com/golang/protobuf v1.5.2
+	github.com/google/go-cmp v0.5.6
 	github.com/gosuri/uilive v0.0.4 // indirect
 	github.com/gosuri/uiprogress v0.0.1
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/lib/pq v1.10.2
 	github.com/mattn/go-isatty v0.0.12 // indirect
-	google.golang.org/api v0.32.0 // indirect
-	google.golang.org/genproto v0.0.0-20200904004341-0bd0a958aa1d
+	golang.org/x/text v0.3.6
+	google.golang.org/api v0.54.0
+	google.golang.org/genproto v0.0.0-20210821163610-241b8fcbd6c8
+	google.golang.org/grpc v1.40.0
+	gopkg.in/yaml.v3 v3.0.1
 )
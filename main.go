@@ -18,8 +18,11 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"strings"
@@ -27,49 +30,804 @@ import (
 
 	"github.com/cloudspannerecosystem/spanner-truncate/truncate"
 	"github.com/jessevdk/go-flags"
+	"google.golang.org/api/option"
 )
 
 type options struct {
-	ProjectID     string `short:"p" long:"project" env:"SPANNER_PROJECT_ID" description:"(required) GCP Project ID."`
-	InstanceID    string `short:"i" long:"instance" env:"SPANNER_INSTANCE_ID" description:"(required) Cloud Spanner Instance ID."`
-	DatabaseID    string `short:"d" long:"database" env:"SPANNER_DATABASE_ID" description:"(required) Cloud Spanner Database ID."`
-	Quiet         bool   `short:"q" long:"quiet" description:"Disable all interactive prompts."`
-	Tables        string `short:"t" long:"tables" description:"Comma separated table names to be truncated. Default to truncate all tables if not specified."`
-	ExcludeTables string `short:"e" long:"exclude-tables" description:"Comma separated table names to be exempted from truncating. 'tables' and 'exclude-tables' cannot co-exist"`
+	ProjectID      string   `short:"p" long:"project" env:"SPANNER_PROJECT_ID" description:"(required) GCP Project ID."`
+	InstanceID     string   `short:"i" long:"instance" env:"SPANNER_INSTANCE_ID" description:"(required) Cloud Spanner Instance ID."`
+	DatabaseID     string   `short:"d" long:"database" env:"SPANNER_DATABASE_ID" description:"(required) Cloud Spanner Database ID."`
+	Databases      string   `long:"databases" description:"Comma-separated IDs of databases in the instance truncated in turn instead of -d, e.g. dev,staging. The schema is fetched once and shared by databases with identical DDL."`
+	Quiet          bool     `short:"q" long:"quiet" description:"Disable all interactive prompts."`
+	Yes            bool     `short:"y" long:"yes" description:"Delete without asking for confirmation, for non-interactive automation. Other prompts, e.g. for a reason, are still shown unless --quiet."`
+	Force          bool     `long:"force" description:"Alias of --yes."`
+	DryRun         bool     `long:"dry-run" description:"Show which tables would be deleted in which order and with which strategy, without executing any DML."`
+	Output         string   `long:"output" choice:"text" choice:"json" default:"text" description:"Format of the output. json writes the computed plan (tables, parents, foreign keys, deletion order and strategies) to stdout without deleting anything, and other messages to stderr."`
+	Tables         string   `short:"t" long:"tables" description:"Comma separated table names or glob patterns, e.g. Events_*, to be truncated. Default to truncate all tables if not specified. '-' reads table names from stdin, one per line."`
+	ExcludeTables  string   `short:"e" long:"exclude-tables" description:"Comma separated table names or glob patterns to be exempted from truncating. 'tables' and 'exclude-tables' cannot co-exist"`
+	OnlyTags       []string `long:"only-tag" description:"Truncate only tables tagged with the tag in the config. Can be specified multiple times."`
+	SkipTags       []string `long:"skip-tag" description:"Exempt tables tagged with the tag in the config from truncating. Can be specified multiple times."`
+	ExcludeRegex   []string `long:"exclude-regex" description:"Always exempt tables whose names match the regular expression from truncating, even if they are selected otherwise. Can be specified multiple times."`
+	IncludeSchemas []string `long:"include-schema" description:"Truncate only tables in the named schema. Can be specified multiple times."`
+	ExcludeSchemas []string `long:"exclude-schema" description:"Exempt tables in the named schema from truncating. Can be specified multiple times."`
+	Namespace      string   `long:"namespace" description:"Truncate only tables prefixed with the namespace followed by an underscore, e.g. pr123_Users of pr123, for environments sharing a database."`
+	StripNamespace bool     `long:"strip-namespace" description:"Remove the prefix of --namespace from table names in reports."`
+	DropNamespace  bool     `long:"drop-namespace" description:"Drop all tables of --namespace and their indexes by DDL after they are emptied, e.g. to tear down a preview environment."`
+	Largest        int      `long:"largest" description:"Truncate only the N largest tables by size (or by rows if sizes are not available) of the selected tables."`
+	MinRows        int64    `long:"min-rows" description:"Skip the selected tables with fewer rows than this threshold, reported as below threshold."`
+	FailIfEmpty    bool     `long:"fail-if-empty" description:"Exit with a non-zero status instead of 0 if all selected tables are already empty, e.g. when emptiness means a wrong database."`
+	NormalizeNames bool     `long:"normalize-names" description:"Match table names in Unicode NFC, so that names typed in a different normalization form match."`
+	Config         string   `short:"c" long:"config" description:"Path to a configuration file in JSON, YAML (.yaml, .yml) or TOML (.toml)."`
+
+	TablesFile        string `long:"tables-file" description:"Path to a file of table names to be truncated, one per line, added to --tables. '-' reads stdin."`
+	ExcludeTablesFile string `long:"exclude-tables-file" description:"Path to a file of table names to be exempted from truncating, one per line, added to --exclude-tables. '-' reads stdin."`
+
+	Pick bool `long:"pick" description:"Pick the tables to be truncated interactively from the selected tables, listed with their sizes."`
+
+	OlderThan       string `long:"older-than" description:"Delete only rows older than the age, e.g. 30d, 2w or 12h, by --timestamp-column. Tables without the column are exempted."`
+	ProtectRecent   string `long:"protect-recent" description:"Keep rows written within the window, e.g. 1h, by --timestamp-column or timestampColumn of tables in the config. Tables without the column are exempted."`
+	KeepLatest      int64  `long:"keep-latest" description:"Keep the newest N rows of each table ordered by --timestamp-column or timestampColumn of tables in the config, and delete the older rows. Tables without the column are exempted."`
+	TimestampColumn string `long:"timestamp-column" description:"TIMESTAMP or commit timestamp column compared with --older-than and --protect-recent, or the column ordering rows of --keep-latest, e.g. CreatedAt."`
+
+	SamplePercent float64 `long:"sample-percent" description:"Delete only the percentage of rows of each table, e.g. 10 to thin out 10% of rows. Rows are chosen by the hash of their primary keys, together with their interleaved child rows."`
+
+	Params []string `long:"param" description:"Value of a parameter in the where conditions of tables in the config, e.g. cutoff=2024-01-01T00:00:00Z for @cutoff. Can be specified multiple times."`
+
+	AllDatabases     bool   `long:"all-databases" description:"Truncate all databases in the instance in turn, except --exclude-databases."`
+	ExcludeDatabases string `long:"exclude-databases" description:"Comma-separated glob patterns of databases excluded from --all-databases, e.g. prod*,staging."`
+
+	Emulator bool `long:"emulator" description:"Connect to the Cloud Spanner Emulator at SPANNER_EMULATOR_HOST, or localhost:9010 if not set, with plaintext, credential-less connections. Tables are deleted one by one unless --concurrency is set."`
+
+	CredentialsFile           string `long:"credentials-file" description:"JSON key of a service account, or another credentials file, used instead of Application Default Credentials."`
+	ImpersonateServiceAccount string `long:"impersonate-service-account" description:"Act as the service account with short-lived tokens issued for your credentials, which need roles/iam.serviceAccountTokenCreator on it."`
+	QuotaProject              string `long:"quota-project" description:"Project to which quota and billing of requests are attributed instead of the project of the credentials."`
+
+	PGAdapter string `long:"pgadapter" description:"Address (host:port) of PGAdapter to connect through the PostgreSQL wire protocol instead of the native client."`
+
+	CleanupSessions bool `long:"cleanup-sessions" description:"Delete idle sessions left behind by previous runs before truncating."`
+
+	LockTable string        `long:"lock-table" description:"Table holding the lock of the database, to prevent concurrent runs against the same database."`
+	LockTTL   time.Duration `long:"lock-ttl" default:"5m" description:"Duration after which the lock expires unless refreshed by the run holding it."`
+	StealLock bool          `long:"steal-lock" description:"Take over the lock even if another run holds it."`
+
+	HistoryTable string `long:"history-table" description:"Table into which runs are recorded with who ran them, when, the tables, rows and status, to be listed by the history command."`
+
+	PlanningTimeout     time.Duration `long:"planning-timeout" default:"5m" description:"Deadline for fetching the schema and planning deletion. 0 means no deadline."`
+	ExecutionTimeout    time.Duration `long:"execution-timeout" default:"24h" description:"Deadline for deleting rows from all tables. 0 means no deadline."`
+	VerificationTimeout time.Duration `long:"verification-timeout" default:"10m" description:"Deadline for verifying that rows have been deleted. 0 means no deadline."`
+	StallTimeout        time.Duration `long:"stall-timeout" description:"Warn if no chunk or table is completed for the duration, e.g. 10m, to detect stalls long before --execution-timeout. 0 disables the check."`
+	OnStall             string        `long:"on-stall" choice:"warn" choice:"dump" choice:"abort" default:"warn" description:"What to do on a stall detected by --stall-timeout: warn, also dump the statements in flight, or also abort the run."`
+	Verify              string        `long:"verify" choice:"full" choice:"sample" default:"full" description:"How to verify that rows have been deleted: count all remaining rows, or probe random key ranges for residual rows."`
+	VerifySampleSize    int           `long:"verify-sample-size" default:"10" description:"Number of key ranges probed in each table with --verify=sample."`
+	BoundedReads        bool          `long:"bounded-reads" description:"Verify deletions with bounded-staleness reads no older than the last commit to each table, which read-only replicas can serve."`
+	Fingerprint         bool          `long:"fingerprint" description:"Take fingerprints of primary keys of tables kept by the run before and after the run, to prove that they were untouched. The results are included in the JUnit report."`
+
+	Concurrency int    `long:"concurrency" description:"Maximum number of tables deleted concurrently. 0 means unlimited."`
+	Order       string `long:"order" choice:"dependencies-only" choice:"size" choice:"alpha" default:"dependencies-only" description:"Order of tables which can be deleted at the same time: largest interleave trees first, alphabetical, or dependencies only."`
+
+	Priority   string `long:"priority" choice:"low" choice:"medium" choice:"high" description:"RPC priority of all read and DML requests, e.g. low to avoid impacting production traffic on the same instance. Priorities of tables in the config override it for their deletes."`
+	RequestTag string `long:"request-tag" description:"Tag attached to all queries, deletes and transactions, to attribute them to the run in query and transaction statistics."`
+
+	Strategy           string `long:"strategy" choice:"pdml" choice:"batch" description:"How rows are deleted: a single Partitioned DML statement per table, or chunks of primary keys deleted with mutations. Default to batch if --mutations-per-second or --max-rows-per-sec is set, or pdml otherwise."`
+	MutationsPerSecond int    `long:"mutations-per-second" description:"Budget of mutations per second shared by all tables, counting secondary index entries. Rows are deleted in chunks by primary keys instead of Partitioned DML if set."`
+
+	MaxRowsPerSec int `long:"max-rows-per-sec" description:"Budget of deleted rows per second shared by all tables. Rows are deleted in chunks by primary keys instead of Partitioned DML if set."`
+
+	PlanCache string `long:"plan-cache" description:"Path of a file caching the schema queried from INFORMATION_SCHEMA, reused while the DDL of the database is unchanged, e.g. for repeated resets in CI."`
+
+	StateFile string `long:"state-file" description:"Path of a file recording the tables completed by the run, so that an interrupted run can be resumed with --resume. The record is removed when the run finishes successfully."`
+	Resume    bool   `long:"resume" description:"Skip the tables completed by the unfinished run recorded in --state-file."`
+
+	Replan bool `long:"replan" description:"Re-fetch the schema and re-plan the remaining tables when the schema changes during the run, e.g. a new interleaved table or foreign key."`
+
+	Window string `long:"window" description:"Daily time window in which deletions are started, e.g. \"22:00-05:00 Asia/Tokyo\". Deletions are not started outside the window."`
+
+	ExplainPlan bool `long:"explain-plan" description:"Explain why each table is included or excluded and how it is deleted, without deleting anything."`
+
+	ReportFormat string `long:"report-format" choice:"junit" choice:"csv" description:"Format of the report written after truncation."`
+	ReportFile   string `long:"report-file" description:"Path to write the report. Default to stdout."`
+
+	BigQueryResultsTable string `long:"bq-results-table" description:"BigQuery table (project.dataset.table or dataset.table) into which per-table results are streamed."`
+	PubSubTopic          string `long:"pubsub-topic" description:"Pub/Sub topic (projects/PROJECT/topics/TOPIC or TOPIC) to which lifecycle events are published."`
+
+	WritePlan       string `long:"write-plan" description:"Write the plan as JSON to the given path for review and approval, without deleting anything."`
+	RequireApproval string `long:"require-approval" description:"Path to an approved plan. Rows are deleted only if the actual plan matches it and it has a valid approval."`
+	Operator        string `long:"operator" description:"Who runs the truncation, recorded with the run. Default to the current user."`
+	Reason          string `long:"reason" description:"Change ticket or reason of the run, recorded with the run. Required for databases matching productionPatterns in the config."`
+
+	ProtectedLabels []string `long:"protected-label" description:"Label of protected instances in the form of key=value, e.g. env=prod, in addition to protectedLabels in the config. Runs against them fail unless --allow-protected. Can be specified multiple times."`
+	AllowProtected  bool     `long:"allow-protected" description:"Allow truncating instances carrying a protected label."`
+
+	AllowProtectedTables bool `long:"allow-protected-tables" description:"Allow deleting rows of tables matching protectedTables in the config, whose runs fail otherwise."`
+
+	Verbose    bool `short:"v" long:"verbose" description:"Print each statement once per table. Values of parameters are redacted unless --show-params."`
+	ShowParams bool `long:"show-params" description:"Show values of parameters of statements printed by --verbose."`
+
+	ThroughputGraph bool `long:"throughput-graph" description:"Graph rows/s and mutations/s of each table over time in the progress bars."`
+
+	Record string `long:"record" description:"Record the schema and results of statements into a fixture file at the path, for offline debugging. Values of rows are not recorded."`
+	Replay string `long:"replay" description:"Replay the fixture file at the path instead of connecting to the database."`
+
+	Failpoints []string `long:"failpoint" description:"Make deletions fail at the point, e.g. before-commit:table=Singers:chunk=3 or before-delete:action=panic, for testing. Can be specified multiple times. Also read from $SPANNER_TRUNCATE_FAILPOINTS separated by commas."`
+
+	Chaos         float64       `long:"chaos" hidden:"yes" description:"Probability of injecting ABORTED/UNAVAILABLE errors into statements, for testing."`
+	ChaosMaxDelay time.Duration `long:"chaos-max-delay" hidden:"yes" description:"Maximum delay injected before each statement, for testing."`
+	ChaosSeed     int64         `long:"chaos-seed" hidden:"yes" description:"Seed of injected failures and delays, for testing."`
+
+	CheckLeaks bool   `long:"check-leaks" description:"Fail the run if any goroutine spawned by the run did not exit when it returns, for debugging."`
+	DebugAddr  string `long:"debug-addr" description:"Address to serve pprof, expvar, in-flight statements and control endpoints for debugging, e.g. localhost:6060."`
+
+	ListTables struct{} `command:"list-tables" description:"List all tables with their approximate sizes, parents and the property graphs they back."`
+	Orphans    struct{} `command:"orphans" description:"Report rows whose referenced rows are missing, for unenforced foreign keys and references declared in the config."`
+	Impact     struct {
+		Args struct {
+			Table string `positional-arg-name:"table" required:"yes"`
+		} `positional-args:"yes"`
+	} `command:"impact" description:"Estimate how many rows in which tables would be affected if the given table were truncated, without deleting anything."`
+	History struct {
+		Since  string `long:"since" description:"List only runs started within the age, e.g. 30d or 12h."`
+		By     string `long:"by" description:"List only runs by the operator."`
+		Table  string `long:"table" description:"List only runs which deleted rows from the table."`
+		Status string `long:"status" choice:"running" choice:"succeeded" choice:"failed" description:"List only runs with the status."`
+		Limit  int    `long:"limit" default:"20" description:"Maximum number of runs listed, newest first. 0 means unlimited."`
+		Prune  struct {
+			OlderThan string `long:"older-than" required:"yes" description:"Delete runs started longer ago than the age, e.g. 90d."`
+		} `command:"prune" description:"Delete old runs from --history-table, so that the history does not grow without bound."`
+	} `command:"history" subcommands-optional:"yes" description:"List previous runs recorded in --history-table with who ran them, when, the tables, rows and status."`
+	Approve struct {
+		Approver   string `long:"approver" required:"yes" description:"Identity of the approver, e.g. an email address."`
+		Annotation string `long:"annotation" description:"Note added to the plan by the approver."`
+		Args       struct {
+			PlanFile string `positional-arg-name:"plan-file" required:"yes"`
+		} `positional-args:"yes"`
+	} `command:"approve" description:"Approve a plan written by --write-plan with the key in $SPANNER_TRUNCATE_APPROVAL_KEY."`
+	Restore struct {
+		Backup     string `long:"backup" required:"yes" description:"ID of the backup in the instance, or its full name."`
+		ToDatabase string `long:"to-database" required:"yes" description:"ID of the new database the backup is restored to."`
+		Wait       bool   `long:"wait" description:"Wait until the restore operation completes."`
+	} `command:"restore" description:"Restore a backup to a new database, to undo a bad truncation. Only -p and -i are required."`
+	RecreateDatabase struct {
+		From     string `long:"from" description:"ID of the source database in the instance, or its full name."`
+		FromDump string `long:"from-dump" description:"Path to a schema dump taken by 'schema dump --ddl', used instead of --from."`
+		To       string `long:"to" required:"yes" description:"ID of the new database created in the instance."`
+	} `command:"recreate-database" description:"Create a new empty database from the DDL of the source database, as an alternative to truncation. Only -p and -i are required."`
+	Schema struct {
+		Diff struct {
+			Args struct {
+				From string `positional-arg-name:"database-a" required:"yes"`
+				To   string `positional-arg-name:"database-b" required:"yes"`
+			} `positional-args:"yes"`
+		} `command:"diff" description:"Compare tables, interleaving, foreign keys and indexes of two databases and write the differences as JSON. Databases are IDs in the instance or full names. Exits with status 1 if they differ. Only -p and -i are required."`
+		Dump struct {
+			DDL bool `long:"ddl" description:"Include the raw DDL statements fetched by the Admin API."`
+		} `command:"dump" description:"Write the schema of the database as JSON, which can be replayed by --replay to plan runs offline."`
+	} `command:"schema" description:"Inspect schemas of databases."`
+	SupportBundle struct {
+		Args struct {
+			File string `positional-arg-name:"file" required:"yes"`
+		} `positional-args:"yes"`
+	} `command:"support-bundle" description:"Write a gzipped tarball with the redacted config, the plan, the schema, versions and diagnoses of the environment for bug reports, to the file. No rows are read."`
+	Doctor    struct{} `command:"doctor" description:"Check the platform, the build and the environment, e.g. proxy settings, for misconfigurations known to break the Cloud Spanner client. Exits with status 1 if any error is found. No options are required."`
+	ConfigCmd struct {
+		Validate struct {
+			Args struct {
+				File string `positional-arg-name:"config-file" required:"yes"`
+			} `positional-args:"yes"`
+		} `command:"validate" description:"Validate a configuration file against the JSON Schema and report problems with their lines and columns. Exits with status 1 if invalid."`
+		Schema struct{} `command:"schema" description:"Write the JSON Schema of configuration files, e.g. for editors."`
+	} `command:"config" description:"Validate configuration files without accessing databases."`
 }
 
-const maxTimeout = time.Hour * 24
+// approvalKeyEnv is the environment variable holding the key to sign and verify approvals of plans.
+const approvalKeyEnv = "SPANNER_TRUNCATE_APPROVAL_KEY"
+
+// failpointsEnv is the environment variable of failpoints separated by commas.
+const failpointsEnv = "SPANNER_TRUNCATE_FAILPOINTS"
 
 func main() {
 	var opts options
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	if _, err := parser.Parse(); err != nil {
 		exitf("Invalid options\n")
 	}
 
-	if opts.ProjectID == "" || opts.InstanceID == "" || opts.DatabaseID == "" {
+	// The config file is loaded first, since it may give the database and the tables in place of flags.
+	config := &truncate.Config{}
+	if opts.Config != "" {
+		c, err := truncate.LoadConfig(opts.Config)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		config = c
+		opts.applyConfig(config)
+	}
+
+	// The emulator is configured before any clients are created, so that subcommands connect to it as well.
+	if opts.Emulator && truncate.EmulatorHost() == "" {
+		if err := truncate.UseEmulator(truncate.DefaultEmulatorHost); err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+	}
+
+	// Credentials are passed to all clients, including those of subcommands.
+	var credentials []option.ClientOption
+	if opts.CredentialsFile != "" {
+		o, err := truncate.CredentialsFile(opts.CredentialsFile)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		credentials = append(credentials, o)
+	}
+	if opts.QuotaProject != "" {
+		credentials = append(credentials, option.WithQuotaProject(opts.QuotaProject))
+	}
+	if opts.ImpersonateServiceAccount != "" {
+		// Clients act as the impersonated service account, while the credentials file is used to impersonate it.
+		o, err := truncate.ImpersonateServiceAccount(context.Background(), opts.ImpersonateServiceAccount, nil, credentials...)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		credentials = []option.ClientOption{o}
+		if opts.QuotaProject != "" {
+			credentials = append(credentials, option.WithQuotaProject(opts.QuotaProject))
+		}
+	}
+	withCredentials := truncate.WithCredentials(credentials...)
+
+	// Approving a plan does not access the database.
+	if parser.Active != nil && parser.Active.Name == "approve" {
+		if err := approvePlan(opts.Approve.Args.PlanFile, opts.Approve.Approver, opts.Approve.Annotation); err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		return
+	}
+
+	// Diagnosing the environment does not access the database.
+	if parser.Active != nil && parser.Active.Name == "doctor" {
+		healthy, err := truncate.WriteDiagnoses(os.Stdout, truncate.DiagnoseEnvironment())
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		if !healthy {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Validating a config does not access the database.
+	if parser.Active != nil && parser.Active.Name == "config" {
+		if parser.Active.Active.Name == "schema" {
+			fmt.Print(truncate.ConfigSchema)
+			return
+		}
+		file := opts.ConfigCmd.Validate.Args.File
+		problems, err := truncate.ValidateConfigFile(file)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", file, p.Error())
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid.\n", file)
+		return
+	}
+
+	// Restoring a backup creates a new database, so -d is not required.
+	if parser.Active != nil && parser.Active.Name == "restore" {
+		if opts.ProjectID == "" || opts.InstanceID == "" {
+			exitf("Missing options: -p, -i are required.\n")
+		}
+		if err := truncate.RestoreDatabase(context.Background(), opts.ProjectID, opts.InstanceID, opts.Restore.Backup, opts.Restore.ToDatabase, opts.Restore.Wait, os.Stdout, withCredentials); err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		return
+	}
+
+	// Recreating a database creates a new database, so -d is not required.
+	if parser.Active != nil && parser.Active.Name == "recreate-database" {
+		if opts.ProjectID == "" || opts.InstanceID == "" {
+			exitf("Missing options: -p, -i are required.\n")
+		}
+		cmd := opts.RecreateDatabase
+		if (cmd.From == "") == (cmd.FromDump == "") {
+			exitf("Invalid options: exactly one of --from or --from-dump is required.\n")
+		}
+		var err error
+		if cmd.FromDump != "" {
+			var dump *truncate.Fixture
+			if dump, err = truncate.LoadFixture(cmd.FromDump); err == nil {
+				err = truncate.RecreateDatabaseFromDump(context.Background(), opts.ProjectID, opts.InstanceID, dump, cmd.To, os.Stdout, withCredentials)
+			}
+		} else {
+			err = truncate.RecreateDatabase(context.Background(), opts.ProjectID, opts.InstanceID, cmd.From, cmd.To, os.Stdout, withCredentials)
+		}
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		return
+	}
+
+	// Comparing schemas accesses the two given databases, so -d is not required.
+	if parser.Active != nil && parser.Active.Name == "schema" && parser.Active.Active.Name == "diff" {
+		if opts.ProjectID == "" || opts.InstanceID == "" {
+			exitf("Missing options: -p, -i are required.\n")
+		}
+		args := opts.Schema.Diff.Args
+		diff, err := truncate.DiffSchemas(context.Background(), opts.ProjectID, opts.InstanceID, args.From, args.To, withCredentials)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		if err := diff.Write(os.Stdout); err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		if !diff.Equal() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.ExcludeDatabases != "" && !opts.AllDatabases {
+		exitf("Invalid options: --exclude-databases can be used only with --all-databases.\n")
+	}
+
+	// Listing databases is interrupted as well as the run.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleInterrupt(cancel)
+
+	databaseIDs := opts.databaseIDs()
+	if opts.AllDatabases {
+		if opts.ProjectID == "" || opts.InstanceID == "" {
+			exitf("Missing options: -p, -i are required.\n")
+		}
+		if len(databaseIDs) > 0 {
+			exitf("Invalid options: --all-databases cannot be used with -d or --databases.\n")
+		}
+		ids, err := truncate.ListDatabases(ctx, opts.ProjectID, opts.InstanceID, splitList(opts.ExcludeDatabases), withCredentials)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		if len(ids) == 0 {
+			fmt.Printf("No databases to truncate in %s.\n", opts.InstanceID)
+			return
+		}
+		databaseIDs = ids
+	}
+	if opts.ProjectID == "" || opts.InstanceID == "" || len(databaseIDs) == 0 {
 		exitf("Missing options: -p, -i, -d are required.\n")
 	}
+	if len(databaseIDs) > 1 && (parser.Active != nil || opts.ExplainPlan || opts.WritePlan != "") {
+		exitf("Invalid options: multiple databases can be used only to truncate.\n")
+	}
+	opts.DatabaseID = databaseIDs[0]
+
+	go handleDumpSignal()
+	controller := truncate.NewController()
+	if opts.DebugAddr != "" {
+		if stop, err := startDebugServer(opts.DebugAddr, controller); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: debug server is not started: %v\n", err)
+		} else {
+			defer stop()
+		}
+	}
+
+	// A support bundle is written with all options of a run, to build the same plan.
+	if parser.Active != nil && parser.Active.Name != "support-bundle" {
+		cmdOpts := []truncate.Option{
+			withCredentials,
+			truncate.WithOutput(truncate.StdOutput()),
+			truncate.WithPGAdapter(opts.PGAdapter),
+			truncate.WithReplay(opts.Replay),
+		}
+		switch parser.Active.Name {
+		case "orphans":
+			if err := truncate.ReportOrphans(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, os.Stdout, config.References, cmdOpts...); err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+		case "impact":
+			if err := truncate.Impact(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, opts.Impact.Args.Table, cmdOpts...); err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+		case "schema":
+			// Warnings are written to stderr so that the dump written to stdout can be parsed.
+			cmdOpts = append(cmdOpts, truncate.WithOutput(truncate.Output{Writer: os.Stderr}))
+			dump, err := truncate.DumpSchema(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, opts.Schema.Dump.DDL, cmdOpts...)
+			if err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+			if err := dump.Write(os.Stdout); err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+		case "history":
+			if opts.HistoryTable == "" {
+				exitf("Missing options: --history-table is required.\n")
+			}
+			if parser.Active.Active != nil && parser.Active.Active.Name == "prune" {
+				age, err := truncate.ParseAge(opts.History.Prune.OlderThan)
+				if err != nil {
+					exitf("Invalid options: %s\n", err.Error())
+				}
+				cutoff := time.Now().Add(-age)
+				count, err := truncate.PruneHistory(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, opts.HistoryTable, cutoff, cmdOpts...)
+				if err != nil {
+					exitf("ERROR: %s", err.Error())
+				}
+				fmt.Printf("Pruned %d runs started before %s.\n", count, cutoff.Format(time.RFC3339))
+				return
+			}
+			filter := truncate.HistoryFilter{Operator: opts.History.By, Table: opts.History.Table, Status: opts.History.Status, Limit: opts.History.Limit}
+			if opts.History.Since != "" {
+				since, err := truncate.ParseAge(opts.History.Since)
+				if err != nil {
+					exitf("Invalid options: %s\n", err.Error())
+				}
+				filter.Since = time.Now().Add(-since)
+			}
+			if err := truncate.History(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, opts.HistoryTable, filter, cmdOpts...); err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+		case "list-tables":
+			if err := truncate.ListTables(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, cmdOpts...); err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+		}
+		return
+	}
 
+	if opts.CleanupSessions {
+		for _, databaseID := range databaseIDs {
+			if err := truncate.CleanupSessions(ctx, opts.ProjectID, opts.InstanceID, databaseID, os.Stdout, withCredentials, truncate.WithLock(opts.LockTable, opts.LockTTL, opts.StealLock)); err != nil {
+				exitf("ERROR: %s", err.Error())
+			}
+		}
+	}
+
+	output := truncate.StdOutput()
+	targetTables, excludeTables, err := tableLists(&opts, os.Stdin)
+	if err != nil {
+		exitf("%s\n", err.Error())
+	}
+
+	// Stdin has been consumed, so read answers of prompts from the terminal.
+	if (opts.TablesFile == "-" || opts.ExcludeTablesFile == "-") && !opts.Quiet {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			exitf("ERROR: --quiet is required when reading table names from stdin without a terminal\n")
+		}
+		defer tty.Close()
+		output.Input = tty
+	}
+
+	if opts.ReportFile != "" {
+		f, err := os.Create(opts.ReportFile)
+		if err != nil {
+			exitf("ERROR: failed to create report file: %s\n", err.Error())
+		}
+		defer f.Close()
+		output.Report = f
+	}
+
+	runOpts := []truncate.Option{
+		withCredentials,
+		truncate.WithQuiet(opts.Quiet),
+		truncate.WithYes(opts.Yes || opts.Force),
+		truncate.WithFailIfEmpty(opts.FailIfEmpty),
+		truncate.WithNamespace(opts.Namespace, opts.StripNamespace),
+		truncate.WithDropNamespace(opts.DropNamespace),
+		truncate.WithPick(opts.Pick),
+		truncate.WithDryRun(opts.DryRun),
+		truncate.WithOutputFormat(outputFormat(opts.Output)),
+		truncate.WithOutput(output),
+		truncate.WithTargetTables(targetTables),
+		truncate.WithExcludeTables(excludeTables),
+		truncate.WithConfig(config),
+		truncate.WithPGAdapter(opts.PGAdapter),
+		truncate.WithRecord(opts.Record),
+		truncate.WithReplay(opts.Replay),
+		truncate.WithPlanCache(opts.PlanCache),
+		truncate.WithStateFile(opts.StateFile, opts.Resume),
+		truncate.WithOnlyTags(opts.OnlyTags),
+		truncate.WithSkipTags(opts.SkipTags),
+		truncate.WithExcludeRegexps(opts.ExcludeRegex),
+		truncate.WithIncludeSchemas(opts.IncludeSchemas),
+		truncate.WithExcludeSchemas(opts.ExcludeSchemas),
+		truncate.WithNormalizeNames(opts.NormalizeNames),
+		truncate.WithLargest(opts.Largest),
+		truncate.WithMinRows(opts.MinRows),
+		truncate.WithReportFormat(truncate.ReportFormat(opts.ReportFormat)),
+		truncate.WithBigQueryResultsTable(opts.BigQueryResultsTable),
+		truncate.WithPubSubTopic(opts.PubSubTopic),
+		truncate.WithController(controller),
+		truncate.WithVerbose(opts.Verbose),
+		truncate.WithThroughputGraph(opts.ThroughputGraph),
+		truncate.WithShowParams(opts.ShowParams),
+		truncate.WithLeakCheck(opts.CheckLeaks),
+		truncate.WithMutationsPerSecond(opts.MutationsPerSecond),
+		truncate.WithRowsPerSecond(opts.MaxRowsPerSec),
+		truncate.WithStrategy(truncate.Strategy(opts.Strategy)),
+		truncate.WithPriority(truncate.Priority(opts.Priority)),
+		truncate.WithRequestTag(opts.RequestTag),
+		truncate.WithKeepLatest(opts.KeepLatest),
+		truncate.WithSamplePercent(opts.SamplePercent),
+		truncate.WithReplan(opts.Replan),
+		truncate.WithConcurrency(opts.Concurrency),
+		truncate.WithOrder(truncate.Order(opts.Order)),
+		truncate.WithTimeouts(truncate.Timeouts{
+			Planning:     opts.PlanningTimeout,
+			Execution:    opts.ExecutionTimeout,
+			Verification: opts.VerificationTimeout,
+		}),
+		truncate.WithStallTimeout(opts.StallTimeout, truncate.StallAction(opts.OnStall)),
+		truncate.WithVerify(truncate.VerifyMode(opts.Verify), opts.VerifySampleSize),
+		truncate.WithBoundedReads(opts.BoundedReads),
+		truncate.WithFingerprint(opts.Fingerprint),
+		truncate.WithLock(opts.LockTable, opts.LockTTL, opts.StealLock),
+		truncate.WithHistoryTable(opts.HistoryTable),
+		truncate.WithOperator(opts.Operator),
+		truncate.WithReason(opts.Reason),
+	}
+	if opts.Window != "" {
+		window, err := truncate.ParseWindow(opts.Window)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		runOpts = append(runOpts, truncate.WithWindow(window))
+	}
+	if opts.Chaos > 0 || opts.ChaosMaxDelay > 0 {
+		runOpts = append(runOpts, truncate.WithChaos(truncate.Chaos{FailureRate: opts.Chaos, MaxDelay: opts.ChaosMaxDelay, Seed: opts.ChaosSeed}))
+	}
+	if opts.OlderThan != "" {
+		age, err := truncate.ParseAge(opts.OlderThan)
+		if err != nil {
+			exitf("Invalid options: %s\n", err.Error())
+		}
+		runOpts = append(runOpts, truncate.WithOlderThan(age, opts.TimestampColumn))
+	}
+	if opts.ProtectRecent != "" {
+		window, err := truncate.ParseAge(opts.ProtectRecent)
+		if err != nil {
+			exitf("Invalid options: %s\n", err.Error())
+		}
+		runOpts = append(runOpts, truncate.WithProtectRecent(window))
+	}
+	if opts.TimestampColumn != "" {
+		if opts.OlderThan == "" && opts.ProtectRecent == "" && opts.KeepLatest == 0 {
+			exitf("Invalid options: --timestamp-column can be used only with --older-than, --protect-recent or --keep-latest\n")
+		}
+		runOpts = append(runOpts, truncate.WithTimestampColumn(opts.TimestampColumn))
+	}
+	params := map[string]string{}
+	for _, p := range opts.Params {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			exitf("Invalid options: --param must be in the form of name=value: %s\n", p)
+		}
+		params[kv[0]] = kv[1]
+	}
+	runOpts = append(runOpts, truncate.WithParams(params))
+	protectedLabels := map[string]string{}
+	for _, l := range opts.ProtectedLabels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			exitf("Invalid options: --protected-label must be in the form of key=value: %s\n", l)
+		}
+		protectedLabels[kv[0]] = kv[1]
+	}
+	runOpts = append(runOpts, truncate.WithProtectedLabels(protectedLabels), truncate.WithAllowProtected(opts.AllowProtected), truncate.WithAllowProtectedTables(opts.AllowProtectedTables))
+	failpoints, err := truncate.ParseFailpoints(strings.Join(append([]string{os.Getenv(failpointsEnv)}, opts.Failpoints...), ","))
+	if err != nil {
+		exitf("ERROR: %s\n", err.Error())
+	}
+	runOpts = append(runOpts, truncate.WithFailpoints(failpoints))
+	if opts.RequireApproval != "" {
+		plan, err := truncate.LoadPlan(opts.RequireApproval)
+		if err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		runOpts = append(runOpts, truncate.WithRequiredApproval(plan, []byte(os.Getenv(approvalKeyEnv))))
+	}
+	if err := truncate.NewOptions(runOpts...).Validate(); err != nil {
+		exitf("%s\n", err.Error())
+	}
+
+	if parser.Active != nil && parser.Active.Name == "support-bundle" {
+		if err := writeSupportBundle(ctx, opts, runOpts); err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		fmt.Printf("Wrote the support bundle to %s\n", opts.SupportBundle.Args.File)
+		return
+	}
+
+	if opts.ExplainPlan {
+		if err := truncate.ExplainPlan(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, runOpts...); err != nil {
+			exitf("ERROR: %s", err.Error())
+		}
+		return
+	}
+
+	if opts.WritePlan != "" {
+		plan, err := truncate.BuildPlan(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, runOpts...)
+		if err != nil {
+			exitf("ERROR: %s", err.Error())
+		}
+		if err := writePlan(opts.WritePlan, plan); err != nil {
+			exitf("ERROR: %s\n", err.Error())
+		}
+		fmt.Printf("Wrote the plan of %d tables to %s\n", len(plan.Tables), opts.WritePlan)
+		return
+	}
+
+	if len(databaseIDs) > 1 {
+		if err := truncate.RunDatabases(ctx, opts.ProjectID, opts.InstanceID, databaseIDs, runOpts...); err != nil {
+			exitf("ERROR: %s", err.Error())
+		}
+		return
+	}
+	if err := truncate.Run(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, runOpts...); err != nil {
+		exitf("ERROR: %s", err.Error())
+	}
+}
+
+// applyConfig fills the database and the tables not given by flags with those in the config file.
+func (o *options) applyConfig(c *truncate.Config) {
+	if o.ProjectID == "" {
+		o.ProjectID = c.Project
+	}
+	if o.InstanceID == "" {
+		o.InstanceID = c.Instance
+	}
+	if o.DatabaseID == "" && o.Databases == "" && !o.AllDatabases {
+		o.DatabaseID = c.Database
+	}
+	if o.Tables == "" && o.TablesFile == "" && o.ExcludeTables == "" && o.ExcludeTablesFile == "" {
+		o.Tables = strings.Join(c.TargetTables, ",")
+		o.ExcludeTables = strings.Join(c.ExcludeTables, ",")
+	}
+}
+
+// databaseIDs returns the databases given by -d and --databases.
+func (o *options) databaseIDs() []string {
+	var ids []string
+	if o.DatabaseID != "" {
+		ids = append(ids, o.DatabaseID)
+	}
+	return append(ids, splitList(o.Databases)...)
+}
+
+// splitList splits the comma-separated list, ignoring spaces around and empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// approvePlan adds an approval and an optional annotation to the plan file.
+func approvePlan(path, approver, annotation string) error {
+	plan, err := truncate.LoadPlan(path)
+	if err != nil {
+		return err
+	}
+	if err := plan.Approve(approver, []byte(os.Getenv(approvalKeyEnv))); err != nil {
+		return fmt.Errorf("failed to approve plan: %v", err)
+	}
+	if annotation != "" {
+		plan.Annotations = append(plan.Annotations, fmt.Sprintf("%s: %s", approver, annotation))
+	}
+	return writePlan(path, plan)
+}
+
+// writeSupportBundle writes the support bundle of the database to the file.
+func writeSupportBundle(ctx context.Context, opts options, runOpts []truncate.Option) error {
+	f, err := os.Create(opts.SupportBundle.Args.File)
+	if err != nil {
+		return fmt.Errorf("failed to create support bundle: %v", err)
+	}
+	if err := truncate.WriteSupportBundle(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, f, runOpts...); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writePlan(path string, plan *truncate.Plan) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file: %v", err)
+	}
+	defer f.Close()
+	return plan.Write(f)
+}
+
+// outputFormat converts the value of --output to truncate.OutputFormat.
+func outputFormat(output string) truncate.OutputFormat {
+	if output == "json" {
+		return truncate.OutputJSON
+	}
+	return truncate.OutputText
+}
+
+// readTableNames reads table names, one per line. Blank lines and comments starting with # are ignored.
+func readTableNames(r io.Reader) ([]string, error) {
+	var tables []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if name := strings.TrimSpace(s.Text()); name != "" && !strings.HasPrefix(name, "#") {
+			tables = append(tables, name)
+		}
+	}
+	return tables, s.Err()
+}
+
+// tableLists returns the tables to be truncated and excluded, given by --tables, --exclude-tables and the files of table names.
+// The options are updated so that --tables - is the same as --tables-file -.
+func tableLists(opts *options, stdin io.Reader) ([]string, []string, error) {
 	var targetTables []string
 	var excludeTables []string
+	if opts.Tables == "-" {
+		if opts.TablesFile != "" {
+			return nil, nil, errors.New("Invalid options: --tables - cannot be used with --tables-file")
+		}
+		opts.TablesFile, opts.Tables = "-", ""
+	}
 	if opts.Tables != "" {
 		targetTables = strings.Split(opts.Tables, ",")
 	}
-
 	if opts.ExcludeTables != "" {
-		if opts.Tables != "" {
-			exitf("Conflict: --tables and --exclude-tables cannot be both set.\n")
-		}
 		excludeTables = strings.Split(opts.ExcludeTables, ",")
 	}
+	if opts.TablesFile == "-" && opts.ExcludeTablesFile == "-" {
+		return nil, nil, errors.New("Invalid options: stdin can be read by only one of --tables and --exclude-tables")
+	}
+	for _, f := range []struct {
+		path  string
+		names *[]string
+	}{
+		{opts.TablesFile, &targetTables},
+		{opts.ExcludeTablesFile, &excludeTables},
+	} {
+		if f.path == "" {
+			continue
+		}
+		tables, err := readTableNamesFile(f.path, stdin)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ERROR: %v", err)
+		}
+		*f.names = append(*f.names, tables...)
+	}
+	// An empty list must not fall back to truncating all tables.
+	if opts.TablesFile != "" && len(targetTables) == 0 {
+		return nil, nil, fmt.Errorf("ERROR: no table names found in %s", opts.TablesFile)
+	}
+	return targetTables, excludeTables, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), maxTimeout)
-	defer cancel()
-	go handleInterrupt(cancel)
-
-	if err := truncate.Run(ctx, opts.ProjectID, opts.InstanceID, opts.DatabaseID, opts.Quiet, os.Stdout, targetTables, excludeTables); err != nil {
-		exitf("ERROR: %s", err.Error())
+// readTableNamesFile reads table names from the file at the path, or from stdin if the path is "-".
+func readTableNamesFile(path string, stdin io.Reader) ([]string, error) {
+	if path == "-" {
+		tables, err := readTableNames(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read table names from stdin: %v", err)
+		}
+		return tables, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table names file: %v", err)
+	}
+	defer f.Close()
+	tables, err := readTableNames(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table names from %s: %v", path, err)
 	}
+	return tables, nil
 }
 
 func exitf(format string, a ...interface{}) {
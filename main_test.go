@@ -0,0 +1,143 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// errReader fails after returning the data.
+type errReader struct {
+	data string
+	read bool
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("broken pipe")
+	}
+	r.read = true
+	return copy(p, r.data), nil
+}
+
+func TestReadTableNames(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		r       io.Reader
+		want    []string
+		wantErr bool
+	}{
+		{desc: "Empty", r: strings.NewReader("")},
+		{desc: "One per line", r: strings.NewReader("Singers\nAlbums\n"), want: []string{"Singers", "Albums"}},
+		{desc: "Without trailing newline", r: strings.NewReader("Singers\nAlbums"), want: []string{"Singers", "Albums"}},
+		{desc: "Blank lines", r: strings.NewReader("\nSingers\n\n  \n\tAlbums\n\n"), want: []string{"Singers", "Albums"}},
+		{desc: "Comments", r: strings.NewReader("# music\nSingers\n  # Albums\n#Songs\n"), want: []string{"Singers"}},
+		{desc: "Whitespace", r: strings.NewReader("  Singers \t\r\n\tAlbums\r\n"), want: []string{"Singers", "Albums"}},
+		{desc: "Only comments", r: strings.NewReader("# Singers\n\n# Albums\n")},
+		{desc: "Schema-qualified and patterns", r: strings.NewReader("music.Singers\nTmp_*\n"), want: []string{"music.Singers", "Tmp_*"}},
+		{desc: "Read error", r: &errReader{data: "Singers\n"}, wantErr: true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := readTableNames(tt.r)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("readTableNames() = %v, but want error = %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("readTableNames() differs: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTableLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"tables.txt":  "# music\nSingers\n\n  Albums  \n",
+		"exclude.txt": "Songs\n",
+		"empty.txt":   "# nothing\n\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	for _, tt := range []struct {
+		desc          string
+		opts          options
+		stdin         string
+		wantTargets   []string
+		wantExcludes  []string
+		wantFile      string
+		wantErrPrefix string
+	}{
+		{desc: "No tables"},
+		{desc: "Tables", opts: options{Tables: "Singers,Albums", ExcludeTables: "Songs"}, wantTargets: []string{"Singers", "Albums"}, wantExcludes: []string{"Songs"}},
+		{desc: "Tables file", opts: options{TablesFile: file("tables.txt")}, wantTargets: []string{"Singers", "Albums"}, wantFile: file("tables.txt")},
+		{desc: "Tables file added to tables", opts: options{Tables: "Concerts", TablesFile: file("tables.txt")}, wantTargets: []string{"Concerts", "Singers", "Albums"}, wantFile: file("tables.txt")},
+		{desc: "Exclude tables file", opts: options{ExcludeTables: "Albums", ExcludeTablesFile: file("exclude.txt")}, wantExcludes: []string{"Albums", "Songs"}},
+		{desc: "Tables from stdin", opts: options{Tables: "-"}, stdin: "Singers\n# Albums\n", wantTargets: []string{"Singers"}, wantFile: "-"},
+		{desc: "Tables file from stdin", opts: options{TablesFile: "-", ExcludeTablesFile: file("exclude.txt")}, stdin: "Singers\n", wantTargets: []string{"Singers"}, wantExcludes: []string{"Songs"}, wantFile: "-"},
+		{desc: "Exclude tables from stdin", opts: options{Tables: "Singers", ExcludeTablesFile: "-"}, stdin: "Songs\n", wantTargets: []string{"Singers"}, wantExcludes: []string{"Songs"}},
+		{desc: "Stdin with tables file", opts: options{Tables: "-", TablesFile: file("tables.txt")}, wantErrPrefix: "Invalid options: --tables - cannot be used with --tables-file"},
+		{desc: "Stdin for both", opts: options{TablesFile: "-", ExcludeTablesFile: "-"}, wantErrPrefix: "Invalid options: stdin can be read by only one"},
+		{desc: "Stdin for both by tables", opts: options{Tables: "-", ExcludeTablesFile: "-"}, wantErrPrefix: "Invalid options: stdin can be read by only one"},
+		{desc: "Empty tables file", opts: options{TablesFile: file("empty.txt")}, wantErrPrefix: "ERROR: no table names found in "},
+		{desc: "Empty stdin", opts: options{Tables: "-"}, stdin: "\n", wantErrPrefix: "ERROR: no table names found in -"},
+		{desc: "Empty tables file with tables", opts: options{Tables: "Singers", TablesFile: file("empty.txt")}, wantTargets: []string{"Singers"}, wantFile: file("empty.txt")},
+		{desc: "Missing tables file", opts: options{TablesFile: file("missing.txt")}, wantErrPrefix: "ERROR: failed to open table names file"},
+		{desc: "Missing exclude tables file", opts: options{ExcludeTablesFile: file("missing.txt")}, wantErrPrefix: "ERROR: failed to open table names file"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			opts := tt.opts
+			targets, excludes, err := tableLists(&opts, strings.NewReader(tt.stdin))
+			if tt.wantErrPrefix != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErrPrefix) {
+					t.Fatalf("tableLists() = %v, but want an error starting with %q", err, tt.wantErrPrefix)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tableLists() = %v", err)
+			}
+			if diff := cmp.Diff(tt.wantTargets, targets); diff != "" {
+				t.Errorf("tables differ: (-want, +got)\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantExcludes, excludes); diff != "" {
+				t.Errorf("exclude tables differ: (-want, +got)\n%s", diff)
+			}
+			if opts.TablesFile != tt.wantFile || opts.Tables == "-" {
+				t.Errorf("tableLists() left --tables %q and --tables-file %q, but want the file %q", opts.Tables, opts.TablesFile, tt.wantFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// ParseAge parses an age such as "30d", "2w" or "12h". Days and weeks are added to the units of time.ParseDuration.
+func ParseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); strings.HasSuffix(s, suffix) && err == nil {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q, must be like 30d, 2w or 12h", s)
+	}
+	return d, nil
+}
+
+// timestampColumns are the timestamp columns of tables compared by --older-than and --protect-recent, and ordering rows of --keep-latest.
+type timestampColumns struct {
+	// defaultColumn is used for the tables without their own columns.
+	defaultColumn string
+	// tables are the columns of tables in the config keyed by table name.
+	tables map[string]string
+}
+
+// of returns the timestamp column of the table, or "" if none.
+func (c *timestampColumns) of(table string) string {
+	if column, ok := c.tables[table]; ok && column != "" {
+		return column
+	}
+	return c.defaultColumn
+}
+
+// timestampColumns returns the timestamp columns of tables, or nil if rows are not filtered by their age.
+func (o *Options) timestampColumns() *timestampColumns {
+	if o.OlderThan <= 0 && o.ProtectRecent <= 0 && o.KeepLatest <= 0 {
+		return nil
+	}
+	return &timestampColumns{defaultColumn: o.TimestampColumn, tables: o.Config.tableTimestampColumns()}
+}
+
+// ageFlags returns the flags filtering rows by their age, used in messages.
+func (o *Options) ageFlags() string {
+	var flags []string
+	if o.OlderThan > 0 {
+		flags = append(flags, "--older-than")
+	}
+	if o.ProtectRecent > 0 {
+		flags = append(flags, "--protect-recent")
+	}
+	if o.KeepLatest > 0 {
+		flags = append(flags, "--keep-latest")
+	}
+	if len(flags) < 2 {
+		return strings.Join(flags, "")
+	}
+	return strings.Join(flags[:len(flags)-1], ", ") + " and " + flags[len(flags)-1]
+}
+
+// minTimestampMicros is the minimum timestamp of Cloud Spanner, 0001-01-01T00:00:00Z, in microseconds since the Unix epoch.
+// No row is older than it, so it is the cutoff of a table with no rows to be deleted.
+const minTimestampMicros = -62135596800000000
+
+// ageFilter deletes only rows whose timestamp column is older than the cutoff.
+type ageFilter struct {
+	columns *timestampColumns
+	cutoff  time.Time
+	// latest makes the cutoff the column of the latest-th newest row of each table instead if positive.
+	latest int64
+	// cutoffs are the cutoffs of tables read by resolveCutoffs if latest is positive.
+	cutoffs map[string]time.Time
+	// keepNull keeps rows whose timestamp is NULL. Otherwise they are deleted as they are not recent.
+	keepNull bool
+}
+
+// ageFilters returns the filters of OlderThan, ProtectRecent and KeepLatest at now.
+// Rows of unknown age are kept by OlderThan and KeepLatest, but deleted by ProtectRecent.
+func (o *Options) ageFilters(now time.Time) []*ageFilter {
+	var filters []*ageFilter
+	if o.OlderThan > 0 {
+		filters = append(filters, &ageFilter{columns: o.timestampColumns(), cutoff: now.Add(-o.OlderThan), keepNull: true})
+	}
+	if o.ProtectRecent > 0 {
+		filters = append(filters, &ageFilter{columns: o.timestampColumns(), cutoff: now.Add(-o.ProtectRecent)})
+	}
+	if o.KeepLatest > 0 {
+		filters = append(filters, &ageFilter{columns: o.timestampColumns(), latest: o.KeepLatest, keepNull: true})
+	}
+	return filters
+}
+
+// condition returns the condition of rows of the table older than the cutoff in the dialect.
+func (f *ageFilter) condition(d Dialect, table string) string {
+	column := d.quote(f.columns.of(table))
+	cutoff := f.cutoff
+	if f.latest > 0 {
+		// A table without its cutoff resolved has the zero time, which is the minimum timestamp, and no rows are deleted.
+		cutoff = f.cutoffs[table]
+	}
+	cond := fmt.Sprintf("%s < %s", column, d.timestampLiteral(cutoff))
+	if f.keepNull {
+		return cond
+	}
+	return fmt.Sprintf("(%s IS NULL OR %s)", column, cond)
+}
+
+// resolveCutoffs reads the cutoffs of the tables not read yet if the filter keeps the latest rows.
+// Partitioned DML cannot compare a row with the other rows of the table, so the cutoff is read before deleting instead of by a subquery.
+// Rows which tie with the latest-th newest row are kept, so that no row newer than another kept row is deleted.
+func (f *ageFilter) resolveCutoffs(ctx context.Context, b Backend, tables []*tableSchema) error {
+	if f.latest <= 0 {
+		return nil
+	}
+	if f.cutoffs == nil {
+		f.cutoffs = map[string]time.Time{}
+	}
+	for _, t := range tables {
+		if _, ok := f.cutoffs[t.tableName]; ok {
+			continue
+		}
+		column := f.columns.of(t.tableName)
+		micros, err := b.Count(ctx, spanner.NewStatement(b.Dialect().latestCutoffSQL(t.tableName, column, f.latest)), 0, PriorityUnspecified)
+		if err != nil {
+			return fmt.Errorf("failed to read the latest %d rows of %s: %v", f.latest, t.tableName, err)
+		}
+		f.cutoffs[t.tableName] = time.Unix(micros/1e6, micros%1e6*1e3).UTC()
+	}
+	return nil
+}
+
+// resolveAgeCutoffs reads the cutoffs of the tables for the filters keeping the latest rows.
+func resolveAgeCutoffs(ctx context.Context, b Backend, ages []*ageFilter, tables []*tableSchema) error {
+	for _, f := range ages {
+		if err := f.resolveCutoffs(ctx, b, tables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestCutoffSQL returns a query returning the column of the n-th newest row of the table in microseconds since the Unix epoch,
+// or the minimum timestamp if the table has fewer rows.
+func (d Dialect) latestCutoffSQL(table, column string, n int64) string {
+	c := d.quote(column)
+	if d == DialectPostgreSQL {
+		return fmt.Sprintf("SELECT COALESCE((SELECT CAST(EXTRACT(EPOCH FROM %s) * 1000000 AS bigint) FROM %s WHERE %s IS NOT NULL ORDER BY %s DESC LIMIT 1 OFFSET %d), %d)",
+			c, d.quote(table), c, c, n-1, minTimestampMicros)
+	}
+	return fmt.Sprintf("SELECT IFNULL((SELECT UNIX_MICROS(%s) FROM %s WHERE %s IS NOT NULL ORDER BY %s DESC LIMIT 1 OFFSET %d), %d)",
+		c, d.quote(table), c, c, n-1, minTimestampMicros)
+}
+
+// timestampLiteral returns the time as a TIMESTAMP literal in the dialect.
+func (d Dialect) timestampLiteral(t time.Time) string {
+	s := t.UTC().Format(time.RFC3339Nano)
+	if d == DialectPostgreSQL {
+		return fmt.Sprintf("'%s'::timestamptz", s)
+	}
+	return fmt.Sprintf("TIMESTAMP %q", s)
+}
+
+// columnExistsSQL returns a query returning 1 if the table has the column, or 0 otherwise.
+func (d Dialect) columnExistsSQL(table, column string) string {
+	schema, name := splitTableName(table)
+	if schema == "" && d == DialectPostgreSQL {
+		schema = "public"
+	}
+	return fmt.Sprintf("SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = %s AND TABLE_NAME = %s AND COLUMN_NAME = %s",
+		d.stringLiteral(schema), d.stringLiteral(name), d.stringLiteral(column))
+}
+
+// tablesWithColumn returns the tables having their timestamp columns.
+func tablesWithColumn(ctx context.Context, b Backend, tables []*tableSchema, columns *timestampColumns) (map[string]bool, error) {
+	has := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		column := columns.of(t.tableName)
+		if column == "" {
+			continue
+		}
+		n, err := b.Count(ctx, spanner.NewStatement(b.Dialect().columnExistsSQL(t.tableName, column)), 0, PriorityUnspecified)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find column %s of %s: %v", column, t.tableName, err)
+		}
+		has[t.tableName] = n > 0
+	}
+	return has, nil
+}
+
+// selectByColumn excludes the tables without their timestamp columns and updates their decisions.
+// flags are the flags filtering rows by the columns, shown in the reasons.
+func selectByColumn(tables []*tableSchema, decisions []*planDecision, columns *timestampColumns, has map[string]bool, flags string) []*tableSchema {
+	for _, d := range decisions {
+		if !d.included || has[d.tableName] {
+			continue
+		}
+		d.included = false
+		if column := columns.of(d.tableName); column != "" {
+			d.reason = fmt.Sprintf("no column %s for %s", column, flags)
+		} else {
+			d.reason = fmt.Sprintf("no timestamp column for %s", flags)
+		}
+	}
+
+	var kept []*tableSchema
+	for _, t := range tables {
+		if has[t.tableName] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
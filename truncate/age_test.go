@@ -0,0 +1,347 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestParseAge(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30d", want: 30 * 24 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "12h", want: 12 * time.Hour},
+		{in: "90m", want: 90 * time.Minute},
+		{in: "1.5d", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "month", wantErr: true},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAge(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseAge(%q) = %v, but want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseAge(%q) = %v, %v, but want = %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgeFilterCondition(t *testing.T) {
+	columns := &timestampColumns{defaultColumn: "CreatedAt", tables: map[string]string{"Events": "CommitTs"}}
+	cutoff := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+	for _, tt := range []struct {
+		desc    string
+		filter  *ageFilter
+		dialect Dialect
+		table   string
+		want    string
+	}{
+		{
+			desc:    "Older than",
+			filter:  &ageFilter{columns: columns, cutoff: cutoff, keepNull: true},
+			dialect: DialectGoogleSQL,
+			table:   "Orders",
+			want:    "`CreatedAt` < TIMESTAMP \"2020-01-01T18:04:05Z\"",
+		},
+		{
+			desc:    "Older than in PostgreSQL",
+			filter:  &ageFilter{columns: columns, cutoff: cutoff, keepNull: true},
+			dialect: DialectPostgreSQL,
+			table:   "Orders",
+			want:    "\"CreatedAt\" < '2020-01-01T18:04:05Z'::timestamptz",
+		},
+		{
+			desc:    "Protect recent with the column of the table",
+			filter:  &ageFilter{columns: columns, cutoff: cutoff},
+			dialect: DialectGoogleSQL,
+			table:   "Events",
+			want:    "(`CommitTs` IS NULL OR `CommitTs` < TIMESTAMP \"2020-01-01T18:04:05Z\")",
+		},
+		{
+			desc:    "Keep latest",
+			filter:  &ageFilter{columns: columns, latest: 100, cutoffs: map[string]time.Time{"Events": cutoff}, keepNull: true},
+			dialect: DialectGoogleSQL,
+			table:   "Events",
+			want:    "`CommitTs` < TIMESTAMP \"2020-01-01T18:04:05Z\"",
+		},
+		{
+			desc:    "Keep latest without the cutoff",
+			filter:  &ageFilter{columns: columns, latest: 1, cutoffs: map[string]time.Time{}, keepNull: true},
+			dialect: DialectPostgreSQL,
+			table:   "Orders",
+			want:    "\"CreatedAt\" < '0001-01-01T00:00:00Z'::timestamptz",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := tt.filter.condition(tt.dialect, tt.table); got != tt.want {
+				t.Errorf("condition(%q) = %q, but want = %q", tt.table, got, tt.want)
+			}
+		})
+	}
+}
+
+// agedBackend is a fakeBackend whose rows have timestamps in the CreatedAt column.
+// Like Partitioned DML, it rejects statements with subqueries, which cannot be partitioned.
+type agedBackend struct {
+	*fakeBackend
+
+	mu         sync.Mutex
+	timestamps map[string][]time.Time
+}
+
+var (
+	agedTableRe  = regexp.MustCompile("FROM `([^`]+)`")
+	agedCutoffRe = regexp.MustCompile("`CreatedAt` < TIMESTAMP \"([^\"]+)\"")
+	agedOffsetRe = regexp.MustCompile(`OFFSET (\d+)`)
+)
+
+// matching returns the table of the statement and the indexes of its rows matching the cutoff in the statement.
+func (b *agedBackend) matching(sql string) (string, []int) {
+	table := agedTableRe.FindStringSubmatch(sql)[1]
+	var cutoff time.Time
+	if m := agedCutoffRe.FindStringSubmatch(sql); m != nil {
+		cutoff, _ = time.Parse(time.RFC3339Nano, m[1])
+	}
+	var rows []int
+	for i, ts := range b.timestamps[table] {
+		if ts.Before(cutoff) {
+			rows = append(rows, i)
+		}
+	}
+	return table, rows
+}
+
+func (b *agedBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if strings.Contains(stmt.SQL, "SELECT") {
+		return grpcstatus.Error(codes.InvalidArgument, "Partitioned DML does not support subqueries")
+	}
+	table, rows := b.matching(stmt.SQL)
+	var kept []time.Time
+	for i, ts := range b.timestamps[table] {
+		if len(rows) == 0 || i != rows[0] {
+			kept = append(kept, ts)
+			continue
+		}
+		rows = rows[1:]
+	}
+	b.timestamps[table] = kept
+	return nil
+}
+
+func (b *agedBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case strings.Contains(stmt.SQL, "INFORMATION_SCHEMA.COLUMNS"):
+		return 1, nil
+	case strings.HasPrefix(stmt.SQL, "SELECT IFNULL"):
+		table := agedTableRe.FindStringSubmatch(stmt.SQL)[1]
+		var offset int
+		fmt.Sscan(agedOffsetRe.FindStringSubmatch(stmt.SQL)[1], &offset)
+		timestamps := append([]time.Time(nil), b.timestamps[table]...)
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i].After(timestamps[j]) })
+		if offset >= len(timestamps) {
+			return minTimestampMicros, nil
+		}
+		return timestamps[offset].UnixNano() / 1e3, nil
+	case !strings.Contains(stmt.SQL, "WHERE"):
+		table := agedTableRe.FindStringSubmatch(stmt.SQL)[1]
+		return int64(len(b.timestamps[table])), nil
+	}
+	_, rows := b.matching(stmt.SQL)
+	if strings.Contains(stmt.SQL, "LIMIT 1") && len(rows) > 0 {
+		return 1, nil
+	}
+	return int64(len(rows)), nil
+}
+
+func TestRunKeepLatest(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return base.AddDate(0, 0, n) }
+	b := &agedBackend{
+		fakeBackend: &fakeBackend{tables: []TableInfo{{Name: "Events"}, {Name: "Logs"}}},
+		timestamps: map[string][]time.Time{
+			"Events": {day(3), day(1), day(5), day(2), day(4), day(5)},
+			"Logs":   {day(1)},
+		},
+	}
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithKeepLatest(2), WithTimestampColumn("CreatedAt"),
+		WithQuiet(true), WithOutput(Output{Writer: ioutil.Discard})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	want := map[string][]time.Time{
+		"Events": {day(5), day(5)},
+		"Logs":   {day(1)},
+	}
+	if diff := cmp.Diff(want, b.timestamps); diff != "" {
+		t.Errorf("Run() remaining rows mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLatestCutoffSQL(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		got  string
+		want string
+	}{
+		{
+			desc: "GoogleSQL",
+			got:  DialectGoogleSQL.latestCutoffSQL("Events", "CreatedAt", 100),
+			want: "SELECT IFNULL((SELECT UNIX_MICROS(`CreatedAt`) FROM `Events` WHERE `CreatedAt` IS NOT NULL ORDER BY `CreatedAt` DESC LIMIT 1 OFFSET 99), -62135596800000000)",
+		},
+		{
+			desc: "PostgreSQL",
+			got:  DialectPostgreSQL.latestCutoffSQL("Events", "CreatedAt", 1),
+			want: `SELECT COALESCE((SELECT CAST(EXTRACT(EPOCH FROM "CreatedAt") * 1000000 AS bigint) FROM "Events" WHERE "CreatedAt" IS NOT NULL ORDER BY "CreatedAt" DESC LIMIT 1 OFFSET 0), -62135596800000000)`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("latestCutoffSQL() = %q, but want = %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnExistsSQL(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		got  string
+		want string
+	}{
+		{
+			desc: "Default schema",
+			got:  DialectGoogleSQL.columnExistsSQL("Orders", "CreatedAt"),
+			want: `SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = "" AND TABLE_NAME = "Orders" AND COLUMN_NAME = "CreatedAt"`,
+		},
+		{
+			desc: "Named schema in PostgreSQL",
+			got:  DialectPostgreSQL.columnExistsSQL("sales.Orders", "created_at"),
+			want: `SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = 'sales' AND TABLE_NAME = 'Orders' AND COLUMN_NAME = 'created_at'`,
+		},
+		{
+			desc: "Default schema in PostgreSQL",
+			got:  DialectPostgreSQL.columnExistsSQL("Orders", "created_at"),
+			want: `SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = 'public' AND TABLE_NAME = 'Orders' AND COLUMN_NAME = 'created_at'`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("columnExistsSQL() = %q, but want = %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectByColumn(t *testing.T) {
+	all := []*tableSchema{{tableName: "Events"}, {tableName: "Countries"}, {tableName: "Sessions"}, {tableName: "Logs"}}
+	tables, decisions := selectTables(all, tableSelection{excludeTables: []string{"Logs"}})
+	columns := &timestampColumns{tables: map[string]string{"Events": "CreatedAt", "Countries": "UpdatedAt"}}
+	tables = selectByColumn(tables, decisions, columns, map[string]bool{"Events": true}, "--protect-recent")
+
+	var gotTables []string
+	for _, table := range tables {
+		gotTables = append(gotTables, table.tableName)
+	}
+	if diff := cmp.Diff([]string{"Events"}, gotTables); diff != "" {
+		t.Errorf("selectByColumn() tables mismatch (-want +got):\n%s", diff)
+	}
+
+	var gotDecisions []string
+	for _, d := range decisions {
+		gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+	}
+	wantDecisions := []string{
+		"Events: true: not excluded by --exclude-tables",
+		"Countries: false: no column UpdatedAt for --protect-recent",
+		"Sessions: false: no timestamp column for --protect-recent",
+		"Logs: false: excluded by --exclude-tables",
+	}
+	if diff := cmp.Diff(wantDecisions, gotDecisions); diff != "" {
+		t.Errorf("selectByColumn() decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyTableFiltersWithAge(t *testing.T) {
+	schemas := []*tableSchema{{tableName: "Orders"}, {tableName: "Events"}}
+	columns := &timestampColumns{defaultColumn: "CreatedAt"}
+	ages := []*ageFilter{
+		{columns: columns, cutoff: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), keepNull: true},
+		{columns: columns, cutoff: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if err := applyTableFilters(schemas, map[string]string{"Orders": "Status = 1 OR Status = 2"}, nil, ages, nil, DialectGoogleSQL); err != nil {
+		t.Fatalf("applyTableFilters() failed: %v", err)
+	}
+	want := []string{
+		"(Status = 1 OR Status = 2) AND `CreatedAt` < TIMESTAMP \"2020-01-01T00:00:00Z\" AND (`CreatedAt` IS NULL OR `CreatedAt` < TIMESTAMP \"2020-02-01T00:00:00Z\")",
+		"`CreatedAt` < TIMESTAMP \"2020-01-01T00:00:00Z\" AND (`CreatedAt` IS NULL OR `CreatedAt` < TIMESTAMP \"2020-02-01T00:00:00Z\")",
+	}
+	if diff := cmp.Diff(want, []string{schemas[0].filter, schemas[1].filter}); diff != "" {
+		t.Errorf("applyTableFilters() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOptionsAgeFilters(t *testing.T) {
+	now := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	o := NewOptions(WithOlderThan(30*24*time.Hour, "CreatedAt"), WithProtectRecent(time.Hour))
+	filters := o.ageFilters(now)
+	if len(filters) != 2 {
+		t.Fatalf("ageFilters() = %d filters, but want = 2", len(filters))
+	}
+	// Rows of unknown age are kept for retention, but deleted when only recent rows are protected.
+	if f := filters[0]; !f.cutoff.Equal(now.AddDate(0, 0, -30)) || !f.keepNull {
+		t.Errorf("ageFilters()[0] = %v, %t, but want = %v, true", f.cutoff, f.keepNull, now.AddDate(0, 0, -30))
+	}
+	if f := filters[1]; !f.cutoff.Equal(now.Add(-time.Hour)) || f.keepNull {
+		t.Errorf("ageFilters()[1] = %v, %t, but want = %v, false", f.cutoff, f.keepNull, now.Add(-time.Hour))
+	}
+	if got := o.ageFlags(); got != "--older-than and --protect-recent" {
+		t.Errorf("ageFlags() = %q, but want = %q", got, "--older-than and --protect-recent")
+	}
+
+	o = NewOptions(WithOlderThan(30*24*time.Hour, "CreatedAt"), WithProtectRecent(time.Hour), WithKeepLatest(100))
+	if filters := o.ageFilters(now); len(filters) != 3 || filters[2].latest != 100 || !filters[2].keepNull {
+		t.Errorf("ageFilters() = %v, but want the last filter keeping the latest 100 rows", filters)
+	}
+	if got := o.ageFlags(); got != "--older-than, --protect-recent and --keep-latest" {
+		t.Errorf("ageFlags() = %q, but want = %q", got, "--older-than, --protect-recent and --keep-latest")
+	}
+}
@@ -0,0 +1,95 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// cloudPlatformScope covers Cloud Spanner, its admin APIs and Pub/Sub used by the tool.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// clientOptions returns the options of a client created for the options, given options taking precedence over the credentials.
+func (o *Options) clientOptions(opts ...option.ClientOption) []option.ClientOption {
+	return append(append([]option.ClientOption(nil), o.Credentials...), opts...)
+}
+
+// fileCredentials are the credentials read by CredentialsFile.
+type fileCredentials struct {
+	option.ClientOption
+}
+
+// impersonatedCredentials are the credentials of the service account impersonated by ImpersonateServiceAccount.
+type impersonatedCredentials struct {
+	option.ClientOption
+	serviceAccount string
+}
+
+// credentialsProblems returns the problems of credentials which would silently override others, since only the last ones are used.
+func credentialsProblems(credentials []option.ClientOption) []string {
+	var files int
+	var impersonated []string
+	for _, c := range credentials {
+		switch c := c.(type) {
+		case fileCredentials:
+			files++
+		case impersonatedCredentials:
+			impersonated = append(impersonated, c.serviceAccount)
+		}
+	}
+	var problems []string
+	if len(impersonated) > 1 {
+		problems = append(problems, fmt.Sprintf("only one service account can be impersonated, but %s", strings.Join(impersonated, ", ")))
+	}
+	if len(impersonated) > 0 && files > 0 {
+		problems = append(problems, "credentials file cannot be used with impersonation; pass it to ImpersonateServiceAccount to impersonate with it")
+	}
+	return problems
+}
+
+// CredentialsFile returns a client option authenticating with the credentials file, e.g. a JSON key of a service account,
+// instead of Application Default Credentials.
+func CredentialsFile(path string) (option.ClientOption, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	return fileCredentials{option.WithCredentialsJSON(b)}, nil
+}
+
+// ImpersonateServiceAccount returns a client option acting as the service account by short-lived tokens issued for the credentials
+// of the given options, e.g. CredentialsFile and option.WithQuotaProject, or Application Default Credentials.
+// It cannot be combined with other credentials, e.g. CredentialsFile, by WithCredentials.
+// The credentials need roles/iam.serviceAccountTokenCreator on the service account, or on the first of the delegates,
+// each of which needs the role on the next one in the chain.
+func ImpersonateServiceAccount(ctx context.Context, serviceAccount string, delegates []string, opts ...option.ClientOption) (option.ClientOption, error) {
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: serviceAccount,
+		Scopes:          []string{cloudPlatformScope},
+		Delegates:       delegates,
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %v", serviceAccount, err)
+	}
+	return impersonatedCredentials{option.WithTokenSource(ts), serviceAccount}, nil
+}
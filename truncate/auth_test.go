@@ -0,0 +1,149 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := CredentialsFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("CredentialsFile() succeeded, but want an error for a missing file")
+	}
+
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, []byte(`{"type": "service_account"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CredentialsFile(path); err != nil {
+		t.Errorf("CredentialsFile() failed: %v", err)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		opts []Option
+		want int
+	}{
+		{
+			desc: "Application Default Credentials",
+			want: 1,
+		},
+		{
+			desc: "credentials followed by the given option",
+			opts: []Option{WithCredentials(option.WithCredentialsJSON([]byte(`{}`)), option.WithQuotaProject("billing-project"))},
+			want: 3,
+		},
+		{
+			desc: "client options of the backend are not included",
+			opts: []Option{WithClientOptions(option.WithUserAgent("test"))},
+			want: 1,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			o := NewOptions(test.opts...)
+			if got := len(o.clientOptions(option.WithEndpoint("localhost:9010"))); got != test.want {
+				t.Errorf("clientOptions() = %d options, but want = %d", got, test.want)
+			}
+			if got := len(o.clientOptions()); got != test.want-1 {
+				t.Errorf("clientOptions() = %d options after another call, but want = %d", got, test.want-1)
+			}
+		})
+	}
+}
+
+// roundTripFunc serves HTTP requests of a client by the function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestImpersonateServiceAccount(t *testing.T) {
+	const serviceAccount = "truncate@p.iam.gserviceaccount.com"
+	// The IAM Credentials API issues a token of the service account for the source credentials.
+	var iamPaths []string
+	iam := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		iamPaths = append(iamPaths, r.URL.Path)
+		body := fmt.Sprintf(`{"accessToken": "impersonated-token", "expireTime": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body)), Header: http.Header{}}, nil
+	})
+	credentials, err := ImpersonateServiceAccount(context.Background(), serviceAccount, nil, option.WithHTTPClient(&http.Client{Transport: iam}))
+	if err != nil {
+		t.Fatalf("ImpersonateServiceAccount() = %v", err)
+	}
+	o := NewOptions(WithCredentials(credentials, option.WithQuotaProject("billing")))
+	if err := o.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	// Clients created for the options authenticate with the token of the service account.
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+	client, _, err := htransport.NewClient(context.Background(), o.clientOptions(option.WithEndpoint(server.URL))...)
+	if err != nil {
+		t.Fatalf("failed to create a client with the options: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to request with the impersonated credentials: %v", err)
+	}
+	resp.Body.Close()
+	if want := "Bearer impersonated-token"; authorization != want {
+		t.Errorf("Authorization = %q, but want %q", authorization, want)
+	}
+	if want := "/v1/projects/-/serviceAccounts/" + serviceAccount + ":generateAccessToken"; len(iamPaths) != 1 || iamPaths[0] != want {
+		t.Errorf("IAM requests = %v, but want [%s]", iamPaths, want)
+	}
+
+	// A credentials file combined with impersonation would silently override the impersonated credentials.
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, []byte(`{"type": "service_account"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := CredentialsFile(path)
+	if err != nil {
+		t.Fatalf("CredentialsFile() = %v", err)
+	}
+	if err := NewOptions(WithCredentials(credentials, file)).Validate(); err == nil {
+		t.Errorf("Validate() = nil, but want an error for impersonation with a credentials file")
+	}
+}
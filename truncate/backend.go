@@ -0,0 +1,528 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// Key is a primary key of a row, whose elements are in the order of the key columns.
+type Key []interface{}
+
+// KeyColumn is a primary key column of a table.
+type KeyColumn struct {
+	Name        string `json:"name"`
+	SpannerType string `json:"spannerType"` // Type in GoogleSQL, e.g. INT64 or STRING(MAX).
+}
+
+// TableInfo is a table in the database returned by Backend.
+type TableInfo struct {
+	Name           string   `json:"name"`
+	ParentName     string   `json:"parentName,omitempty"`     // Parent table if the table is interleaved.
+	OnDeleteAction string   `json:"onDeleteAction,omitempty"` // ON DELETE action of the interleaving, i.e. CASCADE or NO ACTION.
+	ReferencedBy   []string `json:"referencedBy,omitempty"`   // Tables referencing the table by foreign keys.
+}
+
+// IndexInfo is a secondary index in the database returned by Backend.
+type IndexInfo struct {
+	Name       string `json:"name"`
+	TableName  string `json:"tableName"`            // Table on which the index is defined.
+	ParentName string `json:"parentName,omitempty"` // Table the index is interleaved in. Blank for a global index.
+}
+
+// PropertyGraphInfo is a property graph in the database returned by Backend.
+type PropertyGraphInfo struct {
+	Name       string   `json:"name"`
+	BaseTables []string `json:"baseTables"` // Tables backing nodes and edges of the graph.
+}
+
+// Backend executes the statements of a run against a database.
+// The planner is shared by all backends, while each backend uses its own transport.
+// Library users can implement it to add a transport or to mock execution in tests.
+type Backend interface {
+	// DatabaseName returns the name of the database in the form of projects/P/instances/I/databases/D.
+	DatabaseName() string
+	// Dialect returns the SQL dialect of statements executed by the backend.
+	Dialect() Dialect
+
+	// Tables returns all tables in the database.
+	Tables(ctx context.Context) ([]TableInfo, error)
+	// Indexes returns all secondary indexes in the database.
+	Indexes(ctx context.Context) ([]IndexInfo, error)
+	// PrimaryKeys returns primary key columns of all tables keyed by table name.
+	PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error)
+	// PropertyGraphs returns all property graphs in the database.
+	PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error)
+	// TableSizes returns approximate storage in bytes of each table from the latest table sizes statistics.
+	TableSizes(ctx context.Context) (map[string]int64, error)
+
+	// PartitionedUpdate executes the statement as Partitioned DML.
+	// Statements may have parameters, e.g. of filters, named p1, p2... for $1, $2... in PostgreSQL-dialect databases.
+	PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error
+	// Count executes the query returning a single count. A stale read may be used if staleness is positive.
+	Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error)
+	// ReadKeys executes the query returning the key columns.
+	ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error)
+	// DeleteKeys deletes the rows with the keys from the table in a single transaction.
+	// It returns the number of mutations reported by the commit statistics, or 0 if not available.
+	DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error)
+
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// newBackend creates a backend for the database according to the options.
+// A backend given by WithBackend is returned as is, and is not closed by Close.
+func newBackend(ctx context.Context, database, runID string, o *Options) (Backend, error) {
+	b, err := newBaseBackend(ctx, database, runID, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.Chaos.enabled() {
+		b = newChaosBackend(b, *o.Chaos)
+	}
+	if o.RecordFile != "" {
+		b = newRecordingBackend(b, o.RecordFile)
+	}
+	return b, nil
+}
+
+// newBaseBackend creates a backend connecting to the database without any wrappers.
+func newBaseBackend(ctx context.Context, database, runID string, o *Options) (Backend, error) {
+	if o.Backend != nil {
+		return unownedBackend{o.Backend}, nil
+	}
+	if o.ReplayFile != "" {
+		f, err := LoadFixture(o.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		return newReplayBackend(f), nil
+	}
+	if o.PGAdapter != "" {
+		b, err := NewPGAdapterBackend(o.PGAdapter, database)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to PGAdapter: %v", err)
+		}
+		return b, nil
+	}
+	client, err := newClient(ctx, database, runID, o.spannerClientOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Spanner client: %v", err)
+	}
+	b, err := newSpannerBackend(ctx, client, o.Priority, o.RequestTag)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	b.flush = func(ctx context.Context) error {
+		return flushSessions(ctx, database, runID, o)
+	}
+	return b, nil
+}
+
+// unownedBackend is a backend owned by the caller, so that closing it is left to the caller.
+type unownedBackend struct {
+	Backend
+}
+
+func (unownedBackend) Close() error {
+	return nil
+}
+
+// spannerClient returns the native client used by the backend, if any.
+func spannerClient(b Backend) (*spanner.Client, bool) {
+	for {
+		switch w := b.(type) {
+		case *spannerBackend:
+			return w.client, true
+		case unownedBackend:
+			b = w.Backend
+		case *chaosBackend:
+			b = w.Backend
+		case *recordingBackend:
+			b = w.Backend
+		case *sharedSchemaBackend:
+			b = w.Backend
+		case *cachedSchemaBackend:
+			b = w.Backend
+		default:
+			return nil, false
+		}
+	}
+}
+
+// uncachedSchema returns the backend without the wrappers serving the schema from memory or the plan cache,
+// to fetch the latest schema after it has changed during the run.
+func uncachedSchema(b Backend) Backend {
+	for {
+		switch w := b.(type) {
+		case *sharedSchemaBackend:
+			b = w.Backend
+		case *cachedSchemaBackend:
+			b = w.Backend
+		default:
+			return b
+		}
+	}
+}
+
+// spannerBackend is a backend using the native Cloud Spanner client.
+// It also works with the Cloud Spanner Emulator when SPANNER_EMULATOR_HOST is set.
+type spannerBackend struct {
+	client *spanner.Client
+	// priority is the RPC priority of requests without their own priority, e.g. reads of the schema.
+	priority Priority
+	// requestTag is attached to all requests and transactions to attribute them in query statistics if set.
+	requestTag string
+	// dialect is the dialect of the database detected when the backend is created.
+	dialect Dialect
+	// flush deletes the sessions left after the client is closed, if set.
+	flush func(ctx context.Context) error
+}
+
+// NewSpannerBackend returns a backend using the native Cloud Spanner client, detecting the dialect of the database.
+// Closing the backend closes the client.
+func NewSpannerBackend(ctx context.Context, client *spanner.Client) (Backend, error) {
+	return newSpannerBackend(ctx, client, PriorityUnspecified, "")
+}
+
+// newSpannerBackend returns a backend using the client with the default priority and request tag of requests.
+func newSpannerBackend(ctx context.Context, client *spanner.Client, priority Priority, requestTag string) (*spannerBackend, error) {
+	b := &spannerBackend{client: client, priority: priority, requestTag: requestTag}
+	if err := b.detectDialect(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// queryOptions returns the options of a query with the priority, or the priority of the backend if unspecified.
+func (b *spannerBackend) queryOptions(priority Priority) spanner.QueryOptions {
+	if priority == PriorityUnspecified {
+		priority = b.priority
+	}
+	return spanner.QueryOptions{Priority: priority.proto(), RequestTag: b.requestTag}
+}
+
+func (b *spannerBackend) DatabaseName() string {
+	return b.client.DatabaseName()
+}
+
+// detectDialect detects the dialect of the database.
+// Databases without the dialect option, e.g. on old emulators, are GoogleSQL databases.
+func (b *spannerBackend) detectDialect(ctx context.Context) error {
+	stmt := spanner.NewStatement("SELECT option_value FROM information_schema.database_options WHERE option_name = 'database_dialect'")
+	err := b.client.Single().QueryWithOptions(ctx, stmt, b.queryOptions(PriorityUnspecified)).Do(func(r *spanner.Row) error {
+		var dialect string
+		if err := r.Column(0, &dialect); err != nil {
+			return err
+		}
+		if dialect == "POSTGRESQL" {
+			b.dialect = DialectPostgreSQL
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to detect database dialect: %v", err)
+	}
+	return nil
+}
+
+func (b *spannerBackend) Dialect() Dialect {
+	return b.dialect
+}
+
+func (b *spannerBackend) Tables(ctx context.Context) ([]TableInfo, error) {
+	if b.Dialect() == DialectPostgreSQL {
+		return b.pgTables(ctx)
+	}
+	// This query fetches the table metadata and relationships in the default schema and named schemas.
+	// Tables in named schemas are qualified with the schema, e.g. sch1.Orders.
+	iter := b.client.Single().QueryWithOptions(ctx, spanner.NewStatement(`
+		WITH FKReferences AS (
+			SELECT CCU.TABLE_SCHEMA AS ReferencedSchema, CCU.TABLE_NAME AS Referenced,
+				ARRAY_AGG(IF(TC.TABLE_SCHEMA = '', TC.TABLE_NAME, CONCAT(TC.TABLE_SCHEMA, '.', TC.TABLE_NAME))) AS Referencing
+			FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS as TC
+			INNER JOIN INFORMATION_SCHEMA.CONSTRAINT_COLUMN_USAGE AS CCU ON TC.CONSTRAINT_SCHEMA = CCU.CONSTRAINT_SCHEMA AND TC.CONSTRAINT_NAME = CCU.CONSTRAINT_NAME
+			WHERE TC.TABLE_CATALOG = '' AND TC.CONSTRAINT_TYPE = 'FOREIGN KEY' AND CCU.TABLE_CATALOG = ''
+			GROUP BY CCU.TABLE_SCHEMA, CCU.TABLE_NAME
+		)
+		SELECT T.TABLE_SCHEMA, T.TABLE_NAME, T.PARENT_TABLE_NAME, T.ON_DELETE_ACTION, IF(F.Referencing IS NULL, ARRAY<STRING>[], F.Referencing) AS referencedBy
+		FROM INFORMATION_SCHEMA.TABLES AS T
+		LEFT OUTER JOIN FKReferences AS F ON T.TABLE_SCHEMA = F.ReferencedSchema AND T.TABLE_NAME = F.Referenced
+		WHERE T.TABLE_CATALOG = "" AND T.TABLE_SCHEMA NOT IN ("INFORMATION_SCHEMA", "SPANNER_SYS") AND T.TABLE_TYPE = "BASE TABLE"
+		ORDER BY T.TABLE_SCHEMA ASC, T.TABLE_NAME ASC
+	`), b.queryOptions(PriorityUnspecified))
+
+	var tables []TableInfo
+	if err := iter.Do(func(r *spanner.Row) error {
+		var (
+			schema       string
+			tableName    string
+			parent       spanner.NullString
+			deleteAction spanner.NullString
+			referencedBy []string
+		)
+		if err := r.Columns(&schema, &tableName, &parent, &deleteAction, &referencedBy); err != nil {
+			return err
+		}
+		var parentName string
+		if parent.Valid && parent.StringVal != "" {
+			// Interleaved tables are in the same schema as their parents.
+			parentName = qualifiedName(schema, parent.StringVal)
+		}
+		tables = append(tables, TableInfo{
+			Name:           qualifiedName(schema, tableName),
+			ParentName:     parentName,
+			OnDeleteAction: deleteAction.StringVal,
+			ReferencedBy:   referencedBy,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// pgTables fetches the tables of a PostgreSQL-dialect database, which does not support ARRAY_AGG.
+func (b *spannerBackend) pgTables(ctx context.Context) ([]TableInfo, error) {
+	var tables []TableInfo
+	if err := b.client.Single().QueryWithOptions(ctx, spanner.NewStatement(pgTablesSQL), b.queryOptions(PriorityUnspecified)).Do(func(r *spanner.Row) error {
+		var tableName string
+		var parent, deleteAction spanner.NullString
+		if err := r.Columns(&tableName, &parent, &deleteAction); err != nil {
+			return err
+		}
+		tables = append(tables, TableInfo{
+			Name:           tableName,
+			ParentName:     parent.StringVal,
+			OnDeleteAction: deleteAction.StringVal,
+			ReferencedBy:   []string{},
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	referencedBy := map[string][]string{}
+	if err := b.client.Single().QueryWithOptions(ctx, spanner.NewStatement(pgReferencesSQL), b.queryOptions(PriorityUnspecified)).Do(func(r *spanner.Row) error {
+		var referenced, referencing string
+		if err := r.Columns(&referenced, &referencing); err != nil {
+			return err
+		}
+		referencedBy[referenced] = append(referencedBy[referenced], referencing)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for i := range tables {
+		if refs, ok := referencedBy[tables[i].Name]; ok {
+			tables[i].ReferencedBy = refs
+		}
+	}
+	return tables, nil
+}
+
+func (b *spannerBackend) Indexes(ctx context.Context) ([]IndexInfo, error) {
+	// This query fetches defined indexes, whose tables in named schemas are qualified with the schema.
+	stmt := spanner.NewStatement(`
+		SELECT INDEX_NAME,
+			IF(TABLE_SCHEMA = '', TABLE_NAME, CONCAT(TABLE_SCHEMA, '.', TABLE_NAME)),
+			IF(TABLE_SCHEMA = '' OR PARENT_TABLE_NAME IS NULL OR PARENT_TABLE_NAME = '', PARENT_TABLE_NAME, CONCAT(TABLE_SCHEMA, '.', PARENT_TABLE_NAME))
+		FROM INFORMATION_SCHEMA.INDEXES
+		WHERE INDEX_TYPE = 'INDEX' AND TABLE_CATALOG = '' AND TABLE_SCHEMA NOT IN ('INFORMATION_SCHEMA', 'SPANNER_SYS');
+	`)
+	if b.Dialect() == DialectPostgreSQL {
+		stmt = spanner.NewStatement(pgIndexesSQL)
+	}
+	iter := b.client.Single().QueryWithOptions(ctx, stmt, b.queryOptions(PriorityUnspecified))
+
+	var indexes []IndexInfo
+	if err := iter.Do(func(r *spanner.Row) error {
+		var (
+			indexName     string
+			baseTableName string
+			parent        spanner.NullString
+		)
+		if err := r.Columns(&indexName, &baseTableName, &parent); err != nil {
+			return err
+		}
+		indexes = append(indexes, IndexInfo{
+			Name:       indexName,
+			TableName:  baseTableName,
+			ParentName: parent.StringVal,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func (b *spannerBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	return fetchPrimaryKeys(ctx, b.client, b.Dialect(), b.queryOptions(PriorityUnspecified))
+}
+
+func (b *spannerBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	// Property graphs are only available in GoogleSQL databases.
+	if b.Dialect() == DialectPostgreSQL {
+		return nil, nil
+	}
+	iter := b.client.Single().QueryWithOptions(ctx, spanner.NewStatement(`
+		SELECT PROPERTY_GRAPH_NAME, PROPERTY_GRAPH_METADATA_JSON FROM INFORMATION_SCHEMA.PROPERTY_GRAPHS
+		WHERE PROPERTY_GRAPH_CATALOG = '' AND PROPERTY_GRAPH_SCHEMA = ''
+		ORDER BY PROPERTY_GRAPH_NAME
+	`), b.queryOptions(PriorityUnspecified))
+
+	var graphs []PropertyGraphInfo
+	if err := iter.Do(func(r *spanner.Row) error {
+		var name string
+		var metadata spanner.NullJSON
+		if err := r.Columns(&name, &metadata); err != nil {
+			return err
+		}
+		// JSON values are decoded into generic values, so encode it back to decode into the structure.
+		raw, err := json.Marshal(metadata.Value)
+		if err != nil {
+			return err
+		}
+		tables, err := graphBaseTables(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse metadata of property graph %s: %v", name, err)
+		}
+		graphs = append(graphs, PropertyGraphInfo{Name: name, BaseTables: tables})
+		return nil
+	}); err != nil {
+		// Databases which do not support property graphs do not have the view.
+		if isTableNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return graphs, nil
+}
+
+func (b *spannerBackend) TableSizes(ctx context.Context) (map[string]int64, error) {
+	iter := b.client.Single().QueryWithOptions(ctx, spanner.NewStatement(`
+		SELECT TABLE_SCHEMA, TABLE_NAME, USED_BYTES FROM SPANNER_SYS.TABLE_SIZES_STATS_1HOUR
+		WHERE INTERVAL_END = (SELECT MAX(INTERVAL_END) FROM SPANNER_SYS.TABLE_SIZES_STATS_1HOUR)
+	`), b.queryOptions(PriorityUnspecified))
+
+	// Sizes are keyed by the names of Tables, which are qualified by their named schemas but not by the default schema.
+	sizes := map[string]int64{}
+	if err := iter.Do(func(r *spanner.Row) error {
+		var schema, tableName string
+		var usedBytes spanner.NullFloat64
+		if err := r.Columns(&schema, &tableName, &usedBytes); err != nil {
+			return err
+		}
+		if b.dialect == DialectPostgreSQL && schema == "public" {
+			schema = ""
+		}
+		sizes[qualifiedName(schema, tableName)] = int64(usedBytes.Float64)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+func (b *spannerBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	if _, err := b.client.PartitionedUpdateWithOptions(ctx, stmt, b.queryOptions(priority)); err != nil {
+		return err
+	}
+	// Partitioned DML does not report its commit timestamps, so that reads observing it must be strong.
+	recordUntimedCommit(ctx)
+	return nil
+}
+
+// single returns a single-use read-only transaction, which may read a stale snapshot bounded by the context.
+func (b *spannerBackend) single(ctx context.Context) *spanner.ReadOnlyTransaction {
+	txn := b.client.Single()
+	if ts, ok := minReadTimestamp(ctx); ok {
+		txn = txn.WithTimestampBound(spanner.MinReadTimestamp(ts))
+	}
+	return txn
+}
+
+// Count reads with the staleness unless the reads are bounded by the context, which already allows stale reads.
+func (b *spannerBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	txn := b.single(ctx)
+	if _, bounded := minReadTimestamp(ctx); staleness > 0 && !bounded {
+		txn = txn.WithTimestampBound(spanner.ExactStaleness(staleness))
+	}
+	var count int64
+	if err := txn.QueryWithOptions(ctx, stmt, b.queryOptions(priority)).Do(func(r *spanner.Row) error {
+		return r.Column(0, &count)
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (b *spannerBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	var keys []Key
+	if err := b.single(ctx).QueryWithOptions(ctx, stmt, b.queryOptions(priority)).Do(func(r *spanner.Row) error {
+		k, err := decodeKey(r, columns)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, Key(k))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (b *spannerBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error) {
+	keySet := make([]spanner.Key, len(keys))
+	for i, k := range keys {
+		keySet[i] = spanner.Key(k)
+	}
+	m := spanner.Delete(table, spanner.KeySetFromKeys(keySet...))
+	resp, err := b.client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		return txn.BufferWrite([]*spanner.Mutation{m})
+	}, spanner.TransactionOptions{
+		CommitOptions:  spanner.CommitOptions{ReturnCommitStats: true},
+		CommitPriority: b.queryOptions(priority).Priority,
+		TransactionTag: b.requestTag,
+	})
+	if err != nil {
+		return 0, err
+	}
+	recordCommit(ctx, resp.CommitTs)
+	return resp.CommitStats.GetMutationCount(), nil
+}
+
+func (b *spannerBackend) Close() error {
+	b.client.Close()
+	if b.flush == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), flushSessionsTimeout)
+	defer cancel()
+	if err := b.flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush sessions: %v", err)
+	}
+	return nil
+}
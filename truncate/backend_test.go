@@ -0,0 +1,157 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeBackend is a Backend deleting rows of tables held in memory.
+type fakeBackend struct {
+	tables []TableInfo
+	graphs []PropertyGraphInfo
+	sizes  map[string]int64
+
+	mu     sync.Mutex
+	rows   map[string]int64
+	closed bool
+}
+
+func (b *fakeBackend) DatabaseName() string { return "projects/p/instances/i/databases/d" }
+func (b *fakeBackend) Dialect() Dialect     { return DialectGoogleSQL }
+
+func (b *fakeBackend) Tables(ctx context.Context) ([]TableInfo, error)  { return b.tables, nil }
+func (b *fakeBackend) Indexes(ctx context.Context) ([]IndexInfo, error) { return nil, nil }
+func (b *fakeBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	return nil, nil
+}
+
+func (b *fakeBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	return b.graphs, nil
+}
+
+func (b *fakeBackend) TableSizes(ctx context.Context) (map[string]int64, error) {
+	return b.sizes, nil
+}
+
+func (b *fakeBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range b.rows {
+		if stmt.SQL == b.Dialect().deleteAllSQL(name) {
+			b.deleteAll(name)
+		}
+	}
+	return nil
+}
+
+// deleteAll deletes all rows of the table and its interleaved tables by ON DELETE CASCADE.
+func (b *fakeBackend) deleteAll(name string) {
+	b.rows[name] = 0
+	for _, t := range b.tables {
+		if t.ParentName == name && t.OnDeleteAction == "CASCADE" {
+			b.deleteAll(t.Name)
+		}
+	}
+}
+
+func (b *fakeBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, n := range b.rows {
+		if stmt.SQL == b.Dialect().countRowsSQL(name) {
+			return n, nil
+		}
+		if stmt.SQL == b.Dialect().existsSQL(name) && n > 0 {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (b *fakeBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	return nil, nil
+}
+
+func (b *fakeBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error) {
+	return 0, nil
+}
+
+func (b *fakeBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRunWithBackend(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{
+			{Name: "Singers"},
+			{Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"},
+			{Name: "Venues"},
+		},
+		rows: map[string]int64{"Singers": 10, "Albums": 20, "Venues": 30},
+	}
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithQuiet(true), WithOutput(Output{Writer: ioutil.Discard})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	var remained []string
+	for name, n := range b.rows {
+		if n != 0 {
+			remained = append(remained, name)
+		}
+	}
+	sort.Strings(remained)
+	if diff := cmp.Diff([]string(nil), remained); diff != "" {
+		t.Errorf("Run() left rows in tables (-want +got):\n%s", diff)
+	}
+	if b.closed {
+		t.Errorf("Run() closed the backend given by WithBackend")
+	}
+}
+
+func TestSpannerBackendQueryOptions(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		backend  Priority
+		priority Priority
+		want     Priority
+	}{
+		{desc: "Priority of the request", backend: PriorityLow, priority: PriorityHigh, want: PriorityHigh},
+		{desc: "Priority of the backend", backend: PriorityLow, priority: PriorityUnspecified, want: PriorityLow},
+		{desc: "Unspecified", backend: PriorityUnspecified, priority: PriorityUnspecified, want: PriorityUnspecified},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &spannerBackend{priority: tt.backend, requestTag: "spanner-truncate"}
+			opts := b.queryOptions(tt.priority)
+			if opts.Priority != tt.want.proto() {
+				t.Errorf("queryOptions(%q).Priority = %v, but want = %v", tt.priority, opts.Priority, tt.want.proto())
+			}
+			if opts.RequestTag != "spanner-truncate" {
+				t.Errorf("queryOptions(%q).RequestTag = %q, but want = %q", tt.priority, opts.RequestTag, "spanner-truncate")
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/spanner"
+)
+
+// Strategy is how rows of a table are deleted.
+type Strategy string
+
+const (
+	// StrategyPDML deletes all rows with a single Partitioned DML statement, which is not limited by mutations per commit.
+	StrategyPDML Strategy = "pdml"
+	// StrategyBatch reads primary keys in chunks and deletes them with mutations, drawing from the mutation budget if any.
+	StrategyBatch Strategy = "batch"
+)
+
+const (
+	// Maximum number of rows deleted in a single commit by StrategyBatch.
+	maxBatchRows = 1000
+	// Maximum number of mutations in a single commit allowed by Cloud Spanner.
+	maxMutationsPerCommit = 20000
+)
+
+// fetchPrimaryKeys returns primary key columns of all tables keyed by table name.
+func fetchPrimaryKeys(ctx context.Context, client *spanner.Client, dialect Dialect, opts spanner.QueryOptions) (map[string][]KeyColumn, error) {
+	stmt := spanner.NewStatement(`
+		SELECT IF(TABLE_SCHEMA = '', TABLE_NAME, CONCAT(TABLE_SCHEMA, '.', TABLE_NAME)), COLUMN_NAME, SPANNER_TYPE
+		FROM INFORMATION_SCHEMA.INDEX_COLUMNS
+		WHERE INDEX_NAME = 'PRIMARY_KEY' AND TABLE_CATALOG = '' AND TABLE_SCHEMA NOT IN ('INFORMATION_SCHEMA', 'SPANNER_SYS')
+		ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION
+	`)
+	if dialect == DialectPostgreSQL {
+		stmt = spanner.NewStatement(pgPrimaryKeysSQL)
+	}
+	iter := client.Single().QueryWithOptions(ctx, stmt, opts)
+
+	keys := map[string][]KeyColumn{}
+	if err := iter.Do(func(r *spanner.Row) error {
+		var tableName, columnName string
+		var spannerType spanner.NullString
+		if err := r.Columns(&tableName, &columnName, &spannerType); err != nil {
+			return err
+		}
+		spannerTypeName := spannerType.StringVal
+		if dialect == DialectPostgreSQL {
+			spannerTypeName = googleSQLType(spannerTypeName)
+		}
+		keys[tableName] = append(keys[tableName], KeyColumn{Name: columnName, SpannerType: spannerTypeName})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// isSupportedKeyType returns true if decodeKey can decode a key column of the type.
+func isSupportedKeyType(spannerType string) bool {
+	switch spannerType {
+	case "INT64", "FLOAT64", "BOOL", "TIMESTAMP", "DATE", "NUMERIC":
+		return true
+	}
+	return strings.HasPrefix(spannerType, "STRING") || strings.HasPrefix(spannerType, "BYTES")
+}
+
+// unchunkableReason returns why a table with the primary key columns cannot be deleted in chunks by StrategyBatch,
+// or an empty string if it can.
+func unchunkableReason(columns []KeyColumn) string {
+	if len(columns) == 0 {
+		return "no primary key columns"
+	}
+	for _, c := range columns {
+		if !isSupportedKeyType(c.SpannerType) {
+			return fmt.Sprintf("key column %s has unsupported type %s", c.Name, c.SpannerType)
+		}
+	}
+	return ""
+}
+
+// batchRows returns the number of rows deleted in a single commit so that a commit fits in the budget per second.
+func batchRows(mutationsPerSecond, mutationsPerRow int) int {
+	rows := maxBatchRows
+	if r := maxMutationsPerCommit / mutationsPerRow; r < rows {
+		rows = r
+	}
+	if mutationsPerSecond > 0 {
+		if r := mutationsPerSecond / mutationsPerRow; r < rows {
+			rows = r
+		}
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return rows
+}
+
+// decodeKey returns the primary key of the row whose columns are the key columns.
+func decodeKey(r *spanner.Row, columns []KeyColumn) (spanner.Key, error) {
+	key := make(spanner.Key, 0, len(columns))
+	for i, c := range columns {
+		var err error
+		switch t := c.SpannerType; {
+		case t == "INT64":
+			var v spanner.NullInt64
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case t == "FLOAT64":
+			var v spanner.NullFloat64
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case t == "BOOL":
+			var v spanner.NullBool
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case strings.HasPrefix(t, "STRING"):
+			var v spanner.NullString
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case strings.HasPrefix(t, "BYTES"):
+			var v []byte
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case t == "TIMESTAMP":
+			var v spanner.NullTime
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case t == "DATE":
+			var v spanner.NullDate
+			err = r.Column(i, &v)
+			key = append(key, v)
+		case t == "NUMERIC":
+			var v spanner.NullNumeric
+			err = r.Column(i, &v)
+			key = append(key, v)
+		default:
+			return nil, fmt.Errorf("unsupported type of key column %s: %s", c.Name, t)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// deleteRowsInBatches deletes rows by reading primary keys in chunks and deleting them with mutations.
+func (d *deleter) deleteRowsInBatches(ctx context.Context) error {
+	var columns []string
+	for _, c := range d.keyColumns {
+		columns = append(columns, c.Name)
+	}
+	var perSecond int
+	if d.budget != nil {
+		perSecond = d.budget.perSecond
+	}
+	limit := batchRows(perSecond, d.mutationsPerRow)
+	if d.rowBudget != nil && d.rowBudget.perSecond < limit {
+		limit = d.rowBudget.perSecond
+	}
+	stmt := filterStatement(d.backend.Dialect().selectMatchingKeysSQL(d.tableName, columns, d.filter, limit), d.params)
+	d.stmtLog.log(d.tableName, stmt)
+
+	for chunk := 1; ; chunk++ {
+		atomic.StoreInt64(&d.chunk, int64(chunk))
+		finished := inflight.start(d, stmt.SQL)
+		keys, err := d.backend.ReadKeys(ctx, stmt, d.keyColumns, d.priority)
+		finished()
+		if err != nil {
+			// The error is kept as is, so that the table dropped concurrently is told by its code.
+			if isTableNotFound(err) {
+				return err
+			}
+			return fmt.Errorf("failed to read keys of %s: %v", d.tableName, err)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if err := d.rowBudget.wait(ctx, len(keys)); err != nil {
+			return err
+		}
+		if err := d.budget.wait(ctx, len(keys)*d.mutationsPerRow); err != nil {
+			return err
+		}
+		if err := d.failpoints.check(failpointBeforeCommit, d.tableName, chunk); err != nil {
+			return err
+		}
+		mutations, err := d.backend.DeleteKeys(ctx, d.tableName, d.keyColumns, keys, d.priority)
+		if err != nil {
+			return fmt.Errorf("failed to delete rows of %s: %v", d.tableName, err)
+		}
+		atomic.AddInt64(&d.keysDeleted, int64(len(keys)))
+		atomic.AddInt64(&d.mutations, mutations)
+		if err := d.failpoints.check(failpointAfterCommit, d.tableName, chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// amplification returns lines describing mutations per deleted row of the tables deleted in chunks,
+// which shows how much secondary indexes amplify deletes.
+func amplification(tables []*table) []string {
+	var lines []string
+	for _, t := range tables {
+		d := t.deleter
+		mutations := atomic.LoadInt64(&d.mutations)
+		rows := d.deletedRows()
+		if d.strategy != StrategyBatch || mutations == 0 || rows == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s mutations for %s rows (%.1f per row)", t.tableName, formatNumber(uint64(mutations)), formatNumber(rows), float64(mutations)/float64(rows)))
+	}
+	return lines
+}
@@ -0,0 +1,129 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBatchRows(t *testing.T) {
+	for _, tt := range []struct {
+		desc               string
+		mutationsPerSecond int
+		mutationsPerRow    int
+		want               int
+	}{
+		{desc: "Unlimited budget", mutationsPerSecond: 0, mutationsPerRow: 1, want: 1000},
+		{desc: "Large budget", mutationsPerSecond: 100000, mutationsPerRow: 3, want: 1000},
+		{desc: "Limited by budget", mutationsPerSecond: 900, mutationsPerRow: 3, want: 300},
+		{desc: "Limited by mutations per commit", mutationsPerSecond: 0, mutationsPerRow: 40, want: 500},
+		{desc: "Budget smaller than a row", mutationsPerSecond: 2, mutationsPerRow: 3, want: 1},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := batchRows(tt.mutationsPerSecond, tt.mutationsPerRow); got != tt.want {
+				t.Errorf("batchRows(%d, %d) = %d, but want = %d", tt.mutationsPerSecond, tt.mutationsPerRow, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnchunkableReason(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		columns []KeyColumn
+		want    string
+	}{
+		{desc: "Supported types", columns: []KeyColumn{{Name: "Id", SpannerType: "INT64"}, {Name: "Name", SpannerType: "STRING(MAX)"}, {Name: "Hash", SpannerType: "BYTES(32)"}}, want: ""},
+		{desc: "No key columns", columns: nil, want: "no primary key columns"},
+		{desc: "Unsupported type", columns: []KeyColumn{{Name: "Id", SpannerType: "INT64"}, {Name: "Point", SpannerType: "PROTO<Point>"}}, want: "key column Point has unsupported type PROTO<Point>"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := unchunkableReason(tt.columns); got != tt.want {
+				t.Errorf("unchunkableReason() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmplification(t *testing.T) {
+	tables := []*table{
+		{tableName: "Singers", deleter: &deleter{strategy: StrategyBatch, keysDeleted: 6000, mutations: 18000}},
+		{tableName: "Albums", deleter: &deleter{strategy: StrategyPDML, totalRows: 100}},
+		{tableName: "Songs", deleter: &deleter{strategy: StrategyBatch}},
+	}
+	want := []string{"  Singers: 18,000 mutations for 6,000 rows (3.0 per row)"}
+	if diff := cmp.Diff(want, amplification(tables)); diff != "" {
+		t.Errorf("amplification() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// keysBackend holds rows whose keys are read and deleted in chunks.
+type keysBackend struct {
+	fakeBackend
+	remaining int
+	sqls      []string
+}
+
+func (b *keysBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	b.sqls = append(b.sqls, stmt.SQL)
+	var limit int
+	fmt.Sscanf(stmt.SQL[strings.LastIndex(stmt.SQL, "LIMIT "):], "LIMIT %d", &limit)
+	var keys []Key
+	for i := 0; i < limit && i < b.remaining; i++ {
+		keys = append(keys, Key{int64(i)})
+	}
+	return keys, nil
+}
+
+func (b *keysBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error) {
+	b.remaining -= len(keys)
+	return int64(len(keys)), nil
+}
+
+func TestDeleteRowsInBatchesRowBudget(t *testing.T) {
+	var clock fakeClock
+	b := &keysBackend{remaining: 150}
+	d := &deleter{
+		tableName:       "Singers",
+		backend:         b,
+		strategy:        StrategyBatch,
+		keyColumns:      []KeyColumn{{Name: "SingerId", SpannerType: "INT64"}},
+		mutationsPerRow: 1,
+		rowBudget:       clock.install(newMutationBudget(100)),
+	}
+	if err := d.deleteRowsInBatches(context.Background()); err != nil {
+		t.Fatalf("deleteRowsInBatches() failed: %v", err)
+	}
+	// Chunks are limited by the budget, and the second chunk of 50 rows waits for half of the budget to be refilled.
+	if want := "SELECT `SingerId` FROM `Singers` LIMIT 100"; b.sqls[0] != want {
+		t.Errorf("deleteRowsInBatches() read keys by %q, but want = %q", b.sqls[0], want)
+	}
+	if got, want := clock.elapsed(), 500*time.Millisecond; got != want {
+		t.Errorf("deleteRowsInBatches() waited for %s, but want = %s", got, want)
+	}
+	if got := atomic.LoadInt64(&d.keysDeleted); got != 150 {
+		t.Errorf("deleteRowsInBatches() deleted %d rows, but want = %d", got, 150)
+	}
+}
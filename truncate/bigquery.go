@@ -0,0 +1,105 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// parseBigQueryTable parses "project.dataset.table" or "dataset.table".
+// If the project is omitted, defaultProject is used.
+func parseBigQueryTable(s, defaultProject string) (project, dataset, table string, err error) {
+	parts := strings.Split(s, ".")
+	switch len(parts) {
+	case 2:
+		project, dataset, table = defaultProject, parts[0], parts[1]
+	case 3:
+		project, dataset, table = parts[0], parts[1], parts[2]
+	default:
+		return "", "", "", fmt.Errorf("invalid BigQuery table %q, must be project.dataset.table or dataset.table", s)
+	}
+	if project == "" || dataset == "" || table == "" {
+		return "", "", "", fmt.Errorf("invalid BigQuery table %q, must be project.dataset.table or dataset.table", s)
+	}
+	return project, dataset, table, nil
+}
+
+// writeBigQueryResults streams per-table results of the report into the BigQuery table.
+// The table must have the columns run_id, database, table_name, rows_deleted, duration_ms, strategy, status, error and finished_at.
+func writeBigQueryResults(ctx context.Context, tableRef string, r *report) error {
+	project, dataset, table, err := parseBigQueryTable(tableRef, projectOf(r.database))
+	if err != nil {
+		return err
+	}
+
+	service, err := bigquery.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+
+	finishedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	req := &bigquery.TableDataInsertAllRequest{}
+	for _, t := range r.tables {
+		var errMsg interface{}
+		if t.err != nil {
+			errMsg = t.err.Error()
+		}
+		req.Rows = append(req.Rows, &bigquery.TableDataInsertAllRequestRows{
+			InsertId: r.runID + "/" + t.table,
+			Json: map[string]bigquery.JsonValue{
+				"run_id":       r.runID,
+				"database":     r.database,
+				"table_name":   t.table,
+				"rows_deleted": t.rowsDeleted,
+				"duration_ms":  t.duration.Milliseconds(),
+				"strategy":     t.strategy,
+				"status":       t.status,
+				"error":        errMsg,
+				"finished_at":  finishedAt,
+			},
+		})
+	}
+
+	resp, err := service.Tabledata.InsertAll(project, dataset, table, req).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to insert results into BigQuery: %v", err)
+	}
+	if len(resp.InsertErrors) > 0 {
+		var msgs []string
+		for _, e := range resp.InsertErrors {
+			for _, ep := range e.Errors {
+				msgs = append(msgs, ep.Message)
+			}
+		}
+		return fmt.Errorf("failed to insert %d results into BigQuery: %s", len(resp.InsertErrors), strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// projectOf returns the project ID of the database name like "projects/p/instances/i/databases/d".
+func projectOf(database string) string {
+	parts := strings.Split(database, "/")
+	if len(parts) < 2 || parts[0] != "projects" {
+		return ""
+	}
+	return parts[1]
+}
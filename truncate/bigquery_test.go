@@ -0,0 +1,48 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "testing"
+
+func TestParseBigQueryTable(t *testing.T) {
+	for _, tt := range []struct {
+		input   string
+		want    [3]string
+		wantErr bool
+	}{
+		{input: "p.d.t", want: [3]string{"p", "d", "t"}},
+		{input: "d.t", want: [3]string{"default", "d", "t"}},
+		{input: "t", wantErr: true},
+		{input: "p..t", wantErr: true},
+		{input: "a.b.c.d", wantErr: true},
+	} {
+		project, dataset, table, err := parseBigQueryTable(tt.input, "default")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBigQueryTable(%q) succeeded, but want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBigQueryTable(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got := [3]string{project, dataset, table}; got != tt.want {
+			t.Errorf("parseBigQueryTable(%q) = %v, but want = %v", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Commit timestamps and read bounds are passed with contexts, so that backends can bound reads without changing the Backend interface.
+// Backends which do not support them simply ignore them, and their reads are strong.
+
+type commitRecorderKey struct{}
+type minReadTimestampKey struct{}
+
+// commitRecorder records the latest commit timestamp of deletes.
+type commitRecorder struct {
+	mu     sync.Mutex
+	latest time.Time
+	// strong is true if some deletes did not report their commit timestamps, so that only strong reads observe them.
+	strong bool
+}
+
+// record updates the latest commit timestamp if the timestamp is later.
+func (r *commitRecorder) record(ts time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ts.After(r.latest) {
+		r.latest = ts
+	}
+}
+
+// timestamp returns the latest commit timestamp, or the zero time if nothing has been committed.
+func (r *commitRecorder) timestamp() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latest
+}
+
+// requiresStrongReads returns true if some deletes were committed without their timestamps.
+func (r *commitRecorder) requiresStrongReads() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.strong
+}
+
+// withCommitRecorder returns a context whose commits are recorded by the recorder.
+func withCommitRecorder(ctx context.Context, r *commitRecorder) context.Context {
+	return context.WithValue(ctx, commitRecorderKey{}, r)
+}
+
+// recordCommit records the commit timestamp to the recorder of the context if any.
+func recordCommit(ctx context.Context, ts time.Time) {
+	if r, ok := ctx.Value(commitRecorderKey{}).(*commitRecorder); ok {
+		r.record(ts)
+	}
+}
+
+// recordUntimedCommit records to the recorder of the context if any that deletes were committed without their timestamps,
+// e.g. by Partitioned DML, so that reads are not bounded by the commit timestamps of other deletes.
+func recordUntimedCommit(ctx context.Context) {
+	if r, ok := ctx.Value(commitRecorderKey{}).(*commitRecorder); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.strong = true
+	}
+}
+
+// withMinReadTimestamp returns a context whose reads may be stale, but not older than the timestamp.
+// Such reads can be served by replicas while observing all commits until the timestamp.
+func withMinReadTimestamp(ctx context.Context, ts time.Time) context.Context {
+	if ts.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, minReadTimestampKey{}, ts)
+}
+
+// minReadTimestamp returns the minimum timestamp of reads of the context, if bounded.
+func minReadTimestamp(ctx context.Context) (time.Time, bool) {
+	ts, ok := ctx.Value(minReadTimestampKey{}).(time.Time)
+	return ts, ok
+}
+
+// readBound returns the minimum timestamp of reads of the table which observe its deletion,
+// i.e. the last commit of the table, or the latest commit of the run if the table has no commits of its own,
+// e.g. when it was deleted by cascade. It returns the zero time if nothing has been committed,
+// or if the deletes which the reads must observe were committed without their timestamps.
+func readBound(t *table, tables []*table) time.Time {
+	if t.deleter.lastCommit.requiresStrongReads() {
+		return time.Time{}
+	}
+	if ts := t.deleter.lastCommit.timestamp(); !ts.IsZero() {
+		return ts
+	}
+	var latest time.Time
+	for _, other := range flattenTables(tables) {
+		if other.deleter.lastCommit.requiresStrongReads() {
+			return time.Time{}
+		}
+		if ts := other.deleter.lastCommit.timestamp(); ts.After(latest) {
+			latest = ts
+		}
+	}
+	return latest
+}
@@ -0,0 +1,122 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+func TestCommitRecorder(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var r commitRecorder
+	ctx := context.Background()
+	// Commits are ignored without a recorder.
+	recordCommit(ctx, ts)
+	recordUntimedCommit(ctx)
+
+	ctx = withCommitRecorder(ctx, &r)
+	recordCommit(ctx, ts.Add(time.Second))
+	recordCommit(ctx, ts)
+	if got := r.timestamp(); !got.Equal(ts.Add(time.Second)) {
+		t.Errorf("timestamp() = %v, but want = %v", got, ts.Add(time.Second))
+	}
+	if r.requiresStrongReads() {
+		t.Errorf("requiresStrongReads() = true, but want false before untimed commits")
+	}
+	recordUntimedCommit(ctx)
+	if !r.requiresStrongReads() {
+		t.Errorf("requiresStrongReads() = false, but want true after an untimed commit")
+	}
+}
+
+func TestMinReadTimestamp(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := minReadTimestamp(withMinReadTimestamp(ctx, time.Time{})); ok {
+		t.Errorf("minReadTimestamp() is bounded by the zero time, but want a strong read")
+	}
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got, ok := minReadTimestamp(withMinReadTimestamp(ctx, ts)); !ok || !got.Equal(ts) {
+		t.Errorf("minReadTimestamp() = %v, %t, but want = %v, true", got, ok, ts)
+	}
+}
+
+func TestReadBound(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	parent := &table{tableName: "Singers", deleter: &deleter{}}
+	child := &table{tableName: "Albums", deleter: &deleter{}, parentTableName: "Singers"}
+	parent.childTables = []*table{child}
+	tables := []*table{parent}
+
+	if got := readBound(child, tables); !got.IsZero() {
+		t.Errorf("readBound() = %v, but want the zero time before commits", got)
+	}
+	parent.deleter.lastCommit.record(ts)
+	// The child deleted by cascade is bounded by the latest commit of the run.
+	if got := readBound(child, tables); !got.Equal(ts) {
+		t.Errorf("readBound() = %v, but want = %v", got, ts)
+	}
+	child.deleter.lastCommit.record(ts.Add(-time.Second))
+	if got := readBound(child, tables); !got.Equal(ts.Add(-time.Second)) {
+		t.Errorf("readBound() = %v, but want = %v", got, ts.Add(-time.Second))
+	}
+
+	// Deletes by Partitioned DML are observed only by strong reads.
+	parent.deleter.lastCommit.strong = true
+	if got := readBound(parent, tables); !got.IsZero() {
+		t.Errorf("readBound() = %v, but want the zero time after Partitioned DML", got)
+	}
+	child.deleter.lastCommit = commitRecorder{}
+	if got := readBound(child, tables); !got.IsZero() {
+		t.Errorf("readBound() = %v, but want the zero time of the child deleted by cascade", got)
+	}
+}
+
+// boundCapturingBackend is a fake backend capturing the minimum read timestamp of counts.
+type boundCapturingBackend struct {
+	*fakeBackend
+	bound time.Time
+}
+
+func (b *boundCapturingBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	b.bound, _ = minReadTimestamp(ctx)
+	return b.fakeBackend.Count(ctx, stmt, staleness, priority)
+}
+
+func TestCountRowsBounded(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &boundCapturingBackend{fakeBackend: &fakeBackend{}}
+	d := &deleter{tableName: "Singers", backend: b, boundedReads: true}
+	d.lastCommit.record(ts)
+
+	// Stale counts, e.g. of the progress, are bounded by the last commit, while verification is strong.
+	if _, err := d.countRows(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !b.bound.Equal(ts) {
+		t.Errorf("countRows() is bounded by %v, but want = %v", b.bound, ts)
+	}
+	if _, err := d.countRows(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !b.bound.IsZero() {
+		t.Errorf("countRows() is bounded by %v, but want a strong read", b.bound)
+	}
+}
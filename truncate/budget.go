@@ -0,0 +1,92 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// mutationBudget is a budget of mutations per second shared by all deleters of a run, as a token bucket.
+// Mutations, amplified by secondary indexes, are what actually drive CPU usage of Cloud Spanner.
+// The same bucket limits deleted rows per second by --max-rows-per-sec.
+type mutationBudget struct {
+	perSecond int
+	// now and after are the clock of the budget, replaced in tests.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	mu        sync.Mutex
+	available float64
+	updatedAt time.Time
+	consumed  int64
+	startedAt time.Time
+}
+
+func newMutationBudget(perSecond int) *mutationBudget {
+	now := time.Now()
+	return &mutationBudget{perSecond: perSecond, now: time.Now, after: time.After, available: float64(perSecond), updatedAt: now, startedAt: now}
+}
+
+// wait blocks until n mutations are available in the budget and consumes them.
+// Requests larger than the budget per second wait for the full budget and go into debt.
+// A nil budget is unlimited.
+func (b *mutationBudget) wait(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	need := float64(n)
+	if need > float64(b.perSecond) {
+		need = float64(b.perSecond)
+	}
+
+	for {
+		b.mu.Lock()
+		now := b.now()
+		b.available += now.Sub(b.updatedAt).Seconds() * float64(b.perSecond)
+		if b.available > float64(b.perSecond) {
+			b.available = float64(b.perSecond)
+		}
+		b.updatedAt = now
+		if b.available >= need {
+			b.available -= float64(n)
+			b.consumed += int64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - b.available) / float64(b.perSecond) * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-b.after(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rate returns the average number of mutations consumed per second since the budget was created.
+func (b *mutationBudget) rate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	elapsed := b.now().Sub(b.startedAt).Seconds()
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	return float64(b.consumed) / elapsed
+}
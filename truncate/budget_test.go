@@ -0,0 +1,89 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock of a budget whose waits advance the time immediately, so that throttling is tested without sleeping.
+type fakeClock struct {
+	mu      sync.Mutex
+	current time.Time
+	waited  time.Duration
+}
+
+// install replaces the clock of the budget with the fake clock.
+func (c *fakeClock) install(b *mutationBudget) *mutationBudget {
+	c.current = b.startedAt
+	b.now, b.after = c.now, c.after
+	return b
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = c.current.Add(d)
+	c.waited += d
+	ch := make(chan time.Time, 1)
+	ch <- c.current
+	return ch
+}
+
+func (c *fakeClock) elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waited
+}
+
+func TestMutationBudget(t *testing.T) {
+	ctx := context.Background()
+	var clock fakeClock
+	b := clock.install(newMutationBudget(1000))
+
+	// The budget for the first second is available immediately.
+	if err := b.wait(ctx, 1000); err != nil {
+		t.Fatalf("wait() failed: %v", err)
+	}
+	if got := clock.elapsed(); got != 0 {
+		t.Errorf("wait() waited for %s, but want immediate", got)
+	}
+
+	// Further mutations wait for the budget to be refilled.
+	if err := b.wait(ctx, 100); err != nil {
+		t.Fatalf("wait() failed: %v", err)
+	}
+	if got, want := clock.elapsed(), 100*time.Millisecond; got != want {
+		t.Errorf("wait() waited for %s, but want = %s", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	b.after = func(time.Duration) <-chan time.Time { return nil }
+	if err := b.wait(ctx, 1000); err == nil {
+		t.Errorf("wait() with canceled context succeeded, but want error")
+	}
+}
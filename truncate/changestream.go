@@ -0,0 +1,215 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+// ChangeStreamDrain waits until no new delete records have appeared in a change stream for a quiet period,
+// so that downstream consumers of the change stream keep up before the next stage starts.
+type ChangeStreamDrain struct {
+	// ChangeStream is the name of the change stream to watch.
+	ChangeStream string `json:"changeStream"`
+
+	// QuietPeriod is how long no delete records must appear, e.g. "1m".
+	QuietPeriod string `json:"quietPeriod"`
+}
+
+// Interval to poll a change stream while draining.
+const changeStreamPollInterval = 5 * time.Second
+
+var changeStreamNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+func (d ChangeStreamDrain) validate() error {
+	if !changeStreamNameRe.MatchString(d.ChangeStream) {
+		return fmt.Errorf("invalid change stream name %q", d.ChangeStream)
+	}
+	quiet, err := time.ParseDuration(d.QuietPeriod)
+	if err != nil {
+		return fmt.Errorf("invalid quietPeriod: %v", err)
+	}
+	if quiet <= 0 {
+		return fmt.Errorf("quietPeriod must be positive: %s", d.QuietPeriod)
+	}
+	return nil
+}
+
+// String returns a human readable description of the drain.
+func (d ChangeStreamDrain) String() string {
+	return fmt.Sprintf("change stream %s to be quiet for %s", d.ChangeStream, d.QuietPeriod)
+}
+
+// drainChangeStream blocks until no delete records have appeared in the change stream for the quiet period since the given time.
+func drainChangeStream(ctx context.Context, client *spanner.Client, d ChangeStreamDrain, since time.Time, opts spanner.QueryOptions) error {
+	quiet, err := time.ParseDuration(d.QuietPeriod)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(changeStreamPollInterval)
+	defer ticker.Stop()
+	lastDelete, from := since, since
+	for {
+		end := time.Now()
+		latest, err := latestDeleteRecord(ctx, client, d.ChangeStream, from, end, opts)
+		if err != nil {
+			return fmt.Errorf("failed to read change stream %s: %v", d.ChangeStream, err)
+		}
+		if latest.After(lastDelete) {
+			lastDelete = latest
+		}
+		from = end
+		if end.Sub(lastDelete) >= quiet {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// latestDeleteRecord returns the commit timestamp of the latest delete record in the change stream between start and end,
+// or the zero time if there are no delete records. All partitions of the change stream in the time range are read.
+func latestDeleteRecord(ctx context.Context, client *spanner.Client, stream string, start, end time.Time, opts spanner.QueryOptions) (time.Time, error) {
+	type partition struct {
+		token spanner.NullString
+		start time.Time
+	}
+	queue := []partition{{start: start}}
+	seen := map[string]bool{}
+
+	var latest time.Time
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if !p.start.Before(end) {
+			continue
+		}
+
+		stmt := spanner.Statement{
+			SQL: fmt.Sprintf("SELECT ChangeRecord FROM READ_%s (start_timestamp => @start, end_timestamp => @end, partition_token => @token, heartbeat_milliseconds => 10000)", stream),
+			Params: map[string]interface{}{
+				"start": p.start,
+				"end":   end,
+				"token": p.token,
+			},
+		}
+		if err := client.Single().QueryWithOptions(ctx, stmt, opts).Do(func(r *spanner.Row) error {
+			var record spanner.GenericColumnValue
+			if err := r.Column(0, &record); err != nil {
+				return err
+			}
+			changes, err := parseChangeRecord(record)
+			if err != nil {
+				return err
+			}
+			if changes.latestDelete.After(latest) {
+				latest = changes.latestDelete
+			}
+			for _, c := range changes.children {
+				if !seen[c.token] {
+					seen[c.token] = true
+					queue = append(queue, partition{token: spanner.NullString{StringVal: c.token, Valid: true}, start: c.start})
+				}
+			}
+			return nil
+		}); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return latest, nil
+}
+
+// childPartition is a partition of a change stream which starts at start.
+type childPartition struct {
+	token string
+	start time.Time
+}
+
+// changeRecordSummary is what drainChangeStream needs from change records.
+type changeRecordSummary struct {
+	latestDelete time.Time
+	children     []childPartition
+}
+
+// parseChangeRecord parses a ChangeRecord column, which is an array of structs holding data change records,
+// heartbeat records and child partitions records.
+func parseChangeRecord(record spanner.GenericColumnValue) (changeRecordSummary, error) {
+	var summary changeRecordSummary
+	elemType, elems := arrayElements(record.Type, record.Value)
+	for _, elem := range elems {
+		dataType, dataValue := structField(elemType, elem, "data_change_record")
+		dataElemType, dataRecords := arrayElements(dataType, dataValue)
+		for _, data := range dataRecords {
+			if _, modType := structField(dataElemType, data, "mod_type"); modType.GetStringValue() != "DELETE" {
+				continue
+			}
+			_, ts := structField(dataElemType, data, "commit_timestamp")
+			commitTimestamp, err := time.Parse(time.RFC3339Nano, ts.GetStringValue())
+			if err != nil {
+				return summary, fmt.Errorf("invalid commit_timestamp of data change record: %v", err)
+			}
+			if commitTimestamp.After(summary.latestDelete) {
+				summary.latestDelete = commitTimestamp
+			}
+		}
+
+		childType, childValue := structField(elemType, elem, "child_partitions_record")
+		childElemType, childRecords := arrayElements(childType, childValue)
+		for _, child := range childRecords {
+			_, ts := structField(childElemType, child, "start_timestamp")
+			start, err := time.Parse(time.RFC3339Nano, ts.GetStringValue())
+			if err != nil {
+				return summary, fmt.Errorf("invalid start_timestamp of child partitions record: %v", err)
+			}
+			partitionsType, partitionsValue := structField(childElemType, child, "child_partitions")
+			partitionType, partitions := arrayElements(partitionsType, partitionsValue)
+			for _, p := range partitions {
+				_, token := structField(partitionType, p, "token")
+				summary.children = append(summary.children, childPartition{token: token.GetStringValue(), start: start})
+			}
+		}
+	}
+	return summary, nil
+}
+
+// arrayElements returns the element type and the elements of an ARRAY value.
+func arrayElements(t *sppb.Type, v *proto3.Value) (*sppb.Type, []*proto3.Value) {
+	return t.GetArrayElementType(), v.GetListValue().GetValues()
+}
+
+// structField returns the type and the value of the named field of a STRUCT value, or nils if not found.
+func structField(t *sppb.Type, v *proto3.Value, name string) (*sppb.Type, *proto3.Value) {
+	values := v.GetListValue().GetValues()
+	for i, f := range t.GetStructType().GetFields() {
+		if f.GetName() == name && i < len(values) {
+			return f.GetType(), values[i]
+		}
+	}
+	return nil, nil
+}
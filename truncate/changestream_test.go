@@ -0,0 +1,83 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	"github.com/google/go-cmp/cmp"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+func TestParseChangeRecord(t *testing.T) {
+	field := func(name string, t *sppb.Type) *sppb.StructType_Field {
+		return &sppb.StructType_Field{Name: name, Type: t}
+	}
+	structType := func(fields ...*sppb.StructType_Field) *sppb.Type {
+		return &sppb.Type{Code: sppb.TypeCode_STRUCT, StructType: &sppb.StructType{Fields: fields}}
+	}
+	arrayType := func(elem *sppb.Type) *sppb.Type { return &sppb.Type{Code: sppb.TypeCode_ARRAY, ArrayElementType: elem} }
+	stringType := &sppb.Type{Code: sppb.TypeCode_STRING}
+	timestampType := &sppb.Type{Code: sppb.TypeCode_TIMESTAMP}
+	list := func(values ...*proto3.Value) *proto3.Value {
+		return &proto3.Value{Kind: &proto3.Value_ListValue{ListValue: &proto3.ListValue{Values: values}}}
+	}
+	str := func(s string) *proto3.Value { return &proto3.Value{Kind: &proto3.Value_StringValue{StringValue: s}} }
+
+	dataChangeRecord := structType(field("commit_timestamp", timestampType), field("table_name", stringType), field("mod_type", stringType))
+	partitionType := structType(field("token", stringType), field("parent_partition_tokens", arrayType(stringType)))
+	childPartitionsRecord := structType(field("start_timestamp", timestampType), field("record_sequence", stringType), field("child_partitions", arrayType(partitionType)))
+	record := spanner.GenericColumnValue{
+		Type: arrayType(structType(
+			field("data_change_record", arrayType(dataChangeRecord)),
+			field("heartbeat_record", arrayType(structType(field("timestamp", timestampType)))),
+			field("child_partitions_record", arrayType(childPartitionsRecord)),
+		)),
+		Value: list(
+			list(
+				list(
+					list(str("2021-09-01T00:00:02Z"), str("Events"), str("DELETE")),
+					list(str("2021-09-01T00:00:03Z"), str("Events"), str("INSERT")),
+					list(str("2021-09-01T00:00:01Z"), str("Events"), str("DELETE")),
+				),
+				list(),
+				list(),
+			),
+			list(
+				list(),
+				list(),
+				list(list(str("2021-09-01T00:00:04Z"), str("00000001"), list(list(str("token1"), list()), list(str("token2"), list(str("token0")))))),
+			),
+		),
+	}
+
+	got, err := parseChangeRecord(record)
+	if err != nil {
+		t.Fatalf("parseChangeRecord() failed: %v", err)
+	}
+	if want := time.Date(2021, 9, 1, 0, 0, 2, 0, time.UTC); !got.latestDelete.Equal(want) {
+		t.Errorf("parseChangeRecord() latest delete = %s, but want = %s", got.latestDelete, want)
+	}
+	start := time.Date(2021, 9, 1, 0, 0, 4, 0, time.UTC)
+	want := []childPartition{{token: "token1", start: start}, {token: "token2", start: start}}
+	if diff := cmp.Diff(want, got.children, cmp.AllowUnexported(childPartition{})); diff != "" {
+		t.Errorf("parseChangeRecord() children mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,126 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Chaos injects simulated failures and delays into statements executed by a run,
+// so that behavior under outages can be tested without real outages.
+type Chaos struct {
+	// FailureRate is the probability that a statement fails with ABORTED or UNAVAILABLE, between 0 and 1.
+	FailureRate float64
+	// MaxDelay is the maximum delay randomly injected before each statement.
+	MaxDelay time.Duration
+	// Seed of the random source, to reproduce the same failures. Zero means a random seed.
+	Seed int64
+}
+
+// enabled returns true if the chaos injects anything.
+func (c *Chaos) enabled() bool {
+	return c != nil && (c.FailureRate > 0 || c.MaxDelay > 0)
+}
+
+func (c *Chaos) validate() error {
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return fmt.Errorf("chaos failure rate must be between 0 and 1, but %v", c.FailureRate)
+	}
+	if c.MaxDelay < 0 {
+		return fmt.Errorf("chaos max delay must not be negative")
+	}
+	return nil
+}
+
+// chaosBackend is a backend injecting failures and delays into statements of the underlying backend.
+// Schema queries are not affected, so that planning is not disturbed.
+type chaosBackend struct {
+	Backend
+	chaos Chaos
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newChaosBackend(b Backend, chaos Chaos) *chaosBackend {
+	seed := chaos.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaosBackend{Backend: b, chaos: chaos, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// inject sleeps for a random delay and returns a simulated error with the failure rate.
+func (b *chaosBackend) inject(ctx context.Context, op string) error {
+	b.mu.Lock()
+	var delay time.Duration
+	if b.chaos.MaxDelay > 0 {
+		delay = time.Duration(b.rnd.Int63n(int64(b.chaos.MaxDelay)))
+	}
+	fail := b.rnd.Float64() < b.chaos.FailureRate
+	code := codes.Aborted
+	if b.rnd.Intn(2) == 0 {
+		code = codes.Unavailable
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if fail {
+		return grpcstatus.Errorf(code, "chaos: injected failure of %s", op)
+	}
+	return nil
+}
+
+func (b *chaosBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	if err := b.inject(ctx, "partitioned update"); err != nil {
+		return err
+	}
+	return b.Backend.PartitionedUpdate(ctx, stmt, priority)
+}
+
+func (b *chaosBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	if err := b.inject(ctx, "count"); err != nil {
+		return 0, err
+	}
+	return b.Backend.Count(ctx, stmt, staleness, priority)
+}
+
+func (b *chaosBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	if err := b.inject(ctx, "read keys"); err != nil {
+		return nil, err
+	}
+	return b.Backend.ReadKeys(ctx, stmt, columns, priority)
+}
+
+func (b *chaosBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error) {
+	if err := b.inject(ctx, "delete keys"); err != nil {
+		return 0, err
+	}
+	return b.Backend.DeleteKeys(ctx, table, columns, keys, priority)
+}
@@ -0,0 +1,69 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestChaosBackend(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeBackend{rows: map[string]int64{"Singers": 10}}
+	stmt := spanner.NewStatement(DialectGoogleSQL.countRowsSQL("Singers"))
+
+	b := newChaosBackend(fake, Chaos{FailureRate: 1, Seed: 1})
+	_, err := b.Count(ctx, stmt, 0, PriorityUnspecified)
+	if code := grpcstatus.Code(err); code != codes.Aborted && code != codes.Unavailable {
+		t.Errorf("Count() with failure rate 1 = %v, but want ABORTED or UNAVAILABLE", err)
+	}
+
+	b = newChaosBackend(fake, Chaos{FailureRate: 0, Seed: 1})
+	count, err := b.Count(ctx, stmt, 0, PriorityUnspecified)
+	if err != nil {
+		t.Fatalf("Count() with failure rate 0 = %v, but want nil", err)
+	}
+	if count != 10 {
+		t.Errorf("Count() = %d, but want = %d", count, 10)
+	}
+}
+
+func TestChaosBackendIsReproducible(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeBackend{rows: map[string]int64{"Singers": 10}}
+	stmt := spanner.NewStatement(DialectGoogleSQL.countRowsSQL("Singers"))
+
+	run := func() []bool {
+		b := newChaosBackend(fake, Chaos{FailureRate: 0.5, Seed: 42})
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			_, err := b.Count(ctx, stmt, 0, PriorityUnspecified)
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("failures with the same seed differ at %d: %v and %v", i, first, second)
+		}
+	}
+}
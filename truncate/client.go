@@ -0,0 +1,47 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// newClient creates a Cloud Spanner client whose sessions are labeled with the given run ID.
+func newClient(ctx context.Context, database, runID string, opts ...option.ClientOption) (*spanner.Client, error) {
+	return spanner.NewClientWithConfig(ctx, database, spanner.ClientConfig{
+		SessionLabels: map[string]string{
+			sessionLabelApp:   sessionAppName,
+			sessionLabelRunID: runID,
+		},
+	}, opts...)
+}
+
+// WithUnaryInterceptors returns a client option which registers the given unary gRPC interceptors on the underlying client.
+// Interceptors are called in the given order, e.g. to audit requests or to inject mandatory headers.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(interceptors...))
+}
+
+// WithStreamInterceptors returns a client option which registers the given stream gRPC interceptors on the underlying client.
+// Note that queries are executed as streaming calls, while commits and Partitioned DML are unary calls.
+func WithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(interceptors...))
+}
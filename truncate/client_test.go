@@ -0,0 +1,133 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	emptypb "github.com/golang/protobuf/ptypes/empty"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc"
+)
+
+// stubSpannerServer serves just enough of the Spanner API for a client to be created and detect the dialect.
+type stubSpannerServer struct {
+	sppb.UnimplementedSpannerServer
+	mu       sync.Mutex
+	sessions int
+}
+
+func (s *stubSpannerServer) BatchCreateSessions(ctx context.Context, req *sppb.BatchCreateSessionsRequest) (*sppb.BatchCreateSessionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &sppb.BatchCreateSessionsResponse{}
+	for i := int32(0); i < req.GetSessionCount(); i++ {
+		s.sessions++
+		resp.Session = append(resp.Session, &sppb.Session{Name: fmt.Sprintf("%s/sessions/s%d", req.GetDatabase(), s.sessions)})
+	}
+	return resp, nil
+}
+
+func (s *stubSpannerServer) ExecuteStreamingSql(req *sppb.ExecuteSqlRequest, stream sppb.Spanner_ExecuteStreamingSqlServer) error {
+	return stream.Send(&sppb.PartialResultSet{
+		Metadata: &sppb.ResultSetMetadata{RowType: &sppb.StructType{Fields: []*sppb.StructType_Field{
+			{Name: "option_value", Type: &sppb.Type{Code: sppb.TypeCode_STRING}},
+		}}},
+		Values: []*proto3.Value{{Kind: &proto3.Value_StringValue{StringValue: "GOOGLE_STANDARD_SQL"}}},
+	})
+}
+
+func (s *stubSpannerServer) ListSessions(ctx context.Context, req *sppb.ListSessionsRequest) (*sppb.ListSessionsResponse, error) {
+	return &sppb.ListSessionsResponse{}, nil
+}
+
+func (s *stubSpannerServer) DeleteSession(ctx context.Context, req *sppb.DeleteSessionRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+// methodRecorder records the methods of calls which go through its interceptors.
+type methodRecorder struct {
+	mu      sync.Mutex
+	methods map[string]bool
+}
+
+func (r *methodRecorder) record(method string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.methods == nil {
+		r.methods = map[string]bool{}
+	}
+	r.methods[method] = true
+}
+
+func (r *methodRecorder) unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	r.record(method)
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (r *methodRecorder) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	r.record(method)
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+func TestClientInterceptors(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	sppb.RegisterSpannerServer(server, &stubSpannerServer{})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	unary, stream := &methodRecorder{}, &methodRecorder{}
+	o := NewOptions(WithEmulator(lis.Addr().String()), WithClientOptions(
+		WithUnaryInterceptors(unary.unary),
+		WithStreamInterceptors(stream.stream),
+	))
+	b, err := newBaseBackend(context.Background(), "projects/p/instances/i/databases/d", "run", o)
+	if err != nil {
+		t.Fatalf("newBaseBackend() = %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	for _, tt := range []struct {
+		desc     string
+		recorder *methodRecorder
+		want     []string
+	}{
+		{desc: "Unary", recorder: unary, want: []string{"/google.spanner.v1.Spanner/BatchCreateSessions", "/google.spanner.v1.Spanner/ListSessions"}},
+		{desc: "Stream", recorder: stream, want: []string{"/google.spanner.v1.Spanner/ExecuteStreamingSql"}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			tt.recorder.mu.Lock()
+			defer tt.recorder.mu.Unlock()
+			for _, method := range tt.want {
+				if !tt.recorder.methods[method] {
+					t.Errorf("%s did not go through the interceptor, but only %v", method, tt.recorder.methods)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	pathpkg "path"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSystemTables are tables of common migration tools.
+// Truncating them breaks deployment pipelines, so they are excluded unless explicitly targeted.
+var DefaultSystemTables = []string{
+	"schema_migrations",       // golang-migrate, Rails
+	"ar_internal_metadata",    // Rails
+	"SchemaMigrations",        // wrench
+	"SchemaMigrationsHistory", // wrench
+	"flyway_schema_history",   // Flyway
+	"DATABASECHANGELOG",       // Liquibase
+	"DATABASECHANGELOGLOCK",   // Liquibase
+	"goose_db_version",        // goose
+	"gorp_migrations",         // sql-migrate
+}
+
+// Config is the content of a configuration file.
+type Config struct {
+	// Project, Instance and Database are the database to be truncated, used by the command line tool if not given by flags.
+	Project  string `json:"project"`
+	Instance string `json:"instance"`
+	Database string `json:"database"`
+
+	// TargetTables and ExcludeTables are names or glob patterns of tables to be truncated or exempted,
+	// used by the command line tool if neither tables nor exclude tables are given by flags.
+	TargetTables  []string `json:"targetTables"`
+	ExcludeTables []string `json:"excludeTables"`
+
+	// References are application-level references between tables which are not declared as foreign keys.
+	References []Reference `json:"references"`
+
+	// SystemTables are tables excluded unless explicitly targeted.
+	// If not set, DefaultSystemTables is used. Set an empty list to disable the exclusion.
+	SystemTables *[]string `json:"systemTables"`
+
+	// SchemaVersion is the schema-version table whose latest row is preserved after truncation.
+	SchemaVersion *SchemaVersion `json:"schemaVersion"`
+
+	// Redaction configures how sensitive values are redacted in logs and reports.
+	Redaction *Redaction `json:"redaction"`
+
+	// Tables are per-table settings keyed by table name.
+	Tables map[string]TableConfig `json:"tables"`
+
+	// ProductionPatterns are regular expressions of production database names, e.g. "/instances/prod-".
+	// Runs against a matching database require a reason, e.g. a change ticket.
+	ProductionPatterns []string `json:"productionPatterns"`
+
+	// ProtectedLabels are labels of protected instances, e.g. {"env": "prod"}.
+	// Runs against an instance carrying any of them fail unless protected instances are allowed.
+	ProtectedLabels map[string]string `json:"protectedLabels"`
+
+	// ProtectedTables are names or glob patterns of tables which must never be truncated, e.g. "FeatureFlags".
+	// Runs which would delete rows of them, even by cascade, fail unless protected tables are allowed.
+	ProtectedTables []string `json:"protectedTables"`
+
+	// ExcludeRegexps are regular expressions of table names always excluded from truncation, in addition to --exclude-regex.
+	ExcludeRegexps []string `json:"excludeRegex"`
+
+	// Where are conditions of rows to be deleted keyed by table name, e.g. "CreatedAt < @cutoff".
+	// Other rows of the tables are kept. Parameters are given by --param.
+	Where map[string]string `json:"where"`
+
+	// Stages are deleted one by one in addition to the ordering by dependencies.
+	// Tables not in any stage are deleted after all stages.
+	Stages []Stage `json:"stages"`
+
+	// ConsistencyGroups are groups of tables whose rows are deleted in a single transaction when they fit in a commit.
+	ConsistencyGroups []ConsistencyGroup `json:"consistencyGroups"`
+}
+
+// TableConfig is the settings of a table.
+type TableConfig struct {
+	// Tags are labels of the table, e.g. "pii", "large" or "reference", used to select tables by --only-tag and --skip-tag.
+	Tags []string `json:"tags"`
+
+	// Priority is the request priority of deletes and row counts of the table, overriding --priority.
+	Priority Priority `json:"priority"`
+
+	// Strategy is how rows of the table are deleted, overriding --strategy.
+	Strategy Strategy `json:"strategy"`
+
+	// TimestampColumn is the timestamp or commit-timestamp column compared by --older-than and --protect-recent,
+	// overriding --timestamp-column.
+	TimestampColumn string `json:"timestampColumn"`
+}
+
+// tableTags returns the tags of each table.
+func (c *Config) tableTags() map[string][]string {
+	tags := map[string][]string{}
+	if c == nil {
+		return tags
+	}
+	for name, t := range c.Tables {
+		tags[name] = t.Tags
+	}
+	return tags
+}
+
+// tablePriority returns the request priority of the table, or the default priority if not overridden.
+func (c *Config) tablePriority(table string, defaultPriority Priority) Priority {
+	if c == nil {
+		return defaultPriority
+	}
+	if t, ok := c.Tables[table]; ok && t.Priority != PriorityUnspecified {
+		return t.Priority
+	}
+	return defaultPriority
+}
+
+// tableStrategy returns how rows of the table are deleted, or the default strategy if not overridden.
+func (c *Config) tableStrategy(table string, defaultStrategy Strategy) Strategy {
+	if c == nil {
+		return defaultStrategy
+	}
+	if t, ok := c.Tables[table]; ok && t.Strategy != "" {
+		return t.Strategy
+	}
+	return defaultStrategy
+}
+
+// systemTables returns the tables excluded unless explicitly targeted.
+func (c *Config) systemTables() []string {
+	if c == nil || c.SystemTables == nil {
+		return DefaultSystemTables
+	}
+	return *c.SystemTables
+}
+
+// excludeRegexps returns the regular expressions of tables always excluded in the config.
+func (c *Config) excludeRegexps() []string {
+	if c == nil {
+		return nil
+	}
+	return c.ExcludeRegexps
+}
+
+// protectedTables returns the patterns of tables which must never be truncated.
+func (c *Config) protectedTables() []string {
+	if c == nil {
+		return nil
+	}
+	return c.ProtectedTables
+}
+
+// tableTimestampColumns returns the timestamp columns of tables in the config keyed by table name.
+func (c *Config) tableTimestampColumns() map[string]string {
+	columns := map[string]string{}
+	if c == nil {
+		return columns
+	}
+	for name, t := range c.Tables {
+		if t.TimestampColumn != "" {
+			columns[name] = t.TimestampColumn
+		}
+	}
+	return columns
+}
+
+// tableFilters returns the conditions of rows to be deleted keyed by table name.
+func (c *Config) tableFilters() map[string]string {
+	if c == nil {
+		return nil
+	}
+	return c.Where
+}
+
+// Reference represents a reference from columns of a table to columns of another table.
+type Reference struct {
+	Table             string   `json:"table"`
+	Columns           []string `json:"columns"`
+	ReferencedTable   string   `json:"referencedTable"`
+	ReferencedColumns []string `json:"referencedColumns"`
+}
+
+// readConfigFile reads the configuration file at the path as JSON.
+// Files with the extension .yaml, .yml or .toml are converted into JSON, so that all formats share the JSON keys and the schema.
+func readConfigFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	var doc map[string]interface{}
+	switch strings.ToLower(pathpkg.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	default:
+		return b, nil
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert config file %s into JSON: %v", path, err)
+	}
+	return b, nil
+}
+
+// LoadConfig reads the configuration file at the given path, in JSON, YAML or TOML by the extension.
+func LoadConfig(path string) (*Config, error) {
+	b, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if len(config.TargetTables) > 0 && len(config.ExcludeTables) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: targetTables and excludeTables cannot be both set", path)
+	}
+	for _, ref := range config.References {
+		if err := ref.validate(); err != nil {
+			return nil, fmt.Errorf("invalid reference in config file %s: %v", path, err)
+		}
+	}
+	if sv := config.SchemaVersion; sv != nil && (sv.Table == "" || sv.OrderBy == "") {
+		return nil, fmt.Errorf("invalid schemaVersion in config file %s: both table and orderBy must be specified", path)
+	}
+	if r := config.Redaction; r != nil {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("invalid redaction in config file %s: %v", path, err)
+		}
+	}
+	for name, t := range config.Tables {
+		switch t.Priority {
+		case PriorityUnspecified, PriorityLow, PriorityMedium, PriorityHigh:
+		default:
+			return nil, fmt.Errorf("invalid priority of %s in config file %s: unknown priority %q, must be one of low, medium or high", name, path, t.Priority)
+		}
+		switch t.Strategy {
+		case "", StrategyPDML, StrategyBatch:
+		default:
+			return nil, fmt.Errorf("invalid strategy of %s in config file %s: unknown strategy %q, must be one of pdml or batch", name, path, t.Strategy)
+		}
+	}
+	for _, p := range config.ProductionPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid productionPatterns in config file %s: %v", path, err)
+		}
+	}
+	for _, p := range config.ProtectedTables {
+		if _, err := pathpkg.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid protectedTables in config file %s: invalid pattern %s: %v", path, p, err)
+		}
+	}
+	for _, p := range config.ExcludeRegexps {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid excludeRegex in config file %s: %v", path, err)
+		}
+	}
+	for name, filter := range config.Where {
+		if strings.TrimSpace(filter) == "" {
+			return nil, fmt.Errorf("invalid where of %s in config file %s: condition must not be empty", name, path)
+		}
+	}
+	if err := validateStages(config.Stages); err != nil {
+		return nil, fmt.Errorf("invalid stages in config file %s: %v", path, err)
+	}
+	if err := validateConsistencyGroups(config.ConsistencyGroups); err != nil {
+		return nil, fmt.Errorf("invalid consistencyGroups in config file %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+func (r Reference) validate() error {
+	if r.Table == "" || r.ReferencedTable == "" {
+		return fmt.Errorf("both table and referencedTable must be specified")
+	}
+	if len(r.Columns) == 0 || len(r.Columns) != len(r.ReferencedColumns) {
+		return fmt.Errorf("%s: columns and referencedColumns must have the same number of columns", r.Table)
+	}
+	return nil
+}
+
+// redaction returns the redaction configuration, or nil if not configured.
+func (c *Config) redaction() *Redaction {
+	if c == nil {
+		return nil
+	}
+	return c.Redaction
+}
+
+// stages returns the stages, or nil if not configured.
+func (c *Config) stages() []Stage {
+	if c == nil {
+		return nil
+	}
+	return c.Stages
+}
+
+// consistencyGroups returns the consistency groups, or nil if not configured.
+func (c *Config) consistencyGroups() []ConsistencyGroup {
+	if c == nil {
+		return nil
+	}
+	return c.ConsistencyGroups
+}
+
+// schemaVersion returns the schema-version table configuration, or nil if not configured.
+func (c *Config) schemaVersion() *SchemaVersion {
+	if c == nil {
+		return nil
+	}
+	return c.SchemaVersion
+}
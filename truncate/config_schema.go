@@ -0,0 +1,476 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// ConfigSchema is the JSON Schema of configuration files, also written by "config schema"
+// so that editors can complete and check configuration files.
+const ConfigSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "spanner-truncate configuration",
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "project": {"description": "GCP project ID, used if -p is not given.", "type": "string"},
+    "instance": {"description": "Cloud Spanner instance ID, used if -i is not given.", "type": "string"},
+    "database": {"description": "Cloud Spanner database ID, used if none of -d, --databases and --all-databases is given.", "type": "string"},
+    "targetTables": {
+      "description": "Names or glob patterns of tables to be truncated, used if neither --tables nor --exclude-tables is given.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "excludeTables": {
+      "description": "Names or glob patterns of tables exempted from truncating, used if neither --tables nor --exclude-tables is given.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "references": {
+      "description": "Application-level references between tables which are not declared as foreign keys.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["table", "columns", "referencedTable", "referencedColumns"],
+        "properties": {
+          "table": {"type": "string"},
+          "columns": {"type": "array", "minItems": 1, "items": {"type": "string"}},
+          "referencedTable": {"type": "string"},
+          "referencedColumns": {"type": "array", "minItems": 1, "items": {"type": "string"}}
+        }
+      }
+    },
+    "systemTables": {
+      "description": "Tables excluded unless explicitly targeted. An empty list disables the exclusion.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "schemaVersion": {
+      "description": "Schema-version table whose latest row is preserved after truncation.",
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["table", "orderBy"],
+      "properties": {
+        "table": {"type": "string"},
+        "orderBy": {"type": "string"},
+        "hook": {"type": "string"}
+      }
+    },
+    "redaction": {
+      "description": "How sensitive values are redacted in logs and reports.",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "columns": {"type": "array", "items": {"type": "string"}},
+        "hash": {"type": "boolean"}
+      }
+    },
+    "tables": {
+      "description": "Per-table settings keyed by table name.",
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "tags": {"type": "array", "items": {"type": "string"}},
+          "priority": {"type": "string", "enum": ["low", "medium", "high"]},
+          "strategy": {"type": "string", "enum": ["pdml", "batch"]},
+          "timestampColumn": {"type": "string"}
+        }
+      }
+    },
+    "productionPatterns": {
+      "description": "Regular expressions of production database names. Runs against them require a reason.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "protectedLabels": {
+      "description": "Labels of protected instances, e.g. {\"env\": \"prod\"}. Runs against them fail unless --allow-protected.",
+      "type": "object",
+      "additionalProperties": {"type": "string"}
+    },
+    "protectedTables": {
+      "description": "Names or glob patterns of tables which must never be truncated. Runs touching them fail unless --allow-protected-tables.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "excludeRegex": {
+      "description": "Regular expressions of table names always excluded from truncation.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "where": {
+      "description": "Conditions of rows to be deleted keyed by table name, e.g. \"CreatedAt < @cutoff\". Other rows are kept.",
+      "type": "object",
+      "additionalProperties": {"type": "string"}
+    },
+    "stages": {
+      "description": "Tables deleted stage by stage.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["tables"],
+        "properties": {
+          "name": {"type": "string"},
+          "tables": {"type": "array", "minItems": 1, "items": {"type": "string"}},
+          "waitAfter": {"type": "string"},
+          "drainChangeStream": {
+            "type": "object",
+            "additionalProperties": false,
+            "required": ["changeStream"],
+            "properties": {
+              "changeStream": {"type": "string"},
+              "quietPeriod": {"type": "string"}
+            }
+          }
+        }
+      }
+    },
+    "consistencyGroups": {
+      "description": "Groups of tables whose rows are deleted in a single transaction.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["name", "tables"],
+        "properties": {
+          "name": {"type": "string"},
+          "tables": {"type": "array", "minItems": 2, "items": {"type": "string"}}
+        }
+      }
+    }
+  }
+}
+`
+
+// ConfigError is a problem of a configuration file at a position.
+type ConfigError struct {
+	// Line and Column are 0 if the position is unknown, e.g. in YAML and TOML files.
+	Line   int
+	Column int
+	// Path is the JSON pointer of the invalid value, e.g. "/tables/Orders/priority".
+	Path    string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		if e.Path == "" {
+			return e.Message
+		}
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// ValidateConfigFile validates the configuration file at the given path against ConfigSchema,
+// and then the values the schema cannot check, such as regular expressions and durations.
+// It returns the problems found, or an error if the file cannot be read.
+func ValidateConfigFile(path string) ([]ConfigError, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	converted, err := readConfigFile(path)
+	if err != nil {
+		return []ConfigError{{Message: err.Error()}}, nil
+	}
+	if problems := validateConfig(converted); len(problems) > 0 {
+		// Positions in YAML and TOML files are unknown after the conversion, so problems are reported by their paths.
+		if !bytes.Equal(b, converted) {
+			for i := range problems {
+				problems[i].Line, problems[i].Column = 0, 0
+			}
+		}
+		return problems, nil
+	}
+	if _, err := LoadConfig(path); err != nil {
+		return []ConfigError{{Line: 1, Column: 1, Message: err.Error()}}, nil
+	}
+	return nil, nil
+}
+
+// validateConfig validates the content of a configuration file against ConfigSchema.
+func validateConfig(b []byte) []ConfigError {
+	var schema jsonSchema
+	if err := json.Unmarshal([]byte(ConfigSchema), &schema); err != nil {
+		panic(fmt.Sprintf("invalid config schema: %v", err))
+	}
+	root, offset, err := parseJSONNode(b)
+	if err != nil {
+		line, col := lineColumn(b, offset)
+		return []ConfigError{{Line: line, Column: col, Message: err.Error()}}
+	}
+	v := &schemaValidator{b: b}
+	v.validate(&schema, root, "")
+	return v.problems
+}
+
+// jsonSchema is the subset of JSON Schema used by ConfigSchema.
+type jsonSchema struct {
+	Type        string                 `json:"type"`
+	Description string                 `json:"description"`
+	Properties  map[string]*jsonSchema `json:"properties"`
+	// AdditionalProperties is either false or a schema of the values of other properties.
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	Required             []string        `json:"required"`
+	Items                *jsonSchema     `json:"items"`
+	MinItems             int             `json:"minItems"`
+	Enum                 []string        `json:"enum"`
+}
+
+// jsonNode is a JSON value with the offset where it starts.
+type jsonNode struct {
+	offset  int
+	value   interface{} // string, float64, bool or nil for scalars.
+	kind    string
+	members []jsonMember
+	items   []*jsonNode
+}
+
+type jsonMember struct {
+	key       string
+	keyOffset int
+	value     *jsonNode
+}
+
+// parseJSONNode parses a JSON document keeping the offsets of values.
+// If the document is invalid, it returns the offset of the problem with the error.
+func parseJSONNode(b []byte) (*jsonNode, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	n, err := parseNextNode(dec, b)
+	if err != nil {
+		// The offset of a syntax error is after the invalid character.
+		if serr, ok := err.(*json.SyntaxError); ok && serr.Offset > 0 {
+			return nil, int(serr.Offset) - 1, err
+		}
+		return nil, len(b), err
+	}
+	offset := skipJSONSpace(b, int(dec.InputOffset()))
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, offset, fmt.Errorf("unexpected content after the top-level value")
+	}
+	return n, 0, nil
+}
+
+func parseNextNode(dec *json.Decoder, b []byte) (*jsonNode, error) {
+	n := &jsonNode{offset: skipJSONSpace(b, int(dec.InputOffset()))}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		n.kind = "object"
+		for dec.More() {
+			keyOffset := skipJSONSpace(b, int(dec.InputOffset()))
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseNextNode(dec, b)
+			if err != nil {
+				return nil, err
+			}
+			n.members = append(n.members, jsonMember{key: key.(string), keyOffset: keyOffset, value: value})
+		}
+	case json.Delim('['):
+		n.kind = "array"
+		for dec.More() {
+			item, err := parseNextNode(dec, b)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+	default:
+		n.value = tok
+		switch tok.(type) {
+		case string:
+			n.kind = "string"
+		case float64:
+			n.kind = "number"
+		case bool:
+			n.kind = "boolean"
+		default:
+			n.kind = "null"
+		}
+		return n, nil
+	}
+	// Consume the closing delimiter.
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// skipJSONSpace returns the offset of the next value from the offset, skipping whitespace and separators.
+func skipJSONSpace(b []byte, offset int) int {
+	for offset < len(b) && strings.IndexByte(" \t\r\n,:", b[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// lineColumn returns the 1-based line and column of the offset.
+func lineColumn(b []byte, offset int) (int, int) {
+	if offset > len(b) {
+		offset = len(b)
+	}
+	line := 1 + bytes.Count(b[:offset], []byte("\n"))
+	column := offset - bytes.LastIndexByte(b[:offset], '\n')
+	return line, column
+}
+
+type schemaValidator struct {
+	b        []byte
+	problems []ConfigError
+}
+
+func (v *schemaValidator) addProblem(offset int, path, format string, args ...interface{}) {
+	line, col := lineColumn(v.b, offset)
+	v.problems = append(v.problems, ConfigError{Line: line, Column: col, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *schemaValidator) validate(s *jsonSchema, n *jsonNode, path string) {
+	if s.Type != "" && s.Type != n.kind {
+		v.addProblem(n.offset, path, "expected %s, but got %s", s.Type, n.kind)
+		return
+	}
+	if len(s.Enum) > 0 {
+		str, _ := n.value.(string)
+		valid := false
+		for _, e := range s.Enum {
+			valid = valid || e == str
+		}
+		if !valid {
+			v.addProblem(n.offset, path, "%q is not one of %s", str, strings.Join(s.Enum, ", "))
+		}
+	}
+	switch n.kind {
+	case "object":
+		v.validateObject(s, n, path)
+	case "array":
+		if len(n.items) < s.MinItems {
+			v.addProblem(n.offset, path, "must have at least %d items", s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range n.items {
+				v.validate(s.Items, item, fmt.Sprintf("%s/%d", path, i))
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(s *jsonSchema, n *jsonNode, path string) {
+	var additional *jsonSchema
+	disallowed := string(s.AdditionalProperties) == "false"
+	if len(s.AdditionalProperties) > 0 && !disallowed {
+		if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+			panic(fmt.Sprintf("invalid additionalProperties in config schema: %v", err))
+		}
+	}
+	seen := map[string]bool{}
+	for _, m := range n.members {
+		seen[m.key] = true
+		memberPath := path + "/" + escapeJSONPointer(m.key)
+		if ps, ok := s.Properties[m.key]; ok {
+			v.validate(ps, m.value, memberPath)
+			continue
+		}
+		switch {
+		case additional != nil:
+			v.validate(additional, m.value, memberPath)
+		case disallowed:
+			msg := fmt.Sprintf("unknown property %q", m.key)
+			if suggestion := suggestProperty(m.key, s.Properties); suggestion != "" {
+				msg += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.addProblem(m.keyOffset, memberPath, "%s", msg)
+		}
+	}
+	for _, r := range s.Required {
+		if !seen[r] {
+			v.addProblem(n.offset, path, "missing required property %q", r)
+		}
+	}
+}
+
+// suggestProperty returns the known property the unknown one is likely a typo of, or "" if none is close enough.
+func suggestProperty(key string, properties map[string]*jsonSchema) string {
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	best, bestDistance := "", len(key)/2+1
+	for _, name := range names {
+		// A truncated name such as "exclud" is a prefix of the intended one.
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(key)) {
+			return name
+		}
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// escapeJSONPointer escapes a property name as a JSON pointer token.
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
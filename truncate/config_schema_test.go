@@ -0,0 +1,148 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateConfig(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		content string
+		want    []ConfigError
+	}{
+		{
+			desc:    "Valid",
+			content: `{"tables": {"Orders": {"priority": "low", "tags": ["large"]}}, "excludeRegex": ["^Config"]}`,
+		},
+		{
+			desc:    "Typo of property",
+			content: "{\n  \"exclud\": [\"^Config\"]\n}",
+			want:    []ConfigError{{Line: 2, Column: 3, Path: "/exclud", Message: `unknown property "exclud", did you mean "excludeRegex"?`}},
+		},
+		{
+			desc:    "Misspelled property",
+			content: `{"refrences": []}`,
+			want:    []ConfigError{{Line: 1, Column: 2, Path: "/refrences", Message: `unknown property "refrences", did you mean "references"?`}},
+		},
+		{
+			desc:    "Unknown property without suggestion",
+			content: `{"tables": {"Orders": {"retention": "30d"}}}`,
+			want:    []ConfigError{{Line: 1, Column: 24, Path: "/tables/Orders/retention", Message: `unknown property "retention"`}},
+		},
+		{
+			desc:    "Invalid enum and type",
+			content: "{\"tables\": {\n  \"Orders\": {\"priority\": \"urgent\", \"tags\": \"pii\"}\n}}",
+			want: []ConfigError{
+				{Line: 2, Column: 26, Path: "/tables/Orders/priority", Message: `"urgent" is not one of low, medium, high`},
+				{Line: 2, Column: 44, Path: "/tables/Orders/tags", Message: "expected array, but got string"},
+			},
+		},
+		{
+			desc:    "Missing required property",
+			content: `{"stages": [{"name": "first"}]}`,
+			want:    []ConfigError{{Line: 1, Column: 13, Path: "/stages/0", Message: `missing required property "tables"`}},
+		},
+		{
+			desc:    "Too few items",
+			content: `{"stages": [{"tables": []}]}`,
+			want:    []ConfigError{{Line: 1, Column: 24, Path: "/stages/0/tables", Message: "must have at least 1 items"}},
+		},
+		{
+			desc:    "Syntax error",
+			content: "{\n  \"tables\": {,}\n}",
+			want:    []ConfigError{{Line: 2, Column: 14, Message: "invalid character ',' looking for beginning of value"}},
+		},
+		{
+			desc:    "Trailing content",
+			content: "{}\n{}",
+			want:    []ConfigError{{Line: 2, Column: 1, Message: "unexpected content after the top-level value"}},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := validateConfig([]byte(tt.content))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("validateConfig() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	// Regular expressions are not checked by the schema, but by LoadConfig.
+	if err := ioutil.WriteFile(path, []byte(`{"excludeRegex": ["^Lookup("]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() = %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0].Message, "invalid excludeRegex") {
+		t.Errorf("ValidateConfigFile() = %v, but want a problem of invalid excludeRegex", problems)
+	}
+}
+
+func TestValidateConfigFileYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("tables:\n  Orders:\n    priority: urgent\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("ValidateConfigFile() = %v", err)
+	}
+	// Positions are unknown in converted files, so only paths are reported.
+	want := []ConfigError{{Path: "/tables/Orders/priority", Message: `"urgent" is not one of low, medium, high`}}
+	if diff := cmp.Diff(want, problems); diff != "" {
+		t.Errorf("ValidateConfigFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestConfigSchemaProperties ensures that the schema is updated when Config is.
+func TestConfigSchemaProperties(t *testing.T) {
+	var schema jsonSchema
+	if err := json.Unmarshal([]byte(ConfigSchema), &schema); err != nil {
+		t.Fatalf("failed to parse ConfigSchema: %v", err)
+	}
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("ConfigSchema has no property %q of Config.%s", name, typ.Field(i).Name)
+		}
+	}
+}
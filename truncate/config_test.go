@@ -0,0 +1,200 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConfigTablePriority(t *testing.T) {
+	config := &Config{Tables: map[string]TableConfig{
+		"Orders":   {Priority: PriorityLow},
+		"Archives": {Priority: PriorityHigh},
+		"Users":    {Tags: []string{"pii"}},
+	}}
+	for _, tt := range []struct {
+		desc   string
+		config *Config
+		table  string
+		want   Priority
+	}{
+		{desc: "Overridden", config: config, table: "Orders", want: PriorityLow},
+		{desc: "Overridden to higher", config: config, table: "Archives", want: PriorityHigh},
+		{desc: "Not overridden", config: config, table: "Users", want: PriorityMedium},
+		{desc: "Not in config", config: config, table: "Singers", want: PriorityMedium},
+		{desc: "No config", table: "Orders", want: PriorityMedium},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := tt.config.tablePriority(tt.table, PriorityMedium); got != tt.want {
+				t.Errorf("tablePriority(%q) = %q, but want = %q", tt.table, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigTableStrategy(t *testing.T) {
+	config := &Config{Tables: map[string]TableConfig{
+		"Events": {Strategy: StrategyPDML},
+		"Orders": {Strategy: StrategyBatch},
+		"Users":  {Priority: PriorityLow},
+	}}
+	for _, tt := range []struct {
+		desc   string
+		config *Config
+		table  string
+		want   Strategy
+	}{
+		{desc: "Overridden to pdml", config: config, table: "Events", want: StrategyPDML},
+		{desc: "Overridden to batch", config: config, table: "Orders", want: StrategyBatch},
+		{desc: "Not overridden", config: config, table: "Users", want: StrategyBatch},
+		{desc: "No config", table: "Events", want: StrategyBatch},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := tt.config.tableStrategy(tt.table, StrategyBatch); got != tt.want {
+				t.Errorf("tableStrategy(%q) = %q, but want = %q", tt.table, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidPriority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"tables": {"Orders": {"priority": "urgent"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), `invalid priority of Orders`) {
+		t.Errorf("LoadConfig() = %v, but want an error of invalid priority of Orders", err)
+	}
+}
+
+func TestLoadConfigInvalidStrategy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"tables": {"Orders": {"strategy": "truncate"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), `invalid strategy of Orders`) {
+		t.Errorf("LoadConfig() = %v, but want an error of invalid strategy of Orders", err)
+	}
+}
+
+func TestLoadConfigInvalidExcludeRegex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"excludeRegex": ["^Lookup("]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "invalid excludeRegex") {
+		t.Errorf("LoadConfig() = %v, but want an error of invalid excludeRegex", err)
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"config.json": `{
+  "project": "my-project",
+  "instance": "my-instance",
+  "database": "my-database",
+  "excludeTables": ["Lookup*"],
+  "tables": {"Events": {"strategy": "batch", "tags": ["large"]}}
+}`,
+		"config.yaml": `project: my-project
+instance: my-instance
+database: my-database
+excludeTables:
+  - Lookup*
+tables:
+  Events:
+    strategy: batch
+    tags: [large]
+`,
+		"config.toml": `project = "my-project"
+instance = "my-instance"
+database = "my-database"
+excludeTables = ["Lookup*"]
+
+[tables.Events]
+strategy = "batch"
+tags = ["large"]
+`,
+	}
+	want := &Config{
+		Project:       "my-project",
+		Instance:      "my-instance",
+		Database:      "my-database",
+		ExcludeTables: []string{"Lookup*"},
+		Tables:        map[string]TableConfig{"Events": {Strategy: StrategyBatch, Tags: []string{"large"}}},
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("LoadConfig() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTargetAndExcludeTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte("targetTables: [Orders]\nexcludeTables: [Lookup*]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "targetTables and excludeTables cannot be both set") {
+		t.Errorf("LoadConfig() = %v, but want an error of both targetTables and excludeTables", err)
+	}
+}
@@ -0,0 +1,277 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+)
+
+// ConsistencyGroup is a group of tables whose rows are deleted in a single transaction, so that related rows,
+// e.g. an order and its items deleted by where conditions, never disappear partially from the application's perspective.
+type ConsistencyGroup struct {
+	// Name is shown in the plan and progress messages.
+	Name string `json:"name"`
+
+	// Tables are the tables deleted together. Tables which are not selected in a run are ignored.
+	Tables []string `json:"tables"`
+}
+
+// validateConsistencyGroups returns an error if a group has no name, fewer than two tables, or a table is in multiple groups.
+func validateConsistencyGroups(groups []ConsistencyGroup) error {
+	seen := map[string]string{}
+	for _, g := range groups {
+		if g.Name == "" {
+			return errors.New("name must be specified")
+		}
+		if len(g.Tables) < 2 {
+			return fmt.Errorf("%s: at least two tables must be specified", g.Name)
+		}
+		for _, t := range g.Tables {
+			if other, ok := seen[t]; ok {
+				return fmt.Errorf("%s: %s is already in %s", g.Name, t, other)
+			}
+			seen[t] = g.Name
+		}
+	}
+	return nil
+}
+
+// selectGroupTables returns the groups with the selected tables only. Groups with fewer than two selected tables are dropped.
+func selectGroupTables(groups []ConsistencyGroup, schemas []*tableSchema) []ConsistencyGroup {
+	selected := map[string]bool{}
+	for _, s := range schemas {
+		selected[s.tableName] = true
+	}
+	var result []ConsistencyGroup
+	for _, g := range groups {
+		var tables []string
+		for _, t := range g.Tables {
+			if selected[t] {
+				tables = append(tables, t)
+			}
+		}
+		if len(tables) >= 2 {
+			result = append(result, ConsistencyGroup{Name: g.Name, Tables: tables})
+		}
+	}
+	return result
+}
+
+// planConsistencyGroups returns the groups of the selected tables whose deletions fit in a single commit.
+// Groups over the limit of mutations are dropped with a warning, so that their tables are deleted separately.
+// Mutations are estimated from the rows of the tables and of the tables interleaved in them with ON DELETE CASCADE,
+// each of which also deletes its index entries.
+func planConsistencyGroups(ctx context.Context, b Backend, groups []ConsistencyGroup, schemas []*tableSchema, indexCounts map[string]int, stages *stagePlan, out Output) ([]ConsistencyGroup, error) {
+	groups = selectGroupTables(groups, schemas)
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	if _, ok := spannerClient(b); !ok {
+		return nil, errors.New("consistency groups require the native Cloud Spanner client")
+	}
+	schemaOf := map[string]*tableSchema{}
+	for _, s := range schemas {
+		schemaOf[s.tableName] = s
+	}
+	// Interleaved tables are deleted by cascade even if they are not selected.
+	all, err := fetchAllTableSchemas(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+
+	var planned []ConsistencyGroup
+	for _, g := range groups {
+		if stages != nil {
+			for _, t := range g.Tables[1:] {
+				if stages.stageOf(t) != stages.stageOf(g.Tables[0]) {
+					return nil, fmt.Errorf("consistency group %s has tables in different stages: %s and %s", g.Name, g.Tables[0], t)
+				}
+			}
+		}
+		var mutations int64
+		for _, t := range g.Tables {
+			rows, err := b.Count(ctx, filterStatement(b.Dialect().countMatchingSQL(t, schemaOf[t].filter), schemaOf[t].params), 0, PriorityUnspecified)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count rows in %s: %v", t, err)
+			}
+			mutations += rows * int64(1+indexCounts[t])
+		}
+		members := map[string]bool{}
+		for _, t := range g.Tables {
+			members[t] = true
+		}
+		for _, t := range g.Tables {
+			// All rows of the cascaded tables are counted, which overestimates them if only some rows of the table are deleted.
+			for _, c := range cascadedTables(all, t, members) {
+				rows, err := b.Count(ctx, spanner.NewStatement(b.Dialect().countRowsSQL(c)), 0, PriorityUnspecified)
+				if err != nil {
+					return nil, fmt.Errorf("failed to count rows in %s: %v", c, err)
+				}
+				mutations += rows * int64(1+indexCounts[c])
+			}
+		}
+		if mutations > maxMutationsPerCommit {
+			out.warnf("consistency group %s has about %s mutations, over the limit of %s per commit, so its tables are deleted separately.",
+				g.Name, formatNumber(uint64(mutations)), formatNumber(maxMutationsPerCommit))
+			continue
+		}
+		planned = append(planned, g)
+	}
+	return planned, nil
+}
+
+// cascadedTables returns the tables deleted by ON DELETE CASCADE when rows of the table are deleted,
+// except the members of the group and the tables interleaved in them, which are counted by the members.
+func cascadedTables(all []*tableSchema, table string, members map[string]bool) []string {
+	var cascaded []string
+	for _, t := range all {
+		if t.parentTableName != table || t.parentOnDeleteAction != deleteActionCascadeDelete || members[t.tableName] {
+			continue
+		}
+		cascaded = append(cascaded, t.tableName)
+		cascaded = append(cascaded, cascadedTables(all, t.tableName, members)...)
+	}
+	return cascaded
+}
+
+// describeConsistencyGroups returns a line for each group.
+func describeConsistencyGroups(groups []ConsistencyGroup) []string {
+	var lines []string
+	for _, g := range groups {
+		lines = append(lines, fmt.Sprintf("%s: %s", g.Name, strings.Join(g.Tables, ", ")))
+	}
+	return lines
+}
+
+// consistencyGroup is a consistency group in the table tree.
+type consistencyGroup struct {
+	name   string
+	tables []*table
+}
+
+// groupTables assigns the tables to their consistency groups.
+func groupTables(tables []*table, groups []ConsistencyGroup) {
+	byName := map[string]*table{}
+	for _, t := range flattenTables(tables) {
+		byName[t.tableName] = t
+	}
+	for _, g := range groups {
+		group := &consistencyGroup{name: g.Name}
+		for _, name := range g.Tables {
+			if t, ok := byName[name]; ok {
+				group.tables = append(group.tables, t)
+				t.group = group
+			}
+		}
+	}
+}
+
+// sameGroup returns true if both tables are in the same consistency group, so that they are deleted together.
+func (t *table) sameGroup(other *table) bool {
+	return t.group != nil && t.group == other.group
+}
+
+// isDeletable returns true if all tables of the group are ready to be deleted.
+func (g *consistencyGroup) isDeletable() bool {
+	for _, t := range g.tables {
+		if s := t.deleter.currentStatus(); s != statusAnalyzing && s != statusWaiting {
+			return false
+		}
+		if !t.isDeletable() {
+			return false
+		}
+	}
+	return true
+}
+
+// deletableGroups removes the tables of groups which cannot be deleted yet from the deletable tables,
+// and keeps only the first table of each group, which stands for the group.
+func deletableGroups(tables []*table) []*table {
+	var result []*table
+	seen := map[*consistencyGroup]bool{}
+	for _, t := range tables {
+		if t.group != nil {
+			if seen[t.group] || !t.group.isDeletable() {
+				continue
+			}
+			seen[t.group] = true
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// ordered returns the tables of the group in the order of statements in the transaction,
+// so that rows of child tables and referencing tables are deleted before the rows they depend on.
+func (g *consistencyGroup) ordered() []*table {
+	remaining := append([]*table{}, g.tables...)
+	var ordered []*table
+	for len(remaining) > 0 {
+		next := 0
+		for i, t := range remaining {
+			if !dependsOnAny(remaining, t) {
+				next = i
+				break
+			}
+		}
+		ordered = append(ordered, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return ordered
+}
+
+// dependsOnAny returns true if rows of any other table must be deleted before the table, i.e. any of them is
+// a descendant or a referencing table of it.
+func dependsOnAny(tables []*table, t *table) bool {
+	for _, other := range tables {
+		if other == t {
+			continue
+		}
+		for _, d := range flattenTables(t.childTables) {
+			if d == other {
+				return true
+			}
+		}
+		for _, r := range t.referencedBy {
+			if r == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deleteInTransaction executes the DML statements in order in a single read-write transaction,
+// and returns the number of rows deleted by each statement.
+func deleteInTransaction(ctx context.Context, client *spanner.Client, stmts []spanner.Statement, opts spanner.QueryOptions) ([]int64, error) {
+	var counts []int64
+	resp, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		var err error
+		counts, err = txn.BatchUpdateWithOptions(ctx, stmts, opts)
+		return err
+	}, spanner.TransactionOptions{CommitPriority: opts.Priority, TransactionTag: opts.RequestTag})
+	if err != nil {
+		return nil, err
+	}
+	recordCommit(ctx, resp.CommitTs)
+	return counts, nil
+}
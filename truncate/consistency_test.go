@@ -0,0 +1,219 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateConsistencyGroups(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		groups []ConsistencyGroup
+		want   string
+	}{
+		{desc: "Valid", groups: []ConsistencyGroup{{Name: "orders", Tables: []string{"Orders", "Payments"}}}},
+		{desc: "No name", groups: []ConsistencyGroup{{Tables: []string{"Orders", "Payments"}}}, want: "name must be specified"},
+		{desc: "Single table", groups: []ConsistencyGroup{{Name: "orders", Tables: []string{"Orders"}}}, want: "orders: at least two tables must be specified"},
+		{
+			desc: "Table in multiple groups",
+			groups: []ConsistencyGroup{
+				{Name: "orders", Tables: []string{"Orders", "Payments"}},
+				{Name: "refunds", Tables: []string{"Refunds", "Payments"}},
+			},
+			want: "refunds: Payments is already in orders",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var got string
+			if err := validateConsistencyGroups(tt.groups); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validateConsistencyGroups() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectGroupTables(t *testing.T) {
+	groups := []ConsistencyGroup{
+		{Name: "orders", Tables: []string{"Orders", "OrderItems", "Invoices"}},
+		{Name: "users", Tables: []string{"Users", "Profiles"}},
+	}
+	schemas := []*tableSchema{{tableName: "Orders"}, {tableName: "OrderItems"}, {tableName: "Users"}}
+	// Users is the only selected table of its group, so the group is dropped.
+	want := []ConsistencyGroup{{Name: "orders", Tables: []string{"Orders", "OrderItems"}}}
+	if diff := cmp.Diff(want, selectGroupTables(groups, schemas)); diff != "" {
+		t.Errorf("selectGroupTables() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestConsistencyGroupOrdered(t *testing.T) {
+	schemas := []*tableSchema{
+		{tableName: "Orders", referencedBy: []string{"Payments"}, filter: "TenantId = 1"},
+		{tableName: "OrderItems", parentTableName: "Orders", parentOnDeleteAction: deleteActionNoAction, referencedBy: []string{}, filter: "TenantId = 1"},
+		{tableName: "Payments", referencedBy: []string{}, filter: "TenantId = 1"},
+		{tableName: "Users", referencedBy: []string{}},
+	}
+	tables := buildTableTree(schemas, nil, nil, nil)
+	groupTables(tables, []ConsistencyGroup{{Name: "orders", Tables: []string{"Orders", "OrderItems", "Payments"}}})
+
+	var got []string
+	for _, t := range findTable(tables, "Orders").group.ordered() {
+		got = append(got, t.tableName)
+	}
+	// Children and referencing tables are deleted before the orders they depend on.
+	want := []string{"OrderItems", "Payments", "Orders"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ordered() mismatch (-want +got):\n%s", diff)
+	}
+	if findTable(tables, "Users").group != nil {
+		t.Errorf("group of Users = %v, but want = nil", findTable(tables, "Users").group)
+	}
+}
+
+func TestDeletableGroups(t *testing.T) {
+	schemas := []*tableSchema{
+		{tableName: "Orders", referencedBy: []string{"Payments"}, filter: "TenantId = 1"},
+		{tableName: "OrderItems", parentTableName: "Orders", parentOnDeleteAction: deleteActionNoAction, referencedBy: []string{}, filter: "TenantId = 1"},
+		{tableName: "Payments", referencedBy: []string{}, filter: "TenantId = 1"},
+	}
+	tables := buildTableTree(schemas, nil, nil, nil)
+	for _, table := range flattenTables(tables) {
+		table.deleter.status = statusWaiting
+	}
+
+	// Orders waits for its children and referencing tables without groups.
+	var got []string
+	for _, t := range deletableGroups(findDeletableTables(tables)) {
+		got = append(got, t.tableName)
+	}
+	if diff := cmp.Diff([]string{"OrderItems", "Payments"}, got); diff != "" {
+		t.Errorf("deletableGroups() mismatch (-want +got):\n%s", diff)
+	}
+
+	// All tables of the group are deleted together, represented by the first one.
+	groupTables(tables, []ConsistencyGroup{{Name: "orders", Tables: []string{"Payments", "Orders", "OrderItems"}}})
+	got = nil
+	for _, t := range deletableGroups(findDeletableTables(tables)) {
+		got = append(got, t.tableName)
+	}
+	if diff := cmp.Diff([]string{"Orders"}, got); diff != "" {
+		t.Errorf("deletableGroups() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCoordinatorDeletesGroupInTransaction(t *testing.T) {
+	b := &fakeBackend{rows: map[string]int64{"Orders": 2, "Payments": 3, "Users": 4}}
+	schemas := []*tableSchema{
+		{tableName: "Orders", referencedBy: []string{"Payments"}},
+		{tableName: "Payments", referencedBy: []string{}},
+	}
+	c := newCoordinator(schemas, nil, b)
+	c.groups = []ConsistencyGroup{{Name: "orders", Tables: []string{"Orders", "Payments"}}}
+	var mu sync.Mutex
+	var transactions [][]string
+	c.deleteGroup = func(ctx context.Context, stmts []spanner.Statement) ([]int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		var sqls []string
+		for _, stmt := range stmts {
+			sqls = append(sqls, stmt.SQL)
+		}
+		transactions = append(transactions, sqls)
+		return []int64{3, 2}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.start(ctx)
+	if err := c.waitCompleted(); err != nil {
+		t.Fatalf("waitCompleted() failed: %v", err)
+	}
+
+	want := [][]string{{"DELETE FROM `Payments` WHERE true", "DELETE FROM `Orders` WHERE true"}}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, transactions); diff != "" {
+		t.Errorf("transactions mismatch (-want +got):\n%s", diff)
+	}
+	if got := findTable(c.tables, "Payments").deleter.deletedRows(); got != 3 {
+		t.Errorf("deletedRows() of Payments = %d, but want = %d", got, 3)
+	}
+}
+
+// findTable returns the table of the name in the table tree, or nil if not found.
+func findTable(tables []*table, name string) *table {
+	for _, t := range flattenTables(tables) {
+		if t.tableName == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func TestCascadedTables(t *testing.T) {
+	all := []*tableSchema{
+		{tableName: "Customers"},
+		{tableName: "Orders", parentTableName: "Customers", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "OrderItems", parentTableName: "Orders", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "Payments", parentTableName: "Orders", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "Refunds", parentTableName: "Payments", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "Notes", parentTableName: "Customers", parentOnDeleteAction: deleteActionNoAction},
+	}
+	for _, tt := range []struct {
+		desc    string
+		table   string
+		members []string
+		want    []string
+	}{
+		{
+			desc:    "Descendants outside the group",
+			table:   "Customers",
+			members: []string{"Customers", "Notes"},
+			want:    []string{"Orders", "OrderItems", "Payments", "Refunds"},
+		},
+		{
+			desc:    "Members and their descendants are counted by the members",
+			table:   "Orders",
+			members: []string{"Orders", "Payments"},
+			want:    []string{"OrderItems"},
+		},
+		{
+			desc:    "No cascaded tables",
+			table:   "Refunds",
+			members: []string{"Refunds"},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			members := map[string]bool{}
+			for _, m := range tt.members {
+				members[m] = true
+			}
+			if diff := cmp.Diff(tt.want, cascadedTables(all, tt.table, members)); diff != "" {
+				t.Errorf("cascadedTables() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "sync"
+
+// Controller lets an operator pause, resume and abort a run while it is executing,
+// e.g. during an incident, without killing the process.
+// Pausing stops starting deletions of further tables. Deletions already started are not paused.
+type Controller struct {
+	mu      sync.Mutex
+	paused  bool
+	aborted bool
+	abort   chan struct{}
+}
+
+// NewController returns a Controller of a running state.
+func NewController() *Controller {
+	return &Controller{abort: make(chan struct{})}
+}
+
+// Pause stops starting deletions of further tables until Resume is called.
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+}
+
+// Resume resumes starting deletions paused by Pause.
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+}
+
+// Abort cancels the deletions being executed and makes the run fail.
+func (c *Controller) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.aborted {
+		c.aborted = true
+		close(c.abort)
+	}
+}
+
+// State returns "running", "paused" or "aborted".
+func (c *Controller) State() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.aborted:
+		return "aborted"
+	case c.paused:
+		return "paused"
+	default:
+		return "running"
+	}
+}
+
+// isPaused returns true if the controller is paused. A nil controller is never paused.
+func (c *Controller) isPaused() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// isAborted returns true if the controller is aborted. A nil controller is never aborted.
+func (c *Controller) isAborted() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.aborted
+}
+
+// abortCh returns a channel closed when the controller is aborted. It returns nil for a nil controller.
+func (c *Controller) abortCh() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.abort
+}
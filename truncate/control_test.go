@@ -0,0 +1,52 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "testing"
+
+func TestController(t *testing.T) {
+	c := NewController()
+	for _, tt := range []struct {
+		desc string
+		op   func()
+		want string
+	}{
+		{desc: "Initial", op: func() {}, want: "running"},
+		{desc: "Pause", op: c.Pause, want: "paused"},
+		{desc: "Resume", op: c.Resume, want: "running"},
+		{desc: "Abort", op: c.Abort, want: "aborted"},
+		{desc: "Abort twice", op: c.Abort, want: "aborted"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			tt.op()
+			if got := c.State(); got != tt.want {
+				t.Errorf("State() = %s, but want = %s", got, tt.want)
+			}
+		})
+	}
+
+	select {
+	case <-c.abortCh():
+	default:
+		t.Errorf("abortCh() is not closed after Abort()")
+	}
+
+	var nilController *Controller
+	if nilController.isPaused() || nilController.isAborted() {
+		t.Errorf("nil controller must be neither paused nor aborted")
+	}
+}
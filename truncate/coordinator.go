@@ -19,6 +19,9 @@ package truncate
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/spanner"
@@ -32,17 +35,34 @@ type table struct {
 	parentOnDeleteAction deleteActionType
 	referencedBy         []*table
 	hasGlobalIndex       bool
-	deleter              *deleter
+	// filter is the condition of rows to be deleted, which keeps the child rows of the other rows.
+	filter string
+	// params are the values of the parameters of the filter.
+	params  map[string]string
+	deleter *deleter
+	// group is the consistency group of the table, whose tables are deleted together. It may be nil.
+	group *consistencyGroup
 }
 
 // isDeletable returns true if the table is ready to be deleted.
 func (t *table) isDeletable() bool {
 	for _, child := range t.childTables {
-		if child.parentOnDeleteAction == deleteActionNoAction && child.deleter.status != statusCompleted {
+		// Rows of tables in the same group are deleted together in the order of their dependencies.
+		if t.sameGroup(child) {
+			if !child.isDeletable() {
+				return false
+			}
+			continue
+		}
+		if child.parentOnDeleteAction == deleteActionNoAction && !child.deleter.isCompleted() {
+			return false
+		}
+		// Deleting some rows of the parent does not cascade to all rows of the child, so the child is deleted by itself.
+		if t.filter != "" && !child.deleter.isCompleted() {
 			return false
 		}
 		// Partitioned DML may not work perfectly if a child of the target table has global indexes.
-		if child.hasGlobalIndex && child.deleter.status != statusCompleted {
+		if child.hasGlobalIndex && !child.deleter.isCompleted() {
 			return false
 		}
 		if !child.isDeletable() {
@@ -51,7 +71,7 @@ func (t *table) isDeletable() bool {
 	}
 
 	for _, referencing := range t.referencedBy {
-		if referencing.deleter.status != statusCompleted {
+		if !t.sameGroup(referencing) && !referencing.deleter.isCompleted() {
 			return false
 		}
 	}
@@ -86,7 +106,7 @@ func flattenTables(tables []*table) []*table {
 func findDeletableTables(tables []*table) []*table {
 	var deletable []*table
 	for _, table := range tables {
-		if s := table.deleter.status; s == statusDeleting || s == statusCompleted {
+		if s := table.deleter.currentStatus(); s == statusDeleting || s == statusCompleted {
 			continue
 		}
 		if table.isDeletable() {
@@ -106,23 +126,89 @@ func findDeletableTables(tables []*table) []*table {
 
 // coordinator initiates deleting rows from tables without violating database constraints.
 type coordinator struct {
+	// tables is replaced by the coordinator goroutine when re-planning, so other goroutines read it by tableTree.
+	mu      sync.Mutex
 	tables  []*table
+	backend Backend
 	errChan chan error
+
+	// Maximum number of tables deleted concurrently. Zero means unlimited.
+	concurrency int
+	// Deletions are started only inside the window if set. Deletions already started are not paused.
+	window *Window
+	// Deletions are not started while the controller is paused if set.
+	controller *Controller
+	// Policy to order tables which can be deleted at the same time.
+	order Order
+	// Tables are deleted stage by stage if set.
+	stages *stagePlan
+	// workers accounts for goroutines spawned by the coordinator. It may be nil.
+	workers *workerGroup
+	// Tables of each consistency group are deleted together by deleteGroup, which executes the statements in a transaction.
+	groups      []ConsistencyGroup
+	deleteGroup func(ctx context.Context, stmts []spanner.Statement) ([]int64, error)
+
+	// replan re-fetches the schema when a deletion fails due to a schema change. Re-planning is disabled if nil.
+	replan func(ctx context.Context) ([]*tableSchema, []*indexSchema, error)
+	// onReplan is called with the cause and the tables added after re-planning, in the goroutine of waitCompleted,
+	// before the added tables are deleted.
+	onReplan   func(cause error, added []*table)
+	replanChan chan error
+	replanned  chan replanned
+	replans    int
+}
+
+// replanned is the result of re-planning passed from the coordinator goroutine to waitCompleted.
+// done is closed once onReplan has returned.
+type replanned struct {
+	cause error
+	added []*table
+	done  chan struct{}
 }
 
-func newCoordinator(schemas []*tableSchema, indexes []*indexSchema, client *spanner.Client) *coordinator {
+// Maximum number of re-plannings in a run, to avoid re-planning forever.
+const maxReplans = 3
+
+func newCoordinator(schemas []*tableSchema, indexes []*indexSchema, b Backend) *coordinator {
+	return &coordinator{
+		tables:     buildTableTree(schemas, indexes, b, nil),
+		backend:    b,
+		errChan:    make(chan error),
+		replanChan: make(chan error, 1),
+		replanned:  make(chan replanned),
+	}
+}
+
+// tableTree returns the current table tree, which may be replaced by re-planning.
+func (c *coordinator) tableTree() []*table {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tables
+}
+
+// buildTableTree creates a table tree from schemas. Deleters of the given tables are reused to keep their progress.
+func buildTableTree(schemas []*tableSchema, indexes []*indexSchema, b Backend, deleters map[string]*deleter) []*table {
 	var tables []*table
 	tableMap := map[string]*table{}
 	for _, schema := range schemas {
+		d, ok := deleters[schema.tableName]
+		if !ok {
+			d = &deleter{
+				tableName: schema.tableName,
+				backend:   b,
+				strategy:  StrategyPDML,
+				filter:    schema.filter,
+				params:    schema.params,
+			}
+		}
 		t := &table{
 			tableName:            schema.tableName,
 			parentTableName:      schema.parentTableName,
 			parentOnDeleteAction: schema.parentOnDeleteAction,
-			deleter: &deleter{
-				tableName: schema.tableName,
-				client:    client,
-			},
-			referencedBy: []*table{},
+			filter:               schema.filter,
+			params:               schema.params,
+			deleter:              d,
+			referencedBy:         []*table{},
 		}
 		tables = append(tables, t)
 		tableMap[schema.tableName] = t
@@ -136,7 +222,10 @@ func newCoordinator(schemas []*tableSchema, indexes []*indexSchema, client *span
 
 		table := tableMap[schema.tableName]
 		for _, referencing := range schema.referencedBy {
-			table.referencedBy = append(table.referencedBy, tableMap[referencing])
+			// Referencing tables which are not deleted in this run can't be waited for.
+			if r, ok := tableMap[referencing]; ok {
+				table.referencedBy = append(table.referencedBy, r)
+			}
 		}
 	}
 
@@ -164,54 +253,249 @@ func newCoordinator(schemas []*tableSchema, indexes []*indexSchema, client *span
 		}
 	}
 
-	return &coordinator{
-		tables:  topLevelTables,
-		errChan: make(chan error),
-	}
+	return topLevelTables
 }
 
 // start starts coordination in another goroutine.
 func (c *coordinator) start(ctx context.Context) {
-	go func() {
+	c.workers.spawn("coordinator", func() {
+		groupTables(c.tables, c.groups)
 		for _, table := range flattenTables(c.tables) {
-			table.deleter.startRowCountUpdater(ctx)
+			table.deleter.startRowCountUpdater(ctx, c.workers)
 		}
 
 		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				tables := findDeletableTables(c.tables)
-				if len(tables) == 0 {
-					if !isAllTablesDeleted(c.tables) && !isAnyTableDeleting(c.tables) {
-						c.errChan <- errors.New("no deletable tables found, probably there is circular dependencies between tables")
-					}
+				if (c.window != nil && !c.window.contains(time.Now())) || c.controller.isPaused() {
+					continue
 				}
-
-				for _, table := range tables {
-					go func() {
-						if err := table.deleter.deleteRows(ctx); err != nil {
-							c.errChan <- err
+				// Row counts are needed to start the largest tables first.
+				if c.order == OrderSize && isAnyTableAnalyzing(c.tables) {
+					continue
+				}
+				tables, err := c.nextTables(ctx, time.Now())
+				if err != nil {
+					c.sendErr(ctx, err)
+					continue
+				}
+				for _, t := range tables {
+					t := t
+					if t.group != nil {
+						c.startGroup(ctx, t.group)
+						continue
+					}
+					c.workers.spawn("deletion of "+t.tableName, func() {
+						if err := t.deleter.deleteRows(ctx); err != nil {
+							if c.replan != nil && isSchemaChangeError(err) {
+								resetStatus([]*table{t})
+								select {
+								case c.replanChan <- err:
+								default:
+								}
+								return
+							}
+							c.sendErr(ctx, err)
 						}
-					}()
-					cascadeDelete(table.childTables)
+					})
+					if t.filter == "" {
+						cascadeDelete(t.childTables)
+					}
+				}
+			case cause := <-c.replanChan:
+				if err := c.doReplan(ctx, cause); err != nil {
+					c.sendErr(ctx, err)
 				}
 			case <-ctx.Done():
-				c.errChan <- ctx.Err()
+				c.sendErr(ctx, ctx.Err())
+				return
 			}
 		}
-	}()
+	})
+}
+
+// nextTables returns the tables to be started at the time, in the order to start them.
+// A table of a consistency group stands for the group, whose tables are started together.
+// It returns an error if no tables can be started although some tables remain and none are being deleted.
+func (c *coordinator) nextTables(ctx context.Context, now time.Time) ([]*table, error) {
+	if c.stages != nil {
+		ok, err := c.stages.advance(ctx, c.tables, now)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+	tables := deletableGroups(findDeletableTables(c.tables))
+	if c.stages != nil {
+		tables = c.stages.filter(tables)
+	}
+	sortTables(tables, c.order)
+	if len(tables) == 0 && !isAllTablesDeleted(c.tables) && !isAnyTableDeleting(c.tables) {
+		if c.stages != nil {
+			return nil, fmt.Errorf("no deletable tables found in %s, probably its tables depend on tables in later stages", stageName(c.stages.stages, c.stages.current))
+		}
+		return nil, errors.New("no deletable tables found, probably there is circular dependencies between tables")
+	}
+
+	if c.concurrency > 0 {
+		available := c.concurrency - countDeletingTables(c.tables)
+		if available < 0 {
+			available = 0
+		}
+		if len(tables) > available {
+			tables = tables[:available]
+		}
+	}
+	return tables, nil
+}
+
+// startGroup deletes the rows of the tables of the group in a single transaction in another goroutine.
+func (c *coordinator) startGroup(ctx context.Context, g *consistencyGroup) {
+	tables := g.ordered()
+	stmts := make([]spanner.Statement, len(tables))
+	for i, t := range tables {
+		t.deleter.markStarted()
+		stmts[i] = filterStatement(c.backend.Dialect().deleteMatchingSQL(t.tableName, t.filter), t.params)
+		t.deleter.stmtLog.log(t.tableName, stmts[i])
+	}
+	c.workers.spawn("deletion of consistency group "+g.name, func() {
+		var commits commitRecorder
+		counts, err := c.deleteGroup(withCommitRecorder(ctx, &commits), stmts)
+		if err != nil {
+			if c.replan != nil && isSchemaChangeError(err) {
+				resetStatus(tables)
+				select {
+				case c.replanChan <- err:
+				default:
+				}
+				return
+			}
+			if ctx.Err() == nil {
+				for _, t := range tables {
+					t.deleter.markFailed(err)
+				}
+			}
+			c.sendErr(ctx, fmt.Errorf("failed to delete consistency group %s: %v", g.name, err))
+			return
+		}
+		for i, t := range tables {
+			t.deleter.markGroupDeleted(counts[i])
+			t.deleter.lastCommit.record(commits.timestamp())
+		}
+	})
+	for _, t := range tables {
+		if t.filter == "" {
+			cascadeDelete(t.childTables)
+		}
+	}
+}
+
+// doReplan re-fetches the schema and rebuilds the table tree of the remaining tables.
+func (c *coordinator) doReplan(ctx context.Context, cause error) error {
+	if c.replans >= maxReplans {
+		return fmt.Errorf("schema changed more than %d times during the run: %v", maxReplans, cause)
+	}
+	c.replans++
+
+	schemas, indexes, err := c.replan(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to re-plan after schema change (%v): %v", cause, err)
+	}
+	deleters := map[string]*deleter{}
+	for _, t := range flattenTables(c.tables) {
+		deleters[t.tableName] = t.deleter
+	}
+	tables := buildTableTree(schemas, indexes, c.backend, deleters)
+	groupTables(tables, c.groups)
+	c.mu.Lock()
+	c.tables = tables
+	c.mu.Unlock()
+
+	var added []*table
+	for _, t := range flattenTables(tables) {
+		if _, ok := deleters[t.tableName]; !ok {
+			added = append(added, t)
+		}
+	}
+	if c.onReplan != nil {
+		// The added tables are not deleted until onReplan has configured them, since this goroutine starts deletions.
+		r := replanned{cause: cause, added: added, done: make(chan struct{})}
+		select {
+		case c.replanned <- r:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, t := range added {
+		t.deleter.startRowCountUpdater(ctx, c.workers)
+	}
+	return nil
+}
+
+// schemaChangeMessages are parts of the messages of errors of deletions violating constraints unknown to the plan.
+var schemaChangeMessages = []string{
+	// A new table interleaved without ON DELETE CASCADE has rows.
+	"Integrity constraint violation during DELETE",
+	// A new foreign key references the rows.
+	"Foreign key constraint violation when deleting or updating referenced row",
+}
+
+// isSchemaChangeError returns true if the deletion failed since the schema has been changed during the run,
+// e.g. a new interleaved table or a foreign key has been added.
+// Other failed preconditions are not regarded as schema changes, since re-planning cannot fix them.
+func isSchemaChangeError(err error) bool {
+	msg := err.Error()
+	if !strings.Contains(msg, `code = "FailedPrecondition"`) {
+		return false
+	}
+	for _, m := range schemaChangeMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// resetStatus makes tables waiting again unless their deletion has completed.
+func resetStatus(tables []*table) {
+	for _, t := range tables {
+		t.deleter.reset()
+		resetStatus(t.childTables)
+	}
+}
+
+// sendErr notifies the error to waitCompleted.
+// It gives up sending once the context is done and nobody waits for the error.
+func (c *coordinator) sendErr(ctx context.Context, err error) {
+	select {
+	case c.errChan <- err:
+	case <-ctx.Done():
+		select {
+		case c.errChan <- err:
+		case <-time.After(time.Second):
+		}
+	}
 }
 
 // waitCompleted blocks until all deletions are completed.
 func (c *coordinator) waitCompleted() error {
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			if isAllTablesDeleted(c.tables) {
+			if isAllTablesDeleted(c.tableTree()) {
 				return nil
 			}
+		case r := <-c.replanned:
+			c.onReplan(r.cause, r.added)
+			close(r.done)
 		case err := <-c.errChan:
 			if err != nil {
 				return err
@@ -222,7 +506,7 @@ func (c *coordinator) waitCompleted() error {
 
 func isAllTablesDeleted(tables []*table) bool {
 	for _, table := range tables {
-		if table.deleter.status != statusCompleted {
+		if !table.deleter.isCompleted() {
 			return false
 		}
 		if !isAllTablesDeleted(table.childTables) {
@@ -234,7 +518,7 @@ func isAllTablesDeleted(tables []*table) bool {
 
 func isAnyTableDeleting(tables []*table) bool {
 	for _, table := range tables {
-		if table.deleter.status == statusDeleting || table.deleter.status == statusCascadeDeleting {
+		if s := table.deleter.currentStatus(); s == statusDeleting || s == statusCascadeDeleting {
 			return true
 		}
 		if isAnyTableDeleting(table.childTables) {
@@ -244,6 +528,18 @@ func isAnyTableDeleting(tables []*table) bool {
 	return false
 }
 
+// countDeletingTables returns the number of tables being deleted, excluding tables deleted by cascade.
+func countDeletingTables(tables []*table) int {
+	var count int
+	for _, table := range tables {
+		if table.deleter.currentStatus() == statusDeleting {
+			count++
+		}
+		count += countDeletingTables(table.childTables)
+	}
+	return count
+}
+
 // cascadeDelete marks all of child tables as cascade deleting status.
 func cascadeDelete(tables []*table) {
 	for _, table := range tables {
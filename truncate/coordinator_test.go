@@ -17,8 +17,13 @@
 package truncate
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/spanner"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -345,6 +350,18 @@ func TestTableIsDeletable(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			desc: "Parent table has a filter",
+			tableFunc: func() *table {
+				parent := &table{tableName: "Parent", filter: "CreatedAt < '2020-01-01'", deleter: &deleter{}}
+				child := &table{tableName: "Child", deleter: &deleter{}}
+				parent.childTables = []*table{child}
+				child.parentTableName = "Parent"
+				child.parentOnDeleteAction = deleteActionCascadeDelete
+				return parent
+			},
+			want: false,
+		},
 		{
 			desc: "Child table has no-action action",
 			tableFunc: func() *table {
@@ -451,3 +468,116 @@ func compareTables(tables1, tables2 []*table) bool {
 	}
 	return true
 }
+
+func TestBuildTableTreeReusesDeleters(t *testing.T) {
+	schemas := []*tableSchema{
+		{tableName: "A", referencedBy: []string{"B", "Unselected"}},
+		{tableName: "B"},
+		{tableName: "C", parentTableName: "A", parentOnDeleteAction: deleteActionCascadeDelete},
+	}
+	existing := &deleter{tableName: "A", status: statusCompleted}
+
+	tables := flattenTables(buildTableTree(schemas, nil, nil, map[string]*deleter{"A": existing}))
+	if len(tables) != 3 {
+		t.Fatalf("buildTableTree() returned %d tables, but want 3", len(tables))
+	}
+	a := tables[0]
+	if a.deleter != existing {
+		t.Errorf("deleter of A is not reused")
+	}
+	if len(a.referencedBy) != 1 || a.referencedBy[0].tableName != "B" {
+		t.Errorf("A is referenced by %v, but want only B", a.referencedBy)
+	}
+	for _, table := range tables[1:] {
+		if table.deleter == nil || table.deleter.status != statusAnalyzing {
+			t.Errorf("deleter of %s is not newly created", table.tableName)
+		}
+	}
+}
+
+func TestIsSchemaChangeError(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{
+			desc: "New interleaved table",
+			err:  errors.New(`spanner: code = "FailedPrecondition", desc = "Integrity constraint violation during DELETE/REPLACE. Found child row [1] in table Concerts."`),
+			want: true,
+		},
+		{
+			desc: "New foreign key",
+			err:  errors.New(`spanner: code = "FailedPrecondition", desc = "Foreign key constraint violation when deleting or updating referenced row(s): referencing row(s) found in table ` + "`Concerts`" + `."`),
+			want: true,
+		},
+		{
+			desc: "Other failed precondition",
+			err:  errors.New(`spanner: code = "FailedPrecondition", desc = "Cannot execute the statement in a read-only transaction."`),
+		},
+		{
+			desc: "Other code",
+			err:  errors.New(`spanner: code = "Aborted", desc = "Integrity constraint violation during DELETE/REPLACE."`),
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := isSchemaChangeError(tt.err); got != tt.want {
+				t.Errorf("isSchemaChangeError() = %t, but want = %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// schemaChangeBackend fails the first deletion of the table as if a table were interleaved in it during the run.
+type schemaChangeBackend struct {
+	*fakeBackend
+	table  string
+	failed bool
+}
+
+func (b *schemaChangeBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	b.mu.Lock()
+	failed := b.failed
+	b.failed = true
+	b.mu.Unlock()
+	if !failed && stmt.SQL == b.Dialect().deleteAllSQL(b.table) {
+		return errors.New(`spanner: code = "FailedPrecondition", desc = "Integrity constraint violation during DELETE/REPLACE. Found child row [1] in table Concerts."`)
+	}
+	return b.fakeBackend.PartitionedUpdate(ctx, stmt, priority)
+}
+
+func TestCoordinatorReplan(t *testing.T) {
+	b := &schemaChangeBackend{fakeBackend: &fakeBackend{rows: map[string]int64{"Singers": 2, "Concerts": 3}}, table: "Singers"}
+	c := newCoordinator([]*tableSchema{{tableName: "Singers", referencedBy: []string{}}}, nil, b)
+	c.workers = newWorkerGroup()
+	c.replan = func(ctx context.Context) ([]*tableSchema, []*indexSchema, error) {
+		return []*tableSchema{
+			{tableName: "Singers", referencedBy: []string{}},
+			{tableName: "Concerts", parentTableName: "Singers", parentOnDeleteAction: deleteActionNoAction, referencedBy: []string{}},
+		}, nil, nil
+	}
+	var mu sync.Mutex
+	var added []string
+	c.onReplan = func(cause error, tables []*table) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, t := range tables {
+			added = append(added, t.tableName)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c.start(ctx)
+	if err := c.waitCompleted(); err != nil {
+		t.Fatalf("waitCompleted() failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"Concerts"}, added); diff != "" {
+		t.Errorf("added tables mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]int64{"Singers": 0, "Concerts": 0}, b.rows); diff != "" {
+		t.Errorf("rows mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,216 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+	"google.golang.org/api/iterator"
+	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+)
+
+// RunDatabases deletes rows from each of the databases in the instance in turn, as Run does.
+// The schema is fetched from the first database and shared with the other databases whose DDL is identical,
+// so that nightly cleanups of several environments with the same schema don't fetch it repeatedly.
+// A failure of a database does not stop the others, and the returned error lists all failed databases.
+func RunDatabases(ctx context.Context, projectID, instanceID string, databaseIDs []string, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	out := o.messageOutput()
+	shared := &sharedSchema{}
+	var failed []string
+	for i, databaseID := range databaseIDs {
+		if ctx.Err() != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", databaseID, ctx.Err()))
+			continue
+		}
+		fmt.Fprintf(out.writer(), "\n[%d/%d] %s\n", i+1, len(databaseIDs), databaseID)
+		if err := runDatabase(ctx, projectID, instanceID, databaseID, o, shared); err != nil {
+			out.warnf("failed to truncate %s: %v", databaseID, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", databaseID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to truncate %d of %d databases:\n%s", len(failed), len(databaseIDs), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// ListDatabases returns the IDs of the ready databases in the instance, except those matching any of the glob patterns,
+// e.g. "prod*", so that all databases of the instance can be truncated by RunDatabases.
+func ListDatabases(ctx context.Context, projectID, instanceID string, excludes []string, opts ...Option) ([]string, error) {
+	for _, pattern := range excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid database pattern %q: %v", pattern, err)
+		}
+	}
+	client, err := adminapi.NewDatabaseAdminClient(ctx, NewOptions(opts...).spannerClientOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Spanner database admin client: %v", err)
+	}
+	defer client.Close()
+
+	var ids []string
+	iter := client.ListDatabases(ctx, &adminpb.ListDatabasesRequest{Parent: fmt.Sprintf("projects/%s/instances/%s", projectID, instanceID)})
+	for {
+		db, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list databases: %v", err)
+		}
+		// Databases being created or restored cannot be truncated yet.
+		if db.State != adminpb.Database_READY && db.State != adminpb.Database_READY_OPTIMIZING {
+			continue
+		}
+		ids = append(ids, db.Name[strings.LastIndex(db.Name, "/")+1:])
+	}
+	return excludeDatabases(ids, excludes), nil
+}
+
+// excludeDatabases returns the databases not matching any of the glob patterns.
+func excludeDatabases(ids, excludes []string) []string {
+	var included []string
+	for _, id := range ids {
+		excluded := false
+		for _, pattern := range excludes {
+			if globMatch(pattern, id) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			included = append(included, id)
+		}
+	}
+	return included
+}
+
+// sharedSchema is the schema of the first database of RunDatabases, shared with the other databases.
+type sharedSchema struct {
+	mu          sync.Mutex
+	source      string
+	hash        string
+	indexes     *[]IndexInfo
+	primaryKeys map[string][]KeyColumn
+	graphs      *[]PropertyGraphInfo
+}
+
+// sharedSchemaBackend is a backend sharing the schema of the first database with the other databases.
+// The tables of each database are always fetched, and the other schema is shared only if the hash of the DDL
+// is the same as that of the first database, since keys and indexes may differ between databases with identical tables.
+type sharedSchemaBackend struct {
+	Backend
+	shared *sharedSchema
+	// same is true if the DDL of the database is the same as that of the first database.
+	same bool
+}
+
+// newSharedSchemaBackend wraps the backend of the database whose DDL hashes to hash.
+// The schema is never shared if hash is empty, e.g. since the DDL is not available.
+func newSharedSchemaBackend(b Backend, shared *sharedSchema, hash string) *sharedSchemaBackend {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.source == "" {
+		shared.source, shared.hash = b.DatabaseName(), hash
+	}
+	same := b.DatabaseName() != shared.source && hash != "" && hash == shared.hash
+	return &sharedSchemaBackend{Backend: b, shared: shared, same: same}
+}
+
+// sharedSchemaHash returns the hash of the DDL of the database to share its schema, or "" if the DDL is not available.
+func sharedSchemaHash(ctx context.Context, b Backend, database string, o *Options) string {
+	if cached, ok := b.(*cachedSchemaBackend); ok {
+		return cached.entry.SchemaHash
+	}
+	if o.Backend != nil || o.ReplayFile != "" {
+		return ""
+	}
+	hash, err := schemaHash(ctx, database, o)
+	if err != nil {
+		o.messageOutput().warnf("The schema of %s is not shared with other databases: %v", database, err)
+		return ""
+	}
+	return hash
+}
+
+// isSource returns true if the backend is of the database whose schema is shared.
+func (b *sharedSchemaBackend) isSource() bool {
+	return b.DatabaseName() == b.shared.source
+}
+
+func (b *sharedSchemaBackend) Indexes(ctx context.Context) ([]IndexInfo, error) {
+	b.shared.mu.Lock()
+	shared, same := b.shared.indexes, b.same
+	b.shared.mu.Unlock()
+	if same && shared != nil {
+		return *shared, nil
+	}
+	indexes, err := b.Backend.Indexes(ctx)
+	if err == nil && b.isSource() {
+		b.shared.mu.Lock()
+		if b.shared.indexes == nil {
+			b.shared.indexes = &indexes
+		}
+		b.shared.mu.Unlock()
+	}
+	return indexes, err
+}
+
+func (b *sharedSchemaBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	b.shared.mu.Lock()
+	shared, same := b.shared.primaryKeys, b.same
+	b.shared.mu.Unlock()
+	if same && shared != nil {
+		return shared, nil
+	}
+	keys, err := b.Backend.PrimaryKeys(ctx)
+	if err == nil && b.isSource() {
+		b.shared.mu.Lock()
+		if b.shared.primaryKeys == nil {
+			b.shared.primaryKeys = keys
+		}
+		b.shared.mu.Unlock()
+	}
+	return keys, err
+}
+
+func (b *sharedSchemaBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	b.shared.mu.Lock()
+	shared, same := b.shared.graphs, b.same
+	b.shared.mu.Unlock()
+	if same && shared != nil {
+		return *shared, nil
+	}
+	graphs, err := b.Backend.PropertyGraphs(ctx)
+	if err == nil && b.isSource() {
+		b.shared.mu.Lock()
+		if b.shared.graphs == nil {
+			b.shared.graphs = &graphs
+		}
+		b.shared.mu.Unlock()
+	}
+	return graphs, err
+}
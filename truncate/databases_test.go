@@ -0,0 +1,85 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// namedBackend is a fake backend of a named database counting fetches of property graphs.
+type namedBackend struct {
+	*fakeBackend
+	name   string
+	graphs int
+}
+
+func (b *namedBackend) DatabaseName() string { return b.name }
+
+func (b *namedBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	b.graphs++
+	return b.fakeBackend.PropertyGraphs(ctx)
+}
+
+func TestSharedSchemaBackend(t *testing.T) {
+	ctx := context.Background()
+	tables := []TableInfo{{Name: "Singers"}, {Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"}}
+	graphs := []PropertyGraphInfo{{Name: "MusicGraph", BaseTables: []string{"Singers"}}}
+	shared := &sharedSchema{}
+
+	for _, tt := range []struct {
+		desc       string
+		hash       string
+		tables     []TableInfo
+		graphs     []PropertyGraphInfo
+		wantFetch  int
+		wantGraphs int
+	}{
+		{desc: "First database", hash: "h1", tables: tables, graphs: graphs, wantFetch: 1, wantGraphs: 1},
+		{desc: "Identical DDL", hash: "h1", tables: tables, wantFetch: 0, wantGraphs: 1},
+		{desc: "Identical tables with different DDL, e.g. indexes", hash: "h2", tables: tables, wantFetch: 1, wantGraphs: 0},
+		{desc: "DDL not available", tables: tables, wantFetch: 1, wantGraphs: 0},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			underlying := &namedBackend{fakeBackend: &fakeBackend{tables: tt.tables, graphs: tt.graphs}, name: tt.desc}
+			b := newSharedSchemaBackend(underlying, shared, tt.hash)
+			if _, err := b.Tables(ctx); err != nil {
+				t.Fatalf("Tables() failed: %v", err)
+			}
+			got, err := b.PropertyGraphs(ctx)
+			if err != nil {
+				t.Fatalf("PropertyGraphs() failed: %v", err)
+			}
+			if underlying.graphs != tt.wantFetch {
+				t.Errorf("PropertyGraphs() fetched %d times, but want = %d", underlying.graphs, tt.wantFetch)
+			}
+			if len(got) != tt.wantGraphs {
+				t.Errorf("PropertyGraphs() = %v, but want %d graphs", got, tt.wantGraphs)
+			}
+		})
+	}
+}
+
+func TestExcludeDatabases(t *testing.T) {
+	ids := []string{"dev", "feature-login", "feature-search", "prod", "staging"}
+	got := excludeDatabases(ids, []string{"prod", "stag*"})
+	if diff := cmp.Diff([]string{"dev", "feature-login", "feature-search"}, got); diff != "" {
+		t.Errorf("excludeDatabases() mismatch (-want +got):\n%s", diff)
+	}
+}
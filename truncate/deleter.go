@@ -18,10 +18,16 @@ package truncate
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/spanner"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 // Status is a delete status.
@@ -38,8 +44,28 @@ const (
 // deleter deletes all rows from the table.
 type deleter struct {
 	tableName string
-	client    *spanner.Client
-	status    status
+	backend   Backend
+	priority  Priority
+	strategy  Strategy
+	// filter is the condition of rows to be deleted. All rows are deleted if empty.
+	filter string
+	// params are the values of the parameters of the filter.
+	params map[string]string
+
+	// stmtLog logs statements in verbose mode. It may be nil.
+	stmtLog *statementLogger
+
+	// Primary key columns, estimated mutations per deleted row and the shared budgets of mutations and rows
+	// used by StrategyBatch.
+	keyColumns      []KeyColumn
+	mutationsPerRow int
+	budget          *mutationBudget
+	rowBudget       *mutationBudget
+
+	// mu guards the status, row counts, times, error and dropped below, which workers update concurrently
+	// while progress bars, reports and the coordinator read them.
+	mu     sync.Mutex
+	status status
 
 	// Total rows in the table.
 	// Once set, we don't update this number even if new rows are added to the table.
@@ -47,28 +73,222 @@ type deleter struct {
 
 	// Remained rows in the table.
 	remainedRows uint64
+
+	// Time when the deletion started and completed, and the error of the deletion.
+	startedAt   time.Time
+	completedAt time.Time
+	err         error
+
+	// The table was dropped during the run, so regarded as deleted.
+	dropped bool
+
+	// Failpoints making the deletion fail at specific points, for testing.
+	failpoints failpoints
+
+	// Rows deleted by StrategyBatch and mutations reported by commit statistics, updated atomically.
+	keysDeleted int64
+	mutations   int64
+	// chunk is the number of the chunk being deleted by StrategyBatch, updated atomically.
+	chunk int64
+
+	// throughput records rows and mutations deleted per second for the throughput graph. It may be nil.
+	throughput *throughput
+
+	// boundedReads records the commit timestamps of deletes in lastCommit, to verify the deletion with bounded-staleness reads.
+	boundedReads bool
+	lastCommit   commitRecorder
 }
 
-// deleteRows deletes rows from the table using PDML.
-func (d *deleter) deleteRows(ctx context.Context) error {
+// deleterState is a snapshot of the state of a deleter guarded by its mutex.
+type deleterState struct {
+	status       status
+	totalRows    uint64
+	remainedRows uint64
+	startedAt    time.Time
+	completedAt  time.Time
+	err          error
+	dropped      bool
+}
+
+// snapshot returns the current state of the deleter.
+func (d *deleter) snapshot() deleterState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return deleterState{
+		status:       d.status,
+		totalRows:    d.totalRows,
+		remainedRows: d.remainedRows,
+		startedAt:    d.startedAt,
+		completedAt:  d.completedAt,
+		err:          d.err,
+		dropped:      d.dropped,
+	}
+}
+
+// currentStatus returns the status of the deletion.
+func (d *deleter) currentStatus() status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// isCompleted returns true if the deletion has completed.
+func (d *deleter) isCompleted() bool {
+	return d.currentStatus() == statusCompleted
+}
+
+// setStatus sets the status of the deletion, e.g. to simulate the deletion order.
+func (d *deleter) setStatus(s status) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = s
+}
+
+// total returns the number of rows counted at the start.
+func (d *deleter) total() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.totalRows
+}
+
+// deletedRows returns the number of rows deleted so far.
+// Rows deleted by StrategyBatch are counted exactly, and rows deleted by Partitioned DML are estimated by row counts.
+func (d *deleter) deletedRows() uint64 {
+	if n := atomic.LoadInt64(&d.keysDeleted); n > 0 {
+		return uint64(n)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.remainedRows > d.totalRows {
+		return 0
+	}
+	return d.totalRows - d.remainedRows
+}
+
+// markStarted marks the deletion started.
+func (d *deleter) markStarted() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	d.status = statusDeleting
-	stmt := spanner.NewStatement(fmt.Sprintf("DELETE FROM `%s` WHERE true", d.tableName))
-	_, err := d.client.PartitionedUpdate(ctx, stmt)
+	d.startedAt = time.Now()
+}
+
+// markFailed records the error of the deletion.
+func (d *deleter) markFailed(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+}
+
+// reset makes the deletion waiting again unless it has completed, e.g. to re-plan it after a schema change.
+func (d *deleter) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status != statusCompleted {
+		d.status = statusWaiting
+		d.err = nil
+	}
+}
+
+// deleteRows deletes rows from the table using the strategy of the deleter.
+func (d *deleter) deleteRows(ctx context.Context) error {
+	if d.boundedReads {
+		ctx = withCommitRecorder(ctx, &d.lastCommit)
+	}
+	d.markStarted()
+	err := d.failpoints.check(failpointBeforeDelete, d.tableName, 0)
+	if err == nil && d.strategy == StrategyBatch {
+		err = d.deleteRowsInBatches(ctx)
+	} else if err == nil {
+		err = d.deleteRowsWithPDML(ctx)
+	}
+	if err != nil && isTableNotFound(err) {
+		d.markDropped()
+		return nil
+	}
+	if err != nil && ctx.Err() == nil {
+		d.markFailed(err)
+	}
 	return err
 }
 
+// tableNotFoundPattern matches the descriptions of errors of a missing table in GoogleSQL and PostgreSQL dialects.
+var tableNotFoundPattern = regexp.MustCompile(`^Table not found: |^relation ".*" does not exist`)
+
+// pgUndefinedTable is the SQLSTATE of a missing table reported through PGAdapter.
+const pgUndefinedTable = "42P01"
+
+// isTableNotFound returns true if the error is caused by the table dropped concurrently.
+// Cloud Spanner reports a missing table in a query with InvalidArgument, and in a read with NotFound.
+func isTableNotFound(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pgUndefinedTable || tableNotFoundPattern.MatchString(pqErr.Message)
+	}
+	s, ok := grpcstatus.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.NotFound, codes.InvalidArgument:
+		return tableNotFoundPattern.MatchString(s.Message())
+	}
+	return false
+}
+
+// markDropped regards the table as deleted since it has been dropped during the run.
+func (d *deleter) markDropped() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dropped = true
+	d.remainedRows = 0
+	if d.status != statusCompleted {
+		d.completedAt = time.Now()
+	}
+	d.status = statusCompleted
+}
+
+// markGroupDeleted marks the table as deleted with the rows deleted in the transaction of its consistency group.
+func (d *deleter) markGroupDeleted(rows int64) {
+	atomic.StoreInt64(&d.keysDeleted, rows)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.remainedRows = 0
+	d.completedAt = time.Now()
+	d.status = statusCompleted
+}
+
+// deleteRowsWithPDML deletes rows from the table using PDML.
+func (d *deleter) deleteRowsWithPDML(ctx context.Context) error {
+	stmt := filterStatement(d.backend.Dialect().deleteMatchingSQL(d.tableName, d.filter), d.params)
+	d.stmtLog.log(d.tableName, stmt)
+	if err := d.failpoints.check(failpointBeforeCommit, d.tableName, 1); err != nil {
+		return err
+	}
+	finished := inflight.start(d, stmt.SQL)
+	err := d.backend.PartitionedUpdate(ctx, stmt, d.priority)
+	finished()
+	if err != nil {
+		return err
+	}
+	return d.failpoints.check(failpointAfterCommit, d.tableName, 1)
+}
+
 // When parent deletion started, change child status unless the child deletion has already completed.
 func (d *deleter) parentDeletionStarted() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.status != statusCompleted {
 		d.status = statusCascadeDeleting
+		d.startedAt = time.Now()
 	}
 }
 
 // startRowCountUpdater starts periodical row count in another goroutine.
-func (d *deleter) startRowCountUpdater(ctx context.Context) {
-	go func() {
+func (d *deleter) startRowCountUpdater(ctx context.Context, workers *workerGroup) {
+	workers.spawn("row count updater of "+d.tableName, func() {
 		for {
-			if d.status == statusCompleted {
+			if d.isCompleted() || ctx.Err() != nil {
 				return
 			}
 
@@ -78,29 +298,37 @@ func (d *deleter) startRowCountUpdater(ctx context.Context) {
 			d.updateRowCount(ctx)
 
 			// Sleep for a while to minimize the impact on CPU usage caused by SELECT COUNT(*) queries.
-			time.Sleep(time.Since(begin) * 10)
+			select {
+			case <-time.After(time.Since(begin) * 10):
+			case <-ctx.Done():
+				return
+			}
 		}
-	}()
+	})
 }
 
 func (d *deleter) updateRowCount(ctx context.Context) error {
-	stmt := spanner.NewStatement(fmt.Sprintf("SELECT COUNT(*) as count FROM `%s`", d.tableName))
-	var count int64
-
 	// Use stale read to minimize the impact on the leader replica.
-	txn := d.client.Single().WithTimestampBound(spanner.ExactStaleness(time.Second))
-	if err := txn.Query(ctx, stmt).Do(func(r *spanner.Row) error {
-		return r.ColumnByName("count", &count)
-	}); err != nil {
+	count, err := d.countRows(ctx, time.Second)
+	if err != nil {
+		if isTableNotFound(err) {
+			d.markDropped()
+			return nil
+		}
 		return err
 	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.totalRows == 0 {
 		d.totalRows = uint64(count)
 	}
 	d.remainedRows = uint64(count)
 
 	if count == 0 {
+		if d.status != statusCompleted {
+			d.completedAt = time.Now()
+		}
 		d.status = statusCompleted
 	} else if d.status == statusAnalyzing {
 		d.status = statusWaiting
@@ -108,3 +336,32 @@ func (d *deleter) updateRowCount(ctx context.Context) error {
 
 	return nil
 }
+
+// verify returns the number of rows remaining in the table using a strong read.
+func (d *deleter) verify(ctx context.Context) (uint64, error) {
+	count, err := d.countRows(ctx, 0)
+	if err != nil {
+		if isTableNotFound(err) {
+			d.markDropped()
+			return 0, nil
+		}
+		return 0, err
+	}
+	return uint64(count), nil
+}
+
+// countRows counts rows in the table matching the filter. A stale read is used if staleness is positive,
+// which is bounded by the last commit to the table with bounded reads, so that counts observe the deletes.
+func (d *deleter) countRows(ctx context.Context, staleness time.Duration) (int64, error) {
+	if d.boundedReads && staleness > 0 {
+		ctx = withMinReadTimestamp(ctx, d.lastCommit.timestamp())
+	}
+	return d.count(ctx, filterStatement(d.backend.Dialect().countMatchingSQL(d.tableName, d.filter), d.params), staleness)
+}
+
+// count executes a query of the table returning a single INT64 value.
+func (d *deleter) count(ctx context.Context, stmt spanner.Statement, staleness time.Duration) (int64, error) {
+	d.stmtLog.log(d.tableName, stmt)
+	defer inflight.start(d, stmt.SQL)()
+	return d.backend.Count(ctx, stmt, staleness, d.priority)
+}
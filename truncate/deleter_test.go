@@ -0,0 +1,100 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestIsTableNotFound(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{desc: "Query of a missing table", err: grpcstatus.Error(codes.InvalidArgument, "Table not found: Singers [at 1:15]"), want: true},
+		{desc: "Read of a missing table", err: grpcstatus.Error(codes.NotFound, "Table not found: Singers"), want: true},
+		{desc: "PostgreSQL dialect", err: grpcstatus.Error(codes.InvalidArgument, `relation "singers" does not exist`), want: true},
+		{desc: "PGAdapter", err: &pq.Error{Code: pgUndefinedTable, Message: `relation "singers" does not exist`}, want: true},
+		{desc: "Other PGAdapter error", err: &pq.Error{Code: "42601", Message: "syntax error at or near \"FROM\""}, want: false},
+		{desc: "Missing database", err: grpcstatus.Error(codes.NotFound, "Database not found: projects/p/instances/i/databases/d"), want: false},
+		{desc: "Other code mentioning the phrase", err: grpcstatus.Error(codes.Aborted, "Table not found: Singers"), want: false},
+		{desc: "Phrase in an unrelated message", err: errors.New("failed to check: Table not found: Singers"), want: false},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := isTableNotFound(tt.err); got != tt.want {
+				t.Errorf("isTableNotFound(%v) = %t, but want = %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// droppedBackend fails every query and DML as if the table had been dropped.
+type droppedBackend struct {
+	fakeBackend
+	err error
+}
+
+func (b *droppedBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	return b.err
+}
+
+func (b *droppedBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	return nil, b.err
+}
+
+func TestDeleteRowsDropped(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		strategy Strategy
+		err      error
+		want     bool
+	}{
+		{desc: "Partitioned DML", strategy: StrategyPDML, err: grpcstatus.Error(codes.InvalidArgument, "Table not found: Singers"), want: true},
+		{desc: "Batch", strategy: StrategyBatch, err: grpcstatus.Error(codes.InvalidArgument, "Table not found: Singers"), want: true},
+		{desc: "PGAdapter", strategy: StrategyPDML, err: &pq.Error{Code: pgUndefinedTable, Message: `relation "Singers" does not exist`}, want: true},
+		{desc: "Other error", strategy: StrategyPDML, err: fmt.Errorf("deadline exceeded"), want: false},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			d := &deleter{
+				tableName:       "Singers",
+				backend:         &droppedBackend{err: tt.err},
+				strategy:        tt.strategy,
+				keyColumns:      []KeyColumn{{Name: "SingerId", SpannerType: "INT64"}},
+				mutationsPerRow: 1,
+			}
+			err := d.deleteRows(context.Background())
+			if got := d.snapshot().dropped; got != tt.want {
+				t.Errorf("deleteRows() dropped = %t, but want = %t", got, tt.want)
+			}
+			if tt.want && err != nil {
+				t.Errorf("deleteRows() = %v, but want nil for a dropped table", err)
+			}
+			if !tt.want && err == nil {
+				t.Errorf("deleteRows() = nil, but want an error")
+			}
+		})
+	}
+}
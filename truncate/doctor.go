@@ -0,0 +1,189 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+)
+
+// Severity of a diagnosis of the runtime environment.
+const (
+	DiagnosisOK      = "ok"      // Nothing is wrong.
+	DiagnosisWarning = "warning" // The environment may not work as expected.
+	DiagnosisError   = "error"   // The environment is known to break the Cloud Spanner client.
+)
+
+// spannerHost is the host of Cloud Spanner, to which the client connects through proxies.
+const spannerHost = "spanner.googleapis.com"
+
+// Diagnosis is the result of a check of the runtime environment.
+type Diagnosis struct {
+	Check    string
+	Severity string
+	Message  string
+}
+
+// buildInfo is the build metadata of the running binary.
+type buildInfo struct {
+	version string
+	goos    string
+	goarch  string
+	cgo     bool
+}
+
+// currentBuild returns the build metadata of the running binary.
+func currentBuild() buildInfo {
+	b := buildInfo{version: "(devel)", goos: runtime.GOOS, goarch: runtime.GOARCH, cgo: cgoEnabled}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		b.version = info.Main.Version
+	}
+	return b
+}
+
+// DiagnoseEnvironment checks the runtime environment for misconfigurations known to break the Cloud Spanner client,
+// such as proxies which gRPC cannot connect through, which typically make the first query hang.
+func DiagnoseEnvironment() []Diagnosis {
+	return diagnoseEnvironment(currentBuild(), os.Getenv)
+}
+
+func diagnoseEnvironment(build buildInfo, getenv func(string) string) []Diagnosis {
+	diagnoses := []Diagnosis{diagnosePlatform(build), diagnoseBuild(build)}
+	diagnoses = append(diagnoses, diagnoseProxy(getenv)...)
+	diagnoses = append(diagnoses, diagnoseEmulator(getenv), diagnoseCredentials(getenv))
+	return diagnoses
+}
+
+func diagnosePlatform(build buildInfo) Diagnosis {
+	d := Diagnosis{Check: "platform", Severity: DiagnosisOK, Message: fmt.Sprintf("spanner-truncate %s built with %s for %s/%s", build.version, runtime.Version(), build.goos, build.goarch)}
+	switch build.goarch {
+	case "386", "arm", "mips", "mipsle":
+		d.Severity = DiagnosisWarning
+		d.Message += ", a 32-bit platform which is not tested"
+	}
+	return d
+}
+
+func diagnoseBuild(build buildInfo) Diagnosis {
+	if build.cgo {
+		return Diagnosis{Check: "build", Severity: DiagnosisWarning,
+			Message: "built with cgo, so the binary depends on the system C library and its DNS resolver; build with CGO_ENABLED=0 for a static binary"}
+	}
+	return Diagnosis{Check: "build", Severity: DiagnosisOK, Message: "static binary built without cgo"}
+}
+
+// lookupEnv returns the first non-empty value of the environment variables and its name.
+func lookupEnv(getenv func(string) string, names ...string) (string, string) {
+	for _, name := range names {
+		if v := getenv(name); v != "" {
+			return name, v
+		}
+	}
+	return "", ""
+}
+
+func diagnoseProxy(getenv func(string) string) []Diagnosis {
+	httpsName, httpsProxy := lookupEnv(getenv, "HTTPS_PROXY", "https_proxy")
+	httpName, _ := lookupEnv(getenv, "HTTP_PROXY", "http_proxy")
+	if httpsProxy == "" {
+		if httpName != "" {
+			return []Diagnosis{{Check: "proxy", Severity: DiagnosisWarning,
+				Message: fmt.Sprintf("%s is set but HTTPS_PROXY is not, so connections to %s bypass the proxy and may hang if only the proxy can reach it", httpName, spannerHost)}}
+		}
+		return []Diagnosis{{Check: "proxy", Severity: DiagnosisOK, Message: "no proxy is configured"}}
+	}
+
+	var diagnoses []Diagnosis
+	// Values of proxies are not written since they may have credentials, except their hosts.
+	if upper, lower := getenv("HTTPS_PROXY"), getenv("https_proxy"); upper != "" && lower != "" && upper != lower {
+		diagnoses = append(diagnoses, Diagnosis{Check: "proxy", Severity: DiagnosisWarning,
+			Message: "HTTPS_PROXY and https_proxy differ, and HTTPS_PROXY is used"})
+	}
+	if _, noProxy := lookupEnv(getenv, "NO_PROXY", "no_proxy"); bypassesProxy(noProxy, spannerHost) {
+		return append(diagnoses, Diagnosis{Check: "proxy", Severity: DiagnosisOK, Message: fmt.Sprintf("%s bypasses the proxy by NO_PROXY", spannerHost)})
+	}
+	// Proxies without a scheme are HTTP proxies, as in net/http.
+	u, err := url.Parse(httpsProxy)
+	if err != nil || u.Host == "" {
+		u, err = url.Parse("http://" + httpsProxy)
+	}
+	switch {
+	case err != nil || u.Host == "":
+		diagnoses = append(diagnoses, Diagnosis{Check: "proxy", Severity: DiagnosisError, Message: fmt.Sprintf("%s is not a valid proxy URL", httpsName)})
+	case u.Scheme == "http":
+		diagnoses = append(diagnoses, Diagnosis{Check: "proxy", Severity: DiagnosisOK, Message: fmt.Sprintf("connections to %s go through the HTTP proxy %s", spannerHost, u.Host)})
+	case u.Scheme == "https":
+		diagnoses = append(diagnoses, Diagnosis{Check: "proxy", Severity: DiagnosisError,
+			Message: fmt.Sprintf("%s is an https:// proxy, but gRPC connects to proxies with plaintext HTTP CONNECT, so the first query hangs; use http://%s", httpsName, u.Host)})
+	default:
+		diagnoses = append(diagnoses, Diagnosis{Check: "proxy", Severity: DiagnosisError,
+			Message: fmt.Sprintf("%s is a %s:// proxy, which gRPC does not support; use an HTTP CONNECT proxy", httpsName, u.Scheme)})
+	}
+	return diagnoses
+}
+
+// bypassesProxy returns true if the host matches NO_PROXY, i.e. "*", the host itself or a domain suffix of it.
+func bypassesProxy(noProxy, host string) bool {
+	for _, p := range strings.Split(noProxy, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "*")
+		if p == "" {
+			continue
+		}
+		if host == strings.TrimPrefix(p, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(p, ".")) {
+			return true
+		}
+	}
+	return strings.TrimSpace(noProxy) == "*"
+}
+
+func diagnoseEmulator(getenv func(string) string) Diagnosis {
+	if host := getenv(emulatorHostEnv); host != "" {
+		return Diagnosis{Check: "emulator", Severity: DiagnosisWarning,
+			Message: fmt.Sprintf("%s is set, so the tool connects to the emulator at %s instead of Cloud Spanner", emulatorHostEnv, host)}
+	}
+	return Diagnosis{Check: "emulator", Severity: DiagnosisOK, Message: fmt.Sprintf("%s is not set", emulatorHostEnv)}
+}
+
+func diagnoseCredentials(getenv func(string) string) Diagnosis {
+	path := getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		return Diagnosis{Check: "credentials", Severity: DiagnosisOK, Message: "GOOGLE_APPLICATION_CREDENTIALS is not set, so gcloud or metadata server credentials are used"}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return Diagnosis{Check: "credentials", Severity: DiagnosisError, Message: fmt.Sprintf("GOOGLE_APPLICATION_CREDENTIALS is not readable: %v", err)}
+	}
+	return Diagnosis{Check: "credentials", Severity: DiagnosisOK, Message: fmt.Sprintf("GOOGLE_APPLICATION_CREDENTIALS is %s", path)}
+}
+
+// WriteDiagnoses writes the diagnoses, and returns true if none of them is an error.
+func WriteDiagnoses(w io.Writer, diagnoses []Diagnosis) (bool, error) {
+	healthy := true
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, d := range diagnoses {
+		if d.Severity == DiagnosisError {
+			healthy = false
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Severity, d.Check, d.Message)
+	}
+	return healthy, tw.Flush()
+}
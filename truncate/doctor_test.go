@@ -0,0 +1,104 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiagnoseProxy(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		env  map[string]string
+		want []Diagnosis
+	}{
+		{
+			desc: "No proxy",
+			want: []Diagnosis{{Check: "proxy", Severity: DiagnosisOK, Message: "no proxy is configured"}},
+		},
+		{
+			desc: "Only HTTP proxy",
+			env:  map[string]string{"http_proxy": "proxy:3128"},
+			want: []Diagnosis{{Check: "proxy", Severity: DiagnosisWarning, Message: "http_proxy is set but HTTPS_PROXY is not, so connections to spanner.googleapis.com bypass the proxy and may hang if only the proxy can reach it"}},
+		},
+		{
+			desc: "HTTP proxy without a scheme",
+			env:  map[string]string{"HTTPS_PROXY": "proxy:3128"},
+			want: []Diagnosis{{Check: "proxy", Severity: DiagnosisOK, Message: "connections to spanner.googleapis.com go through the HTTP proxy proxy:3128"}},
+		},
+		{
+			desc: "HTTPS proxy",
+			env:  map[string]string{"https_proxy": "https://proxy:3128"},
+			want: []Diagnosis{{Check: "proxy", Severity: DiagnosisError, Message: "https_proxy is an https:// proxy, but gRPC connects to proxies with plaintext HTTP CONNECT, so the first query hangs; use http://proxy:3128"}},
+		},
+		{
+			desc: "SOCKS proxy with different cases",
+			env:  map[string]string{"HTTPS_PROXY": "socks5://proxy:1080", "https_proxy": "http://proxy:3128"},
+			want: []Diagnosis{
+				{Check: "proxy", Severity: DiagnosisWarning, Message: "HTTPS_PROXY and https_proxy differ, and HTTPS_PROXY is used"},
+				{Check: "proxy", Severity: DiagnosisError, Message: "HTTPS_PROXY is a socks5:// proxy, which gRPC does not support; use an HTTP CONNECT proxy"},
+			},
+		},
+		{
+			desc: "Bypassed by NO_PROXY",
+			env:  map[string]string{"HTTPS_PROXY": "https://proxy:3128", "NO_PROXY": "localhost, .googleapis.com"},
+			want: []Diagnosis{{Check: "proxy", Severity: DiagnosisOK, Message: "spanner.googleapis.com bypasses the proxy by NO_PROXY"}},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := diagnoseProxy(func(name string) string { return tt.env[name] })
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("diagnoseProxy() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiagnoseBuild(t *testing.T) {
+	if d := diagnosePlatform(buildInfo{version: "v1.0.0", goos: "linux", goarch: "arm"}); d.Severity != DiagnosisWarning {
+		t.Errorf("diagnosePlatform() = %v, but want a warning for a 32-bit platform", d)
+	}
+	if d := diagnosePlatform(buildInfo{version: "v1.0.0", goos: "darwin", goarch: "arm64"}); d.Severity != DiagnosisOK {
+		t.Errorf("diagnosePlatform() = %v, but want ok", d)
+	}
+	if d := diagnoseBuild(buildInfo{cgo: true}); d.Severity != DiagnosisWarning {
+		t.Errorf("diagnoseBuild() = %v, but want a warning for a cgo build", d)
+	}
+}
+
+func TestWriteDiagnoses(t *testing.T) {
+	var buf bytes.Buffer
+	healthy, err := WriteDiagnoses(&buf, []Diagnosis{
+		{Check: "build", Severity: DiagnosisOK, Message: "static binary built without cgo"},
+		{Check: "credentials", Severity: DiagnosisError, Message: "GOOGLE_APPLICATION_CREDENTIALS is not readable"},
+	})
+	if err != nil {
+		t.Fatalf("WriteDiagnoses() failed: %v", err)
+	}
+	if healthy {
+		t.Errorf("WriteDiagnoses() = true, but want false with an error")
+	}
+	want := `ok     build        static binary built without cgo
+error  credentials  GOOGLE_APPLICATION_CREDENTIALS is not readable
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("WriteDiagnoses() output mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,210 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OutputFormat is the format of the output of a run.
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = ""     // Human-readable messages.
+	OutputJSON OutputFormat = "json" // The computed plan as JSON, without deleting anything.
+)
+
+// plannedDeletion is a table started in a simulated run and the child tables cascaded by its deletion.
+type plannedDeletion struct {
+	table    *table
+	cascaded []string
+}
+
+// simulateOrder drives the coordinator without deleting anything and returns the tables started at each step,
+// assuming that all deletions started at a step complete before the next step, and that waits between stages elapse at once.
+// Child tables deleted by the deletion of their parents are not started by themselves.
+// The statuses of the tables of the coordinator are changed, so the coordinator must not be used for deletion.
+func simulateOrder(ctx context.Context, c *coordinator) ([][]plannedDeletion, error) {
+	groupTables(c.tables, c.groups)
+	var now time.Time
+	var steps [][]plannedDeletion
+	for !isAllTablesDeleted(c.tables) {
+		tables, err := c.nextTables(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		if len(tables) == 0 {
+			// The coordinator is waiting for the barrier of a stage.
+			if c.stages == nil || !now.Before(c.stages.barrierUntil) {
+				return nil, errors.New("no deletable tables found, probably there is circular dependencies between tables")
+			}
+			now = c.stages.barrierUntil
+			continue
+		}
+		var step []plannedDeletion
+		for _, t := range tables {
+			started := []*table{t}
+			if t.group != nil {
+				started = t.group.ordered()
+			}
+			for _, t := range started {
+				t.deleter.setStatus(statusCompleted)
+			}
+			for _, t := range started {
+				d := plannedDeletion{table: t}
+				if t.filter == "" {
+					d.cascaded = cascadeTree(t.childTables)
+				}
+				step = append(step, d)
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// cascadeTree marks the tables and their child tables as completed, and returns the names of those not completed yet.
+func cascadeTree(tables []*table) []string {
+	var cascaded []string
+	for _, t := range tables {
+		if !t.deleter.isCompleted() {
+			t.deleter.setStatus(statusCompleted)
+			cascaded = append(cascaded, t.tableName)
+		}
+		cascaded = append(cascaded, cascadeTree(t.childTables)...)
+	}
+	return cascaded
+}
+
+// describeOrder returns lines describing the tables started at each step with their strategies,
+// and the child tables cascaded by them.
+func describeOrder(steps [][]plannedDeletion, strategyOf func(table string) Strategy) []string {
+	var lines []string
+	for i, step := range steps {
+		for _, d := range step {
+			line := fmt.Sprintf("%d. %s (%s)", i+1, d.table.tableName, strategyOf(d.table.tableName))
+			if len(d.cascaded) > 0 {
+				line += fmt.Sprintf(", cascading %s", strings.Join(d.cascaded, ", "))
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// executionPlan is the computed plan of a run written by OutputJSON, so that CI pipelines can diff and validate it.
+type executionPlan struct {
+	Database string         `json:"database"`
+	Tables   []plannedTable `json:"tables"`
+	// ForeignKeys are the foreign keys between the tables to be deleted, which order the deletion.
+	ForeignKeys []foreignKeyEdge `json:"foreignKeys"`
+	// Order is the tables started at each step. Tables cascaded by their parents are not included.
+	Order [][]string `json:"order"`
+	// Excluded are the tables not deleted with the reasons.
+	Excluded []excludedTable `json:"excluded"`
+}
+
+// plannedTable is a table to be deleted in executionPlan.
+type plannedTable struct {
+	Name     string   `json:"name"`
+	Parent   string   `json:"parent,omitempty"`
+	OnDelete string   `json:"onDelete,omitempty"`
+	Strategy Strategy `json:"strategy"`
+	// Step is the 1-origin step at which the table is started, or 0 if the table is cascaded by its parent.
+	Step       int    `json:"step"`
+	CascadedBy string `json:"cascadedBy,omitempty"`
+	Reason     string `json:"reason"`
+	// Where is the condition of rows to be deleted, if only some rows are deleted, and Params are its parameters.
+	Where  string            `json:"where,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// foreignKeyEdge is a foreign key from a referencing table to a referenced table.
+type foreignKeyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// excludedTable is a table not deleted in executionPlan.
+type excludedTable struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// newExecutionPlan creates the plan of the tables to be deleted from the simulated steps.
+func newExecutionPlan(database string, schemas []*tableSchema, decisions []*planDecision, steps [][]plannedDeletion, strategyOf func(table string) Strategy) *executionPlan {
+	p := &executionPlan{Database: database, Tables: []plannedTable{}, ForeignKeys: []foreignKeyEdge{}, Order: [][]string{}, Excluded: []excludedTable{}}
+
+	stepOf := map[string]int{}
+	cascadedBy := map[string]string{}
+	for i, step := range steps {
+		var names []string
+		for _, d := range step {
+			stepOf[d.table.tableName] = i + 1
+			names = append(names, d.table.tableName)
+			for _, c := range d.cascaded {
+				cascadedBy[c] = d.table.tableName
+			}
+		}
+		p.Order = append(p.Order, names)
+	}
+
+	reasons := map[string]string{}
+	for _, d := range decisions {
+		if d.included {
+			reasons[d.tableName] = d.reason
+		} else {
+			p.Excluded = append(p.Excluded, excludedTable{Name: d.tableName, Reason: d.reason})
+		}
+	}
+
+	planned := make(map[string]bool, len(schemas))
+	for _, s := range schemas {
+		planned[s.tableName] = true
+	}
+	for _, s := range schemas {
+		t := plannedTable{
+			Name:       s.tableName,
+			Strategy:   strategyOf(s.tableName),
+			Step:       stepOf[s.tableName],
+			CascadedBy: cascadedBy[s.tableName],
+			Reason:     reasons[s.tableName],
+			Where:      s.filter,
+			Params:     s.params,
+		}
+		if s.parentTableName != "" {
+			t.Parent = s.parentTableName
+			switch s.parentOnDeleteAction {
+			case deleteActionCascadeDelete:
+				t.OnDelete = "CASCADE"
+			case deleteActionNoAction:
+				t.OnDelete = "NO ACTION"
+			}
+		}
+		p.Tables = append(p.Tables, t)
+		for _, r := range s.referencedBy {
+			if planned[r] {
+				p.ForeignKeys = append(p.ForeignKeys, foreignKeyEdge{From: r, To: s.tableName})
+			}
+		}
+	}
+	return p
+}
@@ -0,0 +1,215 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSimulateOrder(t *testing.T) {
+	schemas := []*tableSchema{
+		{tableName: "Singers", referencedBy: []string{}},
+		{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete, referencedBy: []string{}},
+		{tableName: "Songs", parentTableName: "Albums", parentOnDeleteAction: deleteActionCascadeDelete, referencedBy: []string{}},
+		{tableName: "Concerts", referencedBy: []string{}},
+		{tableName: "Venues", referencedBy: []string{"Concerts"}},
+	}
+	strategyOf := func(table string) Strategy {
+		if table == "Concerts" {
+			return StrategyBatch
+		}
+		return StrategyPDML
+	}
+
+	for _, tt := range []struct {
+		desc        string
+		stages      *stagePlan
+		concurrency int
+		groups      []ConsistencyGroup
+		want        []string
+	}{
+		{
+			desc: "Dependencies",
+			want: []string{
+				"1. Singers (pdml), cascading Albums, Songs",
+				"1. Concerts (batch)",
+				"2. Venues (pdml)",
+			},
+		},
+		{
+			desc:   "Stages",
+			stages: newStagePlan([]Stage{{Name: "concerts", Tables: []string{"Concerts", "Venues"}}}),
+			want: []string{
+				"1. Concerts (batch)",
+				"2. Venues (pdml)",
+				"3. Singers (pdml), cascading Albums, Songs",
+			},
+		},
+		{
+			desc:   "Stages with waits",
+			stages: newStagePlan([]Stage{{Name: "concerts", Tables: []string{"Concerts", "Venues"}, WaitAfter: "10m"}}),
+			want: []string{
+				"1. Concerts (batch)",
+				"2. Venues (pdml)",
+				"3. Singers (pdml), cascading Albums, Songs",
+			},
+		},
+		{
+			desc:        "Concurrency",
+			concurrency: 1,
+			want: []string{
+				"1. Singers (pdml), cascading Albums, Songs",
+				"2. Concerts (batch)",
+				"3. Venues (pdml)",
+			},
+		},
+		{
+			desc:   "Consistency group",
+			groups: []ConsistencyGroup{{Name: "concerts", Tables: []string{"Venues", "Concerts"}}},
+			want: []string{
+				"1. Singers (pdml), cascading Albums, Songs",
+				"1. Concerts (batch)",
+				"1. Venues (pdml)",
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := newCoordinator(schemas, nil, nil)
+			c.stages, c.concurrency, c.groups = tt.stages, tt.concurrency, tt.groups
+			steps, err := simulateOrder(context.Background(), c)
+			if err != nil {
+				t.Fatalf("simulateOrder() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, describeOrder(steps, strategyOf)); diff != "" {
+				t.Errorf("describeOrder() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSimulateOrderWithFilter(t *testing.T) {
+	schemas := []*tableSchema{
+		{tableName: "Singers", referencedBy: []string{}, filter: "SingerId > 100"},
+		{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete, referencedBy: []string{}},
+	}
+	steps, err := simulateOrder(context.Background(), newCoordinator(schemas, nil, nil))
+	if err != nil {
+		t.Fatalf("simulateOrder() failed: %v", err)
+	}
+	// Deleting some singers does not delete all albums, so albums are deleted first.
+	want := []string{"1. Albums (pdml)", "2. Singers (pdml)"}
+	if diff := cmp.Diff(want, describeOrder(steps, func(string) Strategy { return StrategyPDML })); diff != "" {
+		t.Errorf("describeOrder() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{
+			{Name: "Singers"},
+			{Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"},
+		},
+		rows: map[string]int64{"Singers": 10, "Albums": 20},
+	}
+	var buf bytes.Buffer
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithDryRun(true), WithOutput(Output{Writer: &buf})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := "Tables would be deleted in the following order:\n  1. Singers (pdml), cascading Albums\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Run() output = %q, but want to contain %q", buf.String(), want)
+	}
+	if b.rows["Singers"] != 10 || b.rows["Albums"] != 20 {
+		t.Errorf("Run() deleted rows in dry run: %v", b.rows)
+	}
+}
+
+func TestNewExecutionPlan(t *testing.T) {
+	schemas := []*tableSchema{
+		{tableName: "Singers", referencedBy: []string{"Concerts", "Fans"}},
+		{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "Concerts"},
+	}
+	decisions := []*planDecision{
+		{tableName: "Singers", included: true, reason: "matched --tables"},
+		{tableName: "Albums", included: true, reason: "matched --tables"},
+		{tableName: "Concerts", included: true, reason: "matched --tables"},
+		{tableName: "Fans", reason: "not listed in --tables"},
+	}
+	steps, err := simulateOrder(context.Background(), newCoordinator(schemas, nil, nil))
+	if err != nil {
+		t.Fatalf("simulateOrder() failed: %v", err)
+	}
+	strategyOf := func(table string) Strategy {
+		if table == "Concerts" {
+			return StrategyBatch
+		}
+		return StrategyPDML
+	}
+
+	got := newExecutionPlan("db", schemas, decisions, steps, strategyOf)
+	want := &executionPlan{
+		Database: "db",
+		Tables: []plannedTable{
+			{Name: "Singers", Strategy: StrategyPDML, Step: 2, Reason: "matched --tables"},
+			// Albums can be deleted before Singers, which waits for Concerts referencing it.
+			{Name: "Albums", Parent: "Singers", OnDelete: "CASCADE", Strategy: StrategyPDML, Step: 1, Reason: "matched --tables"},
+			{Name: "Concerts", Strategy: StrategyBatch, Step: 1, Reason: "matched --tables"},
+		},
+		ForeignKeys: []foreignKeyEdge{{From: "Concerts", To: "Singers"}},
+		Order:       [][]string{{"Albums", "Concerts"}, {"Singers"}},
+		Excluded:    []excludedTable{{Name: "Fans", Reason: "not listed in --tables"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("newExecutionPlan() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunOutputJSON(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{
+			{Name: "Singers"},
+			{Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"},
+		},
+		rows: map[string]int64{"Singers": 10, "Albums": 20},
+	}
+	var buf bytes.Buffer
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithOutputFormat(OutputJSON), WithOutput(Output{Writer: &buf})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	var plan executionPlan
+	if err := json.Unmarshal(buf.Bytes(), &plan); err != nil {
+		t.Fatalf("Run() output is not a plan: %v\n%s", err, buf.String())
+	}
+	if want := [][]string{{"Singers"}}; !cmp.Equal(plan.Order, want) {
+		t.Errorf("Run() order = %v, but want = %v", plan.Order, want)
+	}
+	if got := plan.Tables[1].CascadedBy; got != "Singers" {
+		t.Errorf("Run() cascadedBy of Albums = %q, but want = %q", got, "Singers")
+	}
+	if b.rows["Singers"] != 10 || b.rows["Albums"] != 20 {
+		t.Errorf("Run() deleted rows with --output=json: %v", b.rows)
+	}
+}
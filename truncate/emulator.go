@@ -0,0 +1,75 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"os"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultEmulatorHost is the address of the Cloud Spanner Emulator started by gcloud or its container image by default.
+const DefaultEmulatorHost = "localhost:9010"
+
+// emulatorHostEnv is the environment variable of the address of the emulator, which the client libraries respect.
+const emulatorHostEnv = "SPANNER_EMULATOR_HOST"
+
+// EmulatorHost returns the address of the emulator in SPANNER_EMULATOR_HOST, or "" if not set.
+func EmulatorHost() string {
+	return os.Getenv(emulatorHostEnv)
+}
+
+// UseEmulator makes all clients created afterwards, including database admin clients, connect to the emulator at the address
+// with plaintext, credential-less connections, by setting SPANNER_EMULATOR_HOST.
+func UseEmulator(addr string) error {
+	return os.Setenv(emulatorHostEnv, addr)
+}
+
+// emulatorClientOptions returns the client options to connect to the emulator at the address.
+func emulatorClientOptions(addr string) []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(addr),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		option.WithoutAuthentication(),
+	}
+}
+
+// spannerClientOptions returns the options of Cloud Spanner clients created for the options, e.g. of the backend and admin clients.
+// Clients connect to the emulator of the options, or SPANNER_EMULATOR_HOST, without the credentials, which the emulator does not accept.
+func (o *Options) spannerClientOptions() []option.ClientOption {
+	host := o.EmulatorHost
+	if host == "" {
+		host = EmulatorHost()
+	}
+	if host != "" {
+		return append(emulatorClientOptions(host), o.ClientOptions...)
+	}
+	return o.clientOptions(o.ClientOptions...)
+}
+
+// emulatorHost returns the address of the emulator which the run connects to, or "" if it connects to Cloud Spanner.
+func (o *Options) emulatorHost() string {
+	if o.Backend != nil || o.PGAdapter != "" || o.ReplayFile != "" {
+		return ""
+	}
+	if o.EmulatorHost != "" {
+		return o.EmulatorHost
+	}
+	return EmulatorHost()
+}
@@ -0,0 +1,65 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+	"google.golang.org/api/option"
+)
+
+func TestOptionsEmulatorHost(t *testing.T) {
+	defer os.Setenv(emulatorHostEnv, os.Getenv(emulatorHostEnv))
+	for _, tt := range []struct {
+		desc string
+		env  string
+		opts []Option
+		want string
+	}{
+		{desc: "Cloud Spanner", want: ""},
+		{desc: "From the environment", env: "localhost:9010", want: "localhost:9010"},
+		{desc: "Explicit", env: "localhost:9010", opts: []Option{WithEmulator("emulator:9010")}, want: "emulator:9010"},
+		{desc: "Through PGAdapter", env: "localhost:9010", opts: []Option{WithPGAdapter("localhost:5432")}, want: ""},
+		{desc: "With a backend", env: "localhost:9010", opts: []Option{WithBackend(&fakeBackend{})}, want: ""},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if err := os.Setenv(emulatorHostEnv, tt.env); err != nil {
+				t.Fatal(err)
+			}
+			if got := NewOptions(tt.opts...).emulatorHost(); got != tt.want {
+				t.Errorf("emulatorHost() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpannerClientOptions(t *testing.T) {
+	defer os.Setenv(emulatorHostEnv, os.Getenv(emulatorHostEnv))
+	if err := os.Unsetenv(emulatorHostEnv); err != nil {
+		t.Fatal(err)
+	}
+	// Admin clients connect to the emulator without the credentials, which cannot be combined with WithoutAuthentication.
+	o := NewOptions(WithEmulator("localhost:9010"), WithCredentials(option.WithAPIKey("key")))
+	client, err := adminapi.NewDatabaseAdminClient(context.Background(), o.spannerClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewDatabaseAdminClient() failed: %v", err)
+	}
+	client.Close()
+}
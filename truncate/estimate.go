@@ -0,0 +1,106 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNothingToDelete is returned by Run with WithFailIfEmpty if all selected tables are empty.
+var ErrNothingToDelete = errors.New("nothing to delete: all selected tables are empty")
+
+// estimateConcurrency is the maximum number of tables counted concurrently for the confirmation.
+const estimateConcurrency = 8
+
+// estimateRows counts the rows to be deleted from each table, i.e. the rows matching its filter, for the confirmation.
+// Stale reads are used since the counts are only estimates, and tables failed to be counted within the timeout,
+// e.g. the planning timeout, are omitted with a warning. The tables are counted concurrently, since each count scans the table.
+func estimateRows(ctx context.Context, b Backend, tables []*tableSchema, timeout time.Duration, out Output) map[string]int64 {
+	ctx, cancel := withPhaseTimeout(ctx, timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, estimateConcurrency)
+	estimates := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		wg.Add(1)
+		go func(t *tableSchema) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			count, err := b.Count(ctx, filterStatement(b.Dialect().countMatchingSQL(t.tableName, t.filter), t.params), 10*time.Second, PriorityUnspecified)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				out.warnf("Rows in %s cannot be estimated: %v", t.tableName, err)
+				return
+			}
+			estimates[t.tableName] = count
+		}(t)
+	}
+	wg.Wait()
+	return estimates
+}
+
+// describeEstimates returns lines describing the estimated rows of each table and their total.
+func describeEstimates(tables []*tableSchema, estimates map[string]int64) []string {
+	width := len("total")
+	for _, t := range tables {
+		if len(t.tableName) > width {
+			width = len(t.tableName)
+		}
+	}
+	var lines []string
+	var total int64
+	for _, t := range tables {
+		count, ok := estimates[t.tableName]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%-*s  unknown", width, t.tableName))
+			continue
+		}
+		total += count
+		lines = append(lines, fmt.Sprintf("%-*s  %s", width, t.tableName, formatNumber(uint64(count))))
+	}
+	totalLine := fmt.Sprintf("%-*s  %s", width, "total", formatNumber(uint64(total)))
+	if len(estimates) < len(tables) {
+		totalLine += " or more"
+	}
+	return append(lines, totalLine)
+}
+
+// allEmpty returns true if none of the tables has rows to be deleted, i.e. rows matching its filter.
+// Strong reads are used, so that rows written just before the run are not overlooked, and tables dropped are regarded as empty.
+func allEmpty(ctx context.Context, b Backend, tables []*tableSchema) (bool, error) {
+	for _, t := range tables {
+		exists, err := b.Count(ctx, filterStatement(b.Dialect().existsMatchingSQL(t.tableName, t.filter), t.params), 0, PriorityUnspecified)
+		if err != nil {
+			if isTableNotFound(err) {
+				continue
+			}
+			return false, fmt.Errorf("failed to check rows in %s: %v", t.tableName, err)
+		}
+		if exists > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,145 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+// slowCountBackend is a fake backend whose counts of the slow table never complete before the context is done.
+type slowCountBackend struct {
+	*fakeBackend
+	slow string
+}
+
+func (b *slowCountBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	if strings.Contains(stmt.SQL, b.slow) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return b.fakeBackend.Count(ctx, stmt, staleness, priority)
+}
+
+func TestEstimateRows(t *testing.T) {
+	b := &slowCountBackend{fakeBackend: &fakeBackend{rows: map[string]int64{"Singers": 1200, "Albums": 34}}, slow: "Concerts"}
+	tables := []*tableSchema{{tableName: "Singers"}, {tableName: "Concerts"}, {tableName: "Albums"}}
+	var buf bytes.Buffer
+	got := estimateRows(context.Background(), b, tables, 100*time.Millisecond, Output{Writer: &buf})
+	// The slow table is omitted after the timeout, without blocking the estimates of the others.
+	if diff := cmp.Diff(map[string]int64{"Singers": 1200, "Albums": 34}, got); diff != "" {
+		t.Errorf("estimateRows() mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), "Concerts cannot be estimated") {
+		t.Errorf("estimateRows() output = %q, but want a warning about Concerts", buf.String())
+	}
+}
+
+func TestDescribeEstimates(t *testing.T) {
+	tables := []*tableSchema{{tableName: "Singers"}, {tableName: "Albums"}, {tableName: "T"}}
+	for _, tt := range []struct {
+		desc      string
+		estimates map[string]int64
+		want      []string
+	}{
+		{
+			desc:      "All tables",
+			estimates: map[string]int64{"Singers": 1200, "Albums": 34, "T": 0},
+			want:      []string{"Singers  1,200", "Albums   34", "T        0", "total    1,234"},
+		},
+		{
+			desc:      "Unknown table",
+			estimates: map[string]int64{"Singers": 1200, "Albums": 34},
+			want:      []string{"Singers  1,200", "Albums   34", "T        unknown", "total    1,234 or more"},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, describeEstimates(tables, tt.estimates)); diff != "" {
+				t.Errorf("describeEstimates() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunConfirmation(t *testing.T) {
+	for _, tt := range []struct {
+		desc        string
+		opts        []Option
+		input       string
+		wantDeleted bool
+	}{
+		{desc: "Confirmed", input: "Y\n", wantDeleted: true},
+		{desc: "Declined", input: "n\n", wantDeleted: false},
+		{desc: "No answer", input: "", wantDeleted: false},
+		{desc: "Yes", opts: []Option{WithYes(true)}, input: "", wantDeleted: true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &fakeBackend{
+				tables: []TableInfo{{Name: "Singers"}},
+				rows:   map[string]int64{"Singers": 1200},
+			}
+			var buf bytes.Buffer
+			opts := append([]Option{WithBackend(b), WithOutput(Output{Writer: &buf, Input: strings.NewReader(tt.input)})}, tt.opts...)
+			if err := Run(context.Background(), "p", "i", "d", opts...); err != nil {
+				t.Fatalf("Run() failed: %v", err)
+			}
+			if deleted := b.rows["Singers"] == 0; deleted != tt.wantDeleted {
+				t.Errorf("Run() deleted = %t, but want = %t", deleted, tt.wantDeleted)
+			}
+			// The estimates are shown only when asking for the confirmation.
+			if got, want := strings.Contains(buf.String(), "Singers  1,200"), tt.opts == nil; got != want {
+				t.Errorf("Run() output contains the estimate = %t, but want = %t:\n%s", got, want, buf.String())
+			}
+		})
+	}
+}
+
+func TestRunEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		opts    []Option
+		wantErr error
+	}{
+		{desc: "Nothing to do"},
+		{desc: "Fail if empty", opts: []Option{WithFailIfEmpty(true)}, wantErr: ErrNothingToDelete},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &fakeBackend{
+				tables: []TableInfo{{Name: "Singers"}, {Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"}},
+				rows:   map[string]int64{"Singers": 0, "Albums": 0},
+			}
+			var buf bytes.Buffer
+			// No input is given, since empty tables need no confirmation.
+			opts := append([]Option{WithBackend(b), WithOutput(Output{Writer: &buf, Input: strings.NewReader("")})}, tt.opts...)
+			if err := Run(context.Background(), "p", "i", "d", opts...); err != tt.wantErr {
+				t.Fatalf("Run() = %v, but want = %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !strings.Contains(buf.String(), "Nothing to do") {
+				t.Errorf("Run() output = %q, but want to contain %q", buf.String(), "Nothing to do")
+			}
+			if strings.Contains(buf.String(), "Do you want to continue?") {
+				t.Errorf("Run() asked for confirmation of empty tables:\n%s", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ExplainPlan writes why each table is included in or excluded from the deletion
+// and how the included tables are deleted, without deleting anything.
+func ExplainPlan(ctx context.Context, projectID, instanceID, databaseID string, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	out := o.Output.writer()
+
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	fmt.Fprintf(out, "Fetching table schema from %s\n", database)
+	schemas, decisions, err := fetchTableSchemas(ctx, b, o.tableSelection())
+	if err != nil {
+		return fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+	indexes, err := fetchIndexSchemas(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch index schema: %v", err)
+	}
+
+	coordinator := newCoordinator(schemas, indexes, b)
+	notes := explainTables(flattenTables(coordinator.tables))
+
+	fmt.Fprintf(out, "\n")
+	for _, d := range decisions {
+		verdict := "excluded"
+		if d.included {
+			verdict = "included"
+		}
+		fmt.Fprintf(out, "%s: %s (%s)\n", d.tableName, verdict, d.reason)
+		for _, note := range notes[d.tableName] {
+			fmt.Fprintf(out, "  - %s\n", note)
+		}
+	}
+	return nil
+}
+
+// explainTables describes how each table is deleted according to inter-table relationships.
+func explainTables(tables []*table) map[string][]string {
+	planned := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		planned[t.tableName] = true
+	}
+
+	notes := make(map[string][]string, len(tables))
+	for _, t := range tables {
+		if t.parentTableName != "" {
+			switch {
+			case !planned[t.parentTableName]:
+				notes[t.tableName] = append(notes[t.tableName], fmt.Sprintf("parent %s is not deleted, so deleted as a top level table", t.parentTableName))
+			case t.parentOnDeleteAction == deleteActionNoAction:
+				notes[t.tableName] = append(notes[t.tableName], fmt.Sprintf("deleted before parent %s since it has ON DELETE NO ACTION", t.parentTableName))
+			case t.hasGlobalIndex:
+				notes[t.tableName] = append(notes[t.tableName], fmt.Sprintf("deleted before parent %s since it has a global index", t.parentTableName))
+			default:
+				notes[t.tableName] = append(notes[t.tableName], fmt.Sprintf("cascaded by parent %s", t.parentTableName))
+			}
+		}
+
+		if len(t.referencedBy) > 0 {
+			// Referencing tables which are not deleted are nil.
+			var names []string
+			for _, r := range t.referencedBy {
+				if r != nil {
+					names = append(names, r.tableName)
+				}
+			}
+			notes[t.tableName] = append(notes[t.tableName], fmt.Sprintf("deleted after %s which reference it by foreign keys", strings.Join(names, ", ")))
+		}
+	}
+	return notes
+}
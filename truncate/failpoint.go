@@ -0,0 +1,132 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Points of a deletion at which failpoints can be set.
+const (
+	failpointBeforeDelete = "before-delete" // Before deleting rows from a table.
+	failpointBeforeCommit = "before-commit" // Before committing a chunk, or before executing Partitioned DML.
+	failpointAfterCommit  = "after-commit"  // After committing a chunk, or after executing Partitioned DML.
+)
+
+// Failpoint makes the deletion of a table fail at a specific point, to reproduce partial failures deterministically.
+type Failpoint struct {
+	// Point is one of before-delete, before-commit and after-commit.
+	Point string
+	// Table is the table at which the failpoint fails. Empty matches all tables.
+	Table string
+	// Chunk is the 1-origin number of the chunk at which the failpoint fails. Zero matches all chunks.
+	// Partitioned DML is regarded as a single chunk.
+	Chunk int
+	// Panic makes the failpoint panic instead of returning an error, to test recovery from panics.
+	Panic bool
+}
+
+// ParseFailpoint parses a failpoint in the form of POINT[:table=TABLE][:chunk=N][:action=panic], e.g. before-commit:table=Singers:chunk=3.
+func ParseFailpoint(s string) (Failpoint, error) {
+	parts := strings.Split(s, ":")
+	fp := Failpoint{Point: parts[0]}
+	switch fp.Point {
+	case failpointBeforeDelete, failpointBeforeCommit, failpointAfterCommit:
+	default:
+		return Failpoint{}, fmt.Errorf("invalid failpoint %q: unknown point %q", s, fp.Point)
+	}
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return Failpoint{}, fmt.Errorf("invalid failpoint %q: %q is not in the form of key=value", s, p)
+		}
+		switch kv[0] {
+		case "table":
+			fp.Table = kv[1]
+		case "chunk":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 1 {
+				return Failpoint{}, fmt.Errorf("invalid failpoint %q: chunk must be a positive number", s)
+			}
+			fp.Chunk = n
+		case "action":
+			switch kv[1] {
+			case "error":
+			case "panic":
+				fp.Panic = true
+			default:
+				return Failpoint{}, fmt.Errorf("invalid failpoint %q: action must be error or panic", s)
+			}
+		default:
+			return Failpoint{}, fmt.Errorf("invalid failpoint %q: unknown key %q", s, kv[0])
+		}
+	}
+	if fp.Point == failpointBeforeDelete && fp.Chunk > 0 {
+		return Failpoint{}, fmt.Errorf("invalid failpoint %q: chunk cannot be set for %s", s, failpointBeforeDelete)
+	}
+	return fp, nil
+}
+
+// ParseFailpoints parses failpoints separated by commas.
+func ParseFailpoints(s string) ([]Failpoint, error) {
+	var fps []Failpoint
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		fp, err := ParseFailpoint(f)
+		if err != nil {
+			return nil, err
+		}
+		fps = append(fps, fp)
+	}
+	return fps, nil
+}
+
+func (fp Failpoint) String() string {
+	s := fp.Point
+	if fp.Table != "" {
+		s += ":table=" + fp.Table
+	}
+	if fp.Chunk > 0 {
+		s += ":chunk=" + strconv.Itoa(fp.Chunk)
+	}
+	if fp.Panic {
+		s += ":action=panic"
+	}
+	return s
+}
+
+// failpoints is a set of failpoints of a run.
+type failpoints []Failpoint
+
+// check returns an error if any failpoint matches the point of the deletion of the table.
+func (fps failpoints) check(point, table string, chunk int) error {
+	for _, fp := range fps {
+		if fp.Point != point || (fp.Table != "" && fp.Table != table) || (fp.Chunk > 0 && fp.Chunk != chunk) {
+			continue
+		}
+		err := fmt.Errorf("failpoint %s hit at chunk %d of %s", fp, chunk, table)
+		if fp.Panic {
+			panic(err)
+		}
+		return err
+	}
+	return nil
+}
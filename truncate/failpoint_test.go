@@ -0,0 +1,122 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFailpoints(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		s       string
+		want    []Failpoint
+		wantErr bool
+	}{
+		{
+			desc: "Point only",
+			s:    "before-delete",
+			want: []Failpoint{{Point: "before-delete"}},
+		},
+		{
+			desc: "Table and chunk",
+			s:    "before-commit:table=Singers:chunk=3",
+			want: []Failpoint{{Point: "before-commit", Table: "Singers", Chunk: 3}},
+		},
+		{
+			desc: "Multiple failpoints",
+			s:    "after-commit:chunk=1, before-delete:table=Albums",
+			want: []Failpoint{{Point: "after-commit", Chunk: 1}, {Point: "before-delete", Table: "Albums"}},
+		},
+		{
+			desc: "Panic",
+			s:    "before-commit:table=Singers:action=panic",
+			want: []Failpoint{{Point: "before-commit", Table: "Singers", Panic: true}},
+		},
+		{
+			desc:    "Unknown action",
+			s:       "before-commit:action=exit",
+			wantErr: true,
+		},
+		{
+			desc:    "Unknown point",
+			s:       "before-read",
+			wantErr: true,
+		},
+		{
+			desc:    "Invalid chunk",
+			s:       "before-commit:chunk=0",
+			wantErr: true,
+		},
+		{
+			desc:    "Chunk before delete",
+			s:       "before-delete:chunk=1",
+			wantErr: true,
+		},
+		{
+			desc:    "Unknown key",
+			s:       "before-commit:row=1",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := ParseFailpoints(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseFailpoints(%q) = %v, but want an error", tt.s, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFailpoints(%q) failed: %v", tt.s, err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseFailpoints(%q) mismatch (-want +got):\n%s", tt.s, diff)
+			}
+		})
+	}
+}
+
+func TestDeleterFailpoints(t *testing.T) {
+	for _, tt := range []struct {
+		desc        string
+		failpoint   Failpoint
+		wantErr     bool
+		wantDeleted bool
+	}{
+		{desc: "Before delete", failpoint: Failpoint{Point: "before-delete", Table: "Singers"}, wantErr: true, wantDeleted: false},
+		{desc: "Before commit", failpoint: Failpoint{Point: "before-commit", Chunk: 1}, wantErr: true, wantDeleted: false},
+		{desc: "After commit", failpoint: Failpoint{Point: "after-commit", Table: "Singers"}, wantErr: true, wantDeleted: true},
+		{desc: "Other table", failpoint: Failpoint{Point: "before-commit", Table: "Albums"}, wantErr: false, wantDeleted: true},
+		{desc: "Other chunk", failpoint: Failpoint{Point: "after-commit", Chunk: 2}, wantErr: false, wantDeleted: true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &fakeBackend{rows: map[string]int64{"Singers": 10}}
+			d := &deleter{tableName: "Singers", backend: b, failpoints: failpoints{tt.failpoint}}
+			err := d.deleteRows(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deleteRows() = %v, but want error = %v", err, tt.wantErr)
+			}
+			if deleted := b.rows["Singers"] == 0; deleted != tt.wantDeleted {
+				t.Errorf("rows deleted = %v, but want = %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
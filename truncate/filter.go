@@ -0,0 +1,111 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	proto3 "github.com/golang/protobuf/ptypes/struct"
+)
+
+// paramRe matches a parameter of a filter, e.g. @cutoff. System variables such as @@version are not matched.
+var paramRe = regexp.MustCompile(`(^|[^@\w])@([A-Za-z_][A-Za-z0-9_]*)`)
+
+// bindParams returns the filter with its parameters as placeholders of the dialect, and their values keyed by the names of the placeholders.
+// Parameters are kept as @name in GoogleSQL, and numbered as $1, $2... in PostgreSQL, whose values are keyed by p1, p2...
+func bindParams(filter string, params map[string]string, dialect Dialect) (string, map[string]string, error) {
+	var missing []string
+	bound := map[string]string{}
+	numbers := map[string]int{}
+	replaced := paramRe.ReplaceAllStringFunc(filter, func(m string) string {
+		sub := paramRe.FindStringSubmatch(m)
+		value, ok := params[sub[2]]
+		if !ok {
+			missing = append(missing, "@"+sub[2])
+			return m
+		}
+		if dialect != DialectPostgreSQL {
+			bound[sub[2]] = value
+			return m
+		}
+		n, ok := numbers[sub[2]]
+		if !ok {
+			n = len(numbers) + 1
+			numbers[sub[2]] = n
+			bound[fmt.Sprintf("p%d", n)] = value
+		}
+		return sub[1] + dialect.placeholder(n)
+	})
+	if len(missing) > 0 {
+		return "", nil, fmt.Errorf("undefined parameters %s, specify them with --param", strings.Join(missing, ", "))
+	}
+	if len(bound) == 0 {
+		return filter, nil, nil
+	}
+	return replaced, bound, nil
+}
+
+// filterStatement returns the statement with the parameters of the filter.
+// The values are bound without their types, so that Cloud Spanner coerces them to the types of the compared columns as literals.
+func filterStatement(sql string, params map[string]string) spanner.Statement {
+	stmt := spanner.NewStatement(sql)
+	for name, value := range params {
+		stmt.Params[name] = spanner.GenericColumnValue{Value: &proto3.Value{Kind: &proto3.Value_StringValue{StringValue: value}}}
+	}
+	return stmt
+}
+
+// stringLiteral returns the value as a string literal in the dialect.
+func (d Dialect) stringLiteral(value string) string {
+	if d == DialectPostgreSQL {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return strconv.Quote(value)
+}
+
+// applyTableFilters sets the filters of the tables to be deleted, with their parameters bound.
+// The age filters apply to all tables in addition to their own filters.
+// Rows not matching the filter of a table are kept.
+func applyTableFilters(schemas []*tableSchema, filters, params map[string]string, ages []*ageFilter, sample *sampleFilter, dialect Dialect) error {
+	for _, s := range schemas {
+		var conds []string
+		for _, age := range ages {
+			conds = append(conds, age.condition(dialect, s.tableName))
+		}
+		if sample != nil {
+			conds = append(conds, sample.condition(s.tableName))
+		}
+		if filter, ok := filters[s.tableName]; ok {
+			bound, values, err := bindParams(filter, params, dialect)
+			if err != nil {
+				return fmt.Errorf("invalid filter of %s: %v", s.tableName, err)
+			}
+			// The filter in the config may have OR at the top level.
+			if len(conds) > 0 {
+				bound = "(" + bound + ")"
+			}
+			conds = append([]string{bound}, conds...)
+			s.params = values
+		}
+		s.filter = strings.Join(conds, " AND ")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBindParams(t *testing.T) {
+	params := map[string]string{
+		"cutoff": "2020-01-01T00:00:00Z",
+		"limit":  "100",
+		"active": "true",
+		"name":   `O'Brien "Jr"`,
+	}
+	for _, tt := range []struct {
+		desc       string
+		filter     string
+		dialect    Dialect
+		want       string
+		wantParams map[string]string
+		wantErr    string
+	}{
+		{
+			desc:       "Named parameters",
+			filter:     "CreatedAt < @cutoff AND Id > @limit AND Active = @active",
+			want:       "CreatedAt < @cutoff AND Id > @limit AND Active = @active",
+			wantParams: map[string]string{"cutoff": "2020-01-01T00:00:00Z", "limit": "100", "active": "true"},
+		},
+		{
+			desc:       "Values are not written into the filter",
+			filter:     "Name = @name",
+			want:       "Name = @name",
+			wantParams: map[string]string{"name": `O'Brien "Jr"`},
+		},
+		{
+			desc:       "PostgreSQL positional parameters",
+			filter:     "name = @name OR (id > @limit AND alias = @name)",
+			dialect:    DialectPostgreSQL,
+			want:       "name = $1 OR (id > $2 AND alias = $1)",
+			wantParams: map[string]string{"p1": `O'Brien "Jr"`, "p2": "100"},
+		},
+		{
+			desc:       "System variables are kept",
+			filter:     "@@version > @limit",
+			want:       "@@version > @limit",
+			wantParams: map[string]string{"limit": "100"},
+		},
+		{
+			desc:   "No parameters",
+			filter: "Status = 1",
+			want:   "Status = 1",
+		},
+		{
+			desc:    "Undefined parameters",
+			filter:  "CreatedAt < @since AND Id > @limit AND Kind = @kind",
+			wantErr: "undefined parameters @since, @kind, specify them with --param",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, gotParams, err := bindParams(tt.filter, params, tt.dialect)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("bindParams(%q) = %v, but want = %q", tt.filter, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("bindParams(%q) failed: %v", tt.filter, err)
+			}
+			if got != tt.want {
+				t.Errorf("bindParams(%q) = %q, but want = %q", tt.filter, got, tt.want)
+			}
+			if diff := cmp.Diff(tt.wantParams, gotParams); diff != "" {
+				t.Errorf("bindParams(%q) params mismatch (-want +got):\n%s", tt.filter, diff)
+			}
+		})
+	}
+}
+
+func TestFilterStatement(t *testing.T) {
+	stmt := filterStatement("DELETE FROM `Orders` WHERE CreatedAt < @cutoff", map[string]string{"cutoff": "2020-01-01"})
+	v, ok := stmt.Params["cutoff"].(spanner.GenericColumnValue)
+	if !ok {
+		t.Fatalf("filterStatement() param = %#v, but want a GenericColumnValue", stmt.Params["cutoff"])
+	}
+	// Parameters without types are coerced to the types of the compared columns like literals.
+	if v.Type != nil || v.Value.GetStringValue() != "2020-01-01" {
+		t.Errorf("filterStatement() param = %v, %v, but want an untyped string 2020-01-01", v.Type, v.Value)
+	}
+	if got := pgArgs(filterStatement("DELETE FROM orders WHERE id > $2 OR name = $1", map[string]string{"p1": "a", "p2": "100"})); !cmp.Equal(got, []interface{}{"a", "100"}) {
+		t.Errorf("pgArgs() = %v, but want = [a 100]", got)
+	}
+}
+
+func TestApplyTableFilters(t *testing.T) {
+	schemas := []*tableSchema{{tableName: "Orders"}, {tableName: "Users"}}
+	filters := map[string]string{"Orders": "CreatedAt < @cutoff", "Missing": "true"}
+	if err := applyTableFilters(schemas, filters, map[string]string{"cutoff": "2020-01-01"}, nil, nil, DialectGoogleSQL); err != nil {
+		t.Fatalf("applyTableFilters() failed: %v", err)
+	}
+	got := []string{schemas[0].filter, schemas[1].filter}
+	if diff := cmp.Diff([]string{"CreatedAt < @cutoff", ""}, got); diff != "" {
+		t.Errorf("applyTableFilters() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{"cutoff": "2020-01-01"}, schemas[0].params); diff != "" {
+		t.Errorf("applyTableFilters() params mismatch (-want +got):\n%s", diff)
+	}
+
+	err := applyTableFilters(schemas, filters, nil, nil, nil, DialectGoogleSQL)
+	if want := "invalid filter of Orders: undefined parameters @cutoff, specify them with --param"; err == nil || err.Error() != want {
+		t.Errorf("applyTableFilters() = %v, but want = %q", err, want)
+	}
+}
@@ -0,0 +1,101 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+)
+
+// fingerprint is a digest of the primary keys of all rows in a table, to prove that a table was untouched by a run.
+type fingerprint struct {
+	rows int64
+	hash int64
+}
+
+func (f fingerprint) String() string {
+	return fmt.Sprintf("%s rows, %016x", formatNumber(uint64(f.rows)), uint64(f.hash))
+}
+
+// keptTable is a table excluded from a run with its fingerprints taken before and after the run.
+type keptTable struct {
+	table  string
+	before fingerprint
+	after  *fingerprint // nil if the run did not complete.
+}
+
+// result describes whether the table was untouched.
+func (k *keptTable) result() string {
+	switch {
+	case k.after == nil:
+		return fmt.Sprintf("not verified: %s", k.before)
+	case *k.after == k.before:
+		return fmt.Sprintf("unchanged: %s", k.before)
+	default:
+		return fmt.Sprintf("changed: %s -> %s", k.before, *k.after)
+	}
+}
+
+// fingerprintSQL returns a query aggregating FARM_FINGERPRINT of the primary keys of all rows in the table.
+// BIT_XOR makes the result independent of the order of rows.
+func fingerprintSQL(table string, keyColumns []KeyColumn) string {
+	return fmt.Sprintf("SELECT IFNULL(BIT_XOR(FARM_FINGERPRINT(%s)), 0) FROM %s", keyStringSQL(keyColumns), DialectGoogleSQL.quote(table))
+}
+
+// keyStringSQL returns a GoogleSQL expression of the key columns of a row as a string.
+func keyStringSQL(keyColumns []KeyColumn) string {
+	parts := make([]string, len(keyColumns))
+	for i, c := range keyColumns {
+		col := quoteIdentifier(c.Name)
+		if strings.HasPrefix(c.SpannerType, "BYTES") {
+			parts[i] = fmt.Sprintf("IFNULL(TO_BASE64(%s), 'NULL')", col)
+		} else {
+			parts[i] = fmt.Sprintf("IFNULL(CAST(%s AS STRING), 'NULL')", col)
+		}
+	}
+	if len(parts) == 0 {
+		return "''"
+	}
+	return fmt.Sprintf("CONCAT(%s)", strings.Join(parts, ", '\\x1f', "))
+}
+
+// takeFingerprint takes the fingerprint of the table with a strong read.
+func takeFingerprint(ctx context.Context, b Backend, table string, keyColumns []KeyColumn) (fingerprint, error) {
+	rows, err := b.Count(ctx, spanner.NewStatement(b.Dialect().countRowsSQL(table)), 0, PriorityUnspecified)
+	if err != nil {
+		return fingerprint{}, fmt.Errorf("failed to count rows in %s: %v", table, err)
+	}
+	hash, err := b.Count(ctx, spanner.NewStatement(fingerprintSQL(table, keyColumns)), 0, PriorityUnspecified)
+	if err != nil {
+		return fingerprint{}, fmt.Errorf("failed to take fingerprint of %s: %v", table, err)
+	}
+	return fingerprint{rows: rows, hash: hash}, nil
+}
+
+// keptTableNames returns the tables in the database which are excluded from the deletion.
+func keptTableNames(decisions []*planDecision) []string {
+	var names []string
+	for _, d := range decisions {
+		if !d.included && !d.missing {
+			names = append(names, d.tableName)
+		}
+	}
+	return names
+}
@@ -0,0 +1,73 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintSQL(t *testing.T) {
+	got := fingerprintSQL("Order", []KeyColumn{{Name: "Id", SpannerType: "INT64"}, {Name: "Hash", SpannerType: "BYTES(32)"}})
+	want := "SELECT IFNULL(BIT_XOR(FARM_FINGERPRINT(CONCAT(IFNULL(CAST(`Id` AS STRING), 'NULL'), '\\x1f', IFNULL(TO_BASE64(`Hash`), 'NULL')))), 0) FROM `Order`"
+	if got != want {
+		t.Errorf("fingerprintSQL() = %s, but want = %s", got, want)
+	}
+}
+
+func TestKeptTableResult(t *testing.T) {
+	before := fingerprint{rows: 1000, hash: 0x1234}
+	changed := fingerprint{rows: 999, hash: 0x5678}
+	for _, tt := range []struct {
+		desc  string
+		after *fingerprint
+		want  string
+	}{
+		{desc: "Unchanged", after: &before, want: "unchanged: 1,000 rows, 0000000000001234"},
+		{desc: "Changed", after: &changed, want: "changed: 1,000 rows, 0000000000001234 -> 999 rows, 0000000000005678"},
+		{desc: "Not verified", want: "not verified: 1,000 rows, 0000000000001234"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			k := &keptTable{table: "Orders", before: before, after: tt.after}
+			if got := k.result(); got != tt.want {
+				t.Errorf("result() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFingerprint(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{{Name: "Singers"}, {Name: "Venues"}},
+		rows:   map[string]int64{"Singers": 10, "Venues": 30},
+	}
+	var out, report bytes.Buffer
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithQuiet(true), WithTargetTables([]string{"Singers"}),
+		WithFingerprint(true), WithReportFormat(ReportFormatJUnit), WithOutput(Output{Writer: &out, Report: &report})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := `<property name="fingerprint.Venues" value="unchanged: 30 rows, 0000000000000000"></property>`
+	if !strings.Contains(report.String(), want) {
+		t.Errorf("Run() report = %q, but want to contain %q", report.String(), want)
+	}
+	if b.rows["Venues"] != 30 {
+		t.Errorf("Run() deleted rows in Venues: %d", b.rows["Venues"])
+	}
+}
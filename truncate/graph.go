@@ -0,0 +1,67 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+)
+
+// graphMetadata is the part of PROPERTY_GRAPH_METADATA_JSON in INFORMATION_SCHEMA.PROPERTY_GRAPHS used to find base tables.
+type graphMetadata struct {
+	NodeTables []graphElementTable `json:"nodeTables"`
+	EdgeTables []graphElementTable `json:"edgeTables"`
+}
+
+type graphElementTable struct {
+	BaseTableName string `json:"baseTableName"`
+}
+
+// graphBaseTables returns the sorted names of tables backing nodes and edges in the metadata of a property graph.
+func graphBaseTables(metadata []byte) ([]string, error) {
+	var m graphMetadata
+	if err := json.Unmarshal(metadata, &m); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var tables []string
+	for _, t := range append(m.NodeTables, m.EdgeTables...) {
+		if t.BaseTableName == "" || seen[t.BaseTableName] {
+			continue
+		}
+		seen[t.BaseTableName] = true
+		tables = append(tables, t.BaseTableName)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// fetchGraphsByTable returns the names of property graphs backed by each table.
+func fetchGraphsByTable(ctx context.Context, b Backend) (map[string][]string, error) {
+	graphs, err := b.PropertyGraphs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byTable := map[string][]string{}
+	for _, g := range graphs {
+		for _, t := range g.BaseTables {
+			byTable[t] = append(byTable[t], g.Name)
+		}
+	}
+	return byTable, nil
+}
@@ -0,0 +1,39 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGraphBaseTables(t *testing.T) {
+	metadata := `{
+		"name": "FinGraph",
+		"nodeTables": [{"name": "Person", "baseTableName": "Person"}, {"name": "Account", "baseTableName": "Account"}],
+		"edgeTables": [{"name": "Owns", "baseTableName": "PersonOwnAccount"}, {"name": "Transfers", "baseTableName": "Account"}]
+	}`
+	got, err := graphBaseTables([]byte(metadata))
+	if err != nil {
+		t.Fatalf("graphBaseTables() failed: %v", err)
+	}
+	want := []string{"Account", "Person", "PersonOwnAccount"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("graphBaseTables() mismatch (-want +got):\n%s", diff)
+	}
+}
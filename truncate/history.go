@@ -0,0 +1,232 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/iterator"
+)
+
+// historyColumns are the columns of the history table. See README for its DDL.
+var historyColumns = []string{"RunID", "StartedAt", "FinishedAt", "Operator", "Reason", "Tables", "DeletedRows", "Status", "Error"}
+
+// Status of a run in the history table.
+const (
+	HistoryRunning   = "running"   // The run is deleting rows, or has crashed.
+	HistorySucceeded = "succeeded" // All rows have been deleted.
+	HistoryFailed    = "failed"    // The run failed with an error.
+)
+
+// historyRecorder records a run into the history table, so that previous runs can be listed by History.
+type historyRecorder struct {
+	client *spanner.Client
+	table  string
+	runID  string
+}
+
+// recordStarted records that the run has started deleting rows from the tables.
+func (h *historyRecorder) recordStarted(ctx context.Context, started time.Time, operator, reason string, tables []string) error {
+	_, err := h.client.Apply(ctx, []*spanner.Mutation{
+		spanner.InsertOrUpdate(h.table, []string{"RunID", "StartedAt", "Operator", "Reason", "Tables", "Status"},
+			[]interface{}{h.runID, started, operator, nullString(reason), tables, HistoryRunning}),
+	})
+	return err
+}
+
+// recordFinished records the result of the run.
+func (h *historyRecorder) recordFinished(ctx context.Context, r *report) error {
+	var deleted int64
+	for _, t := range r.tables {
+		deleted += int64(t.rowsDeleted)
+	}
+	status, errMsg := HistorySucceeded, spanner.NullString{}
+	if r.err != nil {
+		status, errMsg = HistoryFailed, nullString(r.err.Error())
+	}
+	_, err := h.client.Apply(ctx, []*spanner.Mutation{
+		spanner.Update(h.table, []string{"RunID", "FinishedAt", "DeletedRows", "Status", "Error"},
+			[]interface{}{h.runID, time.Now(), deleted, status, errMsg}),
+	})
+	return err
+}
+
+// nullString returns NULL for an empty string.
+func nullString(s string) spanner.NullString {
+	return spanner.NullString{StringVal: s, Valid: s != ""}
+}
+
+// HistoryFilter selects the runs listed by History. Zero values match all runs.
+type HistoryFilter struct {
+	// Since lists only runs started at or after the time.
+	Since time.Time
+	// Operator lists only runs by the operator.
+	Operator string
+	// Table lists only runs which deleted rows from the table.
+	Table string
+	// Status lists only runs with the status, one of HistoryRunning, HistorySucceeded or HistoryFailed.
+	Status string
+	// Limit is the maximum number of runs listed, newest first, if positive.
+	Limit int
+}
+
+// statement returns the query of the runs in the history table matching the filter.
+func (f HistoryFilter) statement(table string) spanner.Statement {
+	var conds []string
+	params := map[string]interface{}{}
+	if !f.Since.IsZero() {
+		conds = append(conds, "StartedAt >= @since")
+		params["since"] = f.Since
+	}
+	if f.Operator != "" {
+		conds = append(conds, "Operator = @operator")
+		params["operator"] = f.Operator
+	}
+	if f.Table != "" {
+		conds = append(conds, "@table IN UNNEST(Tables)")
+		params["table"] = f.Table
+	}
+	if f.Status != "" {
+		conds = append(conds, "Status = @status")
+		params["status"] = f.Status
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(historyColumns, ", "), quoteIdentifier(table))
+	if len(conds) > 0 {
+		sql += " WHERE " + strings.Join(conds, " AND ")
+	}
+	sql += " ORDER BY StartedAt DESC"
+	if f.Limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	return spanner.Statement{SQL: sql, Params: params}
+}
+
+// historyEntry is a run in the history table.
+type historyEntry struct {
+	RunID       string
+	StartedAt   time.Time
+	FinishedAt  spanner.NullTime
+	Operator    string
+	Reason      spanner.NullString
+	Tables      []string
+	DeletedRows spanner.NullInt64
+	Status      string
+	Error       spanner.NullString
+}
+
+// History writes the previous runs recorded in the history table of the database matching the filter, newest first.
+// Runs are recorded into the table by runs with WithHistoryTable.
+func History(ctx context.Context, projectID, instanceID, databaseID, table string, filter HistoryFilter, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	client, ok := spannerClient(b)
+	if !ok {
+		return fmt.Errorf("history table requires the native Cloud Spanner client")
+	}
+	if b.Dialect() == DialectPostgreSQL {
+		return fmt.Errorf("history is only supported in GoogleSQL databases")
+	}
+
+	var entries []*historyEntry
+	iter := client.Single().QueryWithOptions(ctx, filter.statement(table), o.queryOptions())
+	defer iter.Stop()
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read history: %v", err)
+		}
+		var e historyEntry
+		if err := row.ToStruct(&e); err != nil {
+			return fmt.Errorf("failed to read history: %v", err)
+		}
+		entries = append(entries, &e)
+	}
+	return writeHistory(o.Output.writer(), entries)
+}
+
+// PruneHistory deletes the runs started before the cutoff from the history table of the database,
+// so that the history does not grow without bound, and returns the number of deleted runs.
+func PruneHistory(ctx context.Context, projectID, instanceID, databaseID, table string, cutoff time.Time, opts ...Option) (int64, error) {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+	client, ok := spannerClient(b)
+	if !ok {
+		return 0, fmt.Errorf("history table requires the native Cloud Spanner client")
+	}
+	if b.Dialect() == DialectPostgreSQL {
+		return 0, fmt.Errorf("history is only supported in GoogleSQL databases")
+	}
+	count, err := client.PartitionedUpdateWithOptions(ctx, pruneHistoryStatement(table, cutoff), o.queryOptions())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune history: %v", err)
+	}
+	return count, nil
+}
+
+// pruneHistoryStatement returns the statement deleting the runs started before the cutoff.
+func pruneHistoryStatement(table string, cutoff time.Time) spanner.Statement {
+	return spanner.Statement{
+		SQL:    fmt.Sprintf("DELETE FROM %s WHERE StartedAt < @cutoff", quoteIdentifier(table)),
+		Params: map[string]interface{}{"cutoff": cutoff},
+	}
+}
+
+func writeHistory(w io.Writer, entries []*historyEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTARTED\tDURATION\tOPERATOR\tSTATUS\tROWS\tTABLES\tREASON")
+	for _, e := range entries {
+		var duration, rows string
+		if e.FinishedAt.Valid {
+			duration = e.FinishedAt.Time.Sub(e.StartedAt).Round(time.Second).String()
+		}
+		if e.DeletedRows.Valid {
+			rows = formatNumber(uint64(e.DeletedRows.Int64))
+		}
+		status := e.Status
+		if e.Error.Valid {
+			status += ": " + e.Error.StringVal
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.RunID, e.StartedAt.Format(time.RFC3339), orDash(duration), e.Operator,
+			status, orDash(rows), orDash(strings.Join(e.Tables, ", ")), orDash(e.Reason.StringVal))
+	}
+	return tw.Flush()
+}
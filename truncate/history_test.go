@@ -0,0 +1,105 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistoryFilterStatement(t *testing.T) {
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		desc   string
+		filter HistoryFilter
+		want   spanner.Statement
+	}{
+		{
+			desc:   "All runs",
+			filter: HistoryFilter{},
+			want: spanner.Statement{
+				SQL:    "SELECT RunID, StartedAt, FinishedAt, Operator, Reason, Tables, DeletedRows, Status, Error FROM `TruncateHistory` ORDER BY StartedAt DESC",
+				Params: map[string]interface{}{},
+			},
+		},
+		{
+			desc:   "Filtered runs",
+			filter: HistoryFilter{Since: since, Operator: "alice", Table: "Singers", Status: HistoryFailed, Limit: 10},
+			want: spanner.Statement{
+				SQL: "SELECT RunID, StartedAt, FinishedAt, Operator, Reason, Tables, DeletedRows, Status, Error FROM `TruncateHistory` " +
+					"WHERE StartedAt >= @since AND Operator = @operator AND @table IN UNNEST(Tables) AND Status = @status ORDER BY StartedAt DESC LIMIT 10",
+				Params: map[string]interface{}{"since": since, "operator": "alice", "table": "Singers", "status": HistoryFailed},
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.filter.statement("TruncateHistory")); diff != "" {
+				t.Errorf("statement() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPruneHistoryStatement(t *testing.T) {
+	cutoff := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := spanner.Statement{
+		SQL:    "DELETE FROM `TruncateHistory` WHERE StartedAt < @cutoff",
+		Params: map[string]interface{}{"cutoff": cutoff},
+	}
+	if diff := cmp.Diff(want, pruneHistoryStatement("TruncateHistory", cutoff)); diff != "" {
+		t.Errorf("pruneHistoryStatement() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteHistory(t *testing.T) {
+	started := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []*historyEntry{
+		{
+			RunID:     "run-2",
+			StartedAt: started.Add(time.Hour),
+			Operator:  "bob",
+			Tables:    []string{"Singers"},
+			Status:    HistoryRunning,
+		},
+		{
+			RunID:       "run-1",
+			StartedAt:   started,
+			FinishedAt:  spanner.NullTime{Time: started.Add(90 * time.Second), Valid: true},
+			Operator:    "alice",
+			Reason:      spanner.NullString{StringVal: "OPS-123", Valid: true},
+			Tables:      []string{"Singers", "Albums"},
+			DeletedRows: spanner.NullInt64{Int64: 12345, Valid: true},
+			Status:      HistoryFailed,
+			Error:       spanner.NullString{StringVal: "aborted", Valid: true},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeHistory(&buf, entries); err != nil {
+		t.Fatalf("writeHistory() failed: %v", err)
+	}
+	want := `RUN ID  STARTED               DURATION  OPERATOR  STATUS           ROWS    TABLES           REASON
+run-2   2020-01-01T01:00:00Z  -         bob       running          -       Singers          -
+run-1   2020-01-01T00:00:00Z  1m30s     alice     failed: aborted  12,345  Singers, Albums  OPS-123
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writeHistory() output mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,132 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// impactedTable is a table affected by truncating a table.
+type impactedTable struct {
+	tableName string
+	reason    string
+	// blocking is true if rows in the table block the deletion instead of being deleted.
+	blocking bool
+}
+
+// impactedTables returns the tables affected by truncating the target table, starting with the target itself.
+func impactedTables(all []*tableSchema, target string) ([]*impactedTable, error) {
+	schemas := make(map[string]*tableSchema, len(all))
+	for _, s := range all {
+		schemas[strings.ToLower(s.tableName)] = s
+	}
+	root, ok := schemas[strings.ToLower(target)]
+	if !ok {
+		return nil, fmt.Errorf("table %s not found", target)
+	}
+
+	impacted := []*impactedTable{{tableName: root.tableName, reason: "target"}}
+	visited := map[string]bool{root.tableName: true}
+	for i := 0; i < len(impacted); i++ {
+		current := impacted[i]
+		if current.blocking {
+			continue
+		}
+
+		for _, s := range all {
+			if s.parentTableName != current.tableName || visited[s.tableName] {
+				continue
+			}
+			visited[s.tableName] = true
+			it := &impactedTable{tableName: s.tableName}
+			if s.parentOnDeleteAction == deleteActionNoAction {
+				it.reason = fmt.Sprintf("interleaved in %s with ON DELETE NO ACTION, must be deleted first", current.tableName)
+			} else {
+				it.reason = fmt.Sprintf("deleted by ON DELETE CASCADE from %s", current.tableName)
+			}
+			impacted = append(impacted, it)
+		}
+
+		for _, referencing := range schemas[strings.ToLower(current.tableName)].referencedBy {
+			if visited[referencing] {
+				continue
+			}
+			visited[referencing] = true
+			impacted = append(impacted, &impactedTable{
+				tableName: referencing,
+				reason:    fmt.Sprintf("references %s by a foreign key, blocks deletion", current.tableName),
+				blocking:  true,
+			})
+		}
+	}
+	return impacted, nil
+}
+
+// Impact estimates how many rows across which tables would be affected if the given table were truncated,
+// following interleaved tables and foreign keys, without deleting anything.
+func Impact(ctx context.Context, projectID, instanceID, databaseID, tableName string, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	out := o.Output.writer()
+
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	fmt.Fprintf(out, "Fetching table schema from %s\n", database)
+	all, err := fetchAllTableSchemas(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+	impacted, err := impactedTables(all, tableName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nImpact of truncating %s:\n", impacted[0].tableName)
+	var deletedRows, blockingRows uint64
+	var deletedTables int
+	for _, it := range impacted {
+		d := &deleter{tableName: it.tableName, backend: b, priority: o.Priority}
+		// Use stale read to minimize the impact on the leader replica.
+		count, err := d.countRows(ctx, 10*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to count rows in %s: %v", it.tableName, err)
+		}
+		fmt.Fprintf(out, "  %s: %s rows (%s)\n", it.tableName, formatNumber(uint64(count)), it.reason)
+		if it.blocking {
+			blockingRows += uint64(count)
+		} else {
+			deletedRows += uint64(count)
+			deletedTables++
+		}
+	}
+
+	fmt.Fprintf(out, "\nTotal: %s rows in %d tables would be deleted.\n", formatNumber(deletedRows), deletedTables)
+	if blockingRows > 0 {
+		fmt.Fprintf(out, "%s rows in referencing tables must be deleted first.\n", formatNumber(blockingRows))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImpactedTables(t *testing.T) {
+	all := []*tableSchema{
+		{tableName: "Singers", referencedBy: []string{"Concerts"}},
+		{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "Songs", parentTableName: "Albums", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "Reviews", parentTableName: "Albums", parentOnDeleteAction: deleteActionNoAction},
+		{tableName: "Concerts"},
+		{tableName: "Venues"},
+	}
+
+	got, err := impactedTables(all, "singers")
+	if err != nil {
+		t.Fatalf("impactedTables() failed: %v", err)
+	}
+	var gotStrs []string
+	for _, it := range got {
+		gotStrs = append(gotStrs, fmt.Sprintf("%s: %s", it.tableName, it.reason))
+	}
+	want := []string{
+		"Singers: target",
+		"Albums: deleted by ON DELETE CASCADE from Singers",
+		"Concerts: references Singers by a foreign key, blocks deletion",
+		"Songs: deleted by ON DELETE CASCADE from Albums",
+		"Reviews: interleaved in Albums with ON DELETE NO ACTION, must be deleted first",
+	}
+	if diff := cmp.Diff(want, gotStrs); diff != "" {
+		t.Errorf("impactedTables() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := impactedTables(all, "Unknown"); err == nil {
+		t.Errorf("impactedTables() for unknown table succeeded, but want error")
+	}
+}
@@ -0,0 +1,84 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// inflight tracks the statements being executed by all runs in this process.
+var inflight = &statementTracker{statements: map[int]*inflightStatement{}}
+
+// inflightStatement is a statement being executed.
+type inflightStatement struct {
+	sql     string
+	started time.Time
+	deleter *deleter
+}
+
+// statementTracker tracks statements being executed.
+type statementTracker struct {
+	mu         sync.Mutex
+	nextID     int
+	statements map[int]*inflightStatement
+}
+
+// start registers the statement and returns a function to unregister it.
+func (t *statementTracker) start(d *deleter, sql string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextID
+	t.nextID++
+	t.statements[id] = &inflightStatement{sql: sql, started: time.Now(), deleter: d}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.statements, id)
+	}
+}
+
+// snapshot returns the statements being executed in the order of the start time.
+func (t *statementTracker) snapshot() []inflightStatement {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statements := make([]inflightStatement, 0, len(t.statements))
+	for _, s := range t.statements {
+		statements = append(statements, *s)
+	}
+	sort.Slice(statements, func(i, j int) bool {
+		return statements[i].started.Before(statements[j].started)
+	})
+	return statements
+}
+
+// DumpInflightStatements writes the statements currently being executed, their tables,
+// elapsed time and progress of deletion to w. It is useful when a run appears hung.
+func DumpInflightStatements(w io.Writer) {
+	statements := inflight.snapshot()
+	fmt.Fprintf(w, "%d statements in flight at %s\n", len(statements), time.Now().Format(time.RFC3339))
+	for _, s := range statements {
+		d := s.deleter
+		fmt.Fprintf(w, "  %s: elapsed %s, progress (%s / %s): %s\n", d.tableName, time.Since(s.started).Truncate(time.Second),
+			formatNumber(d.deletedRows()), formatNumber(d.total()), s.sql)
+	}
+}
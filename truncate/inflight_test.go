@@ -0,0 +1,41 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpInflightStatements(t *testing.T) {
+	d := &deleter{tableName: "Singers", totalRows: 6000, remainedRows: 4800}
+	done := inflight.start(d, "DELETE FROM `Singers` WHERE true")
+
+	var buf bytes.Buffer
+	DumpInflightStatements(&buf)
+	if got, want := buf.String(), "Singers: elapsed 0s, progress (1,200 / 6,000): DELETE FROM `Singers` WHERE true"; !strings.Contains(got, want) {
+		t.Errorf("DumpInflightStatements() = %q, but want to contain %q", got, want)
+	}
+
+	done()
+	buf.Reset()
+	DumpInflightStatements(&buf)
+	if got := buf.String(); strings.Contains(got, "Singers") {
+		t.Errorf("DumpInflightStatements() = %q, but finished statement remains", got)
+	}
+}
@@ -26,6 +26,7 @@ import (
 
 	"cloud.google.com/go/spanner"
 	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+	"github.com/google/go-cmp/cmp"
 	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
 )
 
@@ -193,11 +194,7 @@ func TestIntegrationTest(t *testing.T) {
 		fmt.Sprintf("DROP TABLE %s", table2),
 	})
 
-	devNull, err := os.Open(os.DevNull)
-	if err != nil {
-		t.Fatalf("failed to open /dev/null: %v", err)
-	}
-	if err := Run(ctx, testProjectID, testInstanceID, testDatabaseID, true, devNull, nil, nil); err != nil {
+	if err := Run(ctx, testProjectID, testInstanceID, testDatabaseID, WithQuiet(true)); err != nil {
 		t.Fatalf("run spanner-truncate failed: %v", err)
 	}
 
@@ -217,3 +214,88 @@ func TestIntegrationTest(t *testing.T) {
 		}
 	}
 }
+
+func TestIntegrationLock(t *testing.T) {
+	if skipIntegrateTest {
+		t.Skip("skip integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+	defer cancel()
+
+	table := generateUniqueTableID()
+	client := setup(t, ctx, []string{
+		fmt.Sprintf(`CREATE TABLE %s (
+  Name STRING(MAX) NOT NULL,
+  RunID STRING(MAX) NOT NULL,
+  Holder STRING(MAX) NOT NULL,
+  ExpiresAt TIMESTAMP NOT NULL,
+) PRIMARY KEY(Name)`, table),
+	}, nil)
+	defer tearDown(t, ctx, []string{fmt.Sprintf("DROP TABLE %s", table)})
+
+	lock, err := acquireLock(ctx, client, table, "run1", "alice", time.Minute, false)
+	if err != nil {
+		t.Fatalf("acquireLock() failed: %v", err)
+	}
+	if _, err := acquireLock(ctx, client, table, "run2", "bob", time.Minute, false); err == nil {
+		t.Errorf("acquireLock() succeeded while another run holds the lock")
+	} else if _, ok := err.(*LockHeldError); !ok {
+		t.Errorf("acquireLock() = %v, but want *LockHeldError", err)
+	}
+	if _, err := acquireLock(ctx, client, table, "run2", "bob", time.Minute, true); err != nil {
+		t.Errorf("acquireLock() with steal failed: %v", err)
+	}
+	// The lock stolen by run2 must not be released by run1.
+	if err := lock.release(ctx); err != nil {
+		t.Errorf("release() failed: %v", err)
+	}
+	if _, err := acquireLock(ctx, client, table, "run3", "carol", time.Minute, false); err == nil {
+		t.Errorf("acquireLock() succeeded while the lock stolen by another run is held")
+	}
+}
+
+func TestIntegrationSchemaVersion(t *testing.T) {
+	if skipIntegrateTest {
+		t.Skip("skip integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+	defer cancel()
+
+	table := generateUniqueTableID()
+	client := setup(t, ctx, []string{
+		fmt.Sprintf(`CREATE TABLE %s (
+  Version INT64 NOT NULL,
+  Dirty BOOL NOT NULL,
+  Label STRING(MAX) AS (CONCAT("v", CAST(Version AS STRING))) STORED,
+) PRIMARY KEY(Version)`, table),
+	}, []string{
+		fmt.Sprintf("INSERT INTO `%s` (`Version`, `Dirty`) VALUES (1, false), (2, true);", table),
+	})
+	defer tearDown(t, ctx, []string{fmt.Sprintf("DROP TABLE %s", table)})
+
+	row, err := readLatestRow(ctx, client, DialectGoogleSQL, &SchemaVersion{Table: table, OrderBy: "Version"}, spanner.QueryOptions{})
+	if err != nil {
+		t.Fatalf("readLatestRow() failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"Version", "Dirty"}, row.columns); diff != "" {
+		t.Errorf("readLatestRow() columns mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := client.Apply(ctx, []*spanner.Mutation{spanner.Delete(table, spanner.AllKeys())}); err != nil {
+		t.Fatalf("failed to delete rows: %v", err)
+	}
+	if err := row.restore(ctx, client, spanner.QueryOptions{}); err != nil {
+		t.Fatalf("restore() failed: %v", err)
+	}
+
+	var label string
+	if err := client.Single().Query(ctx, spanner.NewStatement(fmt.Sprintf("SELECT Label FROM %s", table))).Do(func(r *spanner.Row) error {
+		return r.Column(0, &label)
+	}); err != nil {
+		t.Fatalf("failed to read the restored row: %v", err)
+	}
+	if label != "v2" {
+		t.Errorf("restored row has label %q, but want %q", label, "v2")
+	}
+}
@@ -0,0 +1,103 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// tableListing is a table in the output of ListTables.
+type tableListing struct {
+	tableName string
+	parent    string
+	graphs    []string
+	size      string
+}
+
+// ListTables writes all tables in the database with their approximate sizes, parents and the property graphs they back.
+func ListTables(ctx context.Context, projectID, instanceID, databaseID string, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	out := o.Output.writer()
+
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	schemas, err := fetchAllTableSchemas(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+	graphs, err := fetchGraphsByTable(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch property graphs: %v", err)
+	}
+	sizes := fetchTableSizes(ctx, b, o.Output)
+
+	var tables []*tableListing
+	for _, s := range schemas {
+		t := &tableListing{tableName: s.tableName, graphs: graphs[s.tableName]}
+		if size, ok := sizes[s.tableName]; ok {
+			t.size = formatBytes(uint64(size))
+		}
+		if s.parentTableName != "" {
+			t.parent = s.parentTableName
+			if s.parentOnDeleteAction == deleteActionCascadeDelete {
+				t.parent += " (ON DELETE CASCADE)"
+			}
+		}
+		tables = append(tables, t)
+	}
+	return writeTableListings(out, tables)
+}
+
+func writeTableListings(w io.Writer, tables []*tableListing) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tSIZE\tPARENT\tPROPERTY GRAPHS")
+	for _, t := range tables {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.tableName, orDash(t.size), orDash(t.parent), orDash(strings.Join(t.graphs, ", ")))
+	}
+	return tw.Flush()
+}
+
+// orDash returns "-" for an empty column so that columns stay aligned.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// fetchTableSizes returns approximate storage in bytes of each table.
+// Sizes are informational, so it warns and returns nil if the statistics are not available, e.g. on the emulator.
+func fetchTableSizes(ctx context.Context, b Backend, out Output) map[string]int64 {
+	sizes, err := b.TableSizes(ctx)
+	if err != nil {
+		out.warnf("Table sizes are not available: %v", err)
+		return nil
+	}
+	return sizes
+}
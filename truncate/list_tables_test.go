@@ -0,0 +1,52 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListTables(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{
+			{Name: "Account"},
+			{Name: "Person"},
+			{Name: "PersonOwnAccount", ParentName: "Person", OnDeleteAction: "CASCADE"},
+		},
+		graphs: []PropertyGraphInfo{
+			{Name: "FinGraph", BaseTables: []string{"Account", "Person", "PersonOwnAccount"}},
+			{Name: "PeopleGraph", BaseTables: []string{"Person"}},
+		},
+		sizes: map[string]int64{"Account": 1536, "Person": 5 << 20},
+	}
+	var buf bytes.Buffer
+	if err := ListTables(context.Background(), "p", "i", "d", WithBackend(b), WithOutput(Output{Writer: &buf})); err != nil {
+		t.Fatalf("ListTables() failed: %v", err)
+	}
+	want := `TABLE             SIZE     PARENT                      PROPERTY GRAPHS
+Account           1.5 KiB  -                           FinGraph
+Person            5.0 MiB  -                           FinGraph, PeopleGraph
+PersonOwnAccount  -        Person (ON DELETE CASCADE)  FinGraph
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("ListTables() output mismatch (-want +got):\n%s", diff)
+	}
+}
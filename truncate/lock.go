@@ -0,0 +1,179 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+)
+
+// Name of the lock row in the lock table. A database has a single lock.
+const lockName = "spanner-truncate"
+
+// lockColumns are the columns of the lock table. See README for its DDL.
+var lockColumns = []string{"Name", "RunID", "Holder", "ExpiresAt"}
+
+// LockHeldError is returned when another run holds the lock of the database.
+type LockHeldError struct {
+	RunID     string
+	Holder    string
+	ExpiresAt time.Time
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("the database is locked by run %s of %s until %s, use --steal-lock to override", e.RunID, e.Holder, e.ExpiresAt.Format(time.RFC3339))
+}
+
+// runLock is a lock of the database held by a run, to prevent concurrent runs against the same database.
+// The lock expires after ttl unless refreshed, so that a lock left behind by a crashed run becomes stale.
+type runLock struct {
+	client *spanner.Client
+	table  string
+	runID  string
+	holder string
+	ttl    time.Duration
+}
+
+// lockHolder returns the identity of the operator and this process written to the lock.
+func lockHolder(operator string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	return fmt.Sprintf("%s on %s (pid %d)", operator, host, os.Getpid())
+}
+
+// acquireLock acquires the lock of the database. A lock held by another run is taken over only if it has expired or steal is true.
+func acquireLock(ctx context.Context, client *spanner.Client, table, runID, operator string, ttl time.Duration, steal bool) (*runLock, error) {
+	l := &runLock{client: client, table: table, runID: runID, holder: lockHolder(operator), ttl: ttl}
+	_, err := client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRow(ctx, table, spanner.Key{lockName}, []string{"RunID", "Holder", "ExpiresAt"})
+		if err != nil && spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+		if err == nil && !steal {
+			var held LockHeldError
+			if err := row.Columns(&held.RunID, &held.Holder, &held.ExpiresAt); err != nil {
+				return err
+			}
+			if held.RunID != runID && time.Now().Before(held.ExpiresAt) {
+				return &held
+			}
+		}
+		return txn.BufferWrite([]*spanner.Mutation{l.mutation()})
+	})
+	if err != nil {
+		var held *LockHeldError
+		if errors.As(err, &held) {
+			return nil, held
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// liveLockRunID returns the ID of the run holding the lock of the database, or "" if the lock is not held or has expired.
+func liveLockRunID(ctx context.Context, client *spanner.Client, table string) (string, error) {
+	row, err := client.Single().ReadRow(ctx, table, spanner.Key{lockName}, []string{"RunID", "ExpiresAt"})
+	if err != nil {
+		if spanner.ErrCode(err) == codes.NotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	var runID string
+	var expiresAt time.Time
+	if err := row.Columns(&runID, &expiresAt); err != nil {
+		return "", err
+	}
+	if !time.Now().Before(expiresAt) {
+		return "", nil
+	}
+	return runID, nil
+}
+
+// mutation returns the mutation writing the lock held by this run.
+func (l *runLock) mutation() *spanner.Mutation {
+	return spanner.InsertOrUpdate(l.table, lockColumns, []interface{}{lockName, l.runID, l.holder, time.Now().Add(l.ttl)})
+}
+
+// keepAlive refreshes the expiry of the lock until the context is done.
+// onLost is called if the lock has been stolen by another run.
+func (l *runLock) keepAlive(ctx context.Context, workers *workerGroup, onLost func(holder string)) {
+	workers.spawn("lock keep-alive", func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				var stolenBy string
+				_, err := l.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+					stolenBy = ""
+					row, err := txn.ReadRow(ctx, l.table, spanner.Key{lockName}, []string{"RunID", "Holder"})
+					if err != nil && spanner.ErrCode(err) != codes.NotFound {
+						return err
+					}
+					if err == nil {
+						var runID, holder string
+						if err := row.Columns(&runID, &holder); err != nil {
+							return err
+						}
+						if runID != l.runID {
+							stolenBy = holder
+							return nil
+						}
+					}
+					return txn.BufferWrite([]*spanner.Mutation{l.mutation()})
+				})
+				if err == nil && stolenBy != "" {
+					onLost(stolenBy)
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// release deletes the lock unless it has been stolen by another run.
+func (l *runLock) release(ctx context.Context) error {
+	_, err := l.client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRow(ctx, l.table, spanner.Key{lockName}, []string{"RunID"})
+		if err != nil {
+			if spanner.ErrCode(err) == codes.NotFound {
+				return nil
+			}
+			return err
+		}
+		var runID string
+		if err := row.Columns(&runID); err != nil {
+			return err
+		}
+		if runID != l.runID {
+			return nil
+		}
+		return txn.BufferWrite([]*spanner.Mutation{spanner.Delete(l.table, spanner.Key{lockName})})
+	})
+	return err
+}
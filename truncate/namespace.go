@@ -0,0 +1,68 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// namespacePattern is the pattern of valid namespaces, which prefix table names followed by an underscore.
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// namespacePrefix returns the prefix of the names of tables in the namespace, e.g. "pr123_" of "pr123".
+func namespacePrefix(namespace string) string {
+	return namespace + "_"
+}
+
+// inNamespace returns true if the table, ignoring its named schema, is prefixed with the namespace.
+func inNamespace(name, namespace string) bool {
+	_, table := splitTableName(name)
+	return strings.HasPrefix(table, namespacePrefix(namespace))
+}
+
+// stripNamespace returns the name of the table without the prefix of the namespace, keeping its named schema.
+// Names of tables not in the namespace are returned as is.
+func stripNamespace(name, namespace string) string {
+	schema, table := splitTableName(name)
+	return qualifiedName(schema, strings.TrimPrefix(table, namespacePrefix(namespace)))
+}
+
+// selectByNamespace excludes the table unless it is in the namespace.
+func (s tableSelection) selectByNamespace(decision *planDecision) {
+	if s.namespace == "" {
+		return
+	}
+	if !inNamespace(s.matchKey(decision.tableName), s.matchKey(s.namespace)) {
+		decision.included = false
+		decision.reason = fmt.Sprintf("not in namespace %s", s.namespace)
+		return
+	}
+	decision.reason += fmt.Sprintf(", in namespace %s", s.namespace)
+}
+
+// stripNamespace removes the prefix of the namespace from the names of tables in the report.
+func (r *report) stripNamespace(namespace string) {
+	for _, t := range r.tables {
+		t.table = stripNamespace(t.table, namespace)
+	}
+	// Kept tables are copied since they are shared with the run.
+	for i, k := range r.kept {
+		r.kept[i] = &keptTable{table: stripNamespace(k.table, namespace), before: k.before, after: k.after}
+	}
+}
@@ -0,0 +1,311 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+)
+
+// dropPollInterval is the interval to report the progress of dropping the tables of a namespace.
+const dropPollInterval = 10 * time.Second
+
+// These queries fetch the schema objects which depend on tables, and must be dropped before them.
+// Tables in named schemas are qualified with the schema, e.g. sch1.Orders.
+const (
+	viewsSQL = `
+		SELECT IF(TABLE_SCHEMA = '', TABLE_NAME, CONCAT(TABLE_SCHEMA, '.', TABLE_NAME)), VIEW_DEFINITION
+		FROM INFORMATION_SCHEMA.VIEWS
+		WHERE TABLE_CATALOG = '' AND TABLE_SCHEMA NOT IN ('INFORMATION_SCHEMA', 'SPANNER_SYS')
+	`
+	changeStreamTablesSQL = `
+		SELECT CHANGE_STREAM_NAME, IF(TABLE_SCHEMA = '', TABLE_NAME, CONCAT(TABLE_SCHEMA, '.', TABLE_NAME))
+		FROM INFORMATION_SCHEMA.CHANGE_STREAM_TABLES
+		WHERE CHANGE_STREAM_CATALOG = ''
+	`
+	specialIndexesSQL = `
+		SELECT INDEX_NAME, INDEX_TYPE, IF(TABLE_SCHEMA = '', TABLE_NAME, CONCAT(TABLE_SCHEMA, '.', TABLE_NAME))
+		FROM INFORMATION_SCHEMA.INDEXES
+		WHERE INDEX_TYPE NOT IN ('INDEX', 'PRIMARY_KEY') AND TABLE_CATALOG = '' AND TABLE_SCHEMA NOT IN ('INFORMATION_SCHEMA', 'SPANNER_SYS')
+	`
+	pgViewsSQL = `
+		SELECT table_name, view_definition FROM information_schema.views
+		WHERE table_schema = 'public'
+	`
+	pgChangeStreamTablesSQL = `
+		SELECT change_stream_name, table_name FROM information_schema.change_stream_tables
+		WHERE table_schema = 'public'
+	`
+	pgSpecialIndexesSQL = `
+		SELECT index_name, index_type, table_name FROM information_schema.indexes
+		WHERE index_type NOT IN ('INDEX', 'PRIMARY_KEY') AND table_schema = 'public'
+	`
+)
+
+// identifierRe matches identifiers in a view definition, optionally qualified with the schema.
+var identifierRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?`)
+
+// schemaDependent is a schema object depending on tables, such as a view or a change stream,
+// which prevents the tables from being dropped until it is dropped.
+type schemaDependent struct {
+	kind   string
+	name   string
+	tables []string
+}
+
+// fetchSchemaDependents fetches the views, change streams, search indexes and property graphs depending on the tables.
+// A view is considered to depend on every table named in its definition.
+func fetchSchemaDependents(ctx context.Context, b Backend, all []*tableSchema) ([]schemaDependent, error) {
+	client, ok := spannerClient(b)
+	if !ok {
+		return nil, fmt.Errorf("dropping a namespace requires the native Cloud Spanner client")
+	}
+	views, changeStreams, indexes := viewsSQL, changeStreamTablesSQL, specialIndexesSQL
+	if b.Dialect() == DialectPostgreSQL {
+		views, changeStreams, indexes = pgViewsSQL, pgChangeStreamTablesSQL, pgSpecialIndexesSQL
+	}
+
+	var dependents []schemaDependent
+	if err := client.Single().Query(ctx, spanner.NewStatement(views)).Do(func(r *spanner.Row) error {
+		var name string
+		var definition spanner.NullString
+		if err := r.Columns(&name, &definition); err != nil {
+			return err
+		}
+		dependents = append(dependents, schemaDependent{kind: "view", name: name, tables: namedTables(definition.StringVal, all)})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to fetch views: %v", err)
+	}
+	if err := client.Single().Query(ctx, spanner.NewStatement(changeStreams)).Do(func(r *spanner.Row) error {
+		var name, table string
+		if err := r.Columns(&name, &table); err != nil {
+			return err
+		}
+		dependents = append(dependents, schemaDependent{kind: "change stream", name: name, tables: []string{table}})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to fetch change streams: %v", err)
+	}
+	if err := client.Single().Query(ctx, spanner.NewStatement(indexes)).Do(func(r *spanner.Row) error {
+		var name, indexType, table string
+		if err := r.Columns(&name, &indexType, &table); err != nil {
+			return err
+		}
+		dependents = append(dependents, schemaDependent{kind: strings.ToLower(indexType) + " index", name: name, tables: []string{table}})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to fetch search indexes: %v", err)
+	}
+
+	graphs, err := b.PropertyGraphs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch property graphs: %v", err)
+	}
+	for _, g := range graphs {
+		dependents = append(dependents, schemaDependent{kind: "property graph", name: g.Name, tables: g.BaseTables})
+	}
+	return dependents, nil
+}
+
+// namedTables returns the tables named in the SQL text, such as the definition of a view.
+// Quoted identifiers are matched as well, and the names are compared case-insensitively as Cloud Spanner does.
+func namedTables(sql string, all []*tableSchema) []string {
+	sql = strings.NewReplacer("`", "", `"`, "").Replace(sql)
+	named := map[string]bool{}
+	for _, id := range identifierRe.FindAllString(sql, -1) {
+		named[strings.ToLower(id)] = true
+	}
+	var tables []string
+	for _, t := range all {
+		if named[strings.ToLower(t.tableName)] {
+			tables = append(tables, t.tableName)
+		}
+	}
+	return tables
+}
+
+// namespaceDropStatements returns the DDL statements dropping all tables of the namespace and their indexes.
+// Indexes are dropped first, and each table is dropped after the tables interleaved in it or referencing it.
+// It returns an error if any table of the namespace is not emptied by the run, i.e. it is not selected or only some of its rows are deleted,
+// or if a table outside the namespace or another schema object, such as a view, depends on a table of the namespace,
+// since the tables cannot be dropped without losing rows or breaking the schema.
+func namespaceDropStatements(all, selected []*tableSchema, indexes []*indexSchema, dependents []schemaDependent, namespace string, dialect Dialect, key func(string) string) ([]string, error) {
+	emptied := make(map[string]bool, len(selected))
+	for _, t := range selected {
+		if t.filter == "" {
+			emptied[t.tableName] = true
+		}
+	}
+	var tables []*tableSchema
+	members := map[string]bool{}
+	for _, t := range all {
+		if !inNamespace(key(t.tableName), key(namespace)) {
+			continue
+		}
+		if !emptied[t.tableName] {
+			return nil, fmt.Errorf("%s is in the namespace, but not all of its rows are deleted by the run", t.tableName)
+		}
+		tables = append(tables, t)
+		members[t.tableName] = true
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no tables in the namespace")
+	}
+
+	// Statements before a failed statement remain applied, so the other schema objects are checked before any statement is sent.
+	for _, d := range dependents {
+		for _, t := range d.tables {
+			if members[t] {
+				return nil, fmt.Errorf("%s %s depends on %s, and must be dropped manually", d.kind, d.name, t)
+			}
+		}
+	}
+
+	// children are the tables which must be dropped before each table.
+	children := map[string][]string{}
+	for _, t := range all {
+		if t.parentTableName != "" && members[t.parentTableName] {
+			if !members[t.tableName] {
+				return nil, fmt.Errorf("%s is interleaved in %s, but is not in the namespace", t.tableName, t.parentTableName)
+			}
+			children[t.parentTableName] = append(children[t.parentTableName], t.tableName)
+		}
+	}
+	for _, t := range tables {
+		for _, referencing := range t.referencedBy {
+			if referencing == t.tableName {
+				continue
+			}
+			if !members[referencing] {
+				return nil, fmt.Errorf("%s references %s by a foreign key, but is not in the namespace", referencing, t.tableName)
+			}
+			children[t.tableName] = append(children[t.tableName], referencing)
+		}
+	}
+
+	var statements []string
+	for _, idx := range indexes {
+		if members[idx.baseTableName] {
+			statements = append(statements, fmt.Sprintf("DROP INDEX %s", dialect.quote(idx.indexName)))
+		}
+	}
+	dropped := map[string]bool{}
+	for len(dropped) < len(tables) {
+		progressed := false
+		for _, t := range tables {
+			if dropped[t.tableName] {
+				continue
+			}
+			ready := true
+			for _, d := range children[t.tableName] {
+				if !dropped[d] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				statements = append(statements, fmt.Sprintf("DROP TABLE %s", dialect.quote(t.tableName)))
+				dropped[t.tableName] = true
+				progressed = true
+			}
+		}
+		if !progressed {
+			var remaining []string
+			for _, t := range tables {
+				if !dropped[t.tableName] {
+					remaining = append(remaining, t.tableName)
+				}
+			}
+			return nil, fmt.Errorf("foreign keys between %s form a cycle, which must be dropped manually", strings.Join(remaining, ", "))
+		}
+	}
+	return statements, nil
+}
+
+// dropNamespace drops the tables of the namespace emptied by the run, planned again with the latest schema,
+// so that tables added to the namespace during the run are not dropped with their rows.
+func dropNamespace(ctx context.Context, b Backend, o *Options, selected []*tableSchema, out io.Writer) error {
+	// The schema cached before the run does not show the tables added during the run.
+	b = uncachedSchema(b)
+	all, err := fetchAllTableSchemas(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+	indexes, err := fetchIndexSchemas(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch index schema: %v", err)
+	}
+	dependents, err := fetchSchemaDependents(ctx, b, all)
+	if err != nil {
+		return fmt.Errorf("failed to fetch schema objects depending on tables: %v", err)
+	}
+	statements, err := namespaceDropStatements(all, selected, indexes, dependents, o.Namespace, b.Dialect(), o.tableSelection().matchKey)
+	if err != nil {
+		return fmt.Errorf("cannot drop namespace %s: %v", o.Namespace, err)
+	}
+	return dropNamespaceTables(ctx, b.DatabaseName(), o.Namespace, statements, o, out)
+}
+
+// dropNamespaceTables executes the DDL statements dropping the tables of the namespace in a single batch,
+// and waits until the long-running operation completes, reporting the number of statements applied.
+func dropNamespaceTables(ctx context.Context, database, namespace string, statements []string, o *Options, out io.Writer) error {
+	client, err := adminapi.NewDatabaseAdminClient(ctx, o.spannerClientOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Spanner database admin client: %v", err)
+	}
+	defer client.Close()
+
+	op, err := client.UpdateDatabaseDdl(ctx, &adminpb.UpdateDatabaseDdlRequest{Database: database, Statements: statements})
+	if err != nil {
+		return fmt.Errorf("failed to drop tables of namespace %s: %v", namespace, err)
+	}
+	fmt.Fprintf(out, "Dropping tables of namespace %s with %d statements (operation %s)\n", namespace, len(statements), op.Name())
+
+	ticker := time.NewTicker(dropPollInterval)
+	defer ticker.Stop()
+	for {
+		// Statements before a failed statement remain applied, so the error tells how far the operation went.
+		err := op.Poll(ctx)
+		applied := 0
+		if md, merr := op.Metadata(); merr == nil && md != nil {
+			applied = len(md.GetCommitTimestamps())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop tables of namespace %s after %d of %d statements: %v", namespace, applied, len(statements), err)
+		}
+		if op.Done() {
+			fmt.Fprintf(out, "Dropped tables of namespace %s\n", namespace)
+			return nil
+		}
+		fmt.Fprintf(out, "Dropping... %d / %d statements\n", applied, len(statements))
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,179 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNamespaceDropStatements(t *testing.T) {
+	key := func(s string) string { return s }
+	users := &tableSchema{tableName: "pr123_Users", referencedBy: []string{"pr123_Orders"}}
+	sessions := &tableSchema{tableName: "pr123_Sessions", parentTableName: "pr123_Users", parentOnDeleteAction: deleteActionCascadeDelete}
+	orders := &tableSchema{tableName: "pr123_Orders", referencedBy: []string{"pr123_Orders"}}
+	other := &tableSchema{tableName: "pr124_Users"}
+	indexes := []*indexSchema{
+		{indexName: "pr123_UsersByEmail", baseTableName: "pr123_Users"},
+		{indexName: "pr124_UsersByEmail", baseTableName: "pr124_Users"},
+	}
+
+	for _, tt := range []struct {
+		desc       string
+		all        []*tableSchema
+		selected   []*tableSchema
+		dependents []schemaDependent
+		want       []string
+		wantErr    string
+	}{
+		{
+			desc:     "Children and referencing tables first",
+			all:      []*tableSchema{users, sessions, orders, other},
+			selected: []*tableSchema{users, sessions, orders},
+			want: []string{
+				"DROP INDEX `pr123_UsersByEmail`",
+				"DROP TABLE `pr123_Sessions`",
+				"DROP TABLE `pr123_Orders`",
+				"DROP TABLE `pr123_Users`",
+			},
+		},
+		{
+			desc:     "Dependents of other tables",
+			all:      []*tableSchema{users, sessions, orders, other},
+			selected: []*tableSchema{users, sessions, orders},
+			dependents: []schemaDependent{
+				{kind: "view", name: "UserNames", tables: []string{"pr124_Users"}},
+				{kind: "change stream", name: "Everything", tables: []string{"pr124_Users"}},
+			},
+			want: []string{
+				"DROP INDEX `pr123_UsersByEmail`",
+				"DROP TABLE `pr123_Sessions`",
+				"DROP TABLE `pr123_Orders`",
+				"DROP TABLE `pr123_Users`",
+			},
+		},
+		{
+			desc:       "View on the namespace",
+			all:        []*tableSchema{users, sessions, orders},
+			selected:   []*tableSchema{users, sessions, orders},
+			dependents: []schemaDependent{{kind: "view", name: "ActiveUsers", tables: []string{"pr123_Users"}}},
+			wantErr:    "view ActiveUsers depends on pr123_Users, and must be dropped manually",
+		},
+		{
+			desc:       "Change stream on the namespace",
+			all:        []*tableSchema{users, sessions, orders},
+			selected:   []*tableSchema{users, sessions, orders},
+			dependents: []schemaDependent{{kind: "change stream", name: "OrderChanges", tables: []string{"pr123_Orders"}}},
+			wantErr:    "change stream OrderChanges depends on pr123_Orders, and must be dropped manually",
+		},
+		{
+			desc:       "Search index on the namespace",
+			all:        []*tableSchema{users, sessions, orders},
+			selected:   []*tableSchema{users, sessions, orders},
+			dependents: []schemaDependent{{kind: "search index", name: "UsersByBio", tables: []string{"pr123_Users"}}},
+			wantErr:    "search index UsersByBio depends on pr123_Users, and must be dropped manually",
+		},
+		{
+			desc:       "Property graph on the namespace",
+			all:        []*tableSchema{users, sessions, orders},
+			selected:   []*tableSchema{users, sessions, orders},
+			dependents: []schemaDependent{{kind: "property graph", name: "Social", tables: []string{"pr124_Users", "pr123_Sessions"}}},
+			wantErr:    "property graph Social depends on pr123_Sessions, and must be dropped manually",
+		},
+		{
+			desc:     "Table not emptied",
+			all:      []*tableSchema{users, sessions, orders},
+			selected: []*tableSchema{users, orders},
+			wantErr:  "pr123_Sessions is in the namespace, but not all of its rows are deleted by the run",
+		},
+		{
+			desc:     "Filtered table",
+			all:      []*tableSchema{orders},
+			selected: []*tableSchema{{tableName: "pr123_Orders", filter: "Status = 1"}},
+			wantErr:  "pr123_Orders is in the namespace, but not all of its rows are deleted by the run",
+		},
+		{
+			desc:     "Referenced from outside",
+			all:      []*tableSchema{{tableName: "pr123_Users", referencedBy: []string{"Audit"}}, {tableName: "Audit"}},
+			selected: []*tableSchema{{tableName: "pr123_Users"}},
+			wantErr:  "Audit references pr123_Users by a foreign key, but is not in the namespace",
+		},
+		{
+			desc:     "Cycle of foreign keys",
+			all:      []*tableSchema{{tableName: "pr123_A", referencedBy: []string{"pr123_B"}}, {tableName: "pr123_B", referencedBy: []string{"pr123_A"}}},
+			selected: []*tableSchema{{tableName: "pr123_A"}, {tableName: "pr123_B"}},
+			wantErr:  "foreign keys between pr123_A, pr123_B form a cycle, which must be dropped manually",
+		},
+		{
+			desc:    "Empty namespace",
+			all:     []*tableSchema{other},
+			wantErr: "no tables in the namespace",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := namespaceDropStatements(tt.all, tt.selected, indexes, tt.dependents, "pr123", DialectGoogleSQL, key)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("namespaceDropStatements() = %v, but want error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("namespaceDropStatements() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("namespaceDropStatements() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNamedTables(t *testing.T) {
+	all := []*tableSchema{{tableName: "Users"}, {tableName: "UserTags"}, {tableName: "sch1.Orders"}}
+	for _, tt := range []struct {
+		desc string
+		sql  string
+		want []string
+	}{
+		{
+			desc: "Plain names",
+			sql:  "SELECT Users.Name FROM Users WHERE Users.Active",
+			want: []string{"Users"},
+		},
+		{
+			desc: "Quoted and case-insensitive names",
+			sql:  "SELECT u.Name FROM `users` AS u JOIN `usertags` AS t ON u.UserId = t.UserId",
+			want: []string{"Users", "UserTags"},
+		},
+		{
+			desc: "Named schema",
+			sql:  `SELECT OrderId FROM sch1."Orders"`,
+			want: []string{"sch1.Orders"},
+		},
+		{
+			desc: "Prefix of a name",
+			sql:  "SELECT UserId FROM UserTagsArchive",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, namedTables(tt.sql, all)); diff != "" {
+				t.Errorf("namedTables() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
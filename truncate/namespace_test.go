@@ -0,0 +1,72 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelectByNamespace(t *testing.T) {
+	all := []*tableSchema{{tableName: "pr123_Users"}, {tableName: "pr1234_Users"}, {tableName: "pr12_Users"}, {tableName: "Users"}, {tableName: "sales.pr123_Orders"}}
+	tables, decisions := selectTables(all, tableSelection{namespace: "pr123"})
+
+	var gotTables []string
+	for _, table := range tables {
+		gotTables = append(gotTables, table.tableName)
+	}
+	if diff := cmp.Diff([]string{"pr123_Users", "sales.pr123_Orders"}, gotTables); diff != "" {
+		t.Errorf("selectTables() tables mismatch (-want +got):\n%s", diff)
+	}
+
+	var gotDecisions []string
+	for _, d := range decisions {
+		gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+	}
+	wantDecisions := []string{
+		"pr123_Users: true: all tables are targeted, in namespace pr123",
+		"pr1234_Users: false: not in namespace pr123",
+		"pr12_Users: false: not in namespace pr123",
+		"Users: false: not in namespace pr123",
+		"sales.pr123_Orders: true: all tables are targeted, in namespace pr123",
+	}
+	if diff := cmp.Diff(wantDecisions, gotDecisions); diff != "" {
+		t.Errorf("selectTables() decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportStripNamespace(t *testing.T) {
+	kept := &keptTable{table: "pr123_Config"}
+	r := &report{
+		tables: []*tableResult{{table: "pr123_Users"}, {table: "sales.pr123_Orders"}, {table: "Users"}},
+		kept:   []*keptTable{kept},
+	}
+	r.stripNamespace("pr123")
+
+	var got []string
+	for _, t := range r.tables {
+		got = append(got, t.table)
+	}
+	if diff := cmp.Diff([]string{"Users", "sales.Orders", "Users"}, got); diff != "" {
+		t.Errorf("stripNamespace() tables mismatch (-want +got):\n%s", diff)
+	}
+	if r.kept[0].table != "Config" || kept.table != "pr123_Config" {
+		t.Errorf("stripNamespace() kept = %q, original = %q, but want = %q, %q", r.kept[0].table, kept.table, "Config", "pr123_Config")
+	}
+}
@@ -0,0 +1,67 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+)
+
+// defaultOperator returns the name of the user running the process, used if no operator is given.
+func defaultOperator() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// isProduction returns true if the database name matches any of productionPatterns in the config.
+func (c *Config) isProduction(database string) bool {
+	if c == nil {
+		return false
+	}
+	for _, p := range c.ProductionPatterns {
+		// Patterns have been validated by LoadConfig.
+		if re, err := regexp.Compile(p); err == nil && re.MatchString(database) {
+			return true
+		}
+	}
+	return false
+}
+
+// promptReason asks the operator for a change ticket or a reason until a non-empty one is entered.
+// The input is read by readLine, so that the answers to the following prompts are left in it.
+func promptReason(out io.Writer, in io.Reader) (string, bool) {
+	fmt.Fprint(out, "Enter a change ticket or the reason for this run: ")
+	for {
+		line, err := readLine(in)
+		if reason := strings.TrimSpace(line); reason != "" {
+			return reason, true
+		}
+		if err != nil {
+			return "", false
+		}
+		fmt.Fprint(out, "A reason is required: ")
+	}
+}
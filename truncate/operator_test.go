@@ -0,0 +1,94 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestConfigIsProduction(t *testing.T) {
+	config := &Config{ProductionPatterns: []string{"/instances/prod-", "databases/live$"}}
+	for _, tt := range []struct {
+		desc     string
+		config   *Config
+		database string
+		want     bool
+	}{
+		{
+			desc:     "Matching instance",
+			config:   config,
+			database: "projects/p/instances/prod-1/databases/d",
+			want:     true,
+		},
+		{
+			desc:     "Matching database",
+			config:   config,
+			database: "projects/p/instances/i/databases/live",
+			want:     true,
+		},
+		{
+			desc:     "Not matching",
+			config:   config,
+			database: "projects/p/instances/dev-1/databases/live-copy",
+			want:     false,
+		},
+		{
+			desc:     "No config",
+			database: "projects/p/instances/prod-1/databases/d",
+			want:     false,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := tt.config.isProduction(tt.database); got != tt.want {
+				t.Errorf("isProduction(%q) = %t, but want = %t", tt.database, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptReason(t *testing.T) {
+	var out bytes.Buffer
+	reason, ok := promptReason(&out, strings.NewReader("\n  \nOPS-123 purge test data\n"))
+	if !ok || reason != "OPS-123 purge test data" {
+		t.Errorf("promptReason() = (%q, %t), but want = (%q, true)", reason, ok, "OPS-123 purge test data")
+	}
+	if got, want := strings.Count(out.String(), "A reason is required: "), 2; got != want {
+		t.Errorf("promptReason() asked again %d times, but want = %d", got, want)
+	}
+
+	if _, ok := promptReason(&out, strings.NewReader("")); ok {
+		t.Errorf("promptReason() = ok without input, but want = not ok")
+	}
+}
+
+func TestPromptsShareInput(t *testing.T) {
+	// Answers piped to stdin are read by the prompts one after another.
+	in := strings.NewReader("OPS-123\nY\n")
+	if reason, ok := promptReason(ioutil.Discard, in); !ok || reason != "OPS-123" {
+		t.Errorf("promptReason() = (%q, %t), but want = (%q, true)", reason, ok, "OPS-123")
+	}
+	if !confirm(ioutil.Discard, in, "Do you want to continue?") {
+		t.Errorf("confirm() = false after promptReason(), but want = true")
+	}
+
+	if !confirm(ioutil.Discard, strings.NewReader("maybe\nY"), "Do you want to continue?") {
+		t.Errorf("confirm() = false for the last line without a newline, but want = true")
+	}
+}
@@ -0,0 +1,835 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/option"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+// Priority is the RPC priority of requests issued by a run.
+type Priority string
+
+const (
+	PriorityUnspecified Priority = ""       // Default priority of Cloud Spanner.
+	PriorityLow         Priority = "low"    // Low priority.
+	PriorityMedium      Priority = "medium" // Medium priority.
+	PriorityHigh        Priority = "high"   // High priority.
+)
+
+func (p Priority) proto() sppb.RequestOptions_Priority {
+	switch p {
+	case PriorityLow:
+		return sppb.RequestOptions_PRIORITY_LOW
+	case PriorityMedium:
+		return sppb.RequestOptions_PRIORITY_MEDIUM
+	case PriorityHigh:
+		return sppb.RequestOptions_PRIORITY_HIGH
+	default:
+		return sppb.RequestOptions_PRIORITY_UNSPECIFIED
+	}
+}
+
+// Options are options of a run.
+type Options struct {
+	// Quiet disables all interactive prompts.
+	Quiet bool
+	// Yes skips the confirmation before deleting rows. Unlike Quiet, other prompts are still shown.
+	Yes bool
+	// Pick lets the user pick the tables to be deleted from the selected tables interactively.
+	Pick bool
+	// Output is the destinations of output.
+	Output Output
+	// TargetTables are the tables to be deleted. If empty, all tables are deleted.
+	TargetTables []string
+	// ExcludeTables are the tables excluded from deletion. It cannot be used with TargetTables.
+	ExcludeTables []string
+	// OnlyTags selects only tables tagged with any of them in the config.
+	OnlyTags []string
+	// SkipTags excludes tables tagged with any of them in the config.
+	SkipTags []string
+	// IncludeSchemas selects only tables in any of the named schemas.
+	IncludeSchemas []string
+	// ExcludeSchemas excludes tables in any of the named schemas.
+	ExcludeSchemas []string
+	// ExcludeRegexps are regular expressions of table names always excluded from deletion, even if they are selected otherwise.
+	ExcludeRegexps []string
+	// NormalizeNames matches table names in Unicode NFC, so that names in different normalization forms match.
+	NormalizeNames bool
+	// Config is the content of a configuration file. It may be nil.
+	Config *Config
+	// OlderThan deletes only rows whose TimestampColumn is older than the age if positive.
+	OlderThan time.Duration
+	// ProtectRecent keeps rows whose TimestampColumn is within the window if positive.
+	ProtectRecent time.Duration
+	// KeepLatest keeps the newest rows of each table ordered by TimestampColumn if positive, and deletes the older rows.
+	KeepLatest int64
+	// SamplePercent deletes only the percentage of rows of each table chosen by the hash of their keys if positive.
+	SamplePercent float64
+	// TimestampColumn is the column compared by OlderThan and ProtectRecent and ordering rows of KeepLatest, unless the config has a column of the table.
+	// Tables without the column are excluded.
+	TimestampColumn string
+	// Params are the values of parameters in the filters of tables in the config, e.g. cutoff for @cutoff.
+	Params map[string]string
+	// Timeouts are deadlines of each phase.
+	Timeouts Timeouts
+	// StallTimeout is how long the run may make no progress before StallAction is taken. Zero disables the watchdog.
+	StallTimeout time.Duration
+	// StallAction is what to do when no progress is made within StallTimeout. Default to StallActionWarn.
+	StallAction StallAction
+	// Priority is the RPC priority of all read and DML requests of the run, overridden by priorities of tables in the config.
+	Priority Priority
+	// RequestTag is attached to all requests and transactions of the run, to attribute them in query statistics if set.
+	RequestTag string
+	// DryRun shows the tables to be deleted without deleting any rows.
+	DryRun bool
+	// Concurrency is the maximum number of tables deleted concurrently. Zero means unlimited.
+	Concurrency int
+	// Order is the policy to order tables which can be deleted at the same time. Default to OrderDependencies.
+	Order Order
+	// MutationsPerSecond is the budget of mutations per second shared by all tables. Zero means unlimited.
+	// If set, rows are deleted in chunks by primary keys instead of Partitioned DML so that deletion can be throttled.
+	MutationsPerSecond int
+	// RowsPerSecond is the budget of deleted rows per second shared by all tables. Zero means unlimited.
+	// Like MutationsPerSecond, rows are deleted in chunks if set.
+	RowsPerSecond int
+	// Strategy is how rows are deleted. Default to StrategyBatch if MutationsPerSecond or RowsPerSecond is set,
+	// or StrategyPDML otherwise.
+	// It is overridden by the strategy of each table in the config.
+	Strategy Strategy
+	// Replan re-fetches the schema and re-plans the remaining tables when a deletion fails due to a schema change,
+	// e.g. a new interleaved table or a foreign key added during the run.
+	Replan bool
+	// Window is the daily time window in which deletions are started. Nil means any time.
+	Window *Window
+	// Controller lets an operator pause, resume and abort the run. It may be nil.
+	Controller *Controller
+	// Verbose logs each statement once per table. Values of parameters are redacted unless ShowParams.
+	Verbose    bool
+	ShowParams bool
+	// LeakCheck waits for all goroutines spawned by a run to exit when it returns, and fails the run if any of them
+	// did not exit. It is for debugging applications embedding the library in long-lived processes.
+	LeakCheck bool
+	// OutputFormat is the format of the output. With OutputJSON, only the computed plan is written to Output.Writer
+	// without deleting anything, and other messages are written to stderr.
+	OutputFormat OutputFormat
+	// ReportFormat is the format of the report written to Output.Report after a run.
+	ReportFormat ReportFormat
+	// BigQueryResultsTable is the BigQuery table ("project.dataset.table" or "dataset.table") into which per-table results are streamed.
+	BigQueryResultsTable string
+	// PubSubTopic is the Pub/Sub topic ("projects/PROJECT/topics/TOPIC" or "TOPIC") to which lifecycle events are published.
+	PubSubTopic string
+	// ApprovedPlan is the plan which must match the actual plan and have a valid approval before deleting.
+	ApprovedPlan *Plan
+	// ApprovalKey is the key to verify approvals of ApprovedPlan.
+	ApprovalKey []byte
+	// Credentials are client options authenticating all clients created for the options, including admin and Pub/Sub clients,
+	// e.g. CredentialsFile or ImpersonateServiceAccount. Application Default Credentials are used if empty.
+	Credentials []option.ClientOption
+	// ClientOptions are passed to the client created by Run, e.g. WithUnaryInterceptors.
+	ClientOptions []option.ClientOption
+	// PGAdapter is the host:port of PGAdapter. If set, statements are executed through the PostgreSQL
+	// wire protocol instead of the native client, and ClientOptions are ignored.
+	PGAdapter string
+	// EmulatorHost is the host:port of the Cloud Spanner Emulator, which Run connects to with plaintext, credential-less
+	// connections instead of Cloud Spanner. SPANNER_EMULATOR_HOST is used if empty.
+	EmulatorHost string
+	// Backend executes statements instead of a backend created by Run. PGAdapter and ClientOptions are ignored if set.
+	Backend Backend
+	// Chaos injects simulated failures and delays into statements if set, for testing.
+	Chaos *Chaos
+	// Failpoints make deletions fail at specific points, for testing.
+	Failpoints []Failpoint
+	// Largest keeps only the given number of the largest tables by size of the selected tables if positive.
+	Largest int
+	// MinRows skips the selected tables with fewer rows if positive.
+	MinRows int64
+	// FailIfEmpty fails the run with ErrNothingToDelete if all selected tables are empty, instead of succeeding without doing anything.
+	FailIfEmpty bool
+	// Fingerprint takes fingerprints of the tables excluded from the run before and after the run,
+	// to prove that they are untouched. The results are included in the report.
+	Fingerprint bool
+	// Verify is how to verify that rows have been deleted. Default to VerifyFull.
+	Verify VerifyMode
+	// VerifySampleSize is the number of key ranges probed in each table by VerifySample.
+	VerifySampleSize int
+	// BoundedReads verifies deletions with bounded-staleness reads no older than the last commit to each table,
+	// so that reads directed to read-only replicas still observe the deletions.
+	BoundedReads bool
+	// LockTable is the table holding the lock of the database, to prevent concurrent runs. No lock is acquired if empty.
+	LockTable string
+	// LockTTL is how long the lock is valid unless refreshed, so that a lock left behind by a crashed run expires.
+	LockTTL time.Duration
+	// StealLock takes over the lock even if another run holds it.
+	StealLock bool
+	// HistoryTable is the table into which runs are recorded with who ran them, when, the tables, rows and status,
+	// to be listed by History. Runs are not recorded if empty.
+	HistoryTable string
+	// Operator is who runs the truncation, recorded with the run. Default to the user running the process.
+	Operator string
+	// Reason is the change ticket or the reason of the run, recorded with the run.
+	// It is required for databases matching productionPatterns in the config, and asked interactively unless Quiet.
+	Reason string
+	// ThroughputGraph graphs rows/s and mutations/s of each table over time in the progress bars.
+	ThroughputGraph bool
+	// RecordFile is the path to write a fixture of the interactions with the database to, if set.
+	RecordFile string
+	// ReplayFile is the path of a fixture replayed instead of connecting to the database, if set.
+	ReplayFile string
+	// PlanCache is the path of a file caching the schema of each database queried from INFORMATION_SCHEMA,
+	// which is reused while the hash of the DDL of the database is unchanged, if set.
+	PlanCache string
+	// ProtectedLabels are labels of protected instances in addition to protectedLabels in the config.
+	ProtectedLabels map[string]string
+	// AllowProtected allows runs against instances carrying any of the protected labels.
+	AllowProtected bool
+	// AllowProtectedTables allows runs deleting rows of tables matching protectedTables in the config.
+	AllowProtectedTables bool
+	// Namespace selects only tables prefixed with the namespace followed by an underscore, e.g. pr123_Users of pr123,
+	// for logical environments sharing a database.
+	Namespace string
+	// StripNamespace removes the prefix of the namespace from the names of tables in reports.
+	StripNamespace bool
+	// DropNamespace drops all tables of the namespace and their indexes after they are emptied.
+	DropNamespace bool
+	// StateFile is the path of a file recording the tables completed by the run of each database, if set.
+	// The state of a database is removed when its run finishes successfully.
+	StateFile string
+	// Resume skips the tables completed by the unfinished run recorded in StateFile.
+	Resume bool
+}
+
+// Option configures Options.
+type Option func(*Options)
+
+// WithOptions sets the options from a struct, for callers which build Options as a value.
+// Only non-zero fields are set, so that options given before it are kept unless the struct sets them,
+// and options given after it override its fields. Pass it first to make its fields the base of the others.
+func WithOptions(opts Options) Option {
+	return func(o *Options) {
+		dst, src := reflect.ValueOf(o).Elem(), reflect.ValueOf(opts)
+		for i := 0; i < src.NumField(); i++ {
+			if f := src.Field(i); !f.IsZero() {
+				dst.Field(i).Set(f)
+			}
+		}
+	}
+}
+
+// WithQuiet disables all interactive prompts.
+func WithQuiet(quiet bool) Option {
+	return func(o *Options) { o.Quiet = quiet }
+}
+
+// WithYes skips the confirmation before deleting rows.
+func WithYes(yes bool) Option {
+	return func(o *Options) { o.Yes = yes }
+}
+
+// WithPick lets the user pick the tables to be deleted from the selected tables interactively.
+func WithPick(pick bool) Option {
+	return func(o *Options) { o.Pick = pick }
+}
+
+// WithOutput sets the destinations of output.
+func WithOutput(out Output) Option {
+	return func(o *Options) { o.Output = out }
+}
+
+// WithTargetTables sets the tables to be deleted.
+func WithTargetTables(tables []string) Option {
+	return func(o *Options) { o.TargetTables = tables }
+}
+
+// WithExcludeTables sets the tables excluded from deletion.
+func WithExcludeTables(tables []string) Option {
+	return func(o *Options) { o.ExcludeTables = tables }
+}
+
+// WithOnlyTags selects only tables tagged with any of the tags in the config.
+func WithOnlyTags(tags []string) Option {
+	return func(o *Options) { o.OnlyTags = tags }
+}
+
+// WithSkipTags excludes tables tagged with any of the tags in the config.
+func WithSkipTags(tags []string) Option {
+	return func(o *Options) { o.SkipTags = tags }
+}
+
+// WithIncludeSchemas selects only tables in any of the named schemas.
+func WithIncludeSchemas(schemas []string) Option {
+	return func(o *Options) { o.IncludeSchemas = schemas }
+}
+
+// WithExcludeRegexps always excludes tables whose names match any of the regular expressions.
+func WithExcludeRegexps(patterns []string) Option {
+	return func(o *Options) { o.ExcludeRegexps = patterns }
+}
+
+// WithOlderThan deletes only rows whose timestamp column is older than the age from the selected tables.
+// Tables without the column are excluded.
+func WithOlderThan(age time.Duration, timestampColumn string) Option {
+	return func(o *Options) {
+		o.OlderThan = age
+		o.TimestampColumn = timestampColumn
+	}
+}
+
+// WithProtectRecent keeps rows whose timestamp column is within the window, e.g. in-flight test data.
+// Tables without the column are excluded.
+func WithProtectRecent(window time.Duration) Option {
+	return func(o *Options) { o.ProtectRecent = window }
+}
+
+// WithKeepLatest keeps the newest n rows of each table ordered by the timestamp column, and deletes the older rows.
+// Tables without the column are excluded.
+func WithKeepLatest(n int64) Option {
+	return func(o *Options) { o.KeepLatest = n }
+}
+
+// WithSamplePercent deletes only the percentage of rows of each table, e.g. to thin out datasets for load testing.
+// The same rows are chosen on every run, and a parent row is chosen together with its interleaved child rows.
+func WithSamplePercent(percent float64) Option {
+	return func(o *Options) { o.SamplePercent = percent }
+}
+
+// WithTimestampColumn sets the column compared by WithOlderThan and WithProtectRecent, and ordering rows of WithKeepLatest.
+func WithTimestampColumn(column string) Option {
+	return func(o *Options) { o.TimestampColumn = column }
+}
+
+// WithParams sets the values of parameters in the filters of tables in the config.
+func WithParams(params map[string]string) Option {
+	return func(o *Options) { o.Params = params }
+}
+
+// WithExcludeSchemas excludes tables in any of the named schemas.
+func WithExcludeSchemas(schemas []string) Option {
+	return func(o *Options) { o.ExcludeSchemas = schemas }
+}
+
+// WithNormalizeNames enables matching table names in Unicode NFC.
+func WithNormalizeNames(normalize bool) Option {
+	return func(o *Options) { o.NormalizeNames = normalize }
+}
+
+// WithConfig sets the content of a configuration file.
+func WithConfig(config *Config) Option {
+	return func(o *Options) { o.Config = config }
+}
+
+// WithTimeouts sets deadlines of each phase.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(o *Options) { o.Timeouts = timeouts }
+}
+
+// WithStallTimeout takes the action when no chunk or table is completed within the timeout,
+// e.g. to detect stalls by lock contention long before the execution timeout.
+func WithStallTimeout(timeout time.Duration, action StallAction) Option {
+	return func(o *Options) {
+		o.StallTimeout = timeout
+		o.StallAction = action
+	}
+}
+
+// WithPriority sets the RPC priority of all read and DML requests, e.g. low to avoid impacting production traffic.
+func WithPriority(priority Priority) Option {
+	return func(o *Options) { o.Priority = priority }
+}
+
+// WithRequestTag attaches the tag to all requests and transactions, e.g. to find them in SPANNER_SYS.QUERY_STATS_*.
+func WithRequestTag(tag string) Option {
+	return func(o *Options) { o.RequestTag = tag }
+}
+
+// queryOptions returns the options of queries with the priority and the request tag of the run.
+func (o *Options) queryOptions() spanner.QueryOptions {
+	return spanner.QueryOptions{Priority: o.Priority.proto(), RequestTag: o.RequestTag}
+}
+
+// Maximum length of request and transaction tags allowed by Cloud Spanner.
+const maxRequestTagLength = 50
+
+// WithDryRun enables dry-run, which shows the tables to be deleted without deleting any rows.
+func WithDryRun(dryRun bool) Option {
+	return func(o *Options) { o.DryRun = dryRun }
+}
+
+// WithConcurrency sets the maximum number of tables deleted concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(o *Options) { o.Concurrency = concurrency }
+}
+
+// WithOrder sets the policy to order tables which can be deleted at the same time.
+func WithOrder(order Order) Option {
+	return func(o *Options) { o.Order = order }
+}
+
+// WithMutationsPerSecond sets the budget of mutations per second shared by all tables.
+func WithMutationsPerSecond(n int) Option {
+	return func(o *Options) { o.MutationsPerSecond = n }
+}
+
+// WithRowsPerSecond sets the budget of deleted rows per second shared by all tables.
+func WithRowsPerSecond(n int) Option {
+	return func(o *Options) { o.RowsPerSecond = n }
+}
+
+// WithStrategy sets how rows are deleted.
+func WithStrategy(strategy Strategy) Option {
+	return func(o *Options) { o.Strategy = strategy }
+}
+
+// strategy returns how rows are deleted unless overridden by the config.
+func (o *Options) strategy() Strategy {
+	if o.Strategy != "" {
+		return o.Strategy
+	}
+	if o.MutationsPerSecond > 0 || o.RowsPerSecond > 0 {
+		return StrategyBatch
+	}
+	return StrategyPDML
+}
+
+// concurrency returns the maximum number of tables deleted concurrently, or zero if unlimited.
+// The emulator aborts concurrent read-write transactions, so tables are deleted one by one unless the concurrency is set.
+func (o *Options) concurrency() int {
+	if o.Concurrency == 0 && o.emulatorHost() != "" {
+		return 1
+	}
+	return o.Concurrency
+}
+
+// WithReplan enables re-planning of the remaining tables when the schema changes during the run.
+func WithReplan(replan bool) Option {
+	return func(o *Options) { o.Replan = replan }
+}
+
+// WithWindow sets the daily time window in which deletions are started.
+func WithWindow(window *Window) Option {
+	return func(o *Options) { o.Window = window }
+}
+
+// WithController sets the controller to pause, resume and abort the run.
+func WithController(controller *Controller) Option {
+	return func(o *Options) { o.Controller = controller }
+}
+
+// WithVerbose enables logging each statement once per table.
+func WithVerbose(verbose bool) Option {
+	return func(o *Options) { o.Verbose = verbose }
+}
+
+// WithShowParams shows values of parameters of statements logged in verbose mode instead of redacting them.
+func WithShowParams(show bool) Option {
+	return func(o *Options) { o.ShowParams = show }
+}
+
+// WithLeakCheck fails a run if any goroutine spawned by the run did not exit when it returns.
+func WithLeakCheck(check bool) Option {
+	return func(o *Options) { o.LeakCheck = check }
+}
+
+// WithOutputFormat sets the format of the output. OutputJSON writes the computed plan without deleting anything.
+func WithOutputFormat(format OutputFormat) Option {
+	return func(o *Options) { o.OutputFormat = format }
+}
+
+// WithReportFormat sets the format of the report written after a run.
+func WithReportFormat(format ReportFormat) Option {
+	return func(o *Options) { o.ReportFormat = format }
+}
+
+// WithBigQueryResultsTable sets the BigQuery table into which per-table results are streamed.
+func WithBigQueryResultsTable(table string) Option {
+	return func(o *Options) { o.BigQueryResultsTable = table }
+}
+
+// WithPubSubTopic sets the Pub/Sub topic to which lifecycle events are published.
+func WithPubSubTopic(topic string) Option {
+	return func(o *Options) { o.PubSubTopic = topic }
+}
+
+// WithRequiredApproval requires the actual plan to match the given plan, which must be approved with the key.
+func WithRequiredApproval(plan *Plan, key []byte) Option {
+	return func(o *Options) {
+		o.ApprovedPlan = plan
+		o.ApprovalKey = key
+	}
+}
+
+// WithClientOptions sets options passed to the client created by Run.
+func WithClientOptions(opts ...option.ClientOption) Option {
+	return func(o *Options) { o.ClientOptions = append(o.ClientOptions, opts...) }
+}
+
+// WithCredentials authenticates all clients with the given options, e.g. CredentialsFile and option.WithQuotaProject.
+func WithCredentials(opts ...option.ClientOption) Option {
+	return func(o *Options) { o.Credentials = append(o.Credentials, opts...) }
+}
+
+// WithPGAdapter connects to the database through PGAdapter listening on the address (host:port).
+func WithPGAdapter(addr string) Option {
+	return func(o *Options) { o.PGAdapter = addr }
+}
+
+// WithEmulator connects to the Cloud Spanner Emulator at the address (host:port) instead of Cloud Spanner,
+// e.g. DefaultEmulatorHost, in local development and integration tests.
+func WithEmulator(addr string) Option {
+	return func(o *Options) { o.EmulatorHost = addr }
+}
+
+// WithBackend executes statements with the given backend, e.g. a mock in tests.
+// The backend is not closed by Run, so that the caller can reuse it.
+func WithBackend(b Backend) Option {
+	return func(o *Options) { o.Backend = b }
+}
+
+// WithChaos injects simulated failures and delays into statements, for testing.
+func WithChaos(chaos Chaos) Option {
+	return func(o *Options) { o.Chaos = &chaos }
+}
+
+// WithFailpoints makes deletions fail at the failpoints, for testing.
+func WithFailpoints(fps []Failpoint) Option {
+	return func(o *Options) { o.Failpoints = append(o.Failpoints, fps...) }
+}
+
+// WithRecord records the interactions with the database into a fixture written to the path, for offline debugging.
+func WithRecord(path string) Option {
+	return func(o *Options) { o.RecordFile = path }
+}
+
+// WithReplay replays the fixture at the path instead of connecting to the database.
+func WithReplay(path string) Option {
+	return func(o *Options) { o.ReplayFile = path }
+}
+
+// WithPlanCache caches the schema of the database in the file at the path, and reuses it instead of querying INFORMATION_SCHEMA
+// while the DDL of the database is unchanged, e.g. for repeated resets of a test database in CI.
+func WithPlanCache(path string) Option {
+	return func(o *Options) { o.PlanCache = path }
+}
+
+// WithProtectedLabels refuses runs against instances carrying any of the labels, e.g. env=prod, unless WithAllowProtected.
+func WithProtectedLabels(labels map[string]string) Option {
+	return func(o *Options) { o.ProtectedLabels = labels }
+}
+
+// WithAllowProtected allows runs against instances carrying any of the protected labels.
+func WithAllowProtected(allow bool) Option {
+	return func(o *Options) { o.AllowProtected = allow }
+}
+
+// WithAllowProtectedTables allows runs deleting rows of tables matching protectedTables in the config.
+func WithAllowProtectedTables(allow bool) Option {
+	return func(o *Options) { o.AllowProtectedTables = allow }
+}
+
+// WithNamespace deletes only tables prefixed with the namespace followed by an underscore, e.g. pr123_Users of pr123.
+// If strip is true, the prefix is removed from the names of tables in reports, so that reports of environments are comparable.
+func WithNamespace(namespace string, strip bool) Option {
+	return func(o *Options) {
+		o.Namespace = namespace
+		o.StripNamespace = strip
+	}
+}
+
+// WithDropNamespace drops all tables of the namespace and their indexes by DDL after they are emptied,
+// e.g. to tear down a preview environment. Every table of the namespace must be emptied by the run.
+func WithDropNamespace(drop bool) Option {
+	return func(o *Options) { o.DropNamespace = drop }
+}
+
+// WithStateFile records the tables completed by the run in the file at the path, so that an interrupted run,
+// e.g. by a network blip or an eviction of the pod, can be resumed. If resume is true, the tables completed by
+// the unfinished run recorded in the file are skipped.
+func WithStateFile(path string, resume bool) Option {
+	return func(o *Options) {
+		o.StateFile = path
+		o.Resume = resume
+	}
+}
+
+// WithLargest deletes only the n largest tables by size of the selected tables.
+func WithLargest(n int) Option {
+	return func(o *Options) { o.Largest = n }
+}
+
+// WithFailIfEmpty fails the run with ErrNothingToDelete if all selected tables are empty,
+// e.g. when emptiness means that the run targets a wrong database.
+func WithFailIfEmpty(fail bool) Option {
+	return func(o *Options) { o.FailIfEmpty = fail }
+}
+
+// WithMinRows skips the selected tables with fewer rows than minRows, reported as below threshold.
+func WithMinRows(minRows int64) Option {
+	return func(o *Options) { o.MinRows = minRows }
+}
+
+// WithFingerprint enables fingerprints of the tables excluded from the run, to prove that they are untouched.
+func WithFingerprint(fingerprint bool) Option {
+	return func(o *Options) { o.Fingerprint = fingerprint }
+}
+
+// WithVerify sets how to verify that rows have been deleted, and the number of key ranges probed in each table by VerifySample.
+func WithVerify(mode VerifyMode, sampleSize int) Option {
+	return func(o *Options) {
+		o.Verify = mode
+		o.VerifySampleSize = sampleSize
+	}
+}
+
+// WithBoundedReads verifies deletions with bounded-staleness reads no older than the last commit to each table.
+func WithBoundedReads(bounded bool) Option {
+	return func(o *Options) { o.BoundedReads = bounded }
+}
+
+// WithLock acquires the lock of the database in the table during the run, to prevent concurrent runs.
+// The lock expires after ttl unless refreshed. If steal is true, the lock is taken over even if another run holds it.
+func WithLock(table string, ttl time.Duration, steal bool) Option {
+	return func(o *Options) {
+		o.LockTable = table
+		o.LockTTL = ttl
+		o.StealLock = steal
+	}
+}
+
+// WithHistoryTable records runs into the table, to be listed by History.
+func WithHistoryTable(table string) Option {
+	return func(o *Options) { o.HistoryTable = table }
+}
+
+// WithOperator sets who runs the truncation, recorded with the run.
+func WithOperator(operator string) Option {
+	return func(o *Options) { o.Operator = operator }
+}
+
+// WithReason sets the change ticket or the reason of the run, recorded with the run.
+func WithReason(reason string) Option {
+	return func(o *Options) { o.Reason = reason }
+}
+
+// WithThroughputGraph graphs rows/s and mutations/s of each table over time in the progress bars.
+func WithThroughputGraph(enabled bool) Option {
+	return func(o *Options) { o.ThroughputGraph = enabled }
+}
+
+// NewOptions returns Options configured by the given functional options.
+func NewOptions(opts ...Option) *Options {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ValidationError is returned by Options.Validate and holds all problems of the options.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid options: " + strings.Join(e.Problems, "; ")
+}
+
+// messageOutput returns the output of messages. They are written to stderr with OutputJSON,
+// so that only the plan is written to Output.Writer and it can be parsed.
+func (o Options) messageOutput() Output {
+	out := o.Output
+	if o.OutputFormat == OutputJSON {
+		out.Writer = os.Stderr
+	}
+	return out
+}
+
+// Validate returns ValidationError describing all problems of the options, or nil if valid.
+func (o *Options) Validate() error {
+	var problems []string
+	if len(o.TargetTables) > 0 && len(o.ExcludeTables) > 0 {
+		problems = append(problems, "target tables and exclude tables cannot be both set")
+	}
+	problems = append(problems, credentialsProblems(o.Credentials)...)
+	if o.Pick && o.Quiet {
+		problems = append(problems, "pick cannot be used with quiet, which disables all interactive prompts")
+	}
+	for _, p := range o.ExcludeRegexps {
+		if _, err := regexp.Compile(p); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid exclude regex %q: %v", p, err))
+		}
+	}
+	switch o.Priority {
+	case PriorityUnspecified, PriorityLow, PriorityMedium, PriorityHigh:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown priority %q, must be one of low, medium or high", o.Priority))
+	}
+	if !isValidRequestTag(o.RequestTag) {
+		problems = append(problems, fmt.Sprintf("invalid request tag %q, must be at most %d printable ASCII characters", o.RequestTag, maxRequestTagLength))
+	}
+	switch o.Order {
+	case "", OrderDependencies, OrderSize, OrderAlpha:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown order %q, must be one of size, alpha or dependencies-only", o.Order))
+	}
+	switch o.OutputFormat {
+	case OutputText, OutputJSON:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown output format %q, must be one of text or json", o.OutputFormat))
+	}
+	switch o.ReportFormat {
+	case ReportFormatNone, ReportFormatJUnit, ReportFormatCSV:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown report format %q", o.ReportFormat))
+	}
+	if o.BigQueryResultsTable != "" {
+		if _, _, _, err := parseBigQueryTable(o.BigQueryResultsTable, "-"); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if o.PubSubTopic != "" {
+		if _, err := topicName(o.PubSubTopic, "-"); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if o.Concurrency < 0 {
+		problems = append(problems, fmt.Sprintf("concurrency must not be negative: %d", o.Concurrency))
+	}
+	if o.MutationsPerSecond < 0 {
+		problems = append(problems, fmt.Sprintf("mutations per second must not be negative: %d", o.MutationsPerSecond))
+	}
+	if o.RowsPerSecond < 0 {
+		problems = append(problems, fmt.Sprintf("rows per second must not be negative: %d", o.RowsPerSecond))
+	}
+	switch o.Strategy {
+	case "", StrategyBatch:
+	case StrategyPDML:
+		if o.MutationsPerSecond > 0 {
+			problems = append(problems, "mutations per second cannot be used with the pdml strategy, which cannot be throttled")
+		}
+		if o.RowsPerSecond > 0 {
+			problems = append(problems, "rows per second cannot be used with the pdml strategy, which cannot be throttled")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown strategy %q, must be one of pdml or batch", o.Strategy))
+	}
+	if o.ApprovedPlan != nil && len(o.ApprovalKey) == 0 {
+		problems = append(problems, "approval key is required to verify the approved plan")
+	}
+	if o.Largest < 0 {
+		problems = append(problems, fmt.Sprintf("number of largest tables must not be negative: %d", o.Largest))
+	}
+	if o.MinRows < 0 {
+		problems = append(problems, fmt.Sprintf("minimum rows must not be negative: %d", o.MinRows))
+	}
+	byAge := o.OlderThan > 0 || o.ProtectRecent > 0 || o.KeepLatest > 0
+	if o.OlderThan < 0 {
+		problems = append(problems, fmt.Sprintf("older than must not be negative: %v", o.OlderThan))
+	}
+	if o.ProtectRecent < 0 {
+		problems = append(problems, fmt.Sprintf("protect recent must not be negative: %v", o.ProtectRecent))
+	}
+	if o.KeepLatest < 0 {
+		problems = append(problems, fmt.Sprintf("keep latest must not be negative: %d", o.KeepLatest))
+	}
+	if byAge && o.TimestampColumn == "" && len(o.Config.tableTimestampColumns()) == 0 {
+		problems = append(problems, "timestamp column must be specified with older than, protect recent or keep latest")
+	}
+	if !byAge && o.TimestampColumn != "" {
+		problems = append(problems, "timestamp column can be used only with older than, protect recent or keep latest")
+	}
+	if o.SamplePercent != 0 && (o.SamplePercent < 0.01 || o.SamplePercent > 100) {
+		problems = append(problems, fmt.Sprintf("sample percent must be between 0.01 and 100: %v", o.SamplePercent))
+	}
+	switch o.Verify {
+	case "", VerifyFull:
+	case VerifySample:
+		if o.VerifySampleSize <= 0 {
+			problems = append(problems, fmt.Sprintf("verify sample size must be positive: %d", o.VerifySampleSize))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown verify mode %q, must be one of full or sample", o.Verify))
+	}
+	if o.LockTable != "" && o.LockTTL <= 0 {
+		problems = append(problems, fmt.Sprintf("lock TTL must be positive: %s", o.LockTTL))
+	}
+	if o.RecordFile != "" && o.ReplayFile != "" {
+		problems = append(problems, "record and replay cannot be both set")
+	}
+	if o.Namespace != "" && !namespacePattern.MatchString(o.Namespace) {
+		problems = append(problems, fmt.Sprintf("invalid namespace %q, must consist of letters, digits and underscores", o.Namespace))
+	}
+	if o.StripNamespace && o.Namespace == "" {
+		problems = append(problems, "strip namespace requires a namespace")
+	}
+	if o.DropNamespace && o.Namespace == "" {
+		problems = append(problems, "drop namespace requires a namespace")
+	}
+	if o.DropNamespace && (o.Backend != nil || o.ReplayFile != "") {
+		problems = append(problems, "drop namespace requires Cloud Spanner, which cannot be used with a custom backend or replay")
+	}
+	if o.Resume && o.StateFile == "" {
+		problems = append(problems, "resume requires a state file")
+	}
+	if o.Resume && o.DropNamespace {
+		problems = append(problems, "resume cannot be used with drop namespace, which requires every table of the namespace to be selected")
+	}
+	if o.RecordFile != "" && o.PlanCache != "" {
+		problems = append(problems, "record and plan cache cannot be both set, since the fixture needs the schema fetched from the database")
+	}
+	if o.Chaos != nil {
+		if err := o.Chaos.validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if o.Timeouts.Planning < 0 || o.Timeouts.Execution < 0 || o.Timeouts.Verification < 0 {
+		problems = append(problems, "timeouts must not be negative")
+	}
+	if o.StallTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("stall timeout must not be negative: %s", o.StallTimeout))
+	}
+	switch o.StallAction {
+	case "", StallActionWarn, StallActionDump, StallActionAbort:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown stall action %q, must be one of warn, dump or abort", o.StallAction))
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// isValidRequestTag returns true if Cloud Spanner accepts the tag.
+func isValidRequestTag(tag string) bool {
+	if len(tag) > maxRequestTagLength {
+		return false
+	}
+	for _, r := range tag {
+		if r < ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
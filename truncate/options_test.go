@@ -0,0 +1,213 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		opts []Option
+		want []string
+	}{
+		{
+			desc: "Valid",
+			opts: []Option{WithTargetTables([]string{"A"}), WithPriority(PriorityLow), WithConcurrency(4), WithDryRun(true)},
+		},
+		{
+			desc: "Impersonation with quota project",
+			opts: []Option{WithCredentials(impersonatedCredentials{option.WithTokenSource(nil), "truncate@p.iam.gserviceaccount.com"}, option.WithQuotaProject("billing"))},
+		},
+		{
+			desc: "Impersonation with credentials file",
+			opts: []Option{WithCredentials(fileCredentials{option.WithCredentialsJSON([]byte(`{}`))}, impersonatedCredentials{option.WithTokenSource(nil), "truncate@p.iam.gserviceaccount.com"})},
+			want: []string{"credentials file cannot be used with impersonation; pass it to ImpersonateServiceAccount to impersonate with it"},
+		},
+		{
+			desc: "Impersonation of service accounts",
+			opts: []Option{
+				WithCredentials(impersonatedCredentials{option.WithTokenSource(nil), "a@p.iam.gserviceaccount.com"}),
+				WithCredentials(impersonatedCredentials{option.WithTokenSource(nil), "b@p.iam.gserviceaccount.com"}),
+			},
+			want: []string{"only one service account can be impersonated, but a@p.iam.gserviceaccount.com, b@p.iam.gserviceaccount.com"},
+		},
+		{
+			desc: "PDML strategy cannot be throttled",
+			opts: []Option{WithStrategy(StrategyPDML), WithMutationsPerSecond(1000)},
+			want: []string{"mutations per second cannot be used with the pdml strategy, which cannot be throttled"},
+		},
+		{
+			desc: "PDML strategy cannot be limited by rows",
+			opts: []Option{WithStrategy(StrategyPDML), WithRowsPerSecond(100)},
+			want: []string{"rows per second cannot be used with the pdml strategy, which cannot be throttled"},
+		},
+		{
+			desc: "Request tag with non-printable characters",
+			opts: []Option{WithRequestTag("truncate\nrun")},
+			want: []string{`invalid request tag "truncate\nrun", must be at most 50 printable ASCII characters`},
+		},
+		{
+			desc: "Sample percent out of range",
+			opts: []Option{WithSamplePercent(150)},
+			want: []string{"sample percent must be between 0.01 and 100: 150"},
+		},
+		{
+			desc: "Invalid exclude regex",
+			opts: []Option{WithExcludeRegexps([]string{"^Config.*", "^Lookup("})},
+			want: []string{"invalid exclude regex \"^Lookup(\": error parsing regexp: missing closing ): `^Lookup(`"},
+		},
+		{
+			desc: "Invalid namespace",
+			opts: []Option{WithNamespace("pr-123", true)},
+			want: []string{`invalid namespace "pr-123", must consist of letters, digits and underscores`},
+		},
+		{
+			desc: "Invalid stall timeout and action",
+			opts: []Option{WithStallTimeout(-time.Minute, "kill")},
+			want: []string{
+				"stall timeout must not be negative: -1m0s",
+				`unknown stall action "kill", must be one of warn, dump or abort`,
+			},
+		},
+		{
+			desc: "All problems of the age filters",
+			opts: []Option{WithOlderThan(-time.Hour, ""), WithProtectRecent(-time.Minute), WithKeepLatest(-1)},
+			want: []string{
+				"older than must not be negative: -1h0m0s",
+				"protect recent must not be negative: -1m0s",
+				"keep latest must not be negative: -1",
+			},
+		},
+		{
+			desc: "Negative age and missing timestamp column",
+			opts: []Option{WithOlderThan(-time.Hour, ""), WithKeepLatest(3)},
+			want: []string{
+				"older than must not be negative: -1h0m0s",
+				"timestamp column must be specified with older than, protect recent or keep latest",
+			},
+		},
+		{
+			desc: "Resume without a state file",
+			opts: []Option{WithStateFile("", true)},
+			want: []string{"resume requires a state file"},
+		},
+		{
+			desc: "All problems are reported",
+			opts: []Option{
+				WithTargetTables([]string{"A"}),
+				WithExcludeTables([]string{"B"}),
+				WithQuiet(true),
+				WithPick(true),
+				WithPriority("urgent"),
+				WithOutputFormat("yaml"),
+				WithConcurrency(-1),
+				WithStrategy("truncate"),
+				WithTimeouts(Timeouts{Execution: -time.Second}),
+				WithMinRows(-1),
+				WithOlderThan(time.Hour, ""),
+				WithVerify(VerifySample, 0),
+				WithLock("SpannerTruncateLock", 0, false),
+				WithChaos(Chaos{FailureRate: 2}),
+			},
+			want: []string{
+				"target tables and exclude tables cannot be both set",
+				"pick cannot be used with quiet, which disables all interactive prompts",
+				`unknown priority "urgent", must be one of low, medium or high`,
+				`unknown output format "yaml", must be one of text or json`,
+				"concurrency must not be negative: -1",
+				`unknown strategy "truncate", must be one of pdml or batch`,
+				"minimum rows must not be negative: -1",
+				"timestamp column must be specified with older than, protect recent or keep latest",
+				"verify sample size must be positive: 0",
+				"lock TTL must be positive: 0s",
+				"chaos failure rate must be between 0 and 1, but 2",
+				"timeouts must not be negative",
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := NewOptions(tt.opts...).Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() = %v, but want nil", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Validate() = %v, but want *ValidationError", err)
+			}
+			if diff := cmp.Diff(tt.want, verr.Problems); diff != "" {
+				t.Errorf("Validate() problems mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithOptions(t *testing.T) {
+	for _, tt := range []struct {
+		desc            string
+		opts            []Option
+		wantPriority    Priority
+		wantConcurrency int
+		wantStrategy    Strategy
+		wantTables      []string
+	}{
+		{
+			desc:            "Options before it are kept unless set by the struct",
+			opts:            []Option{WithPriority(PriorityHigh), WithConcurrency(8), WithOptions(Options{TargetTables: []string{"Singers"}, Concurrency: 4})},
+			wantPriority:    PriorityHigh,
+			wantConcurrency: 4,
+			wantTables:      []string{"Singers"},
+		},
+		{
+			desc:            "Options after it override the struct",
+			opts:            []Option{WithOptions(Options{TargetTables: []string{"Singers"}, Strategy: StrategyBatch, Concurrency: 4}), WithConcurrency(2)},
+			wantConcurrency: 2,
+			wantStrategy:    StrategyBatch,
+			wantTables:      []string{"Singers"},
+		},
+		{
+			desc:         "Zero fields do not reset options before it",
+			opts:         []Option{WithStrategy(StrategyPDML), WithOptions(Options{Priority: PriorityLow})},
+			wantPriority: PriorityLow,
+			wantStrategy: StrategyPDML,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			o := NewOptions(tt.opts...)
+			if o.Priority != tt.wantPriority {
+				t.Errorf("NewOptions() priority = %q, but want = %q", o.Priority, tt.wantPriority)
+			}
+			if o.Concurrency != tt.wantConcurrency {
+				t.Errorf("NewOptions() concurrency = %d, but want = %d", o.Concurrency, tt.wantConcurrency)
+			}
+			if o.Strategy != tt.wantStrategy {
+				t.Errorf("NewOptions() strategy = %q, but want = %q", o.Strategy, tt.wantStrategy)
+			}
+			if diff := cmp.Diff(tt.wantTables, o.TargetTables); diff != "" {
+				t.Errorf("NewOptions() target tables mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
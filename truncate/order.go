@@ -0,0 +1,62 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "sort"
+
+// Order is the policy to order tables which can be deleted at the same time.
+// It matters when the number of tables deleted concurrently is limited.
+type Order string
+
+const (
+	OrderDependencies Order = "dependencies-only" // Only dependencies between tables are respected.
+	OrderSize         Order = "size"              // Largest interleave trees first, to maximize overlap.
+	OrderAlpha        Order = "alpha"             // Alphabetical, for reproducible logs.
+)
+
+// treeRows returns the total rows of the table and its descendants.
+func treeRows(t *table) uint64 {
+	rows := t.deleter.total()
+	for _, child := range t.childTables {
+		rows += treeRows(child)
+	}
+	return rows
+}
+
+// sortTables sorts tables by the order. Tables are kept as is for OrderDependencies.
+func sortTables(tables []*table, order Order) {
+	switch order {
+	case OrderSize:
+		sort.SliceStable(tables, func(i, j int) bool {
+			return treeRows(tables[i]) > treeRows(tables[j])
+		})
+	case OrderAlpha:
+		sort.SliceStable(tables, func(i, j int) bool {
+			return tables[i].tableName < tables[j].tableName
+		})
+	}
+}
+
+// isAnyTableAnalyzing returns true if the row count of any table is not known yet.
+func isAnyTableAnalyzing(tables []*table) bool {
+	for _, t := range flattenTables(tables) {
+		if t.deleter.currentStatus() == statusAnalyzing {
+			return true
+		}
+	}
+	return false
+}
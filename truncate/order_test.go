@@ -0,0 +1,57 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSortTables(t *testing.T) {
+	newTables := func() []*table {
+		return []*table{
+			{tableName: "B", deleter: &deleter{totalRows: 10}},
+			{tableName: "C", deleter: &deleter{totalRows: 5}, childTables: []*table{
+				{tableName: "D", deleter: &deleter{totalRows: 100}},
+			}},
+			{tableName: "A", deleter: &deleter{totalRows: 50}},
+		}
+	}
+
+	for _, tt := range []struct {
+		desc  string
+		order Order
+		want  []string
+	}{
+		{desc: "Dependencies only", order: OrderDependencies, want: []string{"B", "C", "A"}},
+		{desc: "Size", order: OrderSize, want: []string{"C", "A", "B"}},
+		{desc: "Alphabetical", order: OrderAlpha, want: []string{"A", "B", "C"}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			tables := newTables()
+			sortTables(tables, tt.order)
+			var got []string
+			for _, table := range tables {
+				got = append(got, table.tableName)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("sortTables() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+)
+
+// ReportOrphans reports rows whose referenced rows are missing.
+// It checks foreign keys which are not enforced by the database and the given application-level references.
+// Unenforced foreign keys are found only with the native Cloud Spanner client, e.g. not through PGAdapter.
+func ReportOrphans(ctx context.Context, projectID, instanceID, databaseID string, out io.Writer, references []Reference, opts ...Option) error {
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	o := NewOptions(opts...)
+
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if client, ok := spannerClient(b); ok {
+		fmt.Fprintf(out, "Fetching foreign keys from %s\n", database)
+		fks, err := fetchUnenforcedForeignKeys(ctx, client)
+		if err != nil {
+			return fmt.Errorf("failed to fetch foreign keys: %v", err)
+		}
+		references = append(fks, references...)
+	} else {
+		o.messageOutput().warnf("Unenforced foreign keys are not found without the native Cloud Spanner client, so that only references in the config are checked.")
+	}
+	if len(references) == 0 {
+		fmt.Fprintf(out, "No unenforced foreign keys or references found.\n")
+		return nil
+	}
+
+	var orphaned int
+	for _, ref := range references {
+		count, err := b.Count(ctx, spanner.NewStatement(b.Dialect().orphanCountSQL(ref)), 0, PriorityUnspecified)
+		if err != nil {
+			return fmt.Errorf("failed to count orphan rows in %s: %v", ref.Table, err)
+		}
+		if count > 0 {
+			orphaned++
+		}
+		fmt.Fprintf(out, "%s(%s) -> %s(%s): %s orphan rows\n", ref.Table, strings.Join(ref.Columns, ", "),
+			ref.ReferencedTable, strings.Join(ref.ReferencedColumns, ", "), formatNumber(uint64(count)))
+	}
+
+	fmt.Fprintf(out, "\n%d of %d references have orphan rows.\n", orphaned, len(references))
+	return nil
+}
+
+// orphanCountSQL returns a query counting rows which have non-null referencing columns without any referenced row.
+func (d Dialect) orphanCountSQL(ref Reference) string {
+	var notNull, match []string
+	for i, col := range ref.Columns {
+		notNull = append(notNull, fmt.Sprintf("C.%s IS NOT NULL", d.quote(col)))
+		match = append(match, fmt.Sprintf("P.%s = C.%s", d.quote(ref.ReferencedColumns[i]), d.quote(col)))
+	}
+	return fmt.Sprintf("SELECT COUNT(*) AS count FROM %s AS C WHERE %s AND NOT EXISTS (SELECT 1 FROM %s AS P WHERE %s)",
+		d.quote(ref.Table), strings.Join(notNull, " AND "), d.quote(ref.ReferencedTable), strings.Join(match, " AND "))
+}
+
+func fetchUnenforcedForeignKeys(ctx context.Context, client *spanner.Client) ([]Reference, error) {
+	// This query fetches the columns of foreign keys which are declared as NOT ENFORCED.
+	iter := client.Single().Query(ctx, spanner.NewStatement(`
+		SELECT RC.CONSTRAINT_NAME, CK.TABLE_NAME, CK.COLUMN_NAME, PK.TABLE_NAME, PK.COLUMN_NAME
+		FROM INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS AS RC
+		INNER JOIN INFORMATION_SCHEMA.TABLE_CONSTRAINTS AS TC ON TC.CONSTRAINT_NAME = RC.CONSTRAINT_NAME
+		INNER JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE AS CK ON CK.CONSTRAINT_NAME = RC.CONSTRAINT_NAME
+		INNER JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE AS PK ON PK.CONSTRAINT_NAME = RC.UNIQUE_CONSTRAINT_NAME AND PK.ORDINAL_POSITION = CK.POSITION_IN_UNIQUE_CONSTRAINT
+		WHERE RC.CONSTRAINT_CATALOG = '' AND RC.CONSTRAINT_SCHEMA = '' AND TC.ENFORCED = 'NO'
+		ORDER BY RC.CONSTRAINT_NAME, CK.ORDINAL_POSITION
+	`))
+
+	var refs []Reference
+	var lastConstraint string
+	if err := iter.Do(func(r *spanner.Row) error {
+		var constraint, table, column, referencedTable, referencedColumn string
+		if err := r.Columns(&constraint, &table, &column, &referencedTable, &referencedColumn); err != nil {
+			return err
+		}
+
+		if constraint != lastConstraint {
+			refs = append(refs, Reference{Table: table, ReferencedTable: referencedTable})
+			lastConstraint = constraint
+		}
+		ref := &refs[len(refs)-1]
+		ref.Columns = append(ref.Columns, column)
+		ref.ReferencedColumns = append(ref.ReferencedColumns, referencedColumn)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
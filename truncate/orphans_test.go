@@ -0,0 +1,91 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOrphanCountSQL(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		dialect Dialect
+		ref     Reference
+		want    string
+	}{
+		{
+			desc: "Single column",
+			ref:  Reference{Table: "Orders", Columns: []string{"CustomerId"}, ReferencedTable: "Customers", ReferencedColumns: []string{"Id"}},
+			want: "SELECT COUNT(*) AS count FROM `Orders` AS C WHERE C.`CustomerId` IS NOT NULL AND NOT EXISTS (SELECT 1 FROM `Customers` AS P WHERE P.`Id` = C.`CustomerId`)",
+		},
+		{
+			desc: "Multiple columns",
+			ref:  Reference{Table: "B", Columns: []string{"X", "Y"}, ReferencedTable: "A", ReferencedColumns: []string{"AX", "AY"}},
+			want: "SELECT COUNT(*) AS count FROM `B` AS C WHERE C.`X` IS NOT NULL AND C.`Y` IS NOT NULL AND NOT EXISTS (SELECT 1 FROM `A` AS P WHERE P.`AX` = C.`X` AND P.`AY` = C.`Y`)",
+		},
+		{
+			desc:    "PostgreSQL",
+			dialect: DialectPostgreSQL,
+			ref:     Reference{Table: "orders", Columns: []string{"customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"id"}},
+			want:    `SELECT COUNT(*) AS count FROM "orders" AS C WHERE C."customer_id" IS NOT NULL AND NOT EXISTS (SELECT 1 FROM "customers" AS P WHERE P."id" = C."customer_id")`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := tt.dialect.orphanCountSQL(tt.ref); got != tt.want {
+				t.Errorf("orphanCountSQL() = %s, but want = %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// orphansBackend returns the number of orphan rows of each query.
+type orphansBackend struct {
+	fakeBackend
+	orphans map[string]int64
+}
+
+func (b *orphansBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	return b.orphans[stmt.SQL], nil
+}
+
+func TestReportOrphans(t *testing.T) {
+	refs := []Reference{
+		{Table: "Orders", Columns: []string{"CustomerId"}, ReferencedTable: "Customers", ReferencedColumns: []string{"Id"}},
+		{Table: "Payments", Columns: []string{"OrderId"}, ReferencedTable: "Orders", ReferencedColumns: []string{"Id"}},
+	}
+	b := &orphansBackend{orphans: map[string]int64{DialectGoogleSQL.orphanCountSQL(refs[0]): 1200}}
+	var out, warnings bytes.Buffer
+	if err := ReportOrphans(context.Background(), "p", "i", "d", &out, refs, WithBackend(b), WithOutput(Output{Writer: &warnings})); err != nil {
+		t.Fatalf("ReportOrphans() failed: %v", err)
+	}
+	want := "Orders(CustomerId) -> Customers(Id): 1,200 orphan rows\n" +
+		"Payments(OrderId) -> Orders(Id): 0 orphan rows\n" +
+		"\n1 of 2 references have orphan rows.\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("ReportOrphans() output mismatch (-want +got):\n%s", diff)
+	}
+	// Foreign keys are not found through the given backend, so that only the references are checked.
+	if warnings.Len() == 0 {
+		t.Errorf("ReportOrphans() did not warn that unenforced foreign keys are not checked")
+	}
+}
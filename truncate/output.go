@@ -0,0 +1,94 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Logger receives log messages such as warnings. *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Output is the destinations of output of a run, so that embedding applications can capture or redirect it.
+type Output struct {
+	// Writer receives messages and reports. If nil, they are discarded.
+	Writer io.Writer
+	// Progress receives progress bars. If nil, Writer is used.
+	Progress io.Writer
+	// Report receives the report written after a run. If nil, Writer is used.
+	Report io.Writer
+	// Input is read for interactive prompts. If nil, os.Stdin is used.
+	Input io.Reader
+	// Logger receives warnings. If nil, warnings are written to Writer.
+	Logger Logger
+}
+
+// StdOutput returns Output which writes to stdout and reads from stdin.
+func StdOutput() Output {
+	return Output{Writer: os.Stdout, Input: os.Stdin}
+}
+
+func (o Output) writer() io.Writer {
+	if o.Writer == nil {
+		return ioutil.Discard
+	}
+	return o.Writer
+}
+
+func (o Output) progress() io.Writer {
+	if o.Progress == nil {
+		return o.writer()
+	}
+	return o.Progress
+}
+
+func (o Output) report() io.Writer {
+	if o.Report == nil {
+		return o.writer()
+	}
+	return o.Report
+}
+
+func (o Output) input() io.Reader {
+	if o.Input == nil {
+		return os.Stdin
+	}
+	return o.Input
+}
+
+// logf writes a verbose message.
+func (o Output) logf(format string, v ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, v...)
+		return
+	}
+	fmt.Fprintf(o.writer(), format+"\n", v...)
+}
+
+// warnf writes a warning message.
+func (o Output) warnf(format string, v ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, v...)
+		return
+	}
+	fmt.Fprintf(o.writer(), "WARNING: "+format+"\n", v...)
+}
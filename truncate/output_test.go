@@ -0,0 +1,41 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestOutputWarnf(t *testing.T) {
+	var buf bytes.Buffer
+	Output{Writer: &buf}.warnf("%d rows remain in %s", 3, "A")
+	if got, want := buf.String(), "WARNING: 3 rows remain in A\n"; got != want {
+		t.Errorf("warnf() without logger wrote %q, but want = %q", got, want)
+	}
+
+	buf.Reset()
+	var logged bytes.Buffer
+	Output{Writer: &buf, Logger: log.New(&logged, "", 0)}.warnf("%d rows remain in %s", 3, "A")
+	if got := buf.String(); got != "" {
+		t.Errorf("warnf() with logger wrote %q to writer, but want nothing", got)
+	}
+	if got, want := logged.String(), "3 rows remain in A\n"; got != want {
+		t.Errorf("warnf() with logger logged %q, but want = %q", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// panicError is a panic recovered in a run, converted into an error so that the run fails with a report
+// instead of crashing with a raw stack trace.
+type panicError struct {
+	// worker is the goroutine which panicked, e.g. "deletion of Singers".
+	worker string
+	value  interface{}
+	stack  []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.worker, e.value)
+}
+
+// writeFatalReport writes which tables and chunks were in progress when the run panicked, and how to resume the run.
+func writeFatalReport(w io.Writer, perr *panicError, tables []*table) {
+	fmt.Fprintf(w, "\nFATAL: %v\n", perr)
+
+	var inProgress, completed, remaining []string
+	for _, t := range tables {
+		d := t.deleter
+		switch d.currentStatus() {
+		case statusCompleted:
+			completed = append(completed, t.tableName)
+			continue
+		case statusDeleting, statusCascadeDeleting:
+			s := fmt.Sprintf("%s: %s / %s rows deleted", t.tableName, formatNumber(d.deletedRows()), formatNumber(d.total()))
+			if chunk := atomic.LoadInt64(&d.chunk); chunk > 0 {
+				s += fmt.Sprintf(", at chunk %d", chunk)
+			}
+			inProgress = append(inProgress, s)
+		}
+		remaining = append(remaining, t.tableName)
+	}
+	sort.Strings(completed)
+	sort.Strings(remaining)
+
+	if len(inProgress) > 0 {
+		fmt.Fprintf(w, "Tables in progress:\n")
+		for _, s := range inProgress {
+			fmt.Fprintf(w, "  %s\n", s)
+		}
+	}
+	if len(completed) > 0 {
+		fmt.Fprintf(w, "Completed tables: %s\n", strings.Join(completed, ", "))
+	}
+	if len(remaining) > 0 {
+		fmt.Fprintf(w, "To resume, run again with --tables %s. Rows already deleted are not deleted again.\n", strings.Join(remaining, ","))
+	}
+	fmt.Fprintf(w, "\nStack trace of the panic:\n%s\n", perr.stack)
+}
@@ -0,0 +1,64 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWriteFatalReport(t *testing.T) {
+	tables := []*table{
+		{tableName: "Venues", deleter: &deleter{status: statusCompleted, totalRows: 30}},
+		{tableName: "Singers", deleter: &deleter{status: statusDeleting, totalRows: 10000, remainedRows: 8800, chunk: 3}},
+		{tableName: "Albums", deleter: &deleter{status: statusWaiting, totalRows: 20}},
+	}
+	var buf bytes.Buffer
+	writeFatalReport(&buf, &panicError{worker: "deletion of Singers", value: "boom", stack: []byte("goroutine 1 [running]:")}, tables)
+
+	want := `
+FATAL: panic in deletion of Singers: boom
+Tables in progress:
+  Singers: 1,200 / 10,000 rows deleted, at chunk 3
+Completed tables: Venues
+To resume, run again with --tables Albums,Singers. Rows already deleted are not deleted again.
+
+Stack trace of the panic:
+goroutine 1 [running]:
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeFatalReport() = %q, but want = %q", got, want)
+	}
+}
+
+func TestRunRecoversPanic(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{{Name: "Singers"}},
+		rows:   map[string]int64{"Singers": 10},
+	}
+	var buf bytes.Buffer
+	err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithQuiet(true), WithOutput(Output{Writer: &buf}),
+		WithFailpoints([]Failpoint{{Point: failpointBeforeDelete, Table: "Singers", Panic: true}}))
+	if err == nil || !strings.Contains(err.Error(), "panic in deletion of Singers") {
+		t.Fatalf("Run() = %v, but want an error of the panic", err)
+	}
+	if want := "To resume, run again with --tables Singers."; !strings.Contains(buf.String(), want) {
+		t.Errorf("Run() output = %q, but want to contain %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,334 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	// Register the PostgreSQL driver used to connect to PGAdapter.
+	_ "github.com/lib/pq"
+)
+
+// sqlBackend is a backend connecting to PGAdapter through the PostgreSQL wire protocol.
+// Request priorities and stale reads are not available through PGAdapter, so they are ignored.
+type sqlBackend struct {
+	db       *sql.DB
+	database string
+}
+
+// NewPGAdapterBackend returns a backend connecting to the database through PGAdapter listening on addr (host:port).
+func NewPGAdapterBackend(addr, database string) (Backend, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	db, err := sql.Open("postgres", pgDSN(host, port, database))
+	if err != nil {
+		return nil, err
+	}
+	return &sqlBackend{db: db, database: database}, nil
+}
+
+// pgDSN returns the connection string of PGAdapter, whose values are quoted so that a database name cannot add other parameters.
+func pgDSN(host, port, database string) string {
+	quote := func(v string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+	}
+	return fmt.Sprintf("host=%s port=%s dbname=%s sslmode=disable", quote(host), quote(port), quote(database))
+}
+
+func (b *sqlBackend) DatabaseName() string {
+	return b.database
+}
+
+func (b *sqlBackend) Dialect() Dialect {
+	return DialectPostgreSQL
+}
+
+// Queries of the schema in PostgreSQL-dialect databases, which are shared with the native client.
+const (
+	pgTablesSQL = `
+		SELECT table_name, parent_table_name, on_delete_action FROM information_schema.tables
+		WHERE table_schema = 'public' AND table_type = 'BASE TABLE'
+		ORDER BY table_name
+	`
+	pgReferencesSQL = `
+		SELECT DISTINCT ccu.table_name, tc.table_name
+		FROM information_schema.table_constraints AS tc
+		INNER JOIN information_schema.constraint_column_usage AS ccu ON tc.constraint_name = ccu.constraint_name
+		WHERE tc.table_schema = 'public' AND tc.constraint_type = 'FOREIGN KEY' AND ccu.table_schema = 'public'
+		ORDER BY ccu.table_name, tc.table_name
+	`
+	pgIndexesSQL = `
+		SELECT index_name, table_name, parent_table_name FROM information_schema.indexes
+		WHERE index_type = 'INDEX' AND table_schema = 'public'
+	`
+	pgPrimaryKeysSQL = `
+		SELECT table_name, column_name, spanner_type FROM information_schema.index_columns
+		WHERE index_name = 'PRIMARY_KEY' AND table_schema = 'public'
+		ORDER BY table_name, ordinal_position
+	`
+)
+
+func (b *sqlBackend) Tables(ctx context.Context) ([]TableInfo, error) {
+	rows, err := b.db.QueryContext(ctx, pgTablesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableInfo
+	for rows.Next() {
+		var tableName string
+		var parent, deleteAction sql.NullString
+		if err := rows.Scan(&tableName, &parent, &deleteAction); err != nil {
+			return nil, err
+		}
+		tables = append(tables, TableInfo{
+			Name:           tableName,
+			ParentName:     parent.String,
+			OnDeleteAction: deleteAction.String,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	referencedBy, err := b.fetchReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tables {
+		tables[i].ReferencedBy = referencedBy[tables[i].Name]
+	}
+	return tables, nil
+}
+
+// fetchReferences returns the tables referencing each table by foreign keys.
+func (b *sqlBackend) fetchReferences(ctx context.Context) (map[string][]string, error) {
+	rows, err := b.db.QueryContext(ctx, pgReferencesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referencedBy := map[string][]string{}
+	for rows.Next() {
+		var referenced, referencing string
+		if err := rows.Scan(&referenced, &referencing); err != nil {
+			return nil, err
+		}
+		referencedBy[referenced] = append(referencedBy[referenced], referencing)
+	}
+	return referencedBy, rows.Err()
+}
+
+func (b *sqlBackend) Indexes(ctx context.Context) ([]IndexInfo, error) {
+	rows, err := b.db.QueryContext(ctx, pgIndexesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []IndexInfo
+	for rows.Next() {
+		var indexName, baseTableName string
+		var parent sql.NullString
+		if err := rows.Scan(&indexName, &baseTableName, &parent); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, IndexInfo{
+			Name:       indexName,
+			TableName:  baseTableName,
+			ParentName: parent.String,
+		})
+	}
+	return indexes, rows.Err()
+}
+
+func (b *sqlBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	rows, err := b.db.QueryContext(ctx, pgPrimaryKeysSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := map[string][]KeyColumn{}
+	for rows.Next() {
+		var tableName, columnName string
+		var spannerType sql.NullString
+		if err := rows.Scan(&tableName, &columnName, &spannerType); err != nil {
+			return nil, err
+		}
+		keys[tableName] = append(keys[tableName], KeyColumn{Name: columnName, SpannerType: googleSQLType(spannerType.String)})
+	}
+	return keys, rows.Err()
+}
+
+// PropertyGraphs returns no graphs since property graphs are only available in GoogleSQL databases.
+func (b *sqlBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	return nil, nil
+}
+
+func (b *sqlBackend) TableSizes(ctx context.Context) (map[string]int64, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT table_name, used_bytes FROM spanner_sys.table_sizes_stats_1hour
+		WHERE interval_end = (SELECT MAX(interval_end) FROM spanner_sys.table_sizes_stats_1hour)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := map[string]int64{}
+	for rows.Next() {
+		var tableName string
+		var usedBytes sql.NullFloat64
+		if err := rows.Scan(&tableName, &usedBytes); err != nil {
+			return nil, err
+		}
+		sizes[tableName] = int64(usedBytes.Float64)
+	}
+	return sizes, rows.Err()
+}
+
+// googleSQLType converts a PostgreSQL type name of a key column to the GoogleSQL one,
+// so that key columns are checked in the same way as with the native client.
+func googleSQLType(pgType string) string {
+	base := pgType
+	if i := strings.Index(base, "("); i >= 0 {
+		base = base[:i]
+	}
+	switch base {
+	case "bigint":
+		return "INT64"
+	case "double precision":
+		return "FLOAT64"
+	case "boolean":
+		return "BOOL"
+	case "character varying", "text":
+		return "STRING"
+	case "bytea":
+		return "BYTES"
+	case "timestamp with time zone":
+		return "TIMESTAMP"
+	case "date":
+		return "DATE"
+	case "numeric":
+		return "NUMERIC"
+	}
+	return pgType
+}
+
+// pgArgs returns the parameters of the statement named p1, p2... as the arguments for $1, $2...
+// Values bound without their types are passed as text, whose types are inferred by PGAdapter.
+func pgArgs(stmt spanner.Statement) []interface{} {
+	var args []interface{}
+	for n := 1; ; n++ {
+		v, ok := stmt.Params[fmt.Sprintf("p%d", n)]
+		if !ok {
+			return args
+		}
+		if g, ok := v.(spanner.GenericColumnValue); ok && g.Type == nil {
+			v = g.Value.GetStringValue()
+		}
+		args = append(args, v)
+	}
+}
+
+func (b *sqlBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, _ Priority) (err error) {
+	// The DML mode is a session setting of PGAdapter, so both statements must use the same connection,
+	// and the setting is reset before the connection is returned to the pool for other statements.
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET SPANNER.AUTOCOMMIT_DML_MODE = 'PARTITIONED_NON_ATOMIC'"); err != nil {
+		return fmt.Errorf("failed to enable Partitioned DML: %v", err)
+	}
+	defer func() {
+		// Use a fresh context since the context of the statement may have been canceled.
+		if _, rerr := conn.ExecContext(context.Background(), "RESET SPANNER.AUTOCOMMIT_DML_MODE"); rerr != nil {
+			// The connection still in Partitioned DML mode is discarded instead of being reused.
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			if err == nil {
+				err = fmt.Errorf("failed to reset DML mode: %v", rerr)
+			}
+		}
+	}()
+	_, err = conn.ExecContext(ctx, stmt.SQL, pgArgs(stmt)...)
+	return err
+}
+
+func (b *sqlBackend) Count(ctx context.Context, stmt spanner.Statement, _ time.Duration, _ Priority) (int64, error) {
+	var count int64
+	if err := b.db.QueryRowContext(ctx, stmt.SQL, pgArgs(stmt)...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (b *sqlBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, _ Priority) ([]Key, error) {
+	rows, err := b.db.QueryContext(ctx, stmt.SQL, pgArgs(stmt)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		k := make(Key, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range k {
+			dest[i] = &k[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, c := range columns {
+			// NUMERIC values are scanned as text, which must be passed back as a string rather than bytea.
+			if v, ok := k[i].([]byte); ok && c.SpannerType == "NUMERIC" {
+				k[i] = string(v)
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteKeys deletes the rows with a DML statement. Commit statistics are not available through PGAdapter.
+func (b *sqlBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, _ Priority) (int64, error) {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	query, params := b.Dialect().deleteKeysSQL(table, names, keys)
+	_, err := b.db.ExecContext(ctx, query, params...)
+	return 0, err
+}
+
+func (b *sqlBackend) Close() error {
+	return b.db.Close()
+}
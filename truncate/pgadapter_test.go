@@ -0,0 +1,68 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "testing"
+
+func TestGoogleSQLType(t *testing.T) {
+	for _, tt := range []struct {
+		pgType string
+		want   string
+	}{
+		{pgType: "bigint", want: "INT64"},
+		{pgType: "character varying(256)", want: "STRING"},
+		{pgType: "bytea", want: "BYTES"},
+		{pgType: "timestamp with time zone", want: "TIMESTAMP"},
+		{pgType: "jsonb", want: "jsonb"},
+	} {
+		t.Run(tt.pgType, func(t *testing.T) {
+			if got := googleSQLType(tt.pgType); got != tt.want {
+				t.Errorf("googleSQLType(%q) = %s, but want = %s", tt.pgType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPGDSN(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		database string
+		want     string
+	}{
+		{
+			desc:     "Database name",
+			database: "projects/p/instances/i/databases/d",
+			want:     `host='localhost' port='5432' dbname='projects/p/instances/i/databases/d' sslmode=disable`,
+		},
+		{
+			desc:     "Quotes in database name",
+			database: `d' sslmode='require`,
+			want:     `host='localhost' port='5432' dbname='d\' sslmode=\'require' sslmode=disable`,
+		},
+		{
+			desc:     "Backslash in database name",
+			database: `d\`,
+			want:     `host='localhost' port='5432' dbname='d\\' sslmode=disable`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := pgDSN("localhost", "5432", tt.database); got != tt.want {
+				t.Errorf("pgDSN() = %s, but want = %s", got, tt.want)
+			}
+		})
+	}
+}
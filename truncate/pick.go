@@ -0,0 +1,185 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// picker lets the user toggle the tables to be deleted.
+type picker struct {
+	tables   []*tableSchema
+	sizes    map[string]int64
+	selected map[string]bool
+	// query shows only the tables matching it by fuzzy search if not empty.
+	query string
+}
+
+// pickTables shows the tables with their sizes and returns the names of the tables selected by the user.
+// Numbers and ranges toggle the tables, "/text" filters the tables by fuzzy search, "a" and "n" select and clear
+// the shown tables, and an empty line finishes. It returns false if the user quits by "q" or the input ends.
+func pickTables(out io.Writer, in io.Reader, tables []*tableSchema, sizes map[string]int64) ([]string, bool) {
+	p := &picker{tables: tables, sizes: sizes, selected: map[string]bool{}}
+	for {
+		p.show(out)
+		fmt.Fprint(out, "Toggle by numbers or ranges (e.g. 1 3 5-7), /text to search, a to select shown, n to clear shown, enter to finish, q to quit: ")
+		line, err := readLine(in)
+		if err != nil && line == "" {
+			return nil, false
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			return p.result(), true
+		case line == "q":
+			return nil, false
+		case line == "a" || line == "n":
+			for _, i := range p.shown() {
+				p.selected[p.tables[i].tableName] = line == "a"
+			}
+		case strings.HasPrefix(line, "/"):
+			p.query = strings.TrimSpace(line[1:])
+		default:
+			if err := p.toggle(line); err != nil {
+				fmt.Fprintf(out, "%v\n", err)
+			}
+		}
+	}
+}
+
+// shown returns the indexes of the tables matching the query.
+func (p *picker) shown() []int {
+	var indexes []int
+	for i, t := range p.tables {
+		if fuzzyMatch(p.query, t.tableName) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func (p *picker) show(out io.Writer) {
+	shown := p.shown()
+	if p.query != "" {
+		fmt.Fprintf(out, "\nTables matching %q (%d of %d, %d selected):\n", p.query, len(shown), len(p.tables), len(p.result()))
+	} else {
+		fmt.Fprintf(out, "\nTables (%d, %d selected):\n", len(p.tables), len(p.result()))
+	}
+	for _, i := range shown {
+		t := p.tables[i]
+		mark := " "
+		if p.selected[t.tableName] {
+			mark = "x"
+		}
+		line := fmt.Sprintf("  [%s] %d. %s", mark, i+1, t.tableName)
+		if size, ok := p.sizes[t.tableName]; ok {
+			line += fmt.Sprintf(" (%s)", formatBytes(uint64(size)))
+		}
+		fmt.Fprintln(out, line)
+	}
+}
+
+// toggle toggles the tables of numbers and ranges separated by spaces or commas, e.g. "1 3 5-7".
+func (p *picker) toggle(line string) error {
+	var indexes []int
+	for _, f := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+		lo, hi := f, f
+		if i := strings.Index(f, "-"); i > 0 {
+			lo, hi = f[:i], f[i+1:]
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || from < 1 || to > len(p.tables) || from > to {
+			return fmt.Errorf("invalid selection %q, must be numbers or ranges from 1 to %d", f, len(p.tables))
+		}
+		for i := from; i <= to; i++ {
+			indexes = append(indexes, i-1)
+		}
+	}
+	for _, i := range indexes {
+		name := p.tables[i].tableName
+		p.selected[name] = !p.selected[name]
+	}
+	return nil
+}
+
+// result returns the names of the selected tables in the order of the tables.
+func (p *picker) result() []string {
+	var names []string
+	for _, t := range p.tables {
+		if p.selected[t.tableName] {
+			names = append(names, t.tableName)
+		}
+	}
+	return names
+}
+
+// fuzzyMatch returns true if the characters of the query appear in the name in order, ignoring case.
+func fuzzyMatch(query, name string) bool {
+	name = strings.ToLower(name)
+	for _, r := range strings.ToLower(query) {
+		i := strings.IndexRune(name, r)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(string(r)):]
+	}
+	return true
+}
+
+// readLine reads a line byte by byte, so that the input following the line is left for later prompts.
+func readLine(in io.Reader) (string, error) {
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := in.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(sb.String(), "\r"), nil
+			}
+			sb.WriteByte(b[0])
+		}
+		if err != nil {
+			return sb.String(), err
+		}
+	}
+}
+
+// selectPicked excludes the tables not picked by the user and updates their decisions.
+func selectPicked(tables []*tableSchema, decisions []*planDecision, picked []string) []*tableSchema {
+	keep := map[string]bool{}
+	for _, name := range picked {
+		keep[name] = true
+	}
+	for _, d := range decisions {
+		if d.included && !keep[d.tableName] {
+			d.included = false
+			d.reason = "not picked by --pick"
+		}
+	}
+
+	var kept []*tableSchema
+	for _, t := range tables {
+		if keep[t.tableName] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
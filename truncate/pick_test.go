@@ -0,0 +1,136 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		name  string
+		want  bool
+	}{
+		{query: "", name: "Singers", want: true},
+		{query: "sng", name: "Singers", want: true},
+		{query: "SNG", name: "Singers", want: true},
+		{query: "gns", name: "Singers", want: false},
+		{query: "ordi", name: "OrderItems", want: true},
+		{query: "orders", name: "Orders_2020", want: true},
+	} {
+		if got := fuzzyMatch(tt.query, tt.name); got != tt.want {
+			t.Errorf("fuzzyMatch(%q, %q) = %t, but want = %t", tt.query, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPickTables(t *testing.T) {
+	tables := []*tableSchema{{tableName: "Singers"}, {tableName: "Albums"}, {tableName: "Songs"}, {tableName: "Concerts"}}
+	sizes := map[string]int64{"Singers": 2048}
+	for _, tt := range []struct {
+		desc   string
+		input  string
+		want   []string
+		wantOK bool
+	}{
+		{desc: "Toggle numbers and ranges", input: "1 3-4\n4\n\n", want: []string{"Singers", "Songs"}, wantOK: true},
+		{desc: "Select shown tables", input: "/so\na\n/\n\n", want: []string{"Songs"}, wantOK: true},
+		{desc: "Clear shown tables", input: "1-4\n/ng\nn\n\n", want: []string{"Albums", "Concerts"}, wantOK: true},
+		{desc: "Invalid selection is ignored", input: "5\n2\n\n", want: []string{"Albums"}, wantOK: true},
+		{desc: "Nothing picked", input: "\n", wantOK: true},
+		{desc: "Quit", input: "1\nq\n"},
+		{desc: "End of input", input: "1\n"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			got, ok := pickTables(&out, strings.NewReader(tt.input), tables, sizes)
+			if ok != tt.wantOK {
+				t.Fatalf("pickTables() ok = %t, but want = %t", ok, tt.wantOK)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("pickTables() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPickerShow(t *testing.T) {
+	p := &picker{
+		tables:   []*tableSchema{{tableName: "Singers"}, {tableName: "Albums"}, {tableName: "Songs"}},
+		sizes:    map[string]int64{"Singers": 2048},
+		selected: map[string]bool{"Songs": true},
+		query:    "s",
+	}
+	var out bytes.Buffer
+	p.show(&out)
+	want := "\nTables matching \"s\" (3 of 3, 1 selected):\n  [ ] 1. Singers (2.0 KiB)\n  [ ] 2. Albums\n  [x] 3. Songs\n"
+	p.query = "song"
+	p.show(&out)
+	want += "\nTables matching \"song\" (1 of 3, 1 selected):\n  [x] 3. Songs\n"
+	if got := out.String(); got != want {
+		t.Errorf("show() = %q, but want = %q", got, want)
+	}
+}
+
+func TestSelectPicked(t *testing.T) {
+	all := []*tableSchema{{tableName: "A"}, {tableName: "B"}, {tableName: "C"}}
+	tables, decisions := selectTables(all, tableSelection{excludeTables: []string{"C"}})
+	tables = selectPicked(tables, decisions, []string{"B"})
+
+	var gotTables []string
+	for _, table := range tables {
+		gotTables = append(gotTables, table.tableName)
+	}
+	if diff := cmp.Diff([]string{"B"}, gotTables); diff != "" {
+		t.Errorf("selectPicked() tables mismatch (-want +got):\n%s", diff)
+	}
+	var gotDecisions []string
+	for _, d := range decisions {
+		gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+	}
+	wantDecisions := []string{
+		"A: false: not picked by --pick",
+		"B: true: not excluded by --exclude-tables",
+		"C: false: excluded by --exclude-tables",
+	}
+	if diff := cmp.Diff(wantDecisions, gotDecisions); diff != "" {
+		t.Errorf("selectPicked() decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunPick(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{{Name: "Singers"}, {Name: "Concerts"}},
+		rows:   map[string]int64{"Singers": 10, "Concerts": 20},
+	}
+	var buf bytes.Buffer
+	// Tables keep their numbers while searching, and the answer of the confirmation prompt follows the picked tables.
+	in := strings.NewReader("/conc\n2\n\nY\n")
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithPick(true), WithOutput(Output{Writer: &buf, Input: in})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if b.rows["Singers"] != 10 || b.rows["Concerts"] != 0 {
+		t.Errorf("Run() rows = %v, but want only Concerts deleted", b.rows)
+	}
+}
@@ -0,0 +1,148 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Plan is the set of tables a run deletes from. It can be reviewed and approved before the run.
+type Plan struct {
+	// Database is the name of the database in the form of projects/P/instances/I/databases/D.
+	Database string `json:"database"`
+	// Tables are the tables to be deleted.
+	Tables []string `json:"tables"`
+	// Hash identifies the content of the plan. It is computed from Database and Tables.
+	Hash string `json:"hash"`
+	// Annotations are free-form notes by reviewers. They are not covered by Hash.
+	Annotations []string `json:"annotations,omitempty"`
+	// Approvals are signed approvals of the plan.
+	Approvals []Approval `json:"approvals,omitempty"`
+}
+
+// Approval is an approval of a plan by an approver.
+type Approval struct {
+	Approver   string    `json:"approver"`
+	PlanHash   string    `json:"planHash"`
+	ApprovedAt time.Time `json:"approvedAt"`
+	// Signature is HMAC-SHA256 of the approver, the plan hash and the approval time.
+	Signature string `json:"signature"`
+}
+
+func newPlan(database string, schemas []*tableSchema) *Plan {
+	p := &Plan{Database: database, Tables: []string{}}
+	for _, s := range schemas {
+		p.Tables = append(p.Tables, s.tableName)
+	}
+	p.Hash = p.computeHash()
+	return p
+}
+
+// computeHash returns the hex encoded SHA-256 of the database and tables of the plan.
+func (p *Plan) computeHash() string {
+	b, _ := json.Marshal(struct {
+		Database string   `json:"database"`
+		Tables   []string `json:"tables"`
+	}{p.Database, p.Tables})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Approve adds a signed approval of the plan by the approver.
+func (p *Plan) Approve(approver string, key []byte) error {
+	if approver == "" {
+		return fmt.Errorf("approver must be specified")
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("approval key must be specified")
+	}
+	a := Approval{Approver: approver, PlanHash: p.computeHash(), ApprovedAt: time.Now().UTC().Truncate(time.Second)}
+	a.Signature = a.sign(key)
+	p.Approvals = append(p.Approvals, a)
+	return nil
+}
+
+func (a Approval) sign(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s", a.Approver, a.PlanHash, a.ApprovedAt.UTC().Format(time.RFC3339))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyApproval returns the approver of a valid approval of the plan.
+// It returns an error unless the plan has a valid approval and matches the actual plan.
+func (p *Plan) verifyApproval(actual *Plan, key []byte) (string, error) {
+	hash := p.computeHash()
+	if hash != actual.Hash {
+		return "", fmt.Errorf("approved plan does not match the actual plan: approved tables %v, actual tables %v", p.Tables, actual.Tables)
+	}
+	for _, a := range p.Approvals {
+		if a.PlanHash == hash && hmac.Equal([]byte(a.Signature), []byte(a.sign(key))) {
+			return a.Approver, nil
+		}
+	}
+	return "", fmt.Errorf("plan has no valid approval")
+}
+
+// Write writes the plan as JSON.
+func (p *Plan) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// LoadPlan reads the plan file at the given path.
+func LoadPlan(path string) (*Plan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %v", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file %s: %v", path, err)
+	}
+	return &plan, nil
+}
+
+// BuildPlan returns the plan of a run with the given options, without deleting anything.
+func BuildPlan(ctx context.Context, projectID, instanceID, databaseID string, opts ...Option) (*Plan, error) {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	schemas, _, err := fetchTableSchemas(ctx, b, o.tableSelection())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+	return newPlan(database, schemas), nil
+}
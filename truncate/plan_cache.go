@@ -0,0 +1,221 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+)
+
+// planCache is the content of the plan cache file, keeping the schema of each database queried from INFORMATION_SCHEMA
+// together with the hash of its DDL, so that repeated runs against an unchanged schema skip the queries.
+type planCache struct {
+	Databases map[string]*planCacheEntry `json:"databases"`
+}
+
+// planCacheEntry is the cached schema of a database. Fields are nil if they have not been fetched yet.
+type planCacheEntry struct {
+	SchemaHash     string                  `json:"schemaHash"`
+	Tables         *[]TableInfo            `json:"tables,omitempty"`
+	Indexes        *[]IndexInfo            `json:"indexes,omitempty"`
+	PrimaryKeys    *map[string][]KeyColumn `json:"primaryKeys,omitempty"`
+	PropertyGraphs *[]PropertyGraphInfo    `json:"propertyGraphs,omitempty"`
+}
+
+// loadPlanCache reads the plan cache file at the path. A missing file is an empty cache.
+func loadPlanCache(path string) (*planCache, error) {
+	c := &planCache{Databases: map[string]*planCacheEntry{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan cache file: %v", err)
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse plan cache file %s: %v", path, err)
+	}
+	if c.Databases == nil {
+		c.Databases = map[string]*planCacheEntry{}
+	}
+	return c, nil
+}
+
+// save writes the plan cache to the file at the path.
+func (c *planCache) save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// schemaHash returns the hash of the DDL statements of the database, which changes whenever the schema changes.
+func schemaHash(ctx context.Context, database string, o *Options) (string, error) {
+	client, err := adminapi.NewDatabaseAdminClient(ctx, o.spannerClientOptions()...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Cloud Spanner database admin client: %v", err)
+	}
+	defer client.Close()
+	statements, err := fetchDatabaseDDL(ctx, client, database)
+	if err != nil {
+		return "", err
+	}
+	return ddlHash(statements), nil
+}
+
+// ddlHash returns the hex-encoded SHA-256 of the DDL statements.
+func ddlHash(statements []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(statements, ";\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedSchemaBackend is a backend serving the schema from the plan cache while the hash of the DDL is unchanged.
+// The schema fetched from the underlying backend is written to the cache file by flush.
+type cachedSchemaBackend struct {
+	Backend
+	path string
+	// reused is true if the cached schema is used since the hash of the DDL is unchanged.
+	reused bool
+
+	mu      sync.Mutex
+	entry   *planCacheEntry
+	changed bool
+}
+
+func newCachedSchemaBackend(b Backend, path, hash string, cache *planCache) *cachedSchemaBackend {
+	entry, reused := cache.Databases[b.DatabaseName()], true
+	if entry == nil || entry.SchemaHash != hash {
+		entry, reused = &planCacheEntry{SchemaHash: hash}, false
+	}
+	return &cachedSchemaBackend{Backend: b, path: path, reused: reused, entry: entry}
+}
+
+func (b *cachedSchemaBackend) Tables(ctx context.Context) ([]TableInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entry.Tables != nil {
+		return *b.entry.Tables, nil
+	}
+	tables, err := b.Backend.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tables == nil {
+		tables = []TableInfo{}
+	}
+	b.entry.Tables, b.changed = &tables, true
+	return tables, nil
+}
+
+func (b *cachedSchemaBackend) Indexes(ctx context.Context) ([]IndexInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entry.Indexes != nil {
+		return *b.entry.Indexes, nil
+	}
+	indexes, err := b.Backend.Indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if indexes == nil {
+		indexes = []IndexInfo{}
+	}
+	b.entry.Indexes, b.changed = &indexes, true
+	return indexes, nil
+}
+
+func (b *cachedSchemaBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entry.PrimaryKeys != nil {
+		return *b.entry.PrimaryKeys, nil
+	}
+	keys, err := b.Backend.PrimaryKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = map[string][]KeyColumn{}
+	}
+	b.entry.PrimaryKeys, b.changed = &keys, true
+	return keys, nil
+}
+
+func (b *cachedSchemaBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entry.PropertyGraphs != nil {
+		return *b.entry.PropertyGraphs, nil
+	}
+	graphs, err := b.Backend.PropertyGraphs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if graphs == nil {
+		graphs = []PropertyGraphInfo{}
+	}
+	b.entry.PropertyGraphs, b.changed = &graphs, true
+	return graphs, nil
+}
+
+// flush writes the entry to the cache file if anything was fetched from the underlying backend.
+// The file is read again, so that entries of other databases written in the meantime are kept.
+func (b *cachedSchemaBackend) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.changed {
+		return nil
+	}
+	cache, err := loadPlanCache(b.path)
+	if err != nil {
+		// A broken cache file is replaced.
+		cache = &planCache{Databases: map[string]*planCacheEntry{}}
+	}
+	cache.Databases[b.DatabaseName()] = b.entry
+	if err := cache.save(b.path); err != nil {
+		return fmt.Errorf("failed to write plan cache file: %v", err)
+	}
+	b.changed = false
+	return nil
+}
+
+// openPlanCache wraps the backend of the database with the plan cache file of the options,
+// keyed by the hash of the DDL fetched with a database admin client.
+func openPlanCache(ctx context.Context, b Backend, database string, o *Options) (*cachedSchemaBackend, error) {
+	if o.Backend != nil || o.ReplayFile != "" {
+		return nil, fmt.Errorf("DDL is not available with a custom backend or a replayed fixture")
+	}
+	hash, err := schemaHash(ctx, database, o)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := loadPlanCache(o.PlanCache)
+	if err != nil {
+		return nil, err
+	}
+	return newCachedSchemaBackend(b, o.PlanCache, hash, cache), nil
+}
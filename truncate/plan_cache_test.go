@@ -0,0 +1,120 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// tableCountingBackend is a fake backend counting fetches of tables.
+type tableCountingBackend struct {
+	*fakeBackend
+	fetches int
+}
+
+func (b *tableCountingBackend) Tables(ctx context.Context) ([]TableInfo, error) {
+	b.fetches++
+	return b.fakeBackend.Tables(ctx)
+}
+
+func TestCachedSchemaBackend(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "plan-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plan-cache.json")
+	cached := []TableInfo{{Name: "Singers"}}
+
+	for _, tt := range []struct {
+		desc       string
+		hash       string
+		tables     []TableInfo
+		wantReused bool
+		want       []TableInfo
+	}{
+		{desc: "No cache", hash: "v1", tables: cached, want: cached},
+		{desc: "Unchanged DDL", hash: "v1", tables: []TableInfo{{Name: "Albums"}}, wantReused: true, want: cached},
+		{desc: "Changed DDL", hash: "v2", tables: []TableInfo{{Name: "Albums"}}, want: []TableInfo{{Name: "Albums"}}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			cache, err := loadPlanCache(path)
+			if err != nil {
+				t.Fatalf("loadPlanCache() failed: %v", err)
+			}
+			underlying := &tableCountingBackend{fakeBackend: &fakeBackend{tables: tt.tables}}
+			b := newCachedSchemaBackend(underlying, path, tt.hash, cache)
+			if b.reused != tt.wantReused {
+				t.Errorf("newCachedSchemaBackend() reused = %t, but want = %t", b.reused, tt.wantReused)
+			}
+			got, err := b.Tables(ctx)
+			if err != nil {
+				t.Fatalf("Tables() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Tables() mismatch (-want +got):\n%s", diff)
+			}
+			if wantFetches := map[bool]int{true: 0, false: 1}[tt.wantReused]; underlying.fetches != wantFetches {
+				t.Errorf("Tables() fetched %d times, but want = %d", underlying.fetches, wantFetches)
+			}
+			if err := b.flush(); err != nil {
+				t.Fatalf("flush() failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadPlanCacheBroken(t *testing.T) {
+	f, err := ioutil.TempFile("", "plan-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("{")
+	f.Close()
+	if _, err := loadPlanCache(f.Name()); err == nil {
+		t.Errorf("loadPlanCache() = nil, but want an error for a broken file")
+	}
+}
+
+func TestUncachedSchema(t *testing.T) {
+	underlying := &tableCountingBackend{fakeBackend: &fakeBackend{tables: []TableInfo{{Name: "Singers"}, {Name: "Concerts"}}}}
+	tables := []TableInfo{{Name: "Singers"}}
+	cache := &planCache{Databases: map[string]*planCacheEntry{
+		underlying.DatabaseName(): {SchemaHash: "h", Tables: &tables},
+	}}
+	b := newSharedSchemaBackend(newCachedSchemaBackend(underlying, "", "h", cache), &sharedSchema{}, "h")
+
+	// The table added during the run is seen only by re-fetching the schema.
+	got, err := uncachedSchema(b).Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(underlying.tables, got); diff != "" {
+		t.Errorf("uncachedSchema().Tables() mismatch (-want +got):\n%s", diff)
+	}
+	if underlying.fetches != 1 {
+		t.Errorf("uncachedSchema().Tables() fetched %d times, but want = 1", underlying.fetches)
+	}
+}
@@ -0,0 +1,84 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "testing"
+
+func TestPlanApproval(t *testing.T) {
+	database := "projects/p/instances/i/databases/d"
+	key := []byte("secret")
+	schemas := []*tableSchema{{tableName: "Singers"}, {tableName: "Albums"}}
+
+	approved := newPlan(database, schemas)
+	if err := approved.Approve("alice@example.com", key); err != nil {
+		t.Fatalf("Approve() failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		plan    func() *Plan
+		actual  *Plan
+		key     []byte
+		wantErr bool
+	}{
+		{
+			desc:   "Valid approval",
+			plan:   func() *Plan { return approved },
+			actual: newPlan(database, schemas),
+			key:    key,
+		},
+		{
+			desc:    "Different key",
+			plan:    func() *Plan { return approved },
+			actual:  newPlan(database, schemas),
+			key:     []byte("other"),
+			wantErr: true,
+		},
+		{
+			desc:    "Actual plan differs",
+			plan:    func() *Plan { return approved },
+			actual:  newPlan(database, schemas[:1]),
+			key:     key,
+			wantErr: true,
+		},
+		{
+			desc: "Tables tampered after approval",
+			plan: func() *Plan {
+				p := *approved
+				p.Tables = []string{"Singers"}
+				return &p
+			},
+			actual:  newPlan(database, schemas[:1]),
+			key:     key,
+			wantErr: true,
+		},
+		{
+			desc:    "No approval",
+			plan:    func() *Plan { return newPlan(database, schemas) },
+			actual:  newPlan(database, schemas),
+			key:     key,
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := tt.plan().verifyApproval(tt.actual, tt.key)
+			if got := err != nil; got != tt.wantErr {
+				t.Errorf("verifyApproval() = %v, but want error = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
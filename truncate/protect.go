@@ -0,0 +1,147 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	instanceapi "cloud.google.com/go/spanner/admin/instance/apiv1"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"google.golang.org/genproto/protobuf/field_mask"
+)
+
+// protectedLabels returns the labels of protected instances given by the options and the config.
+func (o *Options) protectedLabels() map[string]string {
+	labels := map[string]string{}
+	if o.Config != nil {
+		for k, v := range o.Config.ProtectedLabels {
+			labels[k] = v
+		}
+	}
+	for k, v := range o.ProtectedLabels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// matchingLabels returns the labels in the form of key=value which are also protected, sorted by key.
+func matchingLabels(labels, protected map[string]string) []string {
+	var matched []string
+	for k, v := range protected {
+		if value, ok := labels[k]; ok && value == v {
+			matched = append(matched, k+"="+v)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// checkProtectedInstance returns an error if the instance carries any of the protected labels, unless AllowProtected.
+// The guard fails closed: if the labels cannot be fetched, the run fails as well.
+// Dry runs, custom backends and replayed fixtures are not checked since they never delete rows of a real database.
+func checkProtectedInstance(ctx context.Context, projectID, instanceID string, o *Options) error {
+	protected := o.protectedLabels()
+	if len(protected) == 0 || o.DryRun || o.Backend != nil || o.ReplayFile != "" {
+		return nil
+	}
+	client, err := instanceapi.NewInstanceAdminClient(ctx, o.spannerClientOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Spanner instance admin client: %v", err)
+	}
+	defer client.Close()
+
+	name := fmt.Sprintf("projects/%s/instances/%s", projectID, instanceID)
+	instance, err := client.GetInstance(ctx, &instancepb.GetInstanceRequest{
+		Name:      name,
+		FieldMask: &field_mask.FieldMask{Paths: []string{"labels"}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get labels of %s to check protected labels: %v", name, err)
+	}
+	matched := matchingLabels(instance.GetLabels(), protected)
+	if len(matched) == 0 {
+		return nil
+	}
+	if o.AllowProtected {
+		o.messageOutput().warnf("%s is protected by label %s, but is truncated since protected instances are allowed.", name, strings.Join(matched, ", "))
+		return nil
+	}
+	return fmt.Errorf("%s is protected by label %s; pass --allow-protected to truncate it anyway", name, strings.Join(matched, ", "))
+}
+
+// instanceOf returns the project ID and the instance ID of the database name like "projects/p/instances/i/databases/d".
+func instanceOf(database string) (projectID, instanceID string, err error) {
+	parts := strings.Split(database, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "instances" || parts[4] != "databases" || parts[1] == "" || parts[3] == "" {
+		return "", "", fmt.Errorf("invalid database name %q: must be in the form of projects/P/instances/I/databases/D", database)
+	}
+	return parts[1], parts[3], nil
+}
+
+// touchedProtectedTables returns the tables matching any of the patterns whose rows would be deleted by deleting the selected tables,
+// with how they are touched: selected, or interleaved with ON DELETE CASCADE in a selected table.
+func touchedProtectedTables(all, selected []*tableSchema, patterns []string, key func(string) string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+	protected := func(name string) bool {
+		for _, p := range patterns {
+			if globMatch(key(p), key(name)) {
+				return true
+			}
+		}
+		return false
+	}
+	selectedNames := make(map[string]bool, len(selected))
+	for _, t := range selected {
+		selectedNames[t.tableName] = true
+	}
+	parents := make(map[string]*tableSchema, len(all))
+	for _, t := range all {
+		parents[t.tableName] = t
+	}
+	// cascadedFrom returns the selected ancestor whose deletion cascades to the table, or "".
+	cascadedFrom := func(t *tableSchema) string {
+		for t.parentOnDeleteAction == deleteActionCascadeDelete {
+			parent, ok := parents[t.parentTableName]
+			if !ok {
+				return ""
+			}
+			if selectedNames[parent.tableName] {
+				return parent.tableName
+			}
+			t = parent
+		}
+		return ""
+	}
+
+	var touched []string
+	for _, t := range all {
+		if !protected(t.tableName) {
+			continue
+		}
+		if selectedNames[t.tableName] {
+			touched = append(touched, fmt.Sprintf("%s (selected)", t.tableName))
+		} else if ancestor := cascadedFrom(t); ancestor != "" {
+			touched = append(touched, fmt.Sprintf("%s (deleted by cascade from %s)", t.tableName, ancestor))
+		}
+	}
+	return touched
+}
@@ -0,0 +1,229 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"google.golang.org/grpc"
+)
+
+func TestMatchingLabels(t *testing.T) {
+	protected := map[string]string{"env": "prod", "tier": "critical"}
+	for _, tt := range []struct {
+		desc   string
+		labels map[string]string
+		want   []string
+	}{
+		{desc: "No labels", labels: nil},
+		{desc: "Different value", labels: map[string]string{"env": "dev"}},
+		{desc: "Protected", labels: map[string]string{"env": "prod", "team": "music"}, want: []string{"env=prod"}},
+		{desc: "Protected by labels", labels: map[string]string{"tier": "critical", "env": "prod"}, want: []string{"env=prod", "tier=critical"}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, matchingLabels(tt.labels, protected)); diff != "" {
+				t.Errorf("matchingLabels() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOptionsProtectedLabels(t *testing.T) {
+	o := NewOptions(
+		WithConfig(&Config{ProtectedLabels: map[string]string{"env": "prod", "tier": "critical"}}),
+		WithProtectedLabels(map[string]string{"env": "production"}),
+	)
+	want := map[string]string{"env": "production", "tier": "critical"}
+	if diff := cmp.Diff(want, o.protectedLabels()); diff != "" {
+		t.Errorf("protectedLabels() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckProtectedInstanceSkipped(t *testing.T) {
+	// Runs which never delete rows of a real database are not checked, so no instance admin client is created.
+	labels := WithProtectedLabels(map[string]string{"env": "prod"})
+	for _, o := range []*Options{
+		NewOptions(),
+		NewOptions(labels, WithDryRun(true)),
+		NewOptions(labels, WithBackend(&fakeBackend{})),
+	} {
+		if err := checkProtectedInstance(context.Background(), "p", "i", o); err != nil {
+			t.Errorf("checkProtectedInstance() = %v, but want nil", err)
+		}
+	}
+}
+
+func TestTouchedProtectedTables(t *testing.T) {
+	all := []*tableSchema{
+		{tableName: "Singers"},
+		{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "AlbumFlags", parentTableName: "Albums", parentOnDeleteAction: deleteActionCascadeDelete},
+		{tableName: "SingerFlags", parentTableName: "Singers", parentOnDeleteAction: deleteActionNoAction},
+		{tableName: "FeatureFlags"},
+		{tableName: "SchemaMigrations"},
+	}
+	patterns := []string{"*Flags", "SchemaMigrations"}
+	key := func(s string) string { return s }
+	for _, tt := range []struct {
+		desc     string
+		selected []string
+		want     []string
+	}{
+		{desc: "Cascaded", selected: []string{"Albums"}, want: []string{"AlbumFlags (deleted by cascade from Albums)"}},
+		{desc: "Selected", selected: []string{"FeatureFlags", "SchemaMigrations"}, want: []string{"FeatureFlags (selected)", "SchemaMigrations (selected)"}},
+		{desc: "Cascaded through an unselected table, but not to NO ACTION", selected: []string{"Singers"}, want: []string{"AlbumFlags (deleted by cascade from Singers)"}},
+		{desc: "Nothing selected"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var selected []*tableSchema
+			for _, s := range all {
+				for _, name := range tt.selected {
+					if s.tableName == name {
+						selected = append(selected, s)
+					}
+				}
+			}
+			if diff := cmp.Diff(tt.want, touchedProtectedTables(all, selected, patterns, key)); diff != "" {
+				t.Errorf("touchedProtectedTables() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunProtectedTables(t *testing.T) {
+	config := WithConfig(&Config{ProtectedTables: []string{"FeatureFlags"}})
+	for _, tt := range []struct {
+		desc    string
+		opts    []Option
+		wantErr bool
+	}{
+		{desc: "Protected", opts: []Option{config}, wantErr: true},
+		{desc: "Excluded", opts: []Option{config, WithExcludeTables([]string{"FeatureFlags"})}},
+		{desc: "Allowed", opts: []Option{config, WithAllowProtectedTables(true)}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &fakeBackend{
+				tables: []TableInfo{{Name: "Singers"}, {Name: "FeatureFlags"}},
+				rows:   map[string]int64{"Singers": 10, "FeatureFlags": 3},
+			}
+			opts := append([]Option{WithBackend(b), WithQuiet(true), WithOutput(Output{Writer: ioutil.Discard})}, tt.opts...)
+			err := Run(context.Background(), "p", "i", "d", opts...)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("Run() = %v, but want error = %t", err, tt.wantErr)
+			}
+			if tt.wantErr && b.rows["Singers"] != 10 {
+				t.Errorf("Run() deleted rows before failing: %v", b.rows)
+			}
+		})
+	}
+}
+
+// fakeInstanceAdminServer serves the labels of instances, recording the names of requested instances.
+type fakeInstanceAdminServer struct {
+	instancepb.UnimplementedInstanceAdminServer
+	labels map[string]string
+	mu     sync.Mutex
+	names  []string
+}
+
+func (s *fakeInstanceAdminServer) GetInstance(ctx context.Context, req *instancepb.GetInstanceRequest) (*instancepb.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = append(s.names, req.GetName())
+	return &instancepb.Instance{Name: req.GetName(), Labels: s.labels}, nil
+}
+
+// startFakeInstanceAdmin starts the server on a local port and returns its address.
+func startFakeInstanceAdmin(t *testing.T, s *fakeInstanceAdminServer) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	instancepb.RegisterInstanceAdminServer(server, s)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func TestRunWithBackendProtectedInstance(t *testing.T) {
+	labels := WithProtectedLabels(map[string]string{"env": "prod"})
+	for _, tt := range []struct {
+		desc      string
+		labels    map[string]string
+		opts      []Option
+		wantErr   bool
+		wantNames []string
+	}{
+		{desc: "Unprotected", labels: map[string]string{"env": "prod"}},
+		{desc: "Protected", labels: map[string]string{"env": "prod"}, opts: []Option{labels}, wantErr: true, wantNames: []string{"projects/p/instances/i"}},
+		{desc: "Other labels", labels: map[string]string{"env": "dev"}, opts: []Option{labels}, wantNames: []string{"projects/p/instances/i"}},
+		{desc: "Allowed", labels: map[string]string{"env": "prod"}, opts: []Option{labels, WithAllowProtected(true)}, wantNames: []string{"projects/p/instances/i"}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			server := &fakeInstanceAdminServer{labels: tt.labels}
+			addr := startFakeInstanceAdmin(t, server)
+			b := &fakeBackend{
+				tables: []TableInfo{{Name: "Singers"}},
+				rows:   map[string]int64{"Singers": 10},
+			}
+			opts := append([]Option{WithEmulator(addr), WithQuiet(true), WithOutput(Output{Writer: ioutil.Discard})}, tt.opts...)
+			err := runWithBackend(context.Background(), b, NewOptions(opts...))
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("runWithBackend() = %v, but want error = %t", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.wantNames, server.names); diff != "" {
+				t.Errorf("GetInstance() requests differ: (-want, +got)\n%s", diff)
+			}
+			if wantRows := map[bool]int64{true: 10, false: 0}[tt.wantErr]; b.rows["Singers"] != wantRows {
+				t.Errorf("runWithBackend() left %d rows, but want %d", b.rows["Singers"], wantRows)
+			}
+		})
+	}
+}
+
+func TestInstanceOf(t *testing.T) {
+	for _, tt := range []struct {
+		desc         string
+		database     string
+		wantProject  string
+		wantInstance string
+		wantErr      bool
+	}{
+		{desc: "Database", database: "projects/p/instances/i/databases/d", wantProject: "p", wantInstance: "i"},
+		{desc: "Instance", database: "projects/p/instances/i", wantErr: true},
+		{desc: "Empty instance", database: "projects/p/instances//databases/d", wantErr: true},
+		{desc: "Empty", database: "", wantErr: true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			project, instance, err := instanceOf(tt.database)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("instanceOf(%q) = %v, but want error = %t", tt.database, err, tt.wantErr)
+			}
+			if project != tt.wantProject || instance != tt.wantInstance {
+				t.Errorf("instanceOf(%q) = (%q, %q), but want (%q, %q)", tt.database, project, instance, tt.wantProject, tt.wantInstance)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// Types of lifecycle events.
+const (
+	eventStarted        = "started"
+	eventTableCompleted = "table_completed"
+	eventFinished       = "finished"
+	eventFailed         = "failed"
+)
+
+// event is a lifecycle event of a run published to Pub/Sub.
+type event struct {
+	Type        string `json:"type"`
+	RunID       string `json:"runId"`
+	Database    string `json:"database"`
+	Table       string `json:"table,omitempty"`
+	RowsDeleted uint64 `json:"rowsDeleted,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Operator and Reason are set to the started event.
+	Operator string    `json:"operator,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// topicName returns the full topic name of "projects/p/topics/t" or "t".
+// If the project is omitted, defaultProject is used.
+func topicName(topic, defaultProject string) (string, error) {
+	if strings.HasPrefix(topic, "projects/") {
+		if parts := strings.Split(topic, "/"); len(parts) != 4 || parts[2] != "topics" || parts[1] == "" || parts[3] == "" {
+			return "", fmt.Errorf("invalid Pub/Sub topic %q, must be projects/PROJECT/topics/TOPIC or TOPIC", topic)
+		}
+		return topic, nil
+	}
+	if topic == "" || strings.Contains(topic, "/") {
+		return "", fmt.Errorf("invalid Pub/Sub topic %q, must be projects/PROJECT/topics/TOPIC or TOPIC", topic)
+	}
+	return fmt.Sprintf("projects/%s/topics/%s", defaultProject, topic), nil
+}
+
+// publisher publishes lifecycle events of a run.
+type publisher struct {
+	service  *pubsub.Service
+	topic    string
+	runID    string
+	database string
+	out      Output
+
+	mu        sync.Mutex
+	completed map[string]bool
+}
+
+func newPublisher(ctx context.Context, topic, runID, database string, out Output, opts ...option.ClientOption) (*publisher, error) {
+	name, err := topicName(topic, projectOf(database))
+	if err != nil {
+		return nil, err
+	}
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %v", err)
+	}
+	return &publisher{service: service, topic: name, runID: runID, database: database, out: out, completed: map[string]bool{}}, nil
+}
+
+// publish publishes the event. Failures are reported as warnings since they must not stop the run.
+func (p *publisher) publish(ctx context.Context, e event) {
+	e.RunID, e.Database, e.Time = p.runID, p.database, time.Now()
+	b, err := json.Marshal(e)
+	if err != nil {
+		p.out.warnf("failed to encode %s event: %v", e.Type, err)
+		return
+	}
+
+	msg := &pubsub.PubsubMessage{
+		Data:       base64.StdEncoding.EncodeToString(b),
+		Attributes: map[string]string{"type": e.Type, "runId": p.runID},
+	}
+	if _, err := p.service.Projects.Topics.Publish(p.topic, &pubsub.PublishRequest{Messages: []*pubsub.PubsubMessage{msg}}).Context(ctx).Do(); err != nil {
+		p.out.warnf("failed to publish %s event: %v", e.Type, err)
+	}
+}
+
+// publishCompletedTables publishes table_completed events of tables completed since the last call.
+func (p *publisher) publishCompletedTables(ctx context.Context, tables []*table) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range tables {
+		if !t.deleter.isCompleted() || p.completed[t.tableName] {
+			continue
+		}
+		p.completed[t.tableName] = true
+		p.publish(ctx, event{Type: eventTableCompleted, Table: t.tableName, RowsDeleted: t.deleter.deletedRows()})
+	}
+}
+
+// watchTables publishes table_completed events periodically until the context is done.
+func (p *publisher) watchTables(ctx context.Context, workers *workerGroup, tables []*table) {
+	workers.spawn("event publisher", func() {
+		for {
+			select {
+			case <-time.After(time.Second):
+				p.publishCompletedTables(ctx, tables)
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
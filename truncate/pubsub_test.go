@@ -0,0 +1,48 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "testing"
+
+func TestTopicName(t *testing.T) {
+	for _, tt := range []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "projects/p/topics/t", want: "projects/p/topics/t"},
+		{input: "t", want: "projects/default/topics/t"},
+		{input: "projects/p/subscriptions/t", wantErr: true},
+		{input: "p/t", wantErr: true},
+		{input: "", wantErr: true},
+	} {
+		got, err := topicName(tt.input, "default")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("topicName(%q) succeeded, but want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("topicName(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("topicName(%q) = %s, but want = %s", tt.input, got, tt.want)
+		}
+	}
+}
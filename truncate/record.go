@@ -0,0 +1,301 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+)
+
+// Fixture is a recording of the interactions of a run with a database.
+// It holds the schema and the results of executed statements, but no values of rows,
+// so that it can be shared in bug reports without sharing access to the database.
+type Fixture struct {
+	Database    string                 `json:"database"`
+	Dialect     Dialect                `json:"dialect"`
+	Tables      []TableInfo            `json:"tables"`
+	Indexes     []IndexInfo            `json:"indexes"`
+	PrimaryKeys map[string][]KeyColumn `json:"primaryKeys,omitempty"`
+	Graphs      []PropertyGraphInfo    `json:"graphs,omitempty"`
+	TableSizes  map[string]int64       `json:"tableSizes,omitempty"`
+	// DDL is the raw DDL statements of the database, only included in schema dumps taken with DDL.
+	DDL        []string            `json:"ddl,omitempty"`
+	Statements []RecordedStatement `json:"statements"`
+}
+
+// RecordedStatement is a statement executed during a recorded run and its result.
+type RecordedStatement struct {
+	// Method is the Backend method executing the statement, e.g. Count.
+	Method string `json:"method"`
+	SQL    string `json:"sql"`
+	// Table is the table of DeleteKeys, whose statement is not SQL.
+	Table string `json:"table,omitempty"`
+	// Count is the row count of Count, the number of keys of ReadKeys or the number of mutations of DeleteKeys.
+	Count int64  `json:"count,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// LoadFixture reads the fixture file at the given path.
+func LoadFixture(path string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture file: %v", err)
+	}
+	var f Fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture file %s: %v", path, err)
+	}
+	return &f, nil
+}
+
+// save writes the fixture to the file at the given path.
+func (f *Fixture) save(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Write writes the fixture as JSON.
+func (f *Fixture) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// recordingBackend is a backend recording the interactions with the underlying backend into a fixture,
+// which is written to the file when the backend is closed.
+type recordingBackend struct {
+	Backend
+	path string
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+func newRecordingBackend(b Backend, path string) *recordingBackend {
+	return &recordingBackend{
+		Backend: b,
+		path:    path,
+		fixture: Fixture{Database: b.DatabaseName(), Dialect: b.Dialect()},
+	}
+}
+
+func (b *recordingBackend) record(s RecordedStatement) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fixture.Statements = append(b.fixture.Statements, s)
+}
+
+func (b *recordingBackend) Tables(ctx context.Context) ([]TableInfo, error) {
+	tables, err := b.Backend.Tables(ctx)
+	if err == nil {
+		b.mu.Lock()
+		b.fixture.Tables = tables
+		b.mu.Unlock()
+	}
+	return tables, err
+}
+
+func (b *recordingBackend) Indexes(ctx context.Context) ([]IndexInfo, error) {
+	indexes, err := b.Backend.Indexes(ctx)
+	if err == nil {
+		b.mu.Lock()
+		b.fixture.Indexes = indexes
+		b.mu.Unlock()
+	}
+	return indexes, err
+}
+
+func (b *recordingBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	keys, err := b.Backend.PrimaryKeys(ctx)
+	if err == nil {
+		b.mu.Lock()
+		b.fixture.PrimaryKeys = keys
+		b.mu.Unlock()
+	}
+	return keys, err
+}
+
+func (b *recordingBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	graphs, err := b.Backend.PropertyGraphs(ctx)
+	if err == nil {
+		b.mu.Lock()
+		b.fixture.Graphs = graphs
+		b.mu.Unlock()
+	}
+	return graphs, err
+}
+
+func (b *recordingBackend) TableSizes(ctx context.Context) (map[string]int64, error) {
+	sizes, err := b.Backend.TableSizes(ctx)
+	if err == nil {
+		b.mu.Lock()
+		b.fixture.TableSizes = sizes
+		b.mu.Unlock()
+	}
+	return sizes, err
+}
+
+func (b *recordingBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	err := b.Backend.PartitionedUpdate(ctx, stmt, priority)
+	b.record(RecordedStatement{Method: "PartitionedUpdate", SQL: stmt.SQL, Error: errorString(err)})
+	return err
+}
+
+func (b *recordingBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	count, err := b.Backend.Count(ctx, stmt, staleness, priority)
+	b.record(RecordedStatement{Method: "Count", SQL: stmt.SQL, Count: count, Error: errorString(err)})
+	return count, err
+}
+
+func (b *recordingBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	keys, err := b.Backend.ReadKeys(ctx, stmt, columns, priority)
+	b.record(RecordedStatement{Method: "ReadKeys", SQL: stmt.SQL, Count: int64(len(keys)), Error: errorString(err)})
+	return keys, err
+}
+
+func (b *recordingBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error) {
+	mutations, err := b.Backend.DeleteKeys(ctx, table, columns, keys, priority)
+	b.record(RecordedStatement{Method: "DeleteKeys", Table: table, Count: mutations, Error: errorString(err)})
+	return mutations, err
+}
+
+func (b *recordingBackend) Close() error {
+	b.mu.Lock()
+	err := b.fixture.save(b.path)
+	b.mu.Unlock()
+	if cerr := b.Backend.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write fixture file: %v", err)
+	}
+	return nil
+}
+
+// replayBackend is a backend replaying a fixture instead of connecting to a database.
+// Results of a statement are replayed in the recorded order, and the last one is repeated once exhausted.
+// Statements not in the fixture succeed with no rows.
+type replayBackend struct {
+	fixture *Fixture
+
+	mu      sync.Mutex
+	results map[string][]RecordedStatement
+}
+
+func newReplayBackend(f *Fixture) *replayBackend {
+	results := map[string][]RecordedStatement{}
+	for _, s := range f.Statements {
+		k := replayKey(s.Method, s.SQL, s.Table)
+		results[k] = append(results[k], s)
+	}
+	return &replayBackend{fixture: f, results: results}
+}
+
+func replayKey(method, sql, table string) string {
+	return method + "\x00" + sql + "\x00" + table
+}
+
+// next returns the next recorded result of the statement.
+func (b *replayBackend) next(method, sql, table string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k := replayKey(method, sql, table)
+	results := b.results[k]
+	if len(results) == 0 {
+		return 0, nil
+	}
+	s := results[0]
+	if len(results) > 1 {
+		b.results[k] = results[1:]
+	}
+	if s.Error != "" {
+		return s.Count, errors.New(s.Error)
+	}
+	return s.Count, nil
+}
+
+func (b *replayBackend) DatabaseName() string {
+	return b.fixture.Database
+}
+
+func (b *replayBackend) Dialect() Dialect {
+	return b.fixture.Dialect
+}
+
+func (b *replayBackend) Tables(ctx context.Context) ([]TableInfo, error) {
+	return b.fixture.Tables, nil
+}
+
+func (b *replayBackend) Indexes(ctx context.Context) ([]IndexInfo, error) {
+	return b.fixture.Indexes, nil
+}
+
+func (b *replayBackend) PrimaryKeys(ctx context.Context) (map[string][]KeyColumn, error) {
+	return b.fixture.PrimaryKeys, nil
+}
+
+func (b *replayBackend) PropertyGraphs(ctx context.Context) ([]PropertyGraphInfo, error) {
+	return b.fixture.Graphs, nil
+}
+
+func (b *replayBackend) TableSizes(ctx context.Context) (map[string]int64, error) {
+	return b.fixture.TableSizes, nil
+}
+
+func (b *replayBackend) PartitionedUpdate(ctx context.Context, stmt spanner.Statement, priority Priority) error {
+	_, err := b.next("PartitionedUpdate", stmt.SQL, "")
+	return err
+}
+
+func (b *replayBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	return b.next("Count", stmt.SQL, "")
+}
+
+func (b *replayBackend) ReadKeys(ctx context.Context, stmt spanner.Statement, columns []KeyColumn, priority Priority) ([]Key, error) {
+	n, err := b.next("ReadKeys", stmt.SQL, "")
+	if err != nil {
+		return nil, err
+	}
+	// Values of keys are not recorded, so only the number of keys is replayed.
+	return make([]Key, n), nil
+}
+
+func (b *replayBackend) DeleteKeys(ctx context.Context, table string, columns []KeyColumn, keys []Key, priority Priority) (int64, error) {
+	return b.next("DeleteKeys", "", table)
+}
+
+func (b *replayBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	b := &fakeBackend{
+		tables: []TableInfo{{Name: "Singers"}, {Name: "Venues"}},
+		rows:   map[string]int64{"Singers": 10, "Venues": 30},
+	}
+	ctx := context.Background()
+	output := WithOutput(Output{Writer: ioutil.Discard})
+	if err := Run(ctx, "p", "i", "d", WithBackend(b), WithRecord(path), WithQuiet(true), output); err != nil {
+		t.Fatalf("Run() with record failed: %v", err)
+	}
+
+	f, err := LoadFixture(path)
+	if err != nil {
+		t.Fatalf("LoadFixture() failed: %v", err)
+	}
+	if diff := cmp.Diff(b.tables, f.Tables); diff != "" {
+		t.Errorf("recorded tables mismatch (-want +got):\n%s", diff)
+	}
+	var updates []string
+	for _, s := range f.Statements {
+		if s.Method == "PartitionedUpdate" {
+			updates = append(updates, s.SQL)
+		}
+	}
+	if len(updates) != 2 {
+		t.Errorf("recorded %d Partitioned DML statements, but want 2: %v", len(updates), updates)
+	}
+
+	if err := Run(ctx, "p", "i", "d", WithReplay(path), WithQuiet(true), output); err != nil {
+		t.Fatalf("Run() with replay failed: %v", err)
+	}
+}
+
+func TestReplayBackendNext(t *testing.T) {
+	stmt := spanner.NewStatement(DialectGoogleSQL.countRowsSQL("Singers"))
+	b := newReplayBackend(&Fixture{Statements: []RecordedStatement{
+		{Method: "Count", SQL: stmt.SQL, Count: 10},
+		{Method: "Count", SQL: stmt.SQL, Error: "deadline exceeded"},
+		{Method: "Count", SQL: stmt.SQL, Count: 0},
+	}})
+	ctx := context.Background()
+
+	var got []int64
+	var errs []string
+	for i := 0; i < 4; i++ {
+		count, err := b.Count(ctx, stmt, 0, PriorityUnspecified)
+		got = append(got, count)
+		errs = append(errs, errorString(err))
+	}
+	if diff := cmp.Diff([]int64{10, 0, 0, 0}, got); diff != "" {
+		t.Errorf("Count() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"", "deadline exceeded", "", ""}, errs); diff != "" {
+		t.Errorf("Count() errors mismatch (-want +got):\n%s", diff)
+	}
+
+	if count, err := b.Count(ctx, spanner.NewStatement(DialectGoogleSQL.countRowsSQL("Albums")), 0, PriorityUnspecified); count != 0 || err != nil {
+		t.Errorf("Count() of unrecorded statement = %d, %v, but want 0, nil", count, err)
+	}
+}
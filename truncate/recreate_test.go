@@ -0,0 +1,47 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCreateDatabaseStatement(t *testing.T) {
+	if got, want := createDatabaseStatement("ci-db-123"), "CREATE DATABASE `ci-db-123`"; got != want {
+		t.Errorf("createDatabaseStatement() = %q, but want = %q", got, want)
+	}
+}
+
+func TestRecreateDatabaseInvalidTarget(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		from string
+		to   string
+	}{
+		{desc: "Same database", from: "mydb", to: "mydb"},
+		{desc: "Same database by full name", from: "projects/p/instances/i/databases/mydb", to: "mydb"},
+		{desc: "Full name of the new database", from: "mydb", to: "projects/p/instances/i/databases/ci"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if err := RecreateDatabase(context.Background(), "p", "i", tt.from, tt.to, ioutil.Discard); err == nil {
+				t.Errorf("RecreateDatabase() succeeded, but want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Redaction configures how sensitive values are redacted in logs and reports, for compliance.
+type Redaction struct {
+	// Columns are case-insensitive glob patterns of column or parameter names whose values are always redacted,
+	// even if values are shown otherwise, e.g. "*email*".
+	Columns []string `json:"columns"`
+	// Hash replaces redacted values with a short SHA-256 hash instead of "<redacted>", so that equal values can be correlated.
+	Hash bool `json:"hash"`
+}
+
+func (r *Redaction) validate() error {
+	for _, pattern := range r.Columns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid column pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// isSensitive returns true if the column matches any of the column patterns. A nil redaction matches nothing.
+func (r *Redaction) isSensitive(column string) bool {
+	if r == nil {
+		return false
+	}
+	for _, pattern := range r.Columns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(column)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns the redacted representation of the value.
+func (r *Redaction) redact(value interface{}) string {
+	if r == nil || !r.Hash {
+		return "<redacted>"
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v", value)))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// format returns the representation of the value of the column in logs and reports.
+// The value is redacted unless show is true and the column is not sensitive.
+func (r *Redaction) format(column string, value interface{}, show bool) string {
+	if !show || r.isSensitive(column) {
+		return r.redact(value)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,259 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ReportFormat is the format of a report written after a run.
+type ReportFormat string
+
+const (
+	ReportFormatNone  ReportFormat = ""      // No report is written.
+	ReportFormatJUnit ReportFormat = "junit" // JUnit XML, where each table is a test case.
+	ReportFormatCSV   ReportFormat = "csv"   // CSV, where each table is a row.
+)
+
+// Status of a table in a report.
+const (
+	resultCompleted  = "completed"  // All rows have been deleted.
+	resultFailed     = "failed"     // Deletion failed with an error.
+	resultIncomplete = "incomplete" // Deletion has not completed because the run stopped.
+	resultSkipped    = "skipped"    // The table was dropped during the run, so regarded as deleted.
+	// The table was not deleted since it has fewer rows than --min-rows.
+	resultBelowThreshold = "below threshold"
+)
+
+// tableResult is the result of deleting rows from a table.
+type tableResult struct {
+	table       string
+	rowsDeleted uint64
+	// mutations is the number of mutations reported by commit statistics, only available for StrategyBatch.
+	mutations int64
+	duration  time.Duration
+	strategy  string
+	status    string
+	err       error
+	// reason describes why the table was skipped if the status is resultBelowThreshold.
+	reason string
+}
+
+// report is the result of a run.
+type report struct {
+	runID    string
+	database string
+	// operator and reason are who ran the truncation and why.
+	operator string
+	reason   string
+	duration time.Duration
+	tables   []*tableResult
+	// kept are the tables excluded from the run with their fingerprints, if taken.
+	kept []*keptTable
+	err  error
+}
+
+// newReport creates a report from the tables of a run.
+func newReport(runID, database string, started time.Time, tables []*table, err error) *report {
+	r := &report{runID: runID, database: database, duration: time.Since(started), err: err}
+	for _, t := range tables {
+		d := t.deleter.snapshot()
+		result := &tableResult{
+			table:       t.tableName,
+			rowsDeleted: t.deleter.deletedRows(),
+			strategy:    string(t.deleter.strategy),
+			mutations:   atomic.LoadInt64(&t.deleter.mutations),
+			err:         d.err,
+		}
+		switch {
+		case d.err != nil:
+			result.status = resultFailed
+		case d.dropped:
+			result.status = resultSkipped
+		case d.status == statusCompleted:
+			result.status = resultCompleted
+		default:
+			result.status = resultIncomplete
+		}
+		if !d.startedAt.IsZero() {
+			end := d.completedAt
+			if end.IsZero() {
+				end = time.Now()
+			}
+			result.duration = end.Sub(d.startedAt)
+		}
+		r.tables = append(r.tables, result)
+	}
+	return r
+}
+
+// addBelowThreshold adds the tables skipped since they have fewer rows than --min-rows.
+func (r *report) addBelowThreshold(decisions []*planDecision) {
+	for _, d := range decisions {
+		if d.belowThreshold {
+			r.tables = append(r.tables, &tableResult{table: d.tableName, status: resultBelowThreshold, reason: d.reason})
+		}
+	}
+}
+
+// write writes the report in the given format.
+func (r *report) write(w io.Writer, format ReportFormat) error {
+	switch format {
+	case ReportFormatJUnit:
+		return r.writeJUnit(w)
+	case ReportFormatCSV:
+		return r.writeCSV(w)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Cases      []junitTestCase  `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (r *report) writeJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name:  r.database,
+		Tests: len(r.tables),
+		Time:  fmt.Sprintf("%.3f", r.duration.Seconds()),
+	}
+	var props []junitProperty
+	if r.operator != "" || r.reason != "" {
+		props = append(props, junitProperty{Name: "operator", Value: r.operator}, junitProperty{Name: "reason", Value: r.reason})
+	}
+	for _, k := range r.kept {
+		props = append(props, junitProperty{Name: "fingerprint." + k.table, Value: k.result()})
+	}
+	if len(props) > 0 {
+		suite.Properties = &junitProperties{Properties: props}
+	}
+	for _, t := range r.tables {
+		tc := junitTestCase{
+			ClassName: r.database,
+			Name:      t.table,
+			Time:      fmt.Sprintf("%.3f", t.duration.Seconds()),
+		}
+		switch t.status {
+		case resultSkipped:
+			tc.Skipped = &junitSkipped{Message: "table was dropped during the run"}
+		case resultBelowThreshold:
+			tc.Skipped = &junitSkipped{Message: t.reason}
+		case resultFailed:
+			tc.Failure = &junitFailure{Message: t.err.Error(), Text: t.err.Error()}
+		case resultIncomplete:
+			msg := "deletion did not complete"
+			if r.err != nil {
+				msg = fmt.Sprintf("deletion did not complete: %v", r.err)
+			}
+			tc.Failure = &junitFailure{Message: msg, Text: fmt.Sprintf("%s rows deleted", formatNumber(t.rowsDeleted))}
+		}
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (r *report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"table", "rows_deleted", "duration_ms", "strategy", "status", "error", "mutations"}); err != nil {
+		return err
+	}
+	for _, t := range r.tables {
+		var errMsg string
+		if t.err != nil {
+			errMsg = t.err.Error()
+		}
+		// Mutations are unknown unless deleted in chunks.
+		var mutations string
+		if t.strategy == string(StrategyBatch) {
+			mutations = strconv.FormatInt(t.mutations, 10)
+		}
+		record := []string{
+			t.table,
+			strconv.FormatUint(t.rowsDeleted, 10),
+			strconv.FormatInt(t.duration.Milliseconds(), 10),
+			t.strategy,
+			t.status,
+			errMsg,
+			mutations,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
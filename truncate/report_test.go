@@ -0,0 +1,119 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testReport() *report {
+	return &report{
+		database: "projects/p/instances/i/databases/d",
+		duration: 3 * time.Second,
+		tables: []*tableResult{
+			{table: "Singers", rowsDeleted: 6000, mutations: 18000, duration: 1500 * time.Millisecond, strategy: "batch", status: resultCompleted},
+			{table: "Albums", rowsDeleted: 10, duration: time.Second, strategy: "pdml", status: resultFailed, err: errors.New("aborted")},
+			{table: "Songs", rowsDeleted: 0, strategy: "pdml", status: resultIncomplete},
+			{table: "Concerts", rowsDeleted: 0, strategy: "pdml", status: resultSkipped},
+			{table: "Venues", status: resultBelowThreshold, reason: "below threshold (5 rows < --min-rows 100)"},
+		},
+	}
+}
+
+func TestReportWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().write(&buf, ReportFormatJUnit); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="projects/p/instances/i/databases/d" tests="5" failures="2" skipped="2" time="3.000">
+    <testcase classname="projects/p/instances/i/databases/d" name="Singers" time="1.500"></testcase>
+    <testcase classname="projects/p/instances/i/databases/d" name="Albums" time="1.000">
+      <failure message="aborted">aborted</failure>
+    </testcase>
+    <testcase classname="projects/p/instances/i/databases/d" name="Songs" time="0.000">
+      <failure message="deletion did not complete">0 rows deleted</failure>
+    </testcase>
+    <testcase classname="projects/p/instances/i/databases/d" name="Concerts" time="0.000">
+      <skipped message="table was dropped during the run"></skipped>
+    </testcase>
+    <testcase classname="projects/p/instances/i/databases/d" name="Venues" time="0.000">
+      <skipped message="below threshold (5 rows &lt; --min-rows 100)"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("write() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().write(&buf, ReportFormatCSV); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	want := `table,rows_deleted,duration_ms,strategy,status,error,mutations
+Singers,6000,1500,batch,completed,,18000
+Albums,10,1000,pdml,failed,aborted,
+Songs,0,0,pdml,incomplete,,
+Concerts,0,0,pdml,skipped,,
+Venues,0,0,,below threshold,,
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("write() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportWriteJUnitProperties(t *testing.T) {
+	r := &report{database: "projects/p/instances/i/databases/d", operator: "alice", reason: "OPS-123"}
+	var buf bytes.Buffer
+	if err := r.write(&buf, ReportFormatJUnit); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="projects/p/instances/i/databases/d" tests="0" failures="0" skipped="0" time="0.000">
+    <properties>
+      <property name="operator" value="alice"></property>
+      <property name="reason" value="OPS-123"></property>
+    </properties>
+  </testsuite>
+</testsuites>
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("write() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewReportRowsInsertedDuringRun(t *testing.T) {
+	// More rows remain than counted at the start, since rows were inserted during the run.
+	tables := []*table{{tableName: "Singers", deleter: &deleter{tableName: "Singers", status: statusDeleting, totalRows: 10, remainedRows: 15}}}
+	r := newReport("r", "d", time.Now(), tables, nil)
+	if got := r.tables[0].rowsDeleted; got != 0 {
+		t.Errorf("newReport() rows deleted = %d, but want = 0", got)
+	}
+}
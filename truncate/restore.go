@@ -0,0 +1,86 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+)
+
+// Interval to report the progress of a restore operation.
+const restorePollInterval = 10 * time.Second
+
+// backupName returns the full name of the backup. The backup is either an ID of a backup in the instance or a full name.
+func backupName(projectID, instanceID, backup string) string {
+	if strings.HasPrefix(backup, "projects/") {
+		return backup
+	}
+	return fmt.Sprintf("projects/%s/instances/%s/backups/%s", projectID, instanceID, backup)
+}
+
+// RestoreDatabase restores the backup into a new database in the instance, to undo a bad truncation.
+// If wait is true, it waits until the restore operation completes, reporting its progress.
+// The restored database is readable once the operation completes, although it may still be optimized afterwards.
+func RestoreDatabase(ctx context.Context, projectID, instanceID, backup, toDatabase string, wait bool, out io.Writer, opts ...Option) error {
+	client, err := adminapi.NewDatabaseAdminClient(ctx, NewOptions(opts...).spannerClientOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Spanner database admin client: %v", err)
+	}
+	defer client.Close()
+
+	name := backupName(projectID, instanceID, backup)
+	op, err := client.RestoreDatabase(ctx, &adminpb.RestoreDatabaseRequest{
+		Parent:     fmt.Sprintf("projects/%s/instances/%s", projectID, instanceID),
+		DatabaseId: toDatabase,
+		Source:     &adminpb.RestoreDatabaseRequest_Backup{Backup: name},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore %s to %s: %v", name, toDatabase, err)
+	}
+	fmt.Fprintf(out, "Restoring %s to database %s (operation %s)\n", name, toDatabase, op.Name())
+	if !wait {
+		return nil
+	}
+
+	ticker := time.NewTicker(restorePollInterval)
+	defer ticker.Stop()
+	for {
+		db, err := op.Poll(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s to %s: %v", name, toDatabase, err)
+		}
+		if op.Done() {
+			fmt.Fprintf(out, "Restored database %s\n", db.GetName())
+			return nil
+		}
+		if md, err := op.Metadata(); err == nil && md != nil {
+			fmt.Fprintf(out, "Restoring... %d%%\n", md.GetProgress().GetProgressPercent())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
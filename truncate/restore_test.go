@@ -0,0 +1,36 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import "testing"
+
+func TestBackupName(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		backup string
+		want   string
+	}{
+		{desc: "Backup ID", backup: "mydb-before-truncate", want: "projects/p/instances/i/backups/mydb-before-truncate"},
+		{desc: "Full name", backup: "projects/p2/instances/i2/backups/b", want: "projects/p2/instances/i2/backups/b"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := backupName("p", "i", tt.backup); got != tt.want {
+				t.Errorf("backupName() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,61 +18,640 @@
 package truncate
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"os"
+	"math/rand"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/spanner"
 	"github.com/gosuri/uiprogress"
 )
 
+// Timeouts are deadlines of each phase of a run. Zero means no deadline for the phase.
+type Timeouts struct {
+	// Planning is the deadline for fetching the schema and planning deletion.
+	Planning time.Duration
+	// Execution is the deadline for deleting rows from all tables.
+	Execution time.Duration
+	// Verification is the deadline for verifying that rows have been deleted.
+	Verification time.Duration
+}
+
 // Run starts a routine to delete all rows from the specified database.
-// If targetTables is not empty, it deletes from the specified tables.
+// If target tables are specified by WithTargetTables, it deletes from the specified tables.
 // Otherwise, it deletes from all tables in the database.
-// If excludeTables is not empty, those tables are excluded from the deleted tables.
-func Run(ctx context.Context, projectID, instanceID, databaseID string, quiet bool, out io.Writer, targetTables, excludeTables []string) error {
+// If exclude tables are specified by WithExcludeTables, those tables are excluded from the deleted tables.
+func Run(ctx context.Context, projectID, instanceID, databaseID string, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	return runDatabase(ctx, projectID, instanceID, databaseID, o, nil)
+}
+
+// runDatabase runs against the database. The schema is shared with other databases if shared is not nil.
+func runDatabase(ctx context.Context, projectID, instanceID, databaseID string, o *Options, shared *sharedSchema) error {
+	if err := checkProtectedInstance(ctx, projectID, instanceID, o); err != nil {
+		return err
+	}
 	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	runID := newRunID()
+	b, err := newBackend(ctx, database, runID, o)
+	if err != nil {
+		return err
+	}
+	if o.PlanCache != "" {
+		out := o.messageOutput()
+		if cached, err := openPlanCache(ctx, b, database, o); err != nil {
+			out.warnf("The plan cache is not used: %v", err)
+		} else {
+			b = cached
+			if cached.reused {
+				fmt.Fprintf(out.writer(), "Using the cached schema of %s, whose DDL is unchanged\n", database)
+			}
+			defer func() {
+				if err := cached.flush(); err != nil {
+					out.warnf("%v", err)
+				}
+			}()
+		}
+	}
+	if shared != nil {
+		b = newSharedSchemaBackend(b, shared, sharedSchemaHash(ctx, b, database, o))
+	}
+	defer func() {
+		out := o.messageOutput()
+		fmt.Fprintf(out.writer(), "Closing spanner client...\n")
+		if err := b.Close(); err != nil {
+			out.warnf("%v", err)
+		}
+	}()
+
+	return run(ctx, b, o, runID)
+}
+
+// RunWithOptions is the same as RunWithClient, but takes the options as a struct,
+// for other Go tools and test harnesses embedding truncation.
+func RunWithOptions(ctx context.Context, client *spanner.Client, o Options) error {
+	return RunWithClient(ctx, client, WithOptions(o))
+}
 
-	client, err := spanner.NewClient(ctx, database)
+// RunWithClient is the same as Run, but uses the given client instead of creating a new one.
+// This allows library users to configure the client with their own options, interceptors and telemetry.
+// The client is not closed by this function.
+// The instance of the client's database is checked for protected labels like Run, and ClientOptions are only used for that check.
+func RunWithClient(ctx context.Context, client *spanner.Client, opts ...Option) error {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	b, err := newSpannerBackend(ctx, client, o.Priority, o.RequestTag)
 	if err != nil {
-		return fmt.Errorf("failed to create Cloud Spanner client: %v", err)
+		return err
+	}
+	return runWithBackend(ctx, b, o)
+}
+
+// runWithBackend runs against the backend created by the caller, after checking the protected labels of its instance.
+func runWithBackend(ctx context.Context, b Backend, o *Options) error {
+	projectID, instanceID, err := instanceOf(b.DatabaseName())
+	if err != nil {
+		return err
+	}
+	if err := checkProtectedInstance(ctx, projectID, instanceID, o); err != nil {
+		return err
 	}
+	return run(ctx, b, o, newRunID())
+}
+
+func run(ctx context.Context, b Backend, o *Options, runID string) (retErr error) {
+	out, config, timeouts := o.messageOutput(), o.Config, o.Timeouts
+	w := out.writer()
+
+	// Panics in the run and its workers fail the run with a report of what was in progress.
+	workers := newWorkerGroup()
+	var tables []*table
 	defer func() {
-		fmt.Fprintf(out, "Closing spanner client...\n")
-		client.Close()
+		if p := recover(); p != nil {
+			workers.recovered(&panicError{worker: "run", value: p, stack: debug.Stack()})
+		}
+		if perr := workers.panicked(); perr != nil {
+			writeFatalReport(w, perr, tables)
+			if retErr == nil {
+				retErr = perr
+			}
+		}
 	}()
 
-	fmt.Fprintf(out, "Fetching table schema from %s\n", database)
-	schemas, err := fetchTableSchemas(ctx, client, targetTables, excludeTables)
+	// Stop all background goroutines before returning, and make sure that they have exited if LeakCheck.
+	if o.LeakCheck {
+		defer func() {
+			if leaked := workers.wait(leakCheckTimeout); len(leaked) > 0 && retErr == nil {
+				retErr = fmt.Errorf("%d workers did not exit after the run: %s", len(leaked), strings.Join(leaked, ", "))
+			}
+		}()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if host := o.emulatorHost(); host != "" {
+		fmt.Fprintf(w, "Using the Cloud Spanner Emulator at %s\n", host)
+	}
+	fmt.Fprintf(w, "Fetching table schema from %s\n", b.DatabaseName())
+	// The time waiting for users to pick tables or to confirm does not count against the planning timeout.
+	planning := &phaseBudget{timeout: timeouts.Planning}
+	planCtx, planCancel := planning.start(ctx)
+	defer planCancel()
+	schemas, decisions, err := fetchTableSchemas(planCtx, b, o.tableSelection())
 	if err != nil {
 		return fmt.Errorf("failed to fetch table schema: %v", err)
 	}
+	// The cutoffs of --older-than, --protect-recent and --keep-latest are fixed at the start, so that re-planning deletes the same rows.
+	ages := o.ageFilters(time.Now())
+	sample, err := newSampleFilter(planCtx, b, o.SamplePercent, schemas)
+	if err != nil {
+		return err
+	}
+	if err := resolveAgeCutoffs(planCtx, b, ages, schemas); err != nil {
+		return err
+	}
+	if err := applyTableFilters(schemas, config.tableFilters(), o.Params, ages, sample, b.Dialect()); err != nil {
+		return err
+	}
+	indexes, err := fetchIndexSchemas(planCtx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch index schema: %v", err)
+	}
+	graphs, err := fetchGraphsByTable(planCtx, b)
+	if err != nil {
+		return fmt.Errorf("failed to fetch property graphs: %v", err)
+	}
+	sizes := fetchTableSizes(planCtx, b, out)
+	// Primary keys are required only if any table is deleted in chunks.
+	var usesBatch bool
 	for _, schema := range schemas {
-		fmt.Fprintf(out, "%s\n", schema.tableName)
+		if config.tableStrategy(schema.tableName, o.strategy()) == StrategyBatch {
+			usesBatch = true
+		}
+	}
+	var primaryKeys map[string][]KeyColumn
+	if usesBatch {
+		primaryKeys, err = b.PrimaryKeys(planCtx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch primary keys: %v", err)
+		}
 	}
-	fmt.Fprintf(out, "\n")
-	if !quiet {
-		if !confirm(out, "Rows in these tables will be deleted. Do you want to continue?") {
+	planCancel()
+
+	// Tables are picked after planning, so that the time to pick does not count against the planning timeout.
+	var picked []string
+	if o.Pick {
+		var ok bool
+		if picked, ok = pickTables(w, out.input(), schemas, sizes); !ok {
 			return nil
 		}
-	} else {
-		fmt.Fprintf(out, "Rows in these tables will be deleted.\n")
+		if len(picked) == 0 {
+			fmt.Fprintf(w, "No tables were picked.\n")
+			return nil
+		}
+		schemas = selectPicked(schemas, decisions, picked)
+		fmt.Fprintf(w, "\n")
+	}
+
+	planCtx, planCancel = planning.start(ctx)
+	defer planCancel()
+
+	// Tables completed by the unfinished run recorded in the state file are skipped if it is resumed.
+	var resumed *databaseState
+	if o.StateFile != "" {
+		state, err := loadRunState(o.StateFile)
+		if err != nil {
+			return err
+		}
+		prior := state.Databases[b.DatabaseName()]
+		switch {
+		case prior != nil && o.Resume:
+			resumed = prior
+			var skipped []string
+			schemas, skipped = skipCompleted(schemas, decisions, resumed)
+			fmt.Fprintf(w, "Resuming the run %s started at %s.\n", resumed.RunID, resumed.StartedAt.Format(time.RFC3339))
+			if len(skipped) > 0 {
+				fmt.Fprintf(w, "Skipped %d tables completed by the run: %s\n\n", len(skipped), strings.Join(skipped, ", "))
+			}
+			if len(schemas) == 0 {
+				fmt.Fprintf(w, "Nothing to do: all selected tables were completed by the resumed run.\n")
+				// The run has finished, so it is not warned as unfinished in the next run.
+				if !o.DryRun && o.OutputFormat != OutputJSON {
+					if err := newStateRecorder(o.StateFile, b.DatabaseName(), runID, time.Now(), nil).clear(); err != nil {
+						out.warnf("%v", err)
+					}
+				}
+				return nil
+			}
+		case o.Resume:
+			fmt.Fprintf(w, "No unfinished run of %s is recorded in %s, so no tables are skipped.\n", b.DatabaseName(), o.StateFile)
+		case prior != nil:
+			out.warnf("the unfinished run %s started at %s is recorded in %s, and is replaced once deletion starts; pass --resume to skip the tables completed by it.",
+				prior.RunID, prior.StartedAt.Format(time.RFC3339), o.StateFile)
+		}
+	}
+
+	var dropStatements []string
+	if patterns := config.protectedTables(); len(patterns) > 0 || o.DropNamespace {
+		all, err := fetchAllTableSchemas(planCtx, b)
+		if err != nil {
+			return fmt.Errorf("failed to fetch table schema: %v", err)
+		}
+		if touched := touchedProtectedTables(all, schemas, patterns, o.tableSelection().matchKey); len(touched) > 0 {
+			if !o.AllowProtectedTables {
+				return fmt.Errorf("rows of protected tables would be deleted: %s; exclude them or pass --allow-protected-tables to delete them anyway", strings.Join(touched, ", "))
+			}
+			out.warnf("Rows of protected tables will be deleted since protected tables are allowed: %s", strings.Join(touched, ", "))
+		}
+		// The namespace is checked before deleting anything, and checked again with the latest schema before dropping it.
+		if o.DropNamespace {
+			dependents, err := fetchSchemaDependents(planCtx, b, all)
+			if err != nil {
+				return fmt.Errorf("failed to fetch schema objects depending on tables: %v", err)
+			}
+			if dropStatements, err = namespaceDropStatements(all, schemas, indexes, dependents, o.Namespace, b.Dialect(), o.tableSelection().matchKey); err != nil {
+				return fmt.Errorf("cannot drop namespace %s: %v", o.Namespace, err)
+			}
+		}
 	}
 
-	indexes, err := fetchIndexSchemas(ctx, client)
+	// Tables which cannot be deleted in chunks are deleted with PDML instead of failing at execution time.
+	unchunkable := map[string]string{}
+	if usesBatch {
+		for _, schema := range schemas {
+			if config.tableStrategy(schema.tableName, o.strategy()) != StrategyBatch {
+				continue
+			}
+			if reason := unchunkableReason(primaryKeys[schema.tableName]); reason != "" {
+				unchunkable[schema.tableName] = reason
+				out.warnf("%s cannot be deleted in chunks (%s), so it is deleted with Partitioned DML without throttling.", schema.tableName, reason)
+			}
+		}
+	}
+
+	for _, schema := range schemas {
+		if g := graphs[schema.tableName]; len(g) > 0 {
+			out.warnf("%s backs property graph %s, whose nodes and edges will be removed as well.", schema.tableName, strings.Join(g, ", "))
+		}
+	}
+
+	if o.Largest > 0 {
+		fmt.Fprintf(w, "Selected the %d largest tables.\n", o.Largest)
+	}
+	for _, schema := range schemas {
+		line := schema.tableName
+		if size, ok := sizes[schema.tableName]; ok {
+			line += fmt.Sprintf(" (%s)", formatBytes(uint64(size)))
+		}
+		if schema.filter != "" {
+			line += fmt.Sprintf(", only rows where %s", schema.filter)
+		}
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	fmt.Fprintf(w, "\n")
+	var belowThreshold, skippedTables []string
+	for _, d := range decisions {
+		if d.belowThreshold {
+			belowThreshold = append(belowThreshold, fmt.Sprintf("%s (%s rows)", d.tableName, formatNumber(uint64(d.rows))))
+			skippedTables = append(skippedTables, d.tableName)
+		}
+	}
+	if len(belowThreshold) > 0 {
+		fmt.Fprintf(w, "Skipped %d tables below threshold (--min-rows %s): %s\n\n", len(belowThreshold), formatNumber(uint64(o.MinRows)), strings.Join(belowThreshold, ", "))
+	}
+	stages := newStagePlan(config.stages())
+	if stages != nil {
+		fmt.Fprintf(w, "Tables are deleted in stages:\n")
+		for _, line := range stages.describe(schemas) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	groups, err := planConsistencyGroups(planCtx, b, config.consistencyGroups(), schemas, countIndexes(indexes), stages, out)
 	if err != nil {
-		return fmt.Errorf("failed to fetch index schema: %v", err)
+		return fmt.Errorf("failed to plan consistency groups: %v", err)
+	}
+	if len(groups) > 0 {
+		fmt.Fprintf(w, "Tables in each consistency group are deleted in a single transaction:\n")
+		for _, line := range describeConsistencyGroups(groups) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if o.DryRun || o.OutputFormat == OutputJSON {
+		// The deletion order is simulated by a coordinator of its own, which executes no DML.
+		simulated := newCoordinator(schemas, indexes, b)
+		simulated.concurrency = o.concurrency()
+		simulated.order = o.Order
+		simulated.groups = groups
+		if stages != nil {
+			simulated.stages = newStagePlan(stages.stages)
+		}
+		steps, err := simulateOrder(planCtx, simulated)
+		if err != nil {
+			return fmt.Errorf("failed to plan the deletion order: %v", err)
+		}
+		strategyOf := func(table string) Strategy {
+			if _, ok := unchunkable[table]; ok {
+				return StrategyPDML
+			}
+			return config.tableStrategy(table, o.strategy())
+		}
+		if o.OutputFormat == OutputJSON {
+			enc := json.NewEncoder(o.Output.writer())
+			enc.SetIndent("", "  ")
+			return enc.Encode(newExecutionPlan(b.DatabaseName(), schemas, decisions, steps, strategyOf))
+		}
+		fmt.Fprintf(w, "Tables would be deleted in the following order:\n")
+		for _, line := range describeOrder(steps, strategyOf) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		if len(dropStatements) > 0 {
+			fmt.Fprintf(w, "Namespace %s would be dropped after truncation by:\n", o.Namespace)
+			for _, stmt := range dropStatements {
+				fmt.Fprintf(w, "  %s\n", stmt)
+			}
+		}
+		fmt.Fprintf(w, "\nDry run: nothing was deleted.\n")
+		return nil
+	}
+	// Nothing is confirmed, locked or recorded if there are no rows to be deleted, e.g. when resetting a database which is already reset.
+	// The tables of a namespace to be dropped are still dropped if they are empty.
+	if empty, err := allEmpty(planCtx, b, schemas); err != nil {
+		out.warnf("%v", err)
+	} else if empty && !o.DropNamespace {
+		if o.FailIfEmpty {
+			return ErrNothingToDelete
+		}
+		fmt.Fprintf(w, "Nothing to do: all selected tables are empty.\n")
+		return nil
+	}
+	planCancel()
+	operator, reason := o.Operator, o.Reason
+	if operator == "" {
+		operator = defaultOperator()
+	}
+	if reason == "" && config.isProduction(b.DatabaseName()) {
+		if o.Quiet {
+			return fmt.Errorf("%s matches productionPatterns in the config, so a reason is required; specify it with --reason", b.DatabaseName())
+		}
+		fmt.Fprintf(w, "%s matches productionPatterns in the config.\n", b.DatabaseName())
+		var ok bool
+		if reason, ok = promptReason(w, out.input()); !ok {
+			return nil
+		}
+	}
+	if reason != "" {
+		fmt.Fprintf(w, "Operator: %s, reason: %s\n", operator, reason)
+	}
+	if o.ApprovedPlan != nil {
+		approver, err := o.ApprovedPlan.verifyApproval(newPlan(b.DatabaseName(), schemas), o.ApprovalKey)
+		if err != nil {
+			return fmt.Errorf("failed to verify approval: %v", err)
+		}
+		fmt.Fprintf(w, "The plan has been approved by %s.\n", approver)
+	}
+	if o.DropNamespace {
+		fmt.Fprintf(w, "Tables of namespace %s will be dropped after truncation.\n", o.Namespace)
+	}
+	if !o.Quiet && !o.Yes {
+		// The database and the number of rows are shown, so that a wrong database can be noticed before anything is deleted.
+		fmt.Fprintf(w, "Estimated rows to be deleted from %s:\n", b.DatabaseName())
+		for _, line := range describeEstimates(schemas, estimateRows(ctx, b, schemas, timeouts.Planning, out)) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		if !confirm(w, out.input(), "Rows in these tables will be deleted. Do you want to continue?") {
+			return nil
+		}
+	} else {
+		fmt.Fprintf(w, "Rows in these tables will be deleted.\n")
+	}
+
+	if o.LockTable != "" {
+		c, ok := spannerClient(b)
+		if !ok {
+			return fmt.Errorf("lock table requires the native Cloud Spanner client")
+		}
+		lock, err := acquireLock(ctx, c, o.LockTable, runID, operator, o.LockTTL, o.StealLock)
+		if err != nil {
+			return fmt.Errorf("failed to acquire lock: %v", err)
+		}
+		defer func() {
+			// Use a fresh context since the context of the run may have been canceled.
+			if err := lock.release(context.Background()); err != nil {
+				out.warnf("failed to release lock: %v", err)
+			}
+		}()
+		lock.keepAlive(ctx, workers, func(holder string) {
+			out.warnf("the lock has been stolen by %s, which may be deleting the same tables.", holder)
+		})
+	}
+	if o.HistoryTable != "" {
+		c, ok := spannerClient(b)
+		if !ok {
+			return fmt.Errorf("history table requires the native Cloud Spanner client")
+		}
+		history := &historyRecorder{client: c, table: o.HistoryTable, runID: runID}
+		started := time.Now()
+		names := make([]string, 0, len(schemas))
+		for _, schema := range schemas {
+			names = append(names, schema.tableName)
+		}
+		if err := history.recordStarted(ctx, started, operator, reason, names); err != nil {
+			return fmt.Errorf("failed to record the run into the history table: %v", err)
+		}
+		defer func() {
+			// Use a fresh context since the context of the run may have been canceled.
+			if err := history.recordFinished(context.Background(), newReport(runID, b.DatabaseName(), started, tables, retErr)); err != nil {
+				out.warnf("failed to record the result of the run into the history table: %v", err)
+			}
+		}()
+	}
+
+	// Reads before deleting anything are the rest of planning.
+	planCtx, planCancel = planning.start(ctx)
+	defer planCancel()
+	var preserved *preservedRow
+	var client *spanner.Client
+	if stages.drainsChangeStreams() {
+		c, ok := spannerClient(b)
+		if !ok {
+			return fmt.Errorf("drainChangeStream requires the native Cloud Spanner client")
+		}
+		if b.Dialect() == DialectPostgreSQL {
+			return fmt.Errorf("drainChangeStream is only supported in GoogleSQL databases")
+		}
+		client = c
+	}
+	if sv := config.schemaVersion(); sv != nil {
+		c, ok := spannerClient(b)
+		if !ok {
+			return fmt.Errorf("schemaVersion requires the native Cloud Spanner client")
+		}
+		client = c
+		preserved, err = readLatestRow(planCtx, client, b.Dialect(), sv, o.queryOptions())
+		if err != nil {
+			return fmt.Errorf("failed to read the latest row of %s: %v", sv.Table, err)
+		}
+	}
+	// Fingerprints of the kept tables prove that they are untouched by the run.
+	var kept []*keptTable
+	var keptKeys map[string][]KeyColumn
+	if o.Fingerprint {
+		if b.Dialect() == DialectPostgreSQL {
+			return fmt.Errorf("fingerprints are only supported in GoogleSQL databases")
+		}
+		if keptKeys, err = b.PrimaryKeys(planCtx); err != nil {
+			return fmt.Errorf("failed to fetch primary keys: %v", err)
+		}
+		fmt.Fprintf(w, "Taking fingerprints of tables kept by the run...\n")
+		for _, name := range keptTableNames(decisions) {
+			f, err := takeFingerprint(planCtx, b, name, keptKeys[name])
+			if err != nil {
+				return err
+			}
+			kept = append(kept, &keptTable{table: name, before: f})
+		}
 	}
 
-	coordinator := newCoordinator(schemas, indexes, client)
-	coordinator.start(ctx)
+	planCancel()
+
+	execCtx, execCancel := withPhaseTimeout(ctx, timeouts.Execution)
+	defer execCancel()
+	coordinator := newCoordinator(schemas, indexes, b)
+	coordinator.concurrency = o.concurrency()
+	coordinator.order = o.Order
+	coordinator.window = o.Window
+	coordinator.controller = o.Controller
+	coordinator.stages = stages
+	if len(groups) > 0 {
+		c, _ := spannerClient(b)
+		coordinator.groups = groups
+		coordinator.deleteGroup = func(ctx context.Context, stmts []spanner.Statement) ([]int64, error) {
+			return deleteInTransaction(ctx, c, stmts, o.queryOptions())
+		}
+	}
+	coordinator.workers = workers
+	workers.setOnPanic(func(perr *panicError) {
+		coordinator.sendErr(execCtx, perr)
+	})
+	if stages != nil {
+		stages.drain = func(ctx context.Context, d ChangeStreamDrain, since time.Time) error {
+			out.logf("Waiting for %s.", d)
+			return drainChangeStream(ctx, client, d, since, o.queryOptions())
+		}
+		stages.onStage = func(name string, wait time.Duration) {
+			if wait > 0 {
+				out.logf("Waiting %s before starting %s.", wait, name)
+			} else {
+				out.logf("Starting %s.", name)
+			}
+		}
+	}
+	workers.spawn("abort watcher", func() {
+		select {
+		case <-o.Controller.abortCh():
+			execCancel()
+		case <-execCtx.Done():
+		}
+	})
+	if o.Window != nil && !o.Window.contains(time.Now()) {
+		fmt.Fprintf(w, "Outside of the execution window %s, deletion starts at %s.\n", o.Window, o.Window.nextOpen(time.Now()).Format(time.RFC3339))
+	}
+	var budget *mutationBudget
+	if o.MutationsPerSecond > 0 {
+		budget = newMutationBudget(o.MutationsPerSecond)
+	}
+	var rowBudget *mutationBudget
+	if o.RowsPerSecond > 0 {
+		rowBudget = newMutationBudget(o.RowsPerSecond)
+	}
+	indexCounts := countIndexes(indexes)
+	var stmtLog *statementLogger
+	if o.Verbose {
+		stmtLog = newStatementLogger(out, o.ShowParams, config.redaction())
+	}
+	configure := func(table *table) {
+		table.deleter.priority = config.tablePriority(table.tableName, o.Priority)
+		table.deleter.stmtLog = stmtLog
+		table.deleter.failpoints = o.Failpoints
+		table.deleter.boundedReads = o.BoundedReads
+		if o.ThroughputGraph {
+			table.deleter.throughput = &throughput{}
+		}
+		if config.tableStrategy(table.tableName, o.strategy()) != StrategyBatch {
+			return
+		}
+		if reason := unchunkableReason(primaryKeys[table.tableName]); reason != "" {
+			if _, ok := unchunkable[table.tableName]; !ok {
+				out.warnf("%s cannot be deleted in chunks (%s), so it is deleted with Partitioned DML without throttling.", table.tableName, reason)
+			}
+			return
+		}
+		table.deleter.strategy = StrategyBatch
+		table.deleter.keyColumns = primaryKeys[table.tableName]
+		// A deleted row costs a mutation for the row and one for each secondary index entry.
+		table.deleter.mutationsPerRow = 1 + indexCounts[table.tableName]
+		table.deleter.budget = budget
+		table.deleter.rowBudget = rowBudget
+	}
+	for _, table := range flattenTables(coordinator.tables) {
+		configure(table)
+	}
+	if o.Replan {
+		coordinator.replan = func(ctx context.Context) ([]*tableSchema, []*indexSchema, error) {
+			// The schema has changed, so it is fetched from the database instead of the plan cache.
+			fresh := uncachedSchema(b)
+			schemas, _, err := fetchTableSchemas(ctx, fresh, o.tableSelection())
+			if err != nil {
+				return nil, nil, err
+			}
+			sample, err := newSampleFilter(ctx, b, o.SamplePercent, schemas)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Tables added by the schema change have their cutoffs read, while the other tables keep theirs.
+			if err := resolveAgeCutoffs(ctx, b, ages, schemas); err != nil {
+				return nil, nil, err
+			}
+			if err := applyTableFilters(schemas, config.tableFilters(), o.Params, ages, sample, b.Dialect()); err != nil {
+				return nil, nil, err
+			}
+			if o.Pick {
+				schemas = selectPicked(schemas, nil, picked)
+			}
+			if resumed != nil {
+				schemas, _ = skipCompleted(schemas, nil, resumed)
+			}
+			indexes, err := fetchIndexSchemas(ctx, fresh)
+			if err != nil {
+				return nil, nil, err
+			}
+			if usesBatch {
+				if primaryKeys, err = fresh.PrimaryKeys(ctx); err != nil {
+					return nil, nil, err
+				}
+			}
+			indexCounts = countIndexes(indexes)
+			return schemas, indexes, nil
+		}
+	}
+	coordinator.start(execCtx)
 
 	// Show progress bars.
 	progress := uiprogress.New()
-	progress.SetOut(out)
+	progress.SetOut(out.progress())
 	progress.SetRefreshInterval(time.Millisecond * 500)
 	progress.Start()
 	var maxNameLength int
@@ -81,41 +660,332 @@ func Run(ctx context.Context, projectID, instanceID, databaseID string, quiet bo
 			maxNameLength = l
 		}
 	}
-	for _, table := range flattenTables(coordinator.tables) {
-		showProgressBar(progress, table, maxNameLength)
+	tables = flattenTables(coordinator.tableTree())
+	var state *stateRecorder
+	if o.StateFile != "" {
+		state = newStateRecorder(o.StateFile, b.DatabaseName(), runID, time.Now(), resumed)
+		state.watch(tables...)
+		state.start(execCtx, workers, out)
+	}
+	if o.PubSubTopic != "" {
+		pub, err := newPublisher(ctx, o.PubSubTopic, runID, b.DatabaseName(), out, o.clientOptions()...)
+		if err != nil {
+			return err
+		}
+		pub.publish(ctx, event{Type: eventStarted, Operator: operator, Reason: reason})
+		pub.watchTables(execCtx, workers, tables)
+		defer func() {
+			// Use a fresh context since the context of the run may have been canceled.
+			ctx := context.Background()
+			pub.publishCompletedTables(ctx, tables)
+			if retErr != nil {
+				pub.publish(ctx, event{Type: eventFailed, Error: retErr.Error()})
+			} else {
+				pub.publish(ctx, event{Type: eventFinished})
+			}
+		}()
 	}
 
-	if err := coordinator.waitCompleted(); err != nil {
+	started := time.Now()
+	defer func() {
+		r := newReport(runID, b.DatabaseName(), started, tables, retErr)
+		r.operator, r.reason = operator, reason
+		r.addBelowThreshold(decisions)
+		r.kept = kept
+		if o.StripNamespace {
+			r.stripNamespace(o.Namespace)
+		}
+		if o.ReportFormat != ReportFormatNone {
+			if err := r.write(out.report(), o.ReportFormat); err != nil {
+				out.warnf("failed to write report: %v", err)
+			}
+		}
+		if o.BigQueryResultsTable != "" {
+			if err := writeBigQueryResults(context.Background(), o.BigQueryResultsTable, r); err != nil {
+				out.warnf("failed to write results to BigQuery: %v", err)
+			}
+		}
+	}()
+	var dog *watchdog
+	if o.StallTimeout > 0 {
+		dog = newWatchdog(o.StallTimeout, o.StallAction, out, func() bool {
+			return (o.Window != nil && !o.Window.contains(time.Now())) || o.Controller.isPaused()
+		}, time.Now())
+		dog.watch(tables...)
+		dog.start(execCtx, workers, execCancel)
+	}
+	coordinator.onReplan = func(cause error, added []*table) {
+		var names []string
+		for _, table := range added {
+			configure(table)
+			showProgressBar(execCtx, workers, progress, table, maxNameLength)
+			dog.watch(table)
+			state.watch(table)
+			tables = append(tables, table)
+			names = append(names, table.tableName)
+		}
+		out.warnf("schema changed during the run (%v), re-planned the remaining tables; added tables: [%s]", cause, strings.Join(names, ", "))
+	}
+	showOverallProgressBar(execCtx, workers, progress, tables, maxNameLength, o.Controller, budget, rowBudget)
+	for _, table := range tables {
+		showProgressBar(execCtx, workers, progress, table, maxNameLength)
+	}
+
+	err = coordinator.waitCompleted()
+	// Tables completed since the last write are recorded, so that they are skipped on resume even if the run failed.
+	if err := state.record(); err != nil {
+		out.warnf("%v", err)
+	}
+	if err != nil {
 		progress.Stop()
+		if o.Controller.isAborted() {
+			return fmt.Errorf("failed to delete: aborted by operator")
+		}
+		if dog.aborted() {
+			return fmt.Errorf("failed to delete: no progress within the stall timeout %s", o.StallTimeout)
+		}
+		if execCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("failed to delete: execution did not complete within %s", timeouts.Execution)
+		}
 		return fmt.Errorf("failed to delete: %v", err)
 	}
 	// Wait for reflecting the latest progresses to progress bars.
 	time.Sleep(time.Second)
 	progress.Stop()
+	execCancel()
 
-	fmt.Fprint(out, "\nDone! All rows have been deleted successfully.\n")
+	verifyCtx, verifyCancel := withPhaseTimeout(ctx, timeouts.Verification)
+	defer verifyCancel()
+	var verifyKeys map[string][]KeyColumn
+	if o.Verify == VerifySample {
+		fmt.Fprintf(w, "\nVerifying deletion by sampling %d key ranges of each table...\n", o.VerifySampleSize)
+		if verifyKeys, err = b.PrimaryKeys(verifyCtx); err != nil {
+			return fmt.Errorf("failed to fetch primary keys: %v", err)
+		}
+	} else {
+		fmt.Fprintf(w, "\nVerifying deletion...\n")
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var remainedTables []string
+	for _, table := range tables {
+		var remained uint64
+		var estimated bool
+		var err error
+		readCtx := verifyCtx
+		if o.BoundedReads {
+			readCtx = withMinReadTimestamp(verifyCtx, readBound(table, tables))
+		}
+		if o.Verify == VerifySample {
+			remained, estimated, err = table.deleter.verifySample(readCtx, verifyKeys[table.tableName], o.VerifySampleSize, rnd, out)
+		} else {
+			remained, err = table.deleter.verify(readCtx)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to verify deletion of %s: %v", table.tableName, err)
+		}
+		if estimated {
+			out.warnf("about %s rows remain in %s (estimated from %d sampled key ranges), which were probably inserted during the run.", formatNumber(remained), table.tableName, o.VerifySampleSize)
+		} else if remained > 0 {
+			out.warnf("%s rows remain in %s, which were probably inserted during the run.", formatNumber(remained), table.tableName)
+		}
+		if remained > 0 {
+			remainedTables = append(remainedTables, table.tableName)
+		}
+	}
+	// Tables in which rows remain are deleted again on resume.
+	if err := state.forget(remainedTables); err != nil {
+		out.warnf("%v", err)
+	}
+
+	if len(kept) > 0 {
+		fmt.Fprintf(w, "Verifying fingerprints of kept tables...\n")
+		for _, k := range kept {
+			f, err := takeFingerprint(verifyCtx, b, k.table, keptKeys[k.table])
+			if err != nil {
+				return err
+			}
+			k.after = &f
+			if f != k.before {
+				out.warnf("%s was %s, probably by other writers during the run.", k.table, k.result())
+			}
+		}
+	}
+
+	var dropped []string
+	for _, table := range tables {
+		if table.deleter.snapshot().dropped {
+			dropped = append(dropped, table.tableName)
+		}
+	}
+	if len(dropped) > 0 {
+		fmt.Fprintf(w, "Skipped tables dropped during the run: %s\n", strings.Join(dropped, ", "))
+	}
+	if lines := amplification(tables); len(lines) > 0 {
+		fmt.Fprintf(w, "Delete amplification:\n%s\n", strings.Join(lines, "\n"))
+	}
+
+	if preserved != nil {
+		fmt.Fprintf(w, "Restoring the latest row of %s...\n", preserved.table)
+		if err := preserved.restore(ctx, client, o.queryOptions()); err != nil {
+			return fmt.Errorf("failed to restore the latest row of %s: %v", preserved.table, err)
+		}
+	}
+	if sv := config.schemaVersion(); sv != nil && sv.Hook != "" {
+		fmt.Fprintf(w, "Running schema version hook...\n")
+		if err := runHook(ctx, sv, w); err != nil {
+			return fmt.Errorf("failed to run schema version hook: %v", err)
+		}
+	}
+
+	if o.DropNamespace {
+		if len(remainedTables) > 0 {
+			return fmt.Errorf("namespace %s is not dropped since rows remain in %s", o.Namespace, strings.Join(remainedTables, ", "))
+		}
+		if err := dropNamespace(ctx, b, o, schemas, w); err != nil {
+			return err
+		}
+	}
+
+	if err := state.clear(); err != nil {
+		out.warnf("%v", err)
+	}
+	fmt.Fprint(w, doneMessage(skippedTables, dropped, remainedTables))
 	return nil
 }
 
+// doneMessage returns the message shown at the end of a run.
+// It claims that all rows have been deleted only if no tables were skipped or dropped and no rows remain.
+func doneMessage(skipped, dropped, remained []string) string {
+	if len(skipped) == 0 && len(dropped) == 0 && len(remained) == 0 {
+		return "\nDone! All rows have been deleted successfully.\n"
+	}
+	var b strings.Builder
+	b.WriteString("\nDone, but not all rows have been deleted:\n")
+	if len(skipped) > 0 {
+		fmt.Fprintf(&b, "  Skipped tables below threshold: %s\n", strings.Join(skipped, ", "))
+	}
+	if len(dropped) > 0 {
+		fmt.Fprintf(&b, "  Tables dropped during the run: %s\n", strings.Join(dropped, ", "))
+	}
+	if len(remained) > 0 {
+		fmt.Fprintf(&b, "  Tables in which rows remain: %s\n", strings.Join(remained, ", "))
+	}
+	return b.String()
+}
+
+// countIndexes returns the number of secondary indexes of each table.
+func countIndexes(indexes []*indexSchema) map[string]int {
+	counts := map[string]int{}
+	for _, idx := range indexes {
+		counts[idx.baseTableName]++
+	}
+	return counts
+}
+
+// withPhaseTimeout returns a context which is canceled when the timeout of a phase elapses.
+func withPhaseTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// phaseBudget is the timeout of a phase interrupted by prompts, so that the time waiting for users does not count against it.
+type phaseBudget struct {
+	timeout time.Duration
+	spent   time.Duration
+}
+
+// start returns a context which is canceled when the rest of the timeout elapses.
+// The time until the returned function is called is spent from the timeout.
+func (p *phaseBudget) start(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, p.timeout-p.spent)
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() { p.spent += time.Since(started) })
+		cancel()
+	}
+}
+
 // confirm returns true if a user confirmed the message, otherwise returns false.
-func confirm(out io.Writer, msg string) bool {
+func confirm(out io.Writer, in io.Reader, msg string) bool {
 	fmt.Fprintf(out, "%s [Y/n] ", msg)
 
-	s := bufio.NewScanner(os.Stdin)
 	for {
-		s.Scan()
-		switch s.Text() {
+		line, err := readLine(in)
+		switch line {
 		case "Y":
 			return true
 		case "n":
 			return false
-		default:
-			fmt.Fprint(out, "Please answer Y or n: ")
 		}
+		if err != nil {
+			return false
+		}
+		fmt.Fprint(out, "Please answer Y or n: ")
 	}
 }
 
-func showProgressBar(progress *uiprogress.Progress, table *table, maxNameLength int) {
+// showOverallProgressBar shows the number of completed tables, the estimated time until all rows are deleted,
+// the remaining time until the execution deadline and the consumption of the budgets of mutations and rows.
+func showOverallProgressBar(ctx context.Context, workers *workerGroup, progress *uiprogress.Progress, tables []*table, maxNameLength int, controller *Controller, budget, rowBudget *mutationBudget) {
+	bar := progress.AddBar(len(tables))
+	bar.PrependFunc(func(b *uiprogress.Bar) string {
+		return fmt.Sprintf("%-*s%-9s %5s", maxNameLength+2, "", "total", "")
+	})
+	started := time.Now()
+	bar.AppendFunc(func(b *uiprogress.Bar) string {
+		s := fmt.Sprintf("(%d / %d tables)", b.Current(), len(tables))
+		var deleted, total uint64
+		for _, t := range tables {
+			deleted += t.deleter.deletedRows()
+			total += t.deleter.total()
+		}
+		if eta := formatETA(deleted, total, time.Since(started)); eta != "" && b.Current() < len(tables) {
+			s += " " + eta
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			s += fmt.Sprintf(" %s remaining", time.Until(deadline).Truncate(time.Second))
+		}
+		if budget != nil {
+			s += fmt.Sprintf(" %s of %s mutations/s", formatNumber(uint64(budget.rate())), formatNumber(uint64(budget.perSecond)))
+		}
+		if rowBudget != nil {
+			s += fmt.Sprintf(" %s of %s rows/s", formatNumber(uint64(rowBudget.rate())), formatNumber(uint64(rowBudget.perSecond)))
+		}
+		if controller.isPaused() {
+			s += " paused"
+		}
+		return s
+	})
+
+	workers.spawn("overall progress bar", func() {
+		for {
+			var completed int
+			for _, table := range tables {
+				if table.deleter.isCompleted() {
+					completed++
+				}
+			}
+			bar.Set(completed)
+			if completed == len(tables) {
+				return
+			}
+
+			select {
+			case <-time.After(time.Second * 1):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+func showProgressBar(ctx context.Context, workers *workerGroup, progress *uiprogress.Progress, table *table, maxNameLength int) {
 	bar := progress.AddBar(100)
 	bar.PrependFunc(func(b *uiprogress.Bar) string {
 		elapsed := int(b.TimeElapsed().Seconds())
@@ -123,7 +993,7 @@ func showProgressBar(progress *uiprogress.Progress, table *table, maxNameLength
 	})
 	bar.PrependFunc(func(b *uiprogress.Bar) string {
 		var s string
-		switch table.deleter.status {
+		switch table.deleter.currentStatus() {
 		case statusAnalyzing:
 			s = "analyzing"
 		case statusWaiting:
@@ -137,33 +1007,52 @@ func showProgressBar(progress *uiprogress.Progress, table *table, maxNameLength
 	})
 	bar.AppendCompleted()
 	bar.AppendFunc(func(b *uiprogress.Bar) string {
-		deletedRows := table.deleter.totalRows - table.deleter.remainedRows
-		return fmt.Sprintf("(%s / %s)", formatNumber(deletedRows), formatNumber(table.deleter.totalRows))
+		return fmt.Sprintf("(%s / %s)", formatNumber(table.deleter.deletedRows()), formatNumber(table.deleter.total()))
 	})
+	bar.AppendFunc(func(b *uiprogress.Bar) string {
+		s := table.deleter.snapshot()
+		if s.status != statusDeleting && s.status != statusCascadeDeleting {
+			return ""
+		}
+		return formatETA(table.deleter.deletedRows(), s.totalRows, time.Since(s.startedAt))
+	})
+	if t := table.deleter.throughput; t != nil {
+		bar.AppendFunc(func(b *uiprogress.Bar) string {
+			return t.String()
+		})
+	}
 
 	// HACK: We call progressBar.Incr() to start timer in the progress bar.
 	bar.Set(-1)
 	bar.Incr()
 
 	// Update progress periodically.
-	go func() {
+	workers.spawn("progress bar of "+table.tableName, func() {
 		for {
-			switch table.deleter.status {
+			switch table.deleter.currentStatus() {
 			case statusCompleted:
 				// Increment the progress bar until it reaches 100
 				for bar.Incr() {
 				}
+				return
 			case statusAnalyzing:
 				// nop
 			default:
-				deletedRows := table.deleter.totalRows - table.deleter.remainedRows
-				target := int(float32(deletedRows) / float32(table.deleter.totalRows) * 100)
+				deletedRows := table.deleter.deletedRows()
+				target := int(float32(deletedRows) / float32(table.deleter.total()) * 100)
 				for i := bar.Current(); i < target; i++ {
 					bar.Incr()
 				}
+				if t := table.deleter.throughput; t != nil {
+					t.sample(table.deleter.deletedRows(), atomic.LoadInt64(&table.deleter.mutations), time.Now())
+				}
 			}
 
-			time.Sleep(time.Second * 1)
+			select {
+			case <-time.After(time.Second * 1):
+			case <-ctx.Done():
+				return
+			}
 		}
-	}()
+	})
 }
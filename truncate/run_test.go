@@ -0,0 +1,96 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDoneMessage(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		skipped  []string
+		dropped  []string
+		remained []string
+		want     string
+	}{
+		{
+			desc: "All rows deleted",
+			want: "\nDone! All rows have been deleted successfully.\n",
+		},
+		{
+			desc:    "Skipped tables",
+			skipped: []string{"Singers", "Albums"},
+			want:    "\nDone, but not all rows have been deleted:\n  Skipped tables below threshold: Singers, Albums\n",
+		},
+		{
+			desc:     "Dropped tables and remaining rows",
+			dropped:  []string{"Concerts"},
+			remained: []string{"Songs"},
+			want:     "\nDone, but not all rows have been deleted:\n  Tables dropped during the run: Concerts\n  Tables in which rows remain: Songs\n",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := doneMessage(tt.skipped, tt.dropped, tt.remained)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("doneMessage() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPhaseBudget(t *testing.T) {
+	p := &phaseBudget{timeout: time.Hour}
+	ctx, cancel := p.start(context.Background())
+	first, _ := ctx.Deadline()
+	cancel()
+	cancel()
+	if p.spent <= 0 || p.spent >= time.Minute {
+		t.Errorf("spent = %s after the first part, but want a short positive duration", p.spent)
+	}
+
+	// The rest of the timeout is given to the next part.
+	p.spent = 40 * time.Minute
+	ctx, cancel = p.start(context.Background())
+	defer cancel()
+	second, _ := ctx.Deadline()
+	if left := time.Until(second); left > 20*time.Minute || left < 19*time.Minute {
+		t.Errorf("the next part has %s left, but want about 20m", left)
+	}
+	if !second.Before(first) {
+		t.Errorf("the deadline of the next part %s is not before the first %s", second, first)
+	}
+
+	// The context expires immediately once the timeout is spent.
+	p.spent = time.Hour
+	ctx, cancel = p.start(context.Background())
+	defer cancel()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("ctx.Err() = %v after the timeout is spent, but want %v", ctx.Err(), context.DeadlineExceeded)
+	}
+
+	// No deadline without a timeout.
+	ctx, cancel = (&phaseBudget{}).start(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("ctx has a deadline without a timeout")
+	}
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// sampleFilter deletes only a percentage of rows of each table chosen by the hash of their keys.
+// Rows are sampled by the keys of their top-level interleaved ancestor, so that a parent row and its child rows are deleted together.
+type sampleFilter struct {
+	// basisPoints is the percentage of rows to be deleted in units of 0.01%.
+	basisPoints int
+	// keys are the columns hashed for each table, i.e. the prefix of its primary key shared with its root.
+	keys map[string][]KeyColumn
+}
+
+// newSampleFilter returns the filter deleting the percentage of rows of the tables, or nil if the percentage is zero.
+func newSampleFilter(ctx context.Context, b Backend, percent float64, schemas []*tableSchema) (*sampleFilter, error) {
+	if percent <= 0 {
+		return nil, nil
+	}
+	if b.Dialect() == DialectPostgreSQL {
+		return nil, errors.New("sampling is only supported in GoogleSQL databases")
+	}
+	primaryKeys, err := b.PrimaryKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch primary keys: %v", err)
+	}
+	return &sampleFilter{
+		basisPoints: int(math.Round(percent * 100)),
+		keys:        sampleKeys(schemas, primaryKeys),
+	}, nil
+}
+
+// sampleKeys returns the key columns hashed for each table.
+// Interleaved tables share the key prefix of their parents, so the keys of the top-level ancestor among the tables are used.
+func sampleKeys(schemas []*tableSchema, primaryKeys map[string][]KeyColumn) map[string][]KeyColumn {
+	parents := map[string]string{}
+	for _, s := range schemas {
+		parents[s.tableName] = s.parentTableName
+	}
+	keys := map[string][]KeyColumn{}
+	for _, s := range schemas {
+		root := s.tableName
+		for {
+			parent, ok := parents[root]
+			if !ok || parent == "" {
+				break
+			}
+			if _, ok := parents[parent]; !ok {
+				break
+			}
+			root = parent
+		}
+		columns := primaryKeys[s.tableName]
+		if n := len(primaryKeys[root]); n < len(columns) {
+			columns = columns[:n]
+		}
+		keys[s.tableName] = columns
+	}
+	return keys
+}
+
+// condition returns the condition of the sampled rows of the table.
+func (f *sampleFilter) condition(table string) string {
+	return fmt.Sprintf("ABS(MOD(FARM_FINGERPRINT(%s), 10000)) < %d", keyStringSQL(f.keys[table]), f.basisPoints)
+}
@@ -0,0 +1,96 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSampleKeys(t *testing.T) {
+	primaryKeys := map[string][]KeyColumn{
+		"Singers":  {{Name: "SingerId", SpannerType: "INT64"}},
+		"Albums":   {{Name: "SingerId", SpannerType: "INT64"}, {Name: "AlbumId", SpannerType: "INT64"}},
+		"Songs":    {{Name: "SingerId", SpannerType: "INT64"}, {Name: "AlbumId", SpannerType: "INT64"}, {Name: "TrackId", SpannerType: "INT64"}},
+		"Concerts": {{Name: "VenueId", SpannerType: "STRING(MAX)"}, {Name: "ConcertId", SpannerType: "BYTES(16)"}},
+	}
+	for _, tt := range []struct {
+		desc    string
+		schemas []*tableSchema
+		want    map[string][]KeyColumn
+	}{
+		{
+			desc: "Interleaved tables are sampled by the keys of the root",
+			schemas: []*tableSchema{
+				{tableName: "Singers"},
+				{tableName: "Albums", parentTableName: "Singers"},
+				{tableName: "Songs", parentTableName: "Albums"},
+				{tableName: "Concerts"},
+			},
+			want: map[string][]KeyColumn{
+				"Singers":  primaryKeys["Singers"],
+				"Albums":   primaryKeys["Singers"],
+				"Songs":    primaryKeys["Singers"],
+				"Concerts": primaryKeys["Concerts"],
+			},
+		},
+		{
+			desc: "Root is the top-level ancestor among the tables",
+			schemas: []*tableSchema{
+				{tableName: "Albums", parentTableName: "Singers"},
+				{tableName: "Songs", parentTableName: "Albums"},
+			},
+			want: map[string][]KeyColumn{
+				"Albums": primaryKeys["Albums"],
+				"Songs":  primaryKeys["Albums"],
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := sampleKeys(tt.schemas, primaryKeys)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("sampleKeys() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyTableFiltersWithSample(t *testing.T) {
+	sample := &sampleFilter{
+		basisPoints: 1050,
+		keys: map[string][]KeyColumn{
+			"Singers":  {{Name: "SingerId", SpannerType: "INT64"}},
+			"Concerts": {{Name: "VenueId", SpannerType: "STRING(MAX)"}, {Name: "ConcertId", SpannerType: "BYTES(16)"}},
+		},
+	}
+	schemas := []*tableSchema{{tableName: "Singers"}, {tableName: "Concerts"}}
+	if err := applyTableFilters(schemas, map[string]string{"Singers": "Active = false OR Deleted = true"}, nil, nil, sample, DialectGoogleSQL); err != nil {
+		t.Fatalf("applyTableFilters() failed: %v", err)
+	}
+	want := map[string]string{
+		"Singers":  "(Active = false OR Deleted = true) AND ABS(MOD(FARM_FINGERPRINT(CONCAT(IFNULL(CAST(`SingerId` AS STRING), 'NULL'))), 10000)) < 1050",
+		"Concerts": "ABS(MOD(FARM_FINGERPRINT(CONCAT(IFNULL(CAST(`VenueId` AS STRING), 'NULL'), '\\x1f', IFNULL(TO_BASE64(`ConcertId`), 'NULL'))), 10000)) < 1050",
+	}
+	got := map[string]string{}
+	for _, s := range schemas {
+		got[s.tableName] = s.filter
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("applyTableFilters() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,188 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kinds of schema objects compared by DiffSchemas, in the order of SchemaDiff.Changes.
+const (
+	schemaKindTable      = "table"
+	schemaKindInterleave = "interleave"
+	schemaKindForeignKey = "foreignKey"
+	schemaKindIndex      = "index"
+)
+
+var schemaKinds = []string{schemaKindTable, schemaKindInterleave, schemaKindForeignKey, schemaKindIndex}
+
+// Types of changes in SchemaChange.
+const (
+	schemaChangeAdded   = "added"   // Only in the second database.
+	schemaChangeRemoved = "removed" // Only in the first database.
+	schemaChangeChanged = "changed" // In both databases, but different.
+)
+
+// SchemaDiff is the difference between the schemas of two databases, as discovered by spanner-truncate.
+type SchemaDiff struct {
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Changes []SchemaChange `json:"changes"`
+}
+
+// SchemaChange is a difference of a schema object between two databases.
+type SchemaChange struct {
+	// Kind is one of table, interleave, foreignKey or index.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Change is one of added, removed or changed.
+	Change string `json:"change"`
+	// From and To describe the object in each database, if any.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Equal returns true if the schemas of the databases are the same.
+func (d *SchemaDiff) Equal() bool {
+	return len(d.Changes) == 0
+}
+
+// Write writes the diff as JSON.
+func (d *SchemaDiff) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// schemaObject identifies a schema object compared by DiffSchemas.
+type schemaObject struct {
+	kind string
+	name string
+}
+
+// schemaModel is the discovered schema objects of a database with their descriptions.
+type schemaModel map[schemaObject]string
+
+func newSchemaModel(tables []*tableSchema, indexes []*indexSchema) schemaModel {
+	m := schemaModel{}
+	for _, t := range tables {
+		m[schemaObject{schemaKindTable, t.tableName}] = ""
+		if t.parentTableName != "" {
+			desc := t.parentTableName
+			switch t.parentOnDeleteAction {
+			case deleteActionCascadeDelete:
+				desc += " ON DELETE CASCADE"
+			case deleteActionNoAction:
+				desc += " ON DELETE NO ACTION"
+			}
+			m[schemaObject{schemaKindInterleave, t.tableName}] = desc
+		}
+		for _, r := range t.referencedBy {
+			m[schemaObject{schemaKindForeignKey, fmt.Sprintf("%s -> %s", r, t.tableName)}] = ""
+		}
+	}
+	for _, idx := range indexes {
+		desc := "ON " + idx.baseTableName
+		if idx.parentTableName != "" {
+			desc += ", INTERLEAVE IN " + idx.parentTableName
+		}
+		m[schemaObject{schemaKindIndex, idx.indexName}] = desc
+	}
+	return m
+}
+
+// diffSchemaModels returns the changes from a to b, ordered by kind and name.
+func diffSchemaModels(a, b schemaModel) []SchemaChange {
+	changes := []SchemaChange{}
+	for obj, from := range a {
+		to, ok := b[obj]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Kind: obj.kind, Name: obj.name, Change: schemaChangeRemoved, From: from})
+		case from != to:
+			changes = append(changes, SchemaChange{Kind: obj.kind, Name: obj.name, Change: schemaChangeChanged, From: from, To: to})
+		}
+	}
+	for obj, to := range b {
+		if _, ok := a[obj]; !ok {
+			changes = append(changes, SchemaChange{Kind: obj.kind, Name: obj.name, Change: schemaChangeAdded, To: to})
+		}
+	}
+
+	kindOrder := map[string]int{}
+	for i, k := range schemaKinds {
+		kindOrder[k] = i
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return kindOrder[changes[i].Kind] < kindOrder[changes[j].Kind]
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// databaseName returns the full name of the database. The database is either an ID of a database in the instance or a full name.
+func databaseName(projectID, instanceID, database string) string {
+	if strings.HasPrefix(database, "projects/") {
+		return database
+	}
+	return fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, database)
+}
+
+// DiffSchemas compares tables, interleaving, foreign keys and indexes of two databases,
+// to confirm schema parity of a clone before truncating it. Each database is either an ID of a database in the instance or a full name.
+func DiffSchemas(ctx context.Context, projectID, instanceID, databaseA, databaseB string, opts ...Option) (*SchemaDiff, error) {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	from, to := databaseName(projectID, instanceID, databaseA), databaseName(projectID, instanceID, databaseB)
+	a, err := fetchSchemaModel(ctx, from, o)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fetchSchemaModel(ctx, to, o)
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaDiff{From: from, To: to, Changes: diffSchemaModels(a, b)}, nil
+}
+
+func fetchSchemaModel(ctx context.Context, database string, o *Options) (schemaModel, error) {
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	tables, err := fetchAllTableSchemas(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch table schema of %s: %v", database, err)
+	}
+	indexes, err := fetchIndexSchemas(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch index schema of %s: %v", database, err)
+	}
+	return newSchemaModel(tables, indexes), nil
+}
@@ -0,0 +1,101 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffSchemaModels(t *testing.T) {
+	a := newSchemaModel(
+		[]*tableSchema{
+			{tableName: "Singers", referencedBy: []string{"Concerts"}},
+			{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete},
+			{tableName: "Concerts"},
+			{tableName: "Venues"},
+		},
+		[]*indexSchema{
+			{indexName: "AlbumsByTitle", baseTableName: "Albums", parentTableName: "Singers"},
+		},
+	)
+
+	for _, tt := range []struct {
+		desc    string
+		tables  []*tableSchema
+		indexes []*indexSchema
+		want    []SchemaChange
+	}{
+		{
+			desc: "Same schema",
+			tables: []*tableSchema{
+				{tableName: "Venues"},
+				{tableName: "Concerts"},
+				{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionCascadeDelete},
+				{tableName: "Singers", referencedBy: []string{"Concerts"}},
+			},
+			indexes: []*indexSchema{
+				{indexName: "AlbumsByTitle", baseTableName: "Albums", parentTableName: "Singers"},
+			},
+			want: []SchemaChange{},
+		},
+		{
+			desc: "Different schema",
+			tables: []*tableSchema{
+				{tableName: "Singers"},
+				{tableName: "Albums", parentTableName: "Singers", parentOnDeleteAction: deleteActionNoAction},
+				{tableName: "Concerts"},
+				{tableName: "Fans"},
+			},
+			indexes: []*indexSchema{
+				{indexName: "AlbumsByTitle", baseTableName: "Albums"},
+			},
+			want: []SchemaChange{
+				{Kind: "table", Name: "Fans", Change: "added"},
+				{Kind: "table", Name: "Venues", Change: "removed"},
+				{Kind: "interleave", Name: "Albums", Change: "changed", From: "Singers ON DELETE CASCADE", To: "Singers ON DELETE NO ACTION"},
+				{Kind: "foreignKey", Name: "Concerts -> Singers", Change: "removed"},
+				{Kind: "index", Name: "AlbumsByTitle", Change: "changed", From: "ON Albums, INTERLEAVE IN Singers", To: "ON Albums"},
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := diffSchemaModels(a, newSchemaModel(tt.tables, tt.indexes))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("diffSchemaModels() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDatabaseName(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		database string
+		want     string
+	}{
+		{desc: "Database ID", database: "mydb", want: "projects/p/instances/i/databases/mydb"},
+		{desc: "Full name", database: "projects/p2/instances/i2/databases/d", want: "projects/p2/instances/i2/databases/d"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := databaseName("p", "i", tt.database); got != tt.want {
+				t.Errorf("databaseName() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+
+	adminapi "cloud.google.com/go/spanner/admin/database/apiv1"
+)
+
+// DumpSchema returns the schema of the database as a fixture without statements, which can be replayed by WithReplay
+// to plan runs offline. If withDDL is true, the raw DDL statements fetched by the Admin API are included along with
+// the discovered model, so that RecreateDatabaseFromDump can recreate the database from the same dump.
+func DumpSchema(ctx context.Context, projectID, instanceID, databaseID string, withDDL bool, opts ...Option) (*Fixture, error) {
+	o := NewOptions(opts...)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	b, err := newBackend(ctx, database, newRunID(), o)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	f := &Fixture{Database: b.DatabaseName(), Dialect: b.Dialect(), Statements: []RecordedStatement{}}
+	if f.Tables, err = b.Tables(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch table schema: %v", err)
+	}
+	if f.Indexes, err = b.Indexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch index schema: %v", err)
+	}
+	if f.PrimaryKeys, err = b.PrimaryKeys(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch primary keys: %v", err)
+	}
+	if f.Graphs, err = b.PropertyGraphs(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch property graphs: %v", err)
+	}
+	f.TableSizes = fetchTableSizes(ctx, b, o.Output)
+
+	if withDDL {
+		client, err := adminapi.NewDatabaseAdminClient(ctx, o.spannerClientOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Cloud Spanner database admin client: %v", err)
+		}
+		defer client.Close()
+		if f.DDL, err = fetchDatabaseDDL(ctx, client, f.Database); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
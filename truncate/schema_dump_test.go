@@ -0,0 +1,62 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDumpSchema(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{
+			{Name: "Singers"},
+			{Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"},
+		},
+	}
+	got, err := DumpSchema(context.Background(), "p", "i", "d", false, WithBackend(b))
+	if err != nil {
+		t.Fatalf("DumpSchema() failed: %v", err)
+	}
+	if diff := cmp.Diff(b.tables, got.Tables); diff != "" {
+		t.Errorf("DumpSchema() tables mismatch (-want +got):\n%s", diff)
+	}
+	if got.DDL != nil {
+		t.Errorf("DumpSchema() DDL = %v, but want nil", got.DDL)
+	}
+}
+
+func TestRecreateDatabaseFromDumpInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		dump *Fixture
+		to   string
+	}{
+		{desc: "No DDL", dump: &Fixture{Database: "d"}, to: "ci"},
+		{desc: "PostgreSQL dialect", dump: &Fixture{Database: "d", Dialect: DialectPostgreSQL, DDL: []string{"CREATE TABLE t (id bigint PRIMARY KEY)"}}, to: "ci"},
+		{desc: "Full name of the new database", dump: &Fixture{Database: "d", DDL: []string{"CREATE TABLE t (id INT64) PRIMARY KEY (id)"}}, to: "projects/p/instances/i/databases/ci"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if err := RecreateDatabaseFromDump(context.Background(), "p", "i", tt.dump, tt.to, ioutil.Discard); err == nil {
+				t.Errorf("RecreateDatabaseFromDump() succeeded, but want error")
+			}
+		})
+	}
+}
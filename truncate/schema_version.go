@@ -0,0 +1,99 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"cloud.google.com/go/spanner"
+)
+
+// SchemaVersion is the configuration of a schema-version table managed by a migration tool.
+type SchemaVersion struct {
+	// Table is the name of the schema-version table.
+	Table string `json:"table"`
+	// OrderBy is the column to find the latest row, e.g. "Version".
+	OrderBy string `json:"orderBy"`
+	// Hook is a shell command executed after truncation, e.g. to bump the schema version.
+	Hook string `json:"hook"`
+}
+
+// preservedRow is a row read before truncation to be inserted again after truncation.
+type preservedRow struct {
+	table   string
+	columns []string
+	values  []interface{}
+}
+
+// readLatestRow reads the latest row of the schema-version table. It returns nil if the table is empty.
+// Generated columns are not read as they cannot be inserted again.
+func readLatestRow(ctx context.Context, client *spanner.Client, dialect Dialect, sv *SchemaVersion, opts spanner.QueryOptions) (*preservedRow, error) {
+	txn := client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	var columns []string
+	if err := txn.QueryWithOptions(ctx, spanner.NewStatement(dialect.writableColumnsSQL(sv.Table)), opts).Do(func(r *spanner.Row) error {
+		var column string
+		if err := r.Column(0, &column); err != nil {
+			return err
+		}
+		columns = append(columns, column)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read columns: %v", err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", sv.Table)
+	}
+
+	var row *preservedRow
+	stmt := spanner.NewStatement(dialect.latestRowSQL(sv.Table, columns, sv.OrderBy))
+	if err := txn.QueryWithOptions(ctx, stmt, opts).Do(func(r *spanner.Row) error {
+		row = &preservedRow{table: sv.Table, columns: r.ColumnNames()}
+		for i := 0; i < r.Size(); i++ {
+			var v spanner.GenericColumnValue
+			if err := r.Column(i, &v); err != nil {
+				return err
+			}
+			row.values = append(row.values, v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// restore inserts the preserved row again.
+// The priority and the request tag of the options are applied to the transaction.
+func (p *preservedRow) restore(ctx context.Context, client *spanner.Client, opts spanner.QueryOptions) error {
+	_, err := client.Apply(ctx, []*spanner.Mutation{spanner.InsertOrUpdate(p.table, p.columns, p.values)}, spanner.Priority(opts.Priority), spanner.TransactionTag(opts.RequestTag))
+	return err
+}
+
+// runHook executes the hook of the schema-version table.
+func runHook(ctx context.Context, sv *SchemaVersion, out io.Writer) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", sv.Hook)
+	cmd.Env = append(os.Environ(), "SPANNER_TRUNCATE_SCHEMA_VERSION_TABLE="+sv.Table)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
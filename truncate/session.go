@@ -0,0 +1,138 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	sapi "cloud.google.com/go/spanner/apiv1"
+	"google.golang.org/api/iterator"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+const (
+	// Labels attached to every session created by this tool.
+	sessionLabelApp   = "app"
+	sessionLabelRunID = "run-id"
+	sessionAppName    = "spanner-truncate"
+
+	// Sessions which have not been used for this duration are regarded as leaked.
+	staleSessionAge = 10 * time.Minute
+
+	// Timeout for deleting the sessions left after a client is closed.
+	flushSessionsTimeout = 30 * time.Second
+)
+
+// newRunID returns a random ID which identifies a run. It is also valid as a session label value.
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "r" + hex.EncodeToString(b)
+}
+
+// CleanupSessions deletes sessions left behind by previous runs of this tool, typically crashed ones.
+// Only sessions which have been idle for a while are deleted so that concurrent runs are not affected.
+// If a lock table is given by WithLock, sessions of the run holding the lock are kept however idle they are.
+func CleanupSessions(ctx context.Context, projectID, instanceID, databaseID string, out io.Writer, opts ...Option) error {
+	database := fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instanceID, databaseID)
+	o := NewOptions(opts...)
+
+	live := map[string]bool{}
+	if o.LockTable != "" {
+		runID := newRunID()
+		live[runID] = true
+		client, err := newClient(ctx, database, runID, o.spannerClientOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to create Cloud Spanner client: %v", err)
+		}
+		held, err := liveLockRunID(ctx, client, o.LockTable)
+		client.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read the lock: %v", err)
+		}
+		if held != "" {
+			live[held] = true
+			fmt.Fprintf(out, "Keeping sessions of run %s holding the lock.\n", held)
+		}
+	}
+
+	now := time.Now()
+	deleted, err := deleteSessions(ctx, database, sessionFilter(sessionLabelApp, sessionAppName), o, func(s *sppb.Session) bool {
+		return staleSession(s, now, live)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Deleted %d leaked sessions.\n", deleted)
+	return nil
+}
+
+// flushSessions deletes the sessions of the run which are left after its client is closed.
+// Closing a client deletes the sessions in its pool only for a few seconds, so that a pool of many sessions may be left behind.
+func flushSessions(ctx context.Context, database, runID string, o *Options) error {
+	_, err := deleteSessions(ctx, database, sessionFilter(sessionLabelRunID, runID), o, func(*sppb.Session) bool { return true })
+	return err
+}
+
+// sessionFilter returns a filter of ListSessions matching sessions with the label.
+func sessionFilter(label, value string) string {
+	return fmt.Sprintf("labels.%s:%s", label, value)
+}
+
+// staleSession returns true if the session has been idle for staleSessionAge and does not belong to a live run.
+// Sessions whose last use is unknown are kept since they may have just been created.
+func staleSession(s *sppb.Session, now time.Time, live map[string]bool) bool {
+	if s.GetLabels()[sessionLabelApp] != sessionAppName || live[s.GetLabels()[sessionLabelRunID]] {
+		return false
+	}
+	lastUsed := s.GetApproximateLastUseTime()
+	return lastUsed != nil && now.Sub(lastUsed.AsTime()) >= staleSessionAge
+}
+
+// deleteSessions deletes the sessions matching the filter which are selected, and returns the number of deleted sessions.
+func deleteSessions(ctx context.Context, database, filter string, o *Options, selected func(*sppb.Session) bool) (int, error) {
+	client, err := sapi.NewClient(ctx, o.spannerClientOptions()...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create Cloud Spanner client: %v", err)
+	}
+	defer client.Close()
+
+	iter := client.ListSessions(ctx, &sppb.ListSessionsRequest{Database: database, Filter: filter})
+	var deleted int
+	for {
+		session, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list sessions: %v", err)
+		}
+		if !selected(session) {
+			continue
+		}
+		if err := client.DeleteSession(ctx, &sppb.DeleteSessionRequest{Name: session.Name}); err != nil {
+			return deleted, fmt.Errorf("failed to delete session %s: %v", session.Name, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,73 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+)
+
+func TestSessionFilter(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		label string
+		value string
+		want  string
+	}{
+		{desc: "Sessions of the tool", label: sessionLabelApp, value: sessionAppName, want: "labels.app:spanner-truncate"},
+		{desc: "Sessions of a run", label: sessionLabelRunID, value: "r0123456789abcdef", want: "labels.run-id:r0123456789abcdef"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := sessionFilter(tt.label, tt.value); got != tt.want {
+				t.Errorf("sessionFilter() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleSession(t *testing.T) {
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	session := func(runID string, idle time.Duration) *sppb.Session {
+		return &sppb.Session{
+			Labels:                 map[string]string{sessionLabelApp: sessionAppName, sessionLabelRunID: runID},
+			ApproximateLastUseTime: &timestamp.Timestamp{Seconds: now.Add(-idle).Unix()},
+		}
+	}
+	live := map[string]bool{"rlocked": true}
+
+	for _, tt := range []struct {
+		desc    string
+		session *sppb.Session
+		want    bool
+	}{
+		{desc: "Idle session of a crashed run", session: session("rcrashed", time.Hour), want: true},
+		{desc: "Idle just for the threshold", session: session("rcrashed", staleSessionAge), want: true},
+		{desc: "Recently used", session: session("rcrashed", staleSessionAge-time.Second), want: false},
+		{desc: "Idle session of the run holding the lock", session: session("rlocked", time.Hour), want: false},
+		{desc: "Unknown last use", session: &sppb.Session{Labels: map[string]string{sessionLabelApp: sessionAppName}}, want: false},
+		{desc: "Session of another application", session: &sppb.Session{Labels: map[string]string{sessionLabelApp: "other"}, ApproximateLastUseTime: &timestamp.Timestamp{Seconds: now.Add(-time.Hour).Unix()}}, want: false},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := staleSession(tt.session, now, live); got != tt.want {
+				t.Errorf("staleSession() = %t, but want = %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect is the SQL dialect of a database.
+type Dialect int
+
+const (
+	DialectGoogleSQL  Dialect = iota // GoogleSQL.
+	DialectPostgreSQL                // PostgreSQL, detected by the native client or used through PGAdapter.
+)
+
+// quoteIdentifier quotes a table or column name so that reserved words such as Group, Order and Select
+// and names with special characters can be used in statements.
+func quoteIdentifier(name string) string {
+	r := strings.NewReplacer("\\", "\\\\", "`", "\\`")
+	return "`" + r.Replace(name) + "`"
+}
+
+// quote quotes a table or column name in the dialect.
+// The schema and the table of a table in a named schema are quoted separately, e.g. `sch1`.`Orders`.
+func (d Dialect) quote(name string) string {
+	if schema, table := splitTableName(name); schema != "" {
+		return d.quote(schema) + "." + d.quote(table)
+	}
+	if d == DialectPostgreSQL {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return quoteIdentifier(name)
+}
+
+// quoteAll quotes names and joins them with commas.
+func (d Dialect) quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = d.quote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// deleteAllSQL returns a PDML statement deleting all rows from the table.
+func (d Dialect) deleteAllSQL(table string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE true", d.quote(table))
+}
+
+// deleteMatchingSQL returns a PDML statement deleting the rows matching the filter, or all rows if the filter is empty.
+func (d Dialect) deleteMatchingSQL(table, filter string) string {
+	if filter == "" {
+		return d.deleteAllSQL(table)
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", d.quote(table), filter)
+}
+
+// countMatchingSQL returns a query counting the rows matching the filter, or all rows if the filter is empty.
+func (d Dialect) countMatchingSQL(table, filter string) string {
+	if filter == "" {
+		return d.countRowsSQL(table)
+	}
+	return fmt.Sprintf("%s WHERE %s", d.countRowsSQL(table), filter)
+}
+
+// countRowsSQL returns a query counting all rows in the table.
+func (d Dialect) countRowsSQL(table string) string {
+	return fmt.Sprintf("SELECT COUNT(*) as count FROM %s", d.quote(table))
+}
+
+// existsSQL returns a query returning 1 if the table has any rows, or 0 otherwise.
+func (d Dialect) existsSQL(table string) string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s LIMIT 1) AS t", d.quote(table))
+}
+
+// existsMatchingSQL returns a query returning 1 if the table has any rows matching the filter, or 0 otherwise.
+func (d Dialect) existsMatchingSQL(table, filter string) string {
+	if filter == "" {
+		return d.existsSQL(table)
+	}
+	return fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s WHERE %s LIMIT 1) AS t", d.quote(table), filter)
+}
+
+// keyBoundSQL returns a query returning the minimum or maximum value of the column by fn, MIN or MAX.
+func (d Dialect) keyBoundSQL(table, column, fn string) string {
+	return fmt.Sprintf("SELECT %s(%s) FROM %s", fn, d.quote(column), d.quote(table))
+}
+
+// countKeyRangeSQL returns a query counting rows whose INT64 column is in the range.
+func (d Dialect) countKeyRangeSQL(table, column string, r keyRange) string {
+	op := "<"
+	if r.inclusive {
+		op = "<="
+	}
+	return fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s >= %d AND %s %s %d", d.quote(table), d.quote(column), r.lo, d.quote(column), op, r.hi)
+}
+
+// selectKeysSQL returns a query reading up to limit primary keys from the table.
+func (d Dialect) selectKeysSQL(table string, keyColumns []string, limit int) string {
+	return fmt.Sprintf("SELECT %s FROM %s LIMIT %d", d.quoteAll(keyColumns), d.quote(table), limit)
+}
+
+// selectMatchingKeysSQL returns a query reading up to limit primary keys of the rows matching the filter,
+// or of any rows if the filter is empty.
+func (d Dialect) selectMatchingKeysSQL(table string, keyColumns []string, filter string, limit int) string {
+	if filter == "" {
+		return d.selectKeysSQL(table, keyColumns, limit)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s LIMIT %d", d.quoteAll(keyColumns), d.quote(table), filter, limit)
+}
+
+// latestRowSQL returns a query reading the columns of the row with the largest value of the column.
+func (d Dialect) latestRowSQL(table string, columns []string, orderBy string) string {
+	return fmt.Sprintf("SELECT %s FROM %s ORDER BY %s DESC LIMIT 1", d.quoteAll(columns), d.quote(table), d.quote(orderBy))
+}
+
+// writableColumnsSQL returns a query reading the names of the columns of the table which are not generated.
+// Generated columns cannot be written by mutations, so that they are excluded from rows inserted again.
+func (d Dialect) writableColumnsSQL(table string) string {
+	schema, name := splitTableName(table)
+	if schema == "" && d == DialectPostgreSQL {
+		schema = "public"
+	}
+	return fmt.Sprintf("SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = %s AND TABLE_NAME = %s AND IS_GENERATED = 'NEVER' ORDER BY ORDINAL_POSITION",
+		d.stringLiteral(schema), d.stringLiteral(name))
+}
+
+// placeholder returns the n-th (1-origin) query parameter in the dialect.
+func (d Dialect) placeholder(n int) string {
+	if d == DialectPostgreSQL {
+		return fmt.Sprintf("$%d", n)
+	}
+	return fmt.Sprintf("@p%d", n)
+}
+
+// deleteKeysSQL returns a DML statement deleting the rows with the keys and its parameters.
+// NULL key values are matched with IS NULL as they never equal to any parameter.
+func (d Dialect) deleteKeysSQL(table string, keyColumns []string, keys []Key) (string, []interface{}) {
+	var params []interface{}
+	conds := make([]string, len(keys))
+	for i, k := range keys {
+		cols := make([]string, len(keyColumns))
+		for j, c := range keyColumns {
+			if k[j] == nil {
+				cols[j] = fmt.Sprintf("%s IS NULL", d.quote(c))
+				continue
+			}
+			params = append(params, k[j])
+			cols[j] = fmt.Sprintf("%s = %s", d.quote(c), d.placeholder(len(params)))
+		}
+		conds[i] = "(" + strings.Join(cols, " AND ") + ")"
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", d.quote(table), strings.Join(conds, " OR ")), params
+}
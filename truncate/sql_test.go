@@ -0,0 +1,175 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatementGeneratorsQuoteIdentifiers(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		got  string
+		want string
+	}{
+		{
+			desc: "Delete from a reserved word",
+			got:  DialectGoogleSQL.deleteAllSQL("Group"),
+			want: "DELETE FROM `Group` WHERE true",
+		},
+		{
+			desc: "Count rows of a reserved word",
+			got:  DialectGoogleSQL.countRowsSQL("Select"),
+			want: "SELECT COUNT(*) as count FROM `Select`",
+		},
+		{
+			desc: "Select reserved key columns",
+			got:  DialectGoogleSQL.selectKeysSQL("Order", []string{"Group", "By"}, 100),
+			want: "SELECT `Group`, `By` FROM `Order` LIMIT 100",
+		},
+		{
+			desc: "Delete rows matching a filter",
+			got:  DialectGoogleSQL.deleteMatchingSQL("Order", `CreatedAt < "2020-01-01"`),
+			want: "DELETE FROM `Order` WHERE CreatedAt < \"2020-01-01\"",
+		},
+		{
+			desc: "Count rows matching a filter",
+			got:  DialectGoogleSQL.countMatchingSQL("Order", "Status = 1"),
+			want: "SELECT COUNT(*) as count FROM `Order` WHERE Status = 1",
+		},
+		{
+			desc: "Rows matching a filter exist",
+			got:  DialectGoogleSQL.existsMatchingSQL("Order", "Status = 1"),
+			want: "SELECT COUNT(*) FROM (SELECT 1 FROM `Order` WHERE Status = 1 LIMIT 1) AS t",
+		},
+		{
+			desc: "Select keys of rows matching a filter",
+			got:  DialectGoogleSQL.selectMatchingKeysSQL("Order", []string{"Id"}, "Status = 1", 100),
+			want: "SELECT `Id` FROM `Order` WHERE Status = 1 LIMIT 100",
+		},
+		{
+			desc: "Delete without a filter",
+			got:  DialectGoogleSQL.deleteMatchingSQL("Order", ""),
+			want: "DELETE FROM `Order` WHERE true",
+		},
+		{
+			desc: "Latest row ordered by a reserved word",
+			got:  DialectGoogleSQL.latestRowSQL("Order", []string{"Limit", "Applied"}, "Limit"),
+			want: "SELECT `Limit`, `Applied` FROM `Order` ORDER BY `Limit` DESC LIMIT 1",
+		},
+		{
+			desc: "Writable columns of a table",
+			got:  DialectGoogleSQL.writableColumnsSQL("SchemaMigrations"),
+			want: "SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = \"\" AND TABLE_NAME = \"SchemaMigrations\" AND IS_GENERATED = 'NEVER' ORDER BY ORDINAL_POSITION",
+		},
+		{
+			desc: "Writable columns of a table in the public schema",
+			got:  DialectPostgreSQL.writableColumnsSQL("schema_migrations"),
+			want: "SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = 'public' AND TABLE_NAME = 'schema_migrations' AND IS_GENERATED = 'NEVER' ORDER BY ORDINAL_POSITION",
+		},
+		{
+			desc: "Orphans between reserved words",
+			got:  DialectGoogleSQL.orphanCountSQL(Reference{Table: "Order", Columns: []string{"Group"}, ReferencedTable: "Select", ReferencedColumns: []string{"From"}}),
+			want: "SELECT COUNT(*) AS count FROM `Order` AS C WHERE C.`Group` IS NOT NULL AND NOT EXISTS (SELECT 1 FROM `Select` AS P WHERE P.`From` = C.`Group`)",
+		},
+		{
+			desc: "Non-ASCII and space",
+			got:  DialectGoogleSQL.selectKeysSQL("Caf\u00e9 Orders", []string{"\u756a\u53f7"}, 10),
+			want: "SELECT `\u756a\u53f7` FROM `Caf\u00e9 Orders` LIMIT 10",
+		},
+		{
+			desc: "Backquote and backslash are escaped",
+			got:  DialectGoogleSQL.deleteAllSQL("A`B\\C"),
+			want: "DELETE FROM `A\\`B\\\\C` WHERE true",
+		},
+		{
+			desc: "Table in a named schema",
+			got:  DialectGoogleSQL.deleteAllSQL("sch1.Order"),
+			want: "DELETE FROM `sch1`.`Order` WHERE true",
+		},
+		{
+			desc: "PostgreSQL delete from a reserved word",
+			got:  DialectPostgreSQL.deleteAllSQL("Group"),
+			want: `DELETE FROM "Group" WHERE true`,
+		},
+		{
+			desc: "PostgreSQL select reserved key columns",
+			got:  DialectPostgreSQL.selectKeysSQL("Order", []string{"Group", "Select"}, 100),
+			want: `SELECT "Group", "Select" FROM "Order" LIMIT 100`,
+		},
+		{
+			desc: "PostgreSQL double quote is escaped",
+			got:  DialectPostgreSQL.countRowsSQL(`A"B`),
+			want: `SELECT COUNT(*) as count FROM "A""B"`,
+		},
+		{
+			desc: "PostgreSQL latest row ordered by a reserved word",
+			got:  DialectPostgreSQL.latestRowSQL("Versions", []string{"Limit"}, "Limit"),
+			want: `SELECT "Limit" FROM "Versions" ORDER BY "Limit" DESC LIMIT 1`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got = %s, but want = %s", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteKeysSQL(t *testing.T) {
+	for _, tt := range []struct {
+		desc       string
+		dialect    Dialect
+		keys       []Key
+		wantSQL    string
+		wantParams []interface{}
+	}{
+		{
+			desc:       "PostgreSQL composite keys",
+			dialect:    DialectPostgreSQL,
+			keys:       []Key{{int64(1), "a"}, {int64(2), "b"}},
+			wantSQL:    `DELETE FROM "Order" WHERE ("Id" = $1 AND "Group" = $2) OR ("Id" = $3 AND "Group" = $4)`,
+			wantParams: []interface{}{int64(1), "a", int64(2), "b"},
+		},
+		{
+			desc:       "PostgreSQL NULL key value",
+			dialect:    DialectPostgreSQL,
+			keys:       []Key{{int64(1), nil}},
+			wantSQL:    `DELETE FROM "Order" WHERE ("Id" = $1 AND "Group" IS NULL)`,
+			wantParams: []interface{}{int64(1)},
+		},
+		{
+			desc:       "GoogleSQL composite key",
+			dialect:    DialectGoogleSQL,
+			keys:       []Key{{int64(1), "a"}},
+			wantSQL:    "DELETE FROM `Order` WHERE (`Id` = @p1 AND `Group` = @p2)",
+			wantParams: []interface{}{int64(1), "a"},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			gotSQL, gotParams := tt.dialect.deleteKeysSQL("Order", []string{"Id", "Group"}, tt.keys)
+			if gotSQL != tt.wantSQL {
+				t.Errorf("deleteKeysSQL() = %s, but want = %s", gotSQL, tt.wantSQL)
+			}
+			if diff := cmp.Diff(tt.wantParams, gotParams); diff != "" {
+				t.Errorf("deleteKeysSQL() params mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
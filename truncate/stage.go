@@ -0,0 +1,215 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stage is a group of tables deleted together. Tables of a stage start to be deleted
+// only after all tables of the previous stages have been deleted.
+type Stage struct {
+	// Name is shown in the plan and progress messages. Defaults to "stage N".
+	Name string `json:"name"`
+
+	// Tables are the tables deleted in the stage.
+	Tables []string `json:"tables"`
+
+	// WaitAfter is how long to wait after the stage completed before the next stage starts, e.g. "10m",
+	// so that consumers such as TTL or change stream readers can drain.
+	WaitAfter string `json:"waitAfter"`
+
+	// DrainChangeStream waits for a change stream to be quiet after the stage completed, before waitAfter.
+	DrainChangeStream *ChangeStreamDrain `json:"drainChangeStream"`
+}
+
+// validateStages returns an error if a stage has no tables, a table is in multiple stages or waitAfter is invalid.
+func validateStages(stages []Stage) error {
+	seen := map[string]string{}
+	for i, s := range stages {
+		name := stageName(stages, i)
+		if len(s.Tables) == 0 {
+			return fmt.Errorf("%s: tables must be specified", name)
+		}
+		for _, t := range s.Tables {
+			if other, ok := seen[t]; ok {
+				return fmt.Errorf("%s: %s is already in %s", name, t, other)
+			}
+			seen[t] = name
+		}
+		if s.WaitAfter != "" {
+			d, err := time.ParseDuration(s.WaitAfter)
+			if err != nil {
+				return fmt.Errorf("%s: invalid waitAfter: %v", name, err)
+			}
+			if d < 0 {
+				return fmt.Errorf("%s: waitAfter must not be negative: %s", name, s.WaitAfter)
+			}
+		}
+		if d := s.DrainChangeStream; d != nil {
+			if err := d.validate(); err != nil {
+				return fmt.Errorf("%s: invalid drainChangeStream: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// stageName returns the name of the i-th stage. Tables not in any stage are in the last, implicit stage.
+func stageName(stages []Stage, i int) string {
+	if i >= len(stages) {
+		return "remaining tables"
+	}
+	if stages[i].Name != "" {
+		return stages[i].Name
+	}
+	return fmt.Sprintf("stage %d", i+1)
+}
+
+// stagePlan gates deletions so that stages are deleted one by one, with a barrier between them.
+type stagePlan struct {
+	stages []Stage
+	// index is the stage index of each table.
+	index map[string]int
+
+	// current is the stage whose tables are being deleted.
+	current int
+	// Tables of the current stage are not started until barrierUntil.
+	barrierUntil time.Time
+	// onStage is called when the stage starts, with the time to wait before starting it.
+	onStage func(name string, wait time.Duration)
+	// drain blocks until the change stream is drained. Stages are not drained if nil.
+	drain func(ctx context.Context, d ChangeStreamDrain, since time.Time) error
+}
+
+// newStagePlan returns a stagePlan of the stages, or nil if no stages are declared.
+func newStagePlan(stages []Stage) *stagePlan {
+	if len(stages) == 0 {
+		return nil
+	}
+	index := map[string]int{}
+	for i, s := range stages {
+		for _, t := range s.Tables {
+			index[t] = i
+		}
+	}
+	return &stagePlan{stages: stages, index: index}
+}
+
+// stageOf returns the stage index of the table.
+func (p *stagePlan) stageOf(tableName string) int {
+	if i, ok := p.index[tableName]; ok {
+		return i
+	}
+	return len(p.stages)
+}
+
+// advance moves to the earliest stage which has tables not deleted yet,
+// and returns true if tables of the current stage can be started.
+// Moving to the next stage blocks while the change stream of the previous stage is drained.
+// Stages completed meanwhile, e.g. by cascade, are passed only after the barriers of all of them,
+// and the barrier of the current stage holds even if tables of later stages complete during it.
+func (p *stagePlan) advance(ctx context.Context, tables []*table, now time.Time) (bool, error) {
+	if now.Before(p.barrierUntil) {
+		return false, nil
+	}
+	next := len(p.stages)
+	for _, t := range flattenTables(tables) {
+		if i := p.stageOf(t.tableName); !t.deleter.isCompleted() && i < next {
+			next = i
+		}
+	}
+	if next > p.current {
+		var wait time.Duration
+		for i := p.current; i < next; i++ {
+			prev := p.stages[i]
+			if prev.DrainChangeStream != nil && p.drain != nil {
+				if err := p.drain(ctx, *prev.DrainChangeStream, now); err != nil {
+					return false, fmt.Errorf("failed to wait for %s after %s: %v", prev.DrainChangeStream, stageName(p.stages, i), err)
+				}
+				now = time.Now()
+			}
+			if prev.WaitAfter != "" {
+				d, _ := time.ParseDuration(prev.WaitAfter)
+				wait += d
+			}
+		}
+		p.current = next
+		p.barrierUntil = now.Add(wait)
+		if p.onStage != nil {
+			p.onStage(stageName(p.stages, next), wait)
+		}
+	}
+	return !now.Before(p.barrierUntil), nil
+}
+
+// filter returns the tables in the current or earlier stages.
+func (p *stagePlan) filter(tables []*table) []*table {
+	var filtered []*table
+	for _, t := range tables {
+		if p.stageOf(t.tableName) <= p.current {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// drainsChangeStreams returns true if any stage drains a change stream.
+func (p *stagePlan) drainsChangeStreams() bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.stages {
+		if s.DrainChangeStream != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns the tables of each stage, one stage per line.
+func (p *stagePlan) describe(schemas []*tableSchema) []string {
+	names := make([][]string, len(p.stages)+1)
+	for _, s := range schemas {
+		i := p.stageOf(s.tableName)
+		names[i] = append(names[i], s.tableName)
+	}
+	var lines []string
+	for i, n := range names {
+		if len(n) == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%s: %s", stageName(p.stages, i), strings.Join(n, ", "))
+		if i < len(p.stages) {
+			var waits []string
+			if d := p.stages[i].DrainChangeStream; d != nil {
+				waits = append(waits, fmt.Sprintf("then wait for %s", d))
+			}
+			if w := p.stages[i].WaitAfter; w != "" {
+				waits = append(waits, fmt.Sprintf("then wait %s", w))
+			}
+			if len(waits) > 0 {
+				line += fmt.Sprintf(" (%s)", strings.Join(waits, ", "))
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,191 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateStages(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		stages []Stage
+		want   string
+	}{
+		{
+			desc:   "Valid",
+			stages: []Stage{{Name: "events", Tables: []string{"A", "B"}, WaitAfter: "10m"}, {Tables: []string{"C"}}},
+		},
+		{
+			desc:   "No tables",
+			stages: []Stage{{Tables: []string{"A"}}, {}},
+			want:   "stage 2: tables must be specified",
+		},
+		{
+			desc:   "Table in multiple stages",
+			stages: []Stage{{Name: "events", Tables: []string{"A"}}, {Name: "aggregates", Tables: []string{"B", "A"}}},
+			want:   "aggregates: A is already in events",
+		},
+		{
+			desc:   "Invalid change stream drain",
+			stages: []Stage{{Tables: []string{"A"}, DrainChangeStream: &ChangeStreamDrain{ChangeStream: "Events", QuietPeriod: "0s"}}},
+			want:   "stage 1: invalid drainChangeStream: quietPeriod must be positive: 0s",
+		},
+		{
+			desc:   "Invalid waitAfter",
+			stages: []Stage{{Tables: []string{"A"}, WaitAfter: "-1m"}},
+			want:   "stage 1: waitAfter must not be negative: -1m",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var got string
+			if err := validateStages(tt.stages); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("validateStages() = %q, but want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStagePlanAdvance(t *testing.T) {
+	tableA := &table{tableName: "A", deleter: &deleter{}}
+	tableB := &table{tableName: "B", deleter: &deleter{}}
+	tableC := &table{tableName: "C", deleter: &deleter{}}
+	tables := []*table{tableA, tableB, tableC}
+
+	var started, drained []string
+	p := newStagePlan([]Stage{{Name: "events", Tables: []string{"B"}, WaitAfter: "1m", DrainChangeStream: &ChangeStreamDrain{ChangeStream: "Events", QuietPeriod: "1m"}}, {Tables: []string{"A"}}})
+	p.onStage = func(name string, wait time.Duration) { started = append(started, name) }
+	p.drain = func(ctx context.Context, d ChangeStreamDrain, since time.Time) error {
+		drained = append(drained, d.ChangeStream)
+		return nil
+	}
+	advance := func(now time.Time) bool {
+		ok, err := p.advance(context.Background(), tables, now)
+		if err != nil {
+			t.Fatalf("advance() failed: %v", err)
+		}
+		return ok
+	}
+	names := func(tables []*table) []string {
+		var names []string
+		for _, t := range tables {
+			names = append(names, t.tableName)
+		}
+		return names
+	}
+
+	now := time.Now()
+	if !advance(now) {
+		t.Fatalf("advance() = false in the first stage, but want = true")
+	}
+	if diff := cmp.Diff([]string{"B"}, names(p.filter(tables))); diff != "" {
+		t.Errorf("filter() mismatch in the first stage (-want +got):\n%s", diff)
+	}
+
+	tableB.deleter.status = statusCompleted
+	now = time.Now()
+	if advance(now) {
+		t.Errorf("advance() = true before waitAfter elapsed, but want = false")
+	}
+	if !advance(now.Add(2 * time.Minute)) {
+		t.Errorf("advance() = false after waitAfter elapsed, but want = true")
+	}
+	if diff := cmp.Diff([]string{"A", "B"}, names(p.filter(tables))); diff != "" {
+		t.Errorf("filter() mismatch in the second stage (-want +got):\n%s", diff)
+	}
+
+	tableA.deleter.status = statusCompleted
+	advance(now.Add(2 * time.Minute))
+	if diff := cmp.Diff([]string{"A", "B", "C"}, names(p.filter(tables))); diff != "" {
+		t.Errorf("filter() mismatch in the remaining tables (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"stage 2", "remaining tables"}, started); diff != "" {
+		t.Errorf("onStage() calls mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"Events"}, drained); diff != "" {
+		t.Errorf("drain() calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStagePlanDescribe(t *testing.T) {
+	p := newStagePlan([]Stage{
+		{Name: "events", Tables: []string{"B", "D"}, WaitAfter: "10m", DrainChangeStream: &ChangeStreamDrain{ChangeStream: "Events", QuietPeriod: "1m"}},
+		{Tables: []string{"A"}},
+	})
+	got := p.describe([]*tableSchema{{tableName: "A"}, {tableName: "B"}, {tableName: "C"}})
+	want := []string{"events: B (then wait for change stream Events to be quiet for 1m, then wait 10m)", "stage 2: A", "remaining tables: C"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("describe() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStagePlanAdvanceCascade(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		// completeAlbums completes the child in the second stage, e.g. by cascade, while the first stage is waiting.
+		completeAlbums time.Duration
+		wantOK         map[time.Duration]bool
+	}{
+		{
+			desc:           "Completed with the first stage",
+			completeAlbums: 0,
+			wantOK:         map[time.Duration]bool{30 * time.Second: false, 90 * time.Second: false, 2 * time.Minute: true},
+		},
+		{
+			desc:           "Completed during the barrier of the first stage",
+			completeAlbums: 30 * time.Second,
+			// The barrier of the second stage starts once the barrier of the first stage is passed at 90s.
+			wantOK: map[time.Duration]bool{30 * time.Second: false, 90 * time.Second: false, 2 * time.Minute: false, 150 * time.Second: true},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			singers := &table{tableName: "Singers", deleter: &deleter{}}
+			albums := &table{tableName: "Albums", deleter: &deleter{}, parentTableName: "Singers"}
+			singers.childTables = []*table{albums}
+			tables := []*table{singers}
+			p := newStagePlan([]Stage{{Tables: []string{"Singers"}, WaitAfter: "1m"}, {Tables: []string{"Albums"}, WaitAfter: "1m"}})
+
+			begin := time.Now()
+			if ok, err := p.advance(context.Background(), tables, begin); err != nil || !ok {
+				t.Fatalf("advance() = %t, %v in the first stage, but want = true", ok, err)
+			}
+			singers.deleter.status = statusCompleted
+			if tt.completeAlbums == 0 {
+				albums.deleter.status = statusCompleted
+			}
+			for _, elapsed := range []time.Duration{0, 30 * time.Second, 90 * time.Second, 2 * time.Minute, 150 * time.Second} {
+				if elapsed == tt.completeAlbums {
+					albums.deleter.status = statusCompleted
+				}
+				ok, err := p.advance(context.Background(), tables, begin.Add(elapsed))
+				if err != nil {
+					t.Fatalf("advance() failed: %v", err)
+				}
+				if want, checked := tt.wantOK[elapsed]; checked && ok != want {
+					t.Errorf("advance() after %s = %t, but want = %t", elapsed, ok, want)
+				}
+			}
+		})
+	}
+}
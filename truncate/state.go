@@ -0,0 +1,250 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Interval at which tables completed during a run are written to the state file.
+const stateInterval = time.Second
+
+// stateFileMu serializes updates of state files by runs of multiple databases in this process.
+var stateFileMu sync.Mutex
+
+// runState is the content of the state file, keeping the tables completed by the last unfinished run of each database,
+// so that the run can be resumed without deleting them again.
+type runState struct {
+	Databases map[string]*databaseState `json:"databases"`
+}
+
+// databaseState is the progress of the last unfinished run against a database.
+type databaseState struct {
+	RunID     string    `json:"runId"`
+	StartedAt time.Time `json:"startedAt"`
+	// Completed are the tables whose matching rows have all been deleted, keyed by table name.
+	Completed map[string]completedTable `json:"completed"`
+}
+
+// completedTable is a table completed by a run.
+type completedTable struct {
+	// Filter and Params are the condition of the deleted rows and its parameters. The table is deleted again if they have changed.
+	Filter      string            `json:"filter,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	CompletedAt time.Time         `json:"completedAt"`
+}
+
+// sameFilter returns true if the table was completed with the same filter and parameters.
+func (c completedTable) sameFilter(t *tableSchema) bool {
+	if c.Filter != t.filter || len(c.Params) != len(t.params) {
+		return false
+	}
+	for name, value := range t.params {
+		if v, ok := c.Params[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// loadRunState reads the state file at the path. A missing file is an empty state.
+func loadRunState(path string) (*runState, error) {
+	s := &runState{Databases: map[string]*databaseState{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	if s.Databases == nil {
+		s.Databases = map[string]*databaseState{}
+	}
+	return s, nil
+}
+
+// save writes the state to the file at the path.
+func (s *runState) save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// skipCompleted returns the tables except those completed by the resumed run with the same filter,
+// and the names of the skipped tables.
+func skipCompleted(tables []*tableSchema, decisions []*planDecision, resumed *databaseState) ([]*tableSchema, []string) {
+	skip := map[string]bool{}
+	var kept []*tableSchema
+	for _, t := range tables {
+		if c, ok := resumed.Completed[t.tableName]; ok && c.sameFilter(t) {
+			skip[t.tableName] = true
+			continue
+		}
+		kept = append(kept, t)
+	}
+	for _, d := range decisions {
+		if d.included && skip[d.tableName] {
+			d.included = false
+			d.reason = fmt.Sprintf("completed by the resumed run %s", resumed.RunID)
+		}
+	}
+	var skipped []string
+	for name := range skip {
+		skipped = append(skipped, name)
+	}
+	sort.Strings(skipped)
+	return kept, skipped
+}
+
+// stateRecorder writes the tables completed by a run to the state file, so that the run can be resumed if interrupted.
+type stateRecorder struct {
+	path     string
+	database string
+
+	mu     sync.Mutex
+	state  *databaseState
+	tables []*table
+	// written is true once the state has been written, replacing the state of the previous run.
+	written bool
+}
+
+// newStateRecorder returns a recorder of the run. Tables completed by the resumed run are kept in the state,
+// so that they are still skipped if the run is interrupted again.
+func newStateRecorder(path, database, runID string, started time.Time, resumed *databaseState) *stateRecorder {
+	state := &databaseState{RunID: runID, StartedAt: started, Completed: map[string]completedTable{}}
+	if resumed != nil {
+		for name, c := range resumed.Completed {
+			state.Completed[name] = c
+		}
+	}
+	return &stateRecorder{path: path, database: database, state: state}
+}
+
+// watch adds tables whose completion is recorded, e.g. tables added by re-planning. It is a no-op for a nil recorder.
+func (r *stateRecorder) watch(tables ...*table) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tables = append(r.tables, tables...)
+}
+
+// start records completed tables periodically until the context is done.
+// Only the first error is reported, not to flood the output while the file cannot be written.
+func (r *stateRecorder) start(ctx context.Context, workers *workerGroup, out Output) {
+	workers.spawn("state recorder", func() {
+		var failed bool
+		for {
+			select {
+			case <-time.After(stateInterval):
+				if err := r.record(); err != nil && !failed {
+					out.warnf("%v", err)
+					failed = true
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// record writes the state if it has not been written yet or any table has been completed since the last write.
+// It is a no-op for a nil recorder.
+func (r *stateRecorder) record() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changed bool
+	for _, t := range r.tables {
+		d := t.deleter.snapshot()
+		if _, ok := r.state.Completed[t.tableName]; ok || d.status != statusCompleted || d.err != nil {
+			continue
+		}
+		completedAt := d.completedAt
+		if completedAt.IsZero() {
+			completedAt = time.Now()
+		}
+		r.state.Completed[t.tableName] = completedTable{Filter: t.filter, Params: t.params, CompletedAt: completedAt}
+		changed = true
+	}
+	if r.written && !changed {
+		return nil
+	}
+	if err := r.update(r.state); err != nil {
+		return err
+	}
+	r.written = true
+	return nil
+}
+
+// forget removes the tables from the state, e.g. tables in which rows remain after the run, to delete them again on resume.
+func (r *stateRecorder) forget(names []string) error {
+	if r == nil || len(names) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		delete(r.state.Completed, name)
+	}
+	return r.update(r.state)
+}
+
+// clear removes the state of the database since the run has finished and there is nothing to resume.
+func (r *stateRecorder) clear() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(nil)
+}
+
+// update sets the state of the database in the state file, or removes it if state is nil.
+// The file is read again, so that states of other databases written in the meantime are kept.
+func (r *stateRecorder) update(state *databaseState) error {
+	stateFileMu.Lock()
+	defer stateFileMu.Unlock()
+	s, err := loadRunState(r.path)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		delete(s.Databases, r.database)
+	} else {
+		s.Databases[r.database] = state
+	}
+	if err := s.save(r.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSkipCompleted(t *testing.T) {
+	tables := []*tableSchema{{tableName: "Singers"}, {tableName: "Albums"}, {tableName: "Events", filter: "CreatedAt < @cutoff"}}
+	decisions := []*planDecision{{tableName: "Singers", included: true}, {tableName: "Albums", included: true}, {tableName: "Events", included: true}}
+	resumed := &databaseState{RunID: "r1", Completed: map[string]completedTable{
+		"Singers": {},
+		// Rows of a different condition are deleted again.
+		"Events": {Filter: "CreatedAt < @other"},
+	}}
+	kept, skipped := skipCompleted(tables, decisions, resumed)
+
+	var gotKept []string
+	for _, t := range kept {
+		gotKept = append(gotKept, t.tableName)
+	}
+	if diff := cmp.Diff([]string{"Albums", "Events"}, gotKept); diff != "" {
+		t.Errorf("skipCompleted() tables mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"Singers"}, skipped); diff != "" {
+		t.Errorf("skipCompleted() skipped mismatch (-want +got):\n%s", diff)
+	}
+	if d := decisions[0]; d.included || d.reason != "completed by the resumed run r1" {
+		t.Errorf("skipCompleted() decision = %t, %q, but want = false, %q", d.included, d.reason, "completed by the resumed run r1")
+	}
+}
+
+func TestStateRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	other := &databaseState{RunID: "other", Completed: map[string]completedTable{"Users": {}}}
+	if err := (&runState{Databases: map[string]*databaseState{"other": other}}).save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	completedAt := started.Add(time.Minute)
+	resumed := &databaseState{RunID: "r1", Completed: map[string]completedTable{"Venues": {CompletedAt: started}}}
+	singers := &table{tableName: "Singers", deleter: &deleter{status: statusCompleted, completedAt: completedAt}}
+	albums := &table{tableName: "Albums", filter: "Id > 0", deleter: &deleter{status: statusCompleted, completedAt: completedAt}}
+	songs := &table{tableName: "Songs", deleter: &deleter{status: statusDeleting}}
+	r := newStateRecorder(path, "d", "r2", started, resumed)
+	r.watch(singers, albums, songs)
+	if err := r.record(); err != nil {
+		t.Fatalf("record() = %v", err)
+	}
+	if err := r.forget([]string{"Albums"}); err != nil {
+		t.Fatalf("forget() = %v", err)
+	}
+
+	state, err := loadRunState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*databaseState{
+		"other": other,
+		"d": {RunID: "r2", StartedAt: started, Completed: map[string]completedTable{
+			"Singers": {CompletedAt: completedAt},
+			"Venues":  {CompletedAt: started},
+		}},
+	}
+	if diff := cmp.Diff(want, state.Databases); diff != "" {
+		t.Errorf("state mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := r.clear(); err != nil {
+		t.Fatalf("clear() = %v", err)
+	}
+	if state, err = loadRunState(path); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]*databaseState{"other": other}, state.Databases); diff != "" {
+		t.Errorf("clear() state mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	b := &fakeBackend{
+		tables: []TableInfo{{Name: "Singers"}, {Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"}, {Name: "Venues"}},
+		rows:   map[string]int64{"Singers": 10, "Albums": 20, "Venues": 30},
+	}
+	// Venues was completed by the interrupted run, and rows inserted since then are kept.
+	interrupted := &databaseState{RunID: "r1", Completed: map[string]completedTable{"Venues": {}}}
+	if err := (&runState{Databases: map[string]*databaseState{b.DatabaseName(): interrupted}}).save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithQuiet(true), WithStateFile(path, true), WithOutput(Output{Writer: &buf})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if want := "Skipped 1 tables completed by the run: Venues"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Run() output = %q, but want to contain %q", buf.String(), want)
+	}
+	if diff := cmp.Diff(map[string]int64{"Singers": 0, "Albums": 0, "Venues": 30}, b.rows); diff != "" {
+		t.Errorf("Run() rows mismatch (-want +got):\n%s", diff)
+	}
+	// The state is removed since the run has finished.
+	state, err := loadRunState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Databases) != 0 {
+		t.Errorf("state after the run = %v, but want empty", state.Databases)
+	}
+}
+
+func TestRunResumeAllCompleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	b := &fakeBackend{tables: []TableInfo{{Name: "Singers"}}, rows: map[string]int64{"Singers": 10}}
+	interrupted := &databaseState{RunID: "r1", Completed: map[string]completedTable{"Singers": {}}}
+	if err := (&runState{Databases: map[string]*databaseState{b.DatabaseName(): interrupted}}).save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithQuiet(true), WithStateFile(path, true), WithOutput(Output{Writer: &buf})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if want := "Nothing to do"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Run() output = %q, but want to contain %q", buf.String(), want)
+	}
+	// The finished run is removed, so that the next run does not warn of it.
+	state, err := loadRunState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Databases) != 0 {
+		t.Errorf("state after the run = %v, but want empty", state.Databases)
+	}
+}
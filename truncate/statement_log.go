@@ -0,0 +1,81 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/spanner"
+)
+
+// statementLogger logs each statement once per table in verbose mode.
+type statementLogger struct {
+	out        Output
+	showParams bool
+	redaction  *Redaction
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newStatementLogger(out Output, showParams bool, redaction *Redaction) *statementLogger {
+	return &statementLogger{out: out, showParams: showParams, redaction: redaction, seen: map[string]bool{}}
+}
+
+// log logs the statement unless it has been logged for the table. A nil logger logs nothing.
+func (l *statementLogger) log(table string, stmt spanner.Statement) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := table + "\x00" + stmt.SQL
+	if l.seen[key] {
+		return
+	}
+	l.seen[key] = true
+	l.out.logf("%s: %s", table, formatStatement(stmt, l.showParams, l.redaction))
+}
+
+// formatStatement returns the SQL and the parameters of the statement.
+// Values of parameters are redacted unless showParams, and values of sensitive parameters are always redacted.
+func formatStatement(stmt spanner.Statement, showParams bool, redaction *Redaction) string {
+	if len(stmt.Params) == 0 {
+		return stmt.SQL
+	}
+
+	names := make([]string, 0, len(stmt.Params))
+	for name := range stmt.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]string, len(names))
+	for i, name := range names {
+		value := stmt.Params[name]
+		// Parameters of filters are bound without their types, and shown as their strings.
+		if v, ok := value.(spanner.GenericColumnValue); ok && v.Type == nil {
+			value = v.Value.GetStringValue()
+		}
+		params[i] = fmt.Sprintf("@%s=%s", name, redaction.format(name, value, showParams))
+	}
+	return fmt.Sprintf("%s [%s]", stmt.SQL, strings.Join(params, ", "))
+}
@@ -0,0 +1,75 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatementLogger(t *testing.T) {
+	stmt := spanner.Statement{
+		SQL:    "DELETE FROM `Users` WHERE CreatedAt < @before AND Status = @status",
+		Params: map[string]interface{}{"status": "inactive", "before": "2020-01-01"},
+	}
+
+	for _, tt := range []struct {
+		desc       string
+		showParams bool
+		redaction  *Redaction
+		want       string
+	}{
+		{
+			desc: "Redacted",
+			want: "Users: DELETE FROM `Users` WHERE CreatedAt < @before AND Status = @status [@before=<redacted>, @status=<redacted>]\n" +
+				"Albums: SELECT COUNT(*) as count FROM `Albums`\n",
+		},
+		{
+			desc:       "Show params",
+			showParams: true,
+			want: "Users: DELETE FROM `Users` WHERE CreatedAt < @before AND Status = @status [@before=2020-01-01, @status=inactive]\n" +
+				"Albums: SELECT COUNT(*) as count FROM `Albums`\n",
+		},
+		{
+			desc:       "Sensitive params redacted even if shown",
+			showParams: true,
+			redaction:  &Redaction{Columns: []string{"*STATUS*"}},
+			want: "Users: DELETE FROM `Users` WHERE CreatedAt < @before AND Status = @status [@before=2020-01-01, @status=<redacted>]\n" +
+				"Albums: SELECT COUNT(*) as count FROM `Albums`\n",
+		},
+		{
+			desc:      "Hashed",
+			redaction: &Redaction{Hash: true},
+			want: "Users: DELETE FROM `Users` WHERE CreatedAt < @before AND Status = @status [@before=sha256:5ac7de6274ec, @status=sha256:d1022618b99a]\n" +
+				"Albums: SELECT COUNT(*) as count FROM `Albums`\n",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := newStatementLogger(Output{Writer: &buf}, tt.showParams, tt.redaction)
+			l.log("Users", stmt)
+			l.log("Users", stmt)
+			l.log("Albums", spanner.NewStatement(DialectGoogleSQL.countRowsSQL("Albums")))
+			if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+				t.Errorf("log() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// redactedValue replaces values removed from support bundles.
+const redactedValue = "<redacted>"
+
+// stringLiteralPattern matches string literals in SQL, which may hold sensitive values.
+var stringLiteralPattern = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"`)
+
+// WriteSupportBundle writes a gzipped tarball for bug reports with the redacted config, the plan and its explanation,
+// the schema of the database, the versions of the binary and its dependencies, and diagnoses of the environment.
+// Failures to collect a part, e.g. because the database is not accessible, are written to log.txt in the bundle
+// instead of failing, since the bundle is most needed when something is broken. No rows are read.
+func WriteSupportBundle(ctx context.Context, projectID, instanceID, databaseID string, w io.Writer, opts ...Option) error {
+	o := NewOptions(opts...)
+	var log bytes.Buffer
+	logf := func(format string, a ...interface{}) {
+		fmt.Fprintf(&log, "%s %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, a...))
+	}
+	// Messages and warnings while collecting the parts are written to the log.
+	opts = append(opts, WithOutput(Output{Writer: &log}))
+
+	files := []bundleFile{{name: "versions.txt", content: versions()}}
+	var diagnoses bytes.Buffer
+	if _, err := WriteDiagnoses(&diagnoses, DiagnoseEnvironment()); err != nil {
+		logf("failed to write diagnoses: %v", err)
+	}
+	files = append(files, bundleFile{name: "diagnostics.txt", content: diagnoses.Bytes()})
+	if o.Config != nil {
+		if b, err := indentJSON(redactConfig(o.Config)); err != nil {
+			logf("failed to write config: %v", err)
+		} else {
+			files = append(files, bundleFile{name: "config.json", content: b})
+		}
+	}
+
+	logf("building the plan of %s", databaseID)
+	if plan, err := BuildPlan(ctx, projectID, instanceID, databaseID, opts...); err != nil {
+		logf("failed to build the plan: %v", err)
+	} else if b, err := indentJSON(plan); err != nil {
+		logf("failed to write the plan: %v", err)
+	} else {
+		files = append(files, bundleFile{name: "plan.json", content: b})
+	}
+	var explain bytes.Buffer
+	if err := ExplainPlan(ctx, projectID, instanceID, databaseID, append(opts, WithOutput(Output{Writer: &explain}))...); err != nil {
+		logf("failed to explain the plan: %v", err)
+	}
+	files = append(files, bundleFile{name: "explain.txt", content: explain.Bytes()})
+
+	logf("dumping the schema of %s", databaseID)
+	if dump, err := DumpSchema(ctx, projectID, instanceID, databaseID, false, opts...); err != nil {
+		logf("failed to dump the schema: %v", err)
+	} else {
+		var schema bytes.Buffer
+		if err := dump.Write(&schema); err != nil {
+			logf("failed to write the schema: %v", err)
+		}
+		files = append(files, bundleFile{name: "schema.json", content: schema.Bytes()})
+	}
+	files = append(files, bundleFile{name: "log.txt", content: log.Bytes()})
+	return writeTarball(w, files)
+}
+
+// bundleFile is a file in a support bundle.
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+// writeTarball writes the files into a gzipped tarball.
+func writeTarball(w io.Writer, files []bundleFile) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	now := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), ModTime: now}); err != nil {
+			return fmt.Errorf("failed to write support bundle: %v", err)
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return fmt.Errorf("failed to write support bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write support bundle: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write support bundle: %v", err)
+	}
+	return nil
+}
+
+// indentJSON returns the indented JSON of the value. HTML characters are not escaped, e.g. in "<redacted>".
+func indentJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// versions returns the versions of the binary, Go and the dependencies.
+func versions() []byte {
+	build := currentBuild()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "spanner-truncate %s\n%s %s/%s, cgo: %t\n", build.version, runtime.Version(), build.goos, build.goarch, build.cgo)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			fmt.Fprintf(&buf, "%s %s\n", dep.Path, dep.Version)
+		}
+	}
+	return buf.Bytes()
+}
+
+// redactConfig returns a copy of the config without values which may be sensitive:
+// string literals in where conditions, and the command of the schema version hook.
+func redactConfig(c *Config) *Config {
+	redacted := *c
+	if c.Where != nil {
+		redacted.Where = make(map[string]string, len(c.Where))
+		for table, cond := range c.Where {
+			redacted.Where[table] = stringLiteralPattern.ReplaceAllString(cond, "'"+redactedValue+"'")
+		}
+	}
+	if c.SchemaVersion != nil && c.SchemaVersion.Hook != "" {
+		sv := *c.SchemaVersion
+		sv.Hook = redactedValue
+		redacted.SchemaVersion = &sv
+	}
+	return &redacted
+}
@@ -0,0 +1,93 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteSupportBundle(t *testing.T) {
+	b := &fakeBackend{tables: []TableInfo{{Name: "Singers"}, {Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"}}}
+	config := &Config{Where: map[string]string{"Singers": "Email = 'alice@example.com' AND Status = 2"}}
+	var buf bytes.Buffer
+	if err := WriteSupportBundle(context.Background(), "p", "i", "d", &buf, WithBackend(b), WithConfig(config)); err != nil {
+		t.Fatalf("WriteSupportBundle() failed: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	var names []string
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		files[h.Name] = string(content)
+	}
+	want := []string{"versions.txt", "diagnostics.txt", "config.json", "plan.json", "explain.txt", "schema.json", "log.txt"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("WriteSupportBundle() files mismatch (-want +got):\n%s", diff)
+	}
+	if strings.Contains(files["config.json"], "alice@example.com") || !strings.Contains(files["config.json"], "Email = '<redacted>' AND Status = 2") {
+		t.Errorf("config.json = %s, but want the string literal redacted", files["config.json"])
+	}
+	if !strings.Contains(files["plan.json"], `"Albums"`) {
+		t.Errorf("plan.json = %s, but want the tables", files["plan.json"])
+	}
+	if strings.Contains(files["log.txt"], "failed") {
+		t.Errorf("log.txt = %s, but want no failures", files["log.txt"])
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	config := &Config{
+		Where:         map[string]string{"Users": `Name = "it's" OR Note = 'say \'hi\''`},
+		SchemaVersion: &SchemaVersion{Table: "SchemaVersion", Hook: "curl -H 'Authorization: token' https://example.com"},
+	}
+	got := redactConfig(config)
+	if want := "Name = '<redacted>' OR Note = '<redacted>'"; got.Where["Users"] != want {
+		t.Errorf("redactConfig() where = %q, but want = %q", got.Where["Users"], want)
+	}
+	if got.SchemaVersion.Hook != redactedValue || got.SchemaVersion.Table != "SchemaVersion" {
+		t.Errorf("redactConfig() schema version = %+v, but want the hook redacted", got.SchemaVersion)
+	}
+	// The original config is untouched.
+	if config.SchemaVersion.Hook == redactedValue || strings.Contains(config.Where["Users"], redactedValue) {
+		t.Errorf("redactConfig() modified the original config: %+v", config)
+	}
+}
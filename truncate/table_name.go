@@ -0,0 +1,97 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// splitTableName splits a table name into its schema and table.
+// The schema of a table in the default schema is empty.
+func splitTableName(name string) (string, string) {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// qualifiedName returns the name of the table qualified with the schema unless it is in the default schema.
+func qualifiedName(schema, table string) string {
+	if schema == "" {
+		return table
+	}
+	return schema + "." + table
+}
+
+// resolveTableNames resolves names given by the user into the names of the tables.
+//   - "sch1.Orders" is the table Orders in the named schema sch1.
+//   - "Orders" is the table Orders in the default schema. If the default schema does not have it,
+//     it is the table Orders in a named schema, and an error is returned if more than one named schema has it.
+//   - "sch1.*" is all tables in the named schema sch1, and "*.Orders" is the table Orders in any schema.
+//   - Schemas and tables are glob patterns, e.g. "Events_*" is all tables prefixed with Events_ in the default schema.
+//
+// Names which do not match any table are returned as is, so that they are reported as not found.
+func resolveTableNames(all []*tableSchema, names []string, key func(string) string) ([]string, error) {
+	var resolved []string
+	for _, name := range names {
+		schema, table := splitTableName(key(name))
+		if _, err := path.Match(schema, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", name, err)
+		}
+		if _, err := path.Match(table, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", name, err)
+		}
+		var matched []string
+		for _, t := range all {
+			if s, n := splitTableName(key(t.tableName)); globMatch(schema, s) && globMatch(table, n) {
+				matched = append(matched, t.tableName)
+			}
+		}
+		if len(matched) == 0 && !strings.Contains(name, ".") {
+			// Fall back to named schemas if the default schema does not have the table.
+			for _, t := range all {
+				if s, n := splitTableName(key(t.tableName)); s != "" && globMatch(table, n) {
+					matched = append(matched, t.tableName)
+				}
+			}
+			// A pattern may match tables in multiple schemas, but a name must identify a single table.
+			if len(matched) > 1 && !isGlobPattern(table) {
+				sort.Strings(matched)
+				return nil, fmt.Errorf("table %s is ambiguous, which is found in multiple schemas: %s; qualify it with the schema, e.g. %s", name, strings.Join(matched, ", "), matched[0])
+			}
+		}
+		if len(matched) == 0 {
+			matched = []string{name}
+		}
+		resolved = append(resolved, matched...)
+	}
+	return resolved, nil
+}
+
+// isGlobPattern returns true if the name has any special characters of glob patterns.
+func isGlobPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[\\")
+}
+
+// globMatch returns true if the name matches the glob pattern. The pattern must have been validated.
+func globMatch(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,74 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveTableNames(t *testing.T) {
+	all := []*tableSchema{
+		{tableName: "Orders"},
+		{tableName: "Singers"},
+		{tableName: "sch1.Orders"},
+		{tableName: "sch1.Items"},
+		{tableName: "sch2.Items"},
+		{tableName: "sch2.Payments"},
+		{tableName: "Events_2023"},
+		{tableName: "Events_2024"},
+		{tableName: "Orders_Audit"},
+		{tableName: "Singers_Audit"},
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		names   []string
+		want    []string
+		wantErr string
+	}{
+		{desc: "Default schema", names: []string{"Orders", "Singers"}, want: []string{"Orders", "Singers"}},
+		{desc: "Qualified name", names: []string{"sch1.Orders"}, want: []string{"sch1.Orders"}},
+		{desc: "Unique table in a named schema", names: []string{"Payments"}, want: []string{"sch2.Payments"}},
+		{desc: "Schema wildcard", names: []string{"*.Orders"}, want: []string{"Orders", "sch1.Orders"}},
+		{desc: "Table wildcard", names: []string{"sch2.*"}, want: []string{"sch2.Items", "sch2.Payments"}},
+		{desc: "Prefix pattern", names: []string{"Events_*"}, want: []string{"Events_2023", "Events_2024"}},
+		{desc: "Suffix pattern", names: []string{"*_Audit"}, want: []string{"Orders_Audit", "Singers_Audit"}},
+		{desc: "Pattern in a named schema", names: []string{"sch*.Items"}, want: []string{"sch1.Items", "sch2.Items"}},
+		{desc: "Pattern falling back to named schemas", names: []string{"It?ms"}, want: []string{"sch1.Items", "sch2.Items"}},
+		{desc: "Not found", names: []string{"Albums", "sch3.*", "Logs_*"}, want: []string{"Albums", "sch3.*", "Logs_*"}},
+		{desc: "Invalid pattern", names: []string{"Events_[0-9"}, wantErr: "invalid pattern Events_[0-9: syntax error in pattern"},
+		{desc: "Ambiguous", names: []string{"Items"}, wantErr: "table Items is ambiguous, which is found in multiple schemas: sch1.Items, sch2.Items; qualify it with the schema, e.g. sch1.Items"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := resolveTableNames(all, tt.names, func(name string) string { return name })
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("resolveTableNames() error = %v, but want = %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveTableNames() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("resolveTableNames() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -18,8 +18,14 @@ package truncate
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/spanner"
+	"golang.org/x/text/unicode/norm"
 )
 
 // deleteActionType is action type on parent delete.
@@ -41,6 +47,11 @@ type tableSchema struct {
 
 	// Foreign Key Reference.
 	referencedBy []string
+
+	// filter is the condition of rows to be deleted. All rows are deleted if empty.
+	filter string
+	// params are the values of the parameters of the filter keyed by their names.
+	params map[string]string
 }
 
 // indexSchema represents secondary index metadata.
@@ -54,121 +65,464 @@ type indexSchema struct {
 	parentTableName string
 }
 
-func fetchTableSchemas(ctx context.Context, client *spanner.Client, targetTables, excludeTables []string) ([]*tableSchema, error) {
-	// This query fetches the table metadata and relationships.
-	iter := client.Single().Query(ctx, spanner.NewStatement(`
-		WITH FKReferences AS (
-			SELECT CCU.TABLE_NAME AS Referenced, ARRAY_AGG(TC.TABLE_NAME) AS Referencing
-			FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS as TC
-			INNER JOIN INFORMATION_SCHEMA.CONSTRAINT_COLUMN_USAGE AS CCU ON TC.CONSTRAINT_NAME = CCU.CONSTRAINT_NAME
-			WHERE TC.TABLE_CATALOG = '' AND TC.TABLE_SCHEMA = '' AND TC.CONSTRAINT_TYPE = 'FOREIGN KEY' AND CCU.TABLE_CATALOG = '' AND CCU.TABLE_SCHEMA = ''
-			GROUP BY CCU.TABLE_NAME
-		)
-		SELECT T.TABLE_NAME, T.PARENT_TABLE_NAME, T.ON_DELETE_ACTION, IF(F.Referencing IS NULL, ARRAY<STRING>[], F.Referencing) AS referencedBy
-		FROM INFORMATION_SCHEMA.TABLES AS T
-		LEFT OUTER JOIN FKReferences AS F ON T.TABLE_NAME = F.Referenced
-		WHERE T.TABLE_CATALOG = "" AND T.TABLE_SCHEMA = "" AND T.TABLE_TYPE = "BASE TABLE"
-		ORDER BY T.TABLE_NAME ASC
-	`))
+// planDecision records why a table was included in or excluded from the deletion.
+type planDecision struct {
+	tableName string
+	included  bool
+	reason    string
+	// belowThreshold is true if the table was excluded since it has fewer rows than --min-rows.
+	belowThreshold bool
+	rows           int64
+	// missing is true if the table given by the user was not found in the database.
+	missing bool
+}
+
+// tableSelection is the criteria to select the tables to be deleted.
+type tableSelection struct {
+	targetTables  []string
+	excludeTables []string
+	// systemTables are excluded unless they are listed in targetTables by their exact names.
+	systemTables []string
+	// patternTargets are the targetTables matched only by glob patterns, which do not select systemTables.
+	patternTargets []string
+	// normalizeNames matches names in Unicode NFC, so that names in different normalization forms match.
+	normalizeNames bool
+	// tags are the tags of each table. Tables are selected by onlyTags and skipTags.
+	tags     map[string][]string
+	onlyTags []string
+	skipTags []string
+	// excludeRegexps always exclude the matching tables, even if they are selected otherwise.
+	excludeRegexps []*regexp.Regexp
+	// includeSchemas and excludeSchemas select tables by their named schemas.
+	includeSchemas []string
+	excludeSchemas []string
+	// namespace selects only tables prefixed with the namespace followed by an underscore if set.
+	namespace string
+	// largest keeps only the largest tables of the selected tables if positive.
+	largest int
+	// minRows excludes the selected tables with fewer rows if positive.
+	minRows int64
+	// ageColumns exclude the selected tables without their timestamp columns if set, for ageFlags.
+	ageColumns *timestampColumns
+	ageFlags   string
+	// lockTable and historyTable are always excluded since they hold the lock and the history of runs.
+	lockTable    string
+	historyTable string
+	// out receives warnings, e.g. when table sizes are not available to select the largest tables.
+	out Output
+}
+
+// tableSelection returns the criteria to select the tables to be deleted.
+func (o *Options) tableSelection() tableSelection {
+	return tableSelection{
+		targetTables:   o.TargetTables,
+		excludeTables:  o.ExcludeTables,
+		systemTables:   o.Config.systemTables(),
+		normalizeNames: o.NormalizeNames,
+		tags:           o.Config.tableTags(),
+		onlyTags:       o.OnlyTags,
+		skipTags:       o.SkipTags,
+		excludeRegexps: compileRegexps(append(append([]string{}, o.Config.excludeRegexps()...), o.ExcludeRegexps...)),
+		includeSchemas: o.IncludeSchemas,
+		excludeSchemas: o.ExcludeSchemas,
+		namespace:      o.Namespace,
+		largest:        o.Largest,
+		minRows:        o.MinRows,
+		ageColumns:     o.timestampColumns(),
+		ageFlags:       o.ageFlags(),
+		lockTable:      o.LockTable,
+		historyTable:   o.HistoryTable,
+		out:            o.messageOutput(),
+	}
+}
+
+// matchKey returns the key of the name to be matched with other names.
+func (s tableSelection) matchKey(name string) string {
+	if s.normalizeNames {
+		return norm.NFC.String(name)
+	}
+	return name
+}
+
+// resolvePatternTargets returns the tables matched by the glob patterns in names but not by the exact names,
+// so that a pattern such as "*" does not select tables which must be explicitly specified.
+func resolvePatternTargets(all []*tableSchema, names []string, key func(string) string) ([]string, error) {
+	var exact, patterns []string
+	for _, name := range names {
+		if isGlobPattern(name) {
+			patterns = append(patterns, name)
+		} else {
+			exact = append(exact, name)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	resolvedExact, err := resolveTableNames(all, exact, key)
+	if err != nil {
+		return nil, err
+	}
+	resolvedPatterns, err := resolveTableNames(all, patterns, key)
+	if err != nil {
+		return nil, err
+	}
+	explicit := make(map[string]bool, len(resolvedExact))
+	for _, name := range resolvedExact {
+		explicit[key(name)] = true
+	}
+	var matched []string
+	for _, name := range resolvedPatterns {
+		if !explicit[key(name)] {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// fetchTableSchemas fetches the tables to be deleted and the decisions how the tables were selected.
+func fetchTableSchemas(ctx context.Context, b Backend, sel tableSelection) ([]*tableSchema, []*planDecision, error) {
+	all, err := fetchAllTableSchemas(ctx, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sel.patternTargets, err = resolvePatternTargets(all, sel.targetTables, sel.matchKey); err != nil {
+		return nil, nil, fmt.Errorf("invalid --tables: %v", err)
+	}
+	if sel.targetTables, err = resolveTableNames(all, sel.targetTables, sel.matchKey); err != nil {
+		return nil, nil, fmt.Errorf("invalid --tables: %v", err)
+	}
+	if sel.excludeTables, err = resolveTableNames(all, sel.excludeTables, sel.matchKey); err != nil {
+		return nil, nil, fmt.Errorf("invalid --exclude-tables: %v", err)
+	}
+	tables, decisions := selectTables(all, sel)
+	if sel.ageColumns != nil {
+		has, err := tablesWithColumn(ctx, b, tables, sel.ageColumns)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables = selectByColumn(tables, decisions, sel.ageColumns, has, sel.ageFlags)
+	}
+	if sel.largest > 0 {
+		measure, format, err := measureTables(ctx, b, tables, sel.out)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables = selectLargest(tables, decisions, sel.largest, measure, format)
+	}
+	if sel.minRows > 0 {
+		counts, err := countTableRows(ctx, b, tables)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables = selectByMinRows(tables, decisions, sel.minRows, counts)
+	}
+	return tables, decisions, nil
+}
+
+// countTableRows counts rows of the tables.
+func countTableRows(ctx context.Context, b Backend, tables []*tableSchema) (map[string]int64, error) {
+	counts := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		// Use stale read to minimize the impact on the leader replica.
+		count, err := b.Count(ctx, spanner.NewStatement(b.Dialect().countRowsSQL(t.tableName)), 10*time.Second, PriorityUnspecified)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count rows in %s: %v", t.tableName, err)
+		}
+		counts[t.tableName] = count
+	}
+	return counts, nil
+}
+
+// selectByMinRows excludes the tables with fewer rows than minRows and updates their decisions.
+func selectByMinRows(tables []*tableSchema, decisions []*planDecision, minRows int64, counts map[string]int64) []*tableSchema {
+	below := map[string]bool{}
+	for _, d := range decisions {
+		if d.included && counts[d.tableName] < minRows {
+			below[d.tableName] = true
+			d.included = false
+			d.belowThreshold = true
+			d.rows = counts[d.tableName]
+			d.reason = fmt.Sprintf("below threshold (%s rows < --min-rows %s)", formatNumber(uint64(d.rows)), formatNumber(uint64(minRows)))
+		}
+	}
+
+	var kept []*tableSchema
+	for _, t := range tables {
+		if !below[t.tableName] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// measureTables returns the sizes of the tables and how to format them.
+// Row counts are used instead if table sizes statistics are not available, e.g. on the emulator.
+// It warns to out if the statistics cannot be read, since counting rows may take much longer.
+func measureTables(ctx context.Context, b Backend, tables []*tableSchema, out Output) (map[string]int64, func(int64) string, error) {
+	sizes, err := b.TableSizes(ctx)
+	if err != nil {
+		out.warnf("Table sizes are not available, so that the largest tables are selected by counting rows: %v", err)
+	} else if len(sizes) > 0 {
+		return sizes, func(n int64) string { return formatBytes(uint64(n)) }, nil
+	}
+	counts, err := countTableRows(ctx, b, tables)
+	if err != nil {
+		return nil, nil, err
+	}
+	return counts, func(n int64) string { return formatNumber(uint64(n)) + " rows" }, nil
+}
+
+// selectLargest keeps the n largest tables and updates the decisions of the others.
+func selectLargest(tables []*tableSchema, decisions []*planDecision, n int, measure map[string]int64, format func(int64) string) []*tableSchema {
+	sorted := make([]*tableSchema, len(tables))
+	copy(sorted, tables)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return measure[sorted[i].tableName] > measure[sorted[j].tableName]
+	})
+	keep := map[string]bool{}
+	for i := 0; i < n && i < len(sorted); i++ {
+		keep[sorted[i].tableName] = true
+	}
+
+	for _, d := range decisions {
+		if !d.included {
+			continue
+		}
+		size := format(measure[d.tableName])
+		if keep[d.tableName] {
+			d.reason += fmt.Sprintf(", among the %d largest tables (%s)", n, size)
+		} else {
+			d.included = false
+			d.reason = fmt.Sprintf("not among the %d largest tables (%s)", n, size)
+		}
+	}
+
+	var kept []*tableSchema
+	for _, t := range tables {
+		if keep[t.tableName] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
 
+// selectTables selects the tables to be deleted from all tables.
+func selectTables(all []*tableSchema, sel tableSelection) ([]*tableSchema, []*planDecision) {
 	truncateAll := true
-	targets := make(map[string]bool, len(targetTables))
-	excludes := make(map[string]bool, len(excludeTables))
-	if len(targetTables) > 0 || len(excludeTables) > 0 {
+	targets := make(map[string]bool, len(sel.targetTables))
+	excludes := make(map[string]bool, len(sel.excludeTables))
+	if len(sel.targetTables) > 0 || len(sel.excludeTables) > 0 {
 		truncateAll = false
-		for _, t := range targetTables {
-			targets[t] = true
+		for _, t := range sel.targetTables {
+			targets[sel.matchKey(t)] = true
 		}
-		for _, t := range excludeTables {
-			excludes[t] = true
+		for _, t := range sel.excludeTables {
+			excludes[sel.matchKey(t)] = true
 		}
 	}
 
+	systems := make(map[string]bool, len(sel.systemTables))
+	for _, t := range sel.systemTables {
+		systems[strings.ToLower(t)] = true
+	}
+	patternTargets := make(map[string]bool, len(sel.patternTargets))
+	for _, t := range sel.patternTargets {
+		patternTargets[sel.matchKey(t)] = true
+	}
+
+	tags := make(map[string][]string, len(sel.tags))
+	for name, t := range sel.tags {
+		tags[sel.matchKey(name)] = t
+	}
+
 	var tables []*tableSchema
-	if err := iter.Do(func(r *spanner.Row) error {
-		var (
-			tableName    string
-			parent       spanner.NullString
-			deleteAction spanner.NullString
-			referencedBy []string
-		)
-		if err := r.Columns(&tableName, &parent, &deleteAction, &referencedBy); err != nil {
-			return err
-		}
-
-		if !truncateAll {
-			if len(excludes) != 0 {
-				if _, ok := excludes[tableName]; ok {
-					return nil
-				}
+	var decisions []*planDecision
+	found := make(map[string]bool, len(all))
+	for _, schema := range all {
+		key := sel.matchKey(schema.tableName)
+		found[key] = true
+
+		decision := &planDecision{tableName: schema.tableName, included: true}
+		switch {
+		case sel.lockTable != "" && key == sel.matchKey(sel.lockTable):
+			decision.included = false
+			decision.reason = "lock table of spanner-truncate"
+		case sel.historyTable != "" && key == sel.matchKey(sel.historyTable):
+			decision.included = false
+			decision.reason = "history table of spanner-truncate"
+		case sel.matchExcludeRegexp(key) != nil:
+			decision.included = false
+			decision.reason = fmt.Sprintf("matched %s, excluded by --exclude-regex", sel.matchExcludeRegexp(key))
+		case systems[strings.ToLower(schema.tableName)] && (!targets[key] || patternTargets[key]):
+			decision.included = false
+			decision.reason = "table of a migration tool, which must be explicitly specified in --tables"
+		case truncateAll:
+			decision.reason = "all tables are targeted"
+		case len(excludes) != 0:
+			if excludes[key] {
+				decision.included = false
+				decision.reason = "excluded by --exclude-tables"
+			} else {
+				decision.reason = "not excluded by --exclude-tables"
+			}
+		default:
+			if targets[key] {
+				decision.reason = "matched --tables"
 			} else {
-				if _, ok := targets[tableName]; !ok {
-					return nil
-				}
+				decision.included = false
+				decision.reason = "not listed in --tables"
 			}
 		}
 
-		var parentTableName string
-		if parent.Valid {
-			parentTableName = parent.StringVal
+		if decision.included {
+			sel.selectByTags(decision, tags[key])
+		}
+		if decision.included {
+			sel.selectBySchema(decision)
+		}
+		if decision.included {
+			sel.selectByNamespace(decision)
 		}
 
-		var typ deleteActionType
-		if deleteAction.Valid {
-			switch deleteAction.StringVal {
-			case "CASCADE":
-				typ = deleteActionCascadeDelete
-			case "NO ACTION":
-				typ = deleteActionNoAction
+		decisions = append(decisions, decision)
+		if decision.included {
+			tables = append(tables, schema)
+		}
+	}
+
+	for _, names := range [][]string{sel.targetTables, sel.excludeTables} {
+		for _, name := range names {
+			if key := sel.matchKey(name); !found[key] {
+				decisions = append(decisions, &planDecision{tableName: name, reason: "not found in the database", missing: true})
+				found[key] = true
 			}
 		}
+	}
 
-		tables = append(tables, &tableSchema{
-			tableName:            tableName,
-			parentTableName:      parentTableName,
-			parentOnDeleteAction: typ,
-			referencedBy:         referencedBy,
-		})
-		return nil
-	}); err != nil {
-		return nil, err
+	return tables, decisions
+}
+
+// matchExcludeRegexp returns the first of excludeRegexps matching the name, or nil.
+func (s tableSelection) matchExcludeRegexp(name string) *regexp.Regexp {
+	for _, re := range s.excludeRegexps {
+		if re.MatchString(name) {
+			return re
+		}
 	}
+	return nil
+}
 
-	return tables, nil
+// compileRegexps compiles the patterns. Invalid patterns are ignored since they are reported by Validate and LoadConfig.
+func compileRegexps(patterns []string) []*regexp.Regexp {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		if re, err := regexp.Compile(p); err == nil {
+			res = append(res, re)
+		}
+	}
+	return res
 }
 
-func fetchIndexSchemas(ctx context.Context, client *spanner.Client) ([]*indexSchema, error) {
-	// This query fetches defined indexes.
-	iter := client.Single().Query(ctx, spanner.NewStatement(`
-		SELECT INDEX_NAME, TABLE_NAME, PARENT_TABLE_NAME FROM INFORMATION_SCHEMA.INDEXES
-		WHERE INDEX_TYPE = 'INDEX' AND TABLE_CATALOG = '' AND TABLE_SCHEMA = '';
-	`))
-
-	var indexes []*indexSchema
-	if err := iter.Do(func(r *spanner.Row) error {
-		var (
-			indexName     string
-			baseTableName string
-			parent        spanner.NullString
-		)
-		if err := r.Columns(&indexName, &baseTableName, &parent); err != nil {
-			return err
-		}
-
-		var parentTableName string
-		if parent.Valid {
-			parentTableName = parent.StringVal
-		}
-
-		indexes = append(indexes, &indexSchema{
-			indexName:       indexName,
-			baseTableName:   baseTableName,
-			parentTableName: parentTableName,
-		})
-		return nil
-	}); err != nil {
+// selectByTags excludes the table unless it has any of onlyTags, or if it has any of skipTags.
+func (s tableSelection) selectByTags(decision *planDecision, tags []string) {
+	if len(s.onlyTags) > 0 {
+		tag, ok := findTag(tags, s.onlyTags)
+		if !ok {
+			decision.included = false
+			decision.reason = "not tagged with any of --only-tag"
+			return
+		}
+		decision.reason += fmt.Sprintf(", tagged %s", tag)
+	}
+	if tag, ok := findTag(tags, s.skipTags); ok {
+		decision.included = false
+		decision.reason = fmt.Sprintf("tagged %s, skipped by --skip-tag", tag)
+	}
+}
+
+// selectBySchema excludes the table unless it is in any of includeSchemas, or if it is in any of excludeSchemas.
+func (s tableSelection) selectBySchema(decision *planDecision) {
+	schema, _ := splitTableName(s.matchKey(decision.tableName))
+	contains := func(schemas []string) bool {
+		for _, sch := range schemas {
+			if s.matchKey(sch) == schema {
+				return true
+			}
+		}
+		return false
+	}
+	if len(s.includeSchemas) > 0 {
+		if !contains(s.includeSchemas) {
+			decision.included = false
+			decision.reason = "not in any of --include-schema"
+			return
+		}
+		decision.reason += fmt.Sprintf(", in schema %s", schema)
+	}
+	if contains(s.excludeSchemas) {
+		decision.included = false
+		decision.reason = fmt.Sprintf("in schema %s, excluded by --exclude-schema", schema)
+	}
+}
+
+// findTag returns the first of tags contained in wanted.
+func findTag(tags, wanted []string) (string, bool) {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseDeleteAction parses ON_DELETE_ACTION of INFORMATION_SCHEMA.TABLES.
+func parseDeleteAction(action string) deleteActionType {
+	switch action {
+	case "CASCADE":
+		return deleteActionCascadeDelete
+	case "NO ACTION":
+		return deleteActionNoAction
+	}
+	return deleteActionUndefined
+}
+
+// fetchAllTableSchemas fetches all tables in the database.
+func fetchAllTableSchemas(ctx context.Context, b Backend) ([]*tableSchema, error) {
+	infos, err := b.Tables(ctx)
+	if err != nil {
 		return nil, err
 	}
+	tables := make([]*tableSchema, len(infos))
+	for i, info := range infos {
+		referencedBy := info.ReferencedBy
+		if referencedBy == nil {
+			referencedBy = []string{}
+		}
+		tables[i] = &tableSchema{
+			tableName:            info.Name,
+			parentTableName:      info.ParentName,
+			parentOnDeleteAction: parseDeleteAction(info.OnDeleteAction),
+			referencedBy:         referencedBy,
+		}
+	}
+	return tables, nil
+}
 
+// fetchIndexSchemas fetches all secondary indexes in the database.
+func fetchIndexSchemas(ctx context.Context, b Backend) ([]*indexSchema, error) {
+	infos, err := b.Indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]*indexSchema, len(infos))
+	for i, info := range infos {
+		indexes[i] = &indexSchema{
+			indexName:       info.Name,
+			baseTableName:   info.TableName,
+			parentTableName: info.ParentName,
+		}
+	}
 	return indexes, nil
 }
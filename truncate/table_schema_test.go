@@ -0,0 +1,374 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelectTables(t *testing.T) {
+	all := []*tableSchema{
+		{tableName: "A"},
+		{tableName: "B"},
+		{tableName: "C", parentTableName: "B"},
+		{tableName: "SchemaMigrations"},
+		{tableName: "Caf\u00e9"},
+		{tableName: "Order Items"},
+	}
+
+	for _, tt := range []struct {
+		desc           string
+		targetTables   []string
+		excludeTables  []string
+		systemTables   []string
+		normalizeNames bool
+		onlyTags       []string
+		skipTags       []string
+		lockTable      string
+		historyTable   string
+		wantTables     []string
+		wantDecisions  []string
+	}{
+		{
+			desc:          "All tables",
+			wantTables:    []string{"A", "B", "C", "Caf\u00e9", "Order Items"},
+			wantDecisions: []string{"A: true: all tables are targeted", "B: true: all tables are targeted", "C: true: all tables are targeted", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: true: all tables are targeted", "Order Items: true: all tables are targeted"},
+		},
+		{
+			desc:          "Target tables",
+			targetTables:  []string{"A", "C", "D", "SchemaMigrations"},
+			wantTables:    []string{"A", "C", "SchemaMigrations"},
+			wantDecisions: []string{"A: true: matched --tables", "B: false: not listed in --tables", "C: true: matched --tables", "SchemaMigrations: true: matched --tables", "Caf\u00e9: false: not listed in --tables", "Order Items: false: not listed in --tables", "D: false: not found in the database"},
+		},
+		{
+			desc:          "Exclude tables",
+			excludeTables: []string{"B"},
+			wantTables:    []string{"A", "C", "Caf\u00e9", "Order Items"},
+			wantDecisions: []string{"A: true: not excluded by --exclude-tables", "B: false: excluded by --exclude-tables", "C: true: not excluded by --exclude-tables", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: true: not excluded by --exclude-tables", "Order Items: true: not excluded by --exclude-tables"},
+		},
+		{
+			desc:          "System tables overridden",
+			systemTables:  []string{},
+			wantTables:    []string{"A", "B", "C", "SchemaMigrations", "Caf\u00e9", "Order Items"},
+			wantDecisions: []string{"A: true: all tables are targeted", "B: true: all tables are targeted", "C: true: all tables are targeted", "SchemaMigrations: true: all tables are targeted", "Caf\u00e9: true: all tables are targeted", "Order Items: true: all tables are targeted"},
+		},
+		{
+			desc:          "Non-ASCII and space in names",
+			targetTables:  []string{"Caf\u00e9", "Order Items"},
+			wantTables:    []string{"Caf\u00e9", "Order Items"},
+			wantDecisions: []string{"A: false: not listed in --tables", "B: false: not listed in --tables", "C: false: not listed in --tables", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: true: matched --tables", "Order Items: true: matched --tables"},
+		},
+		{
+			desc:          "Decomposed name without normalization",
+			targetTables:  []string{"Cafe\u0301"},
+			wantDecisions: []string{"A: false: not listed in --tables", "B: false: not listed in --tables", "C: false: not listed in --tables", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: false: not listed in --tables", "Order Items: false: not listed in --tables", "Cafe\u0301: false: not found in the database"},
+		},
+		{
+			desc:           "Decomposed name with normalization",
+			targetTables:   []string{"Cafe\u0301"},
+			normalizeNames: true,
+			wantTables:     []string{"Caf\u00e9"},
+			wantDecisions:  []string{"A: false: not listed in --tables", "B: false: not listed in --tables", "C: false: not listed in --tables", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: true: matched --tables", "Order Items: false: not listed in --tables"},
+		},
+		{
+			desc:          "Only tags",
+			onlyTags:      []string{"pii"},
+			wantTables:    []string{"A", "C"},
+			wantDecisions: []string{"A: true: all tables are targeted, tagged pii", "B: false: not tagged with any of --only-tag", "C: true: all tables are targeted, tagged pii", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: false: not tagged with any of --only-tag", "Order Items: false: not tagged with any of --only-tag"},
+		},
+		{
+			desc:          "Skip tags",
+			targetTables:  []string{"A", "B", "C"},
+			skipTags:      []string{"large", "reference"},
+			wantTables:    []string{"C"},
+			wantDecisions: []string{"A: false: tagged large, skipped by --skip-tag", "B: false: tagged reference, skipped by --skip-tag", "C: true: matched --tables", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: false: not listed in --tables", "Order Items: false: not listed in --tables"},
+		},
+		{
+			desc:          "Lock table",
+			targetTables:  []string{"A", "B"},
+			lockTable:     "B",
+			wantTables:    []string{"A"},
+			wantDecisions: []string{"A: true: matched --tables", "B: false: lock table of spanner-truncate", "C: false: not listed in --tables", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: false: not listed in --tables", "Order Items: false: not listed in --tables"},
+		},
+		{
+			desc:          "History table",
+			targetTables:  []string{"A", "C"},
+			historyTable:  "C",
+			wantTables:    []string{"A"},
+			wantDecisions: []string{"A: true: matched --tables", "B: false: not listed in --tables", "C: false: history table of spanner-truncate", "SchemaMigrations: false: table of a migration tool, which must be explicitly specified in --tables", "Caf\u00e9: false: not listed in --tables", "Order Items: false: not listed in --tables"},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			systemTables := DefaultSystemTables
+			if tt.systemTables != nil {
+				systemTables = tt.systemTables
+			}
+			tables, decisions := selectTables(all, tableSelection{
+				targetTables:   tt.targetTables,
+				excludeTables:  tt.excludeTables,
+				systemTables:   systemTables,
+				normalizeNames: tt.normalizeNames,
+				tags:           map[string][]string{"A": {"pii", "large"}, "B": {"reference"}, "C": {"pii"}},
+				onlyTags:       tt.onlyTags,
+				skipTags:       tt.skipTags,
+				lockTable:      tt.lockTable,
+				historyTable:   tt.historyTable,
+			})
+
+			var gotTables []string
+			for _, table := range tables {
+				gotTables = append(gotTables, table.tableName)
+			}
+			if diff := cmp.Diff(tt.wantTables, gotTables); diff != "" {
+				t.Errorf("selectTables() tables mismatch (-want +got):\n%s", diff)
+			}
+
+			var gotDecisions []string
+			for _, d := range decisions {
+				gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+			}
+			if diff := cmp.Diff(tt.wantDecisions, gotDecisions); diff != "" {
+				t.Errorf("selectTables() decisions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFetchTableSchemasSystemTables(t *testing.T) {
+	b := &fakeBackend{tables: []TableInfo{{Name: "Singers"}, {Name: "Albums"}, {Name: "SchemaMigrations"}}}
+	for _, tt := range []struct {
+		desc         string
+		targetTables []string
+		want         []string
+	}{
+		{desc: "Glob", targetTables: []string{"*"}, want: []string{"Albums", "Singers"}},
+		{desc: "Glob matching the system table", targetTables: []string{"Schema*"}, want: nil},
+		{desc: "Exact name", targetTables: []string{"SchemaMigrations"}, want: []string{"SchemaMigrations"}},
+		{desc: "Glob and exact name", targetTables: []string{"*", "SchemaMigrations"}, want: []string{"Albums", "SchemaMigrations", "Singers"}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			tables, _, err := fetchTableSchemas(context.Background(), b, tableSelection{targetTables: tt.targetTables, systemTables: DefaultSystemTables})
+			if err != nil {
+				t.Fatalf("fetchTableSchemas() failed: %v", err)
+			}
+			var got []string
+			for _, table := range tables {
+				got = append(got, table.tableName)
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("fetchTableSchemas() tables mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectLargest(t *testing.T) {
+	all := []*tableSchema{{tableName: "A"}, {tableName: "B"}, {tableName: "C"}, {tableName: "D"}}
+	tables, decisions := selectTables(all, tableSelection{excludeTables: []string{"D"}})
+	measure := map[string]int64{"A": 10, "B": 30, "C": 20, "D": 40}
+	tables = selectLargest(tables, decisions, 2, measure, func(n int64) string { return fmt.Sprintf("%d rows", n) })
+
+	var gotTables []string
+	for _, table := range tables {
+		gotTables = append(gotTables, table.tableName)
+	}
+	if diff := cmp.Diff([]string{"B", "C"}, gotTables); diff != "" {
+		t.Errorf("selectLargest() tables mismatch (-want +got):\n%s", diff)
+	}
+
+	var gotDecisions []string
+	for _, d := range decisions {
+		gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+	}
+	wantDecisions := []string{
+		"A: false: not among the 2 largest tables (10 rows)",
+		"B: true: not excluded by --exclude-tables, among the 2 largest tables (30 rows)",
+		"C: true: not excluded by --exclude-tables, among the 2 largest tables (20 rows)",
+		"D: false: excluded by --exclude-tables",
+	}
+	if diff := cmp.Diff(wantDecisions, gotDecisions); diff != "" {
+		t.Errorf("selectLargest() decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// noSizesBackend is a backend whose table sizes statistics cannot be read.
+type noSizesBackend struct {
+	*fakeBackend
+}
+
+func (b *noSizesBackend) TableSizes(ctx context.Context) (map[string]int64, error) {
+	return nil, errors.New("permission denied")
+}
+
+func TestMeasureTables(t *testing.T) {
+	tables := []*tableSchema{{tableName: "A"}, {tableName: "B"}}
+	for _, tt := range []struct {
+		desc        string
+		b           Backend
+		want        []string
+		wantWarning string
+	}{
+		{
+			desc: "Table sizes",
+			b:    &fakeBackend{sizes: map[string]int64{"A": 2048, "B": 1024}, rows: map[string]int64{"A": 1, "B": 2}},
+			want: []string{"A: 2.0 KiB", "B: 1.0 KiB"},
+		},
+		{
+			desc: "No statistics yet",
+			b:    &fakeBackend{rows: map[string]int64{"A": 1, "B": 2}},
+			want: []string{"A: 1 rows", "B: 2 rows"},
+		},
+		{
+			desc:        "Statistics not readable",
+			b:           &noSizesBackend{&fakeBackend{rows: map[string]int64{"A": 1, "B": 2}}},
+			want:        []string{"A: 1 rows", "B: 2 rows"},
+			wantWarning: "WARNING: Table sizes are not available, so that the largest tables are selected by counting rows: permission denied\n",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			measure, format, err := measureTables(context.Background(), tt.b, tables, Output{Writer: &buf})
+			if err != nil {
+				t.Fatalf("measureTables() failed: %v", err)
+			}
+			var got []string
+			for _, table := range tables {
+				got = append(got, fmt.Sprintf("%s: %s", table.tableName, format(measure[table.tableName])))
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("measureTables() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantWarning, buf.String()); diff != "" {
+				t.Errorf("measureTables() warning mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectByMinRows(t *testing.T) {
+	all := []*tableSchema{{tableName: "A"}, {tableName: "B"}, {tableName: "C"}}
+	tables, decisions := selectTables(all, tableSelection{excludeTables: []string{"C"}})
+	counts := map[string]int64{"A": 99, "B": 100, "C": 0}
+	tables = selectByMinRows(tables, decisions, 100, counts)
+
+	var gotTables []string
+	for _, table := range tables {
+		gotTables = append(gotTables, table.tableName)
+	}
+	if diff := cmp.Diff([]string{"B"}, gotTables); diff != "" {
+		t.Errorf("selectByMinRows() tables mismatch (-want +got):\n%s", diff)
+	}
+
+	var gotDecisions []string
+	for _, d := range decisions {
+		gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %t: %s", d.tableName, d.included, d.belowThreshold, d.reason))
+	}
+	wantDecisions := []string{
+		"A: false: true: below threshold (99 rows < --min-rows 100)",
+		"B: true: false: not excluded by --exclude-tables",
+		"C: false: false: excluded by --exclude-tables",
+	}
+	if diff := cmp.Diff(wantDecisions, gotDecisions); diff != "" {
+		t.Errorf("selectByMinRows() decisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSelectBySchema(t *testing.T) {
+	all := []*tableSchema{{tableName: "Singers"}, {tableName: "audit.Logs"}, {tableName: "reporting.Sales"}, {tableName: "sales.Orders"}}
+
+	for _, tt := range []struct {
+		desc           string
+		includeSchemas []string
+		excludeSchemas []string
+		wantDecisions  []string
+	}{
+		{
+			desc:           "Exclude schemas",
+			excludeSchemas: []string{"audit", "reporting"},
+			wantDecisions:  []string{"Singers: true: all tables are targeted", "audit.Logs: false: in schema audit, excluded by --exclude-schema", "reporting.Sales: false: in schema reporting, excluded by --exclude-schema", "sales.Orders: true: all tables are targeted"},
+		},
+		{
+			desc:           "Include schemas",
+			includeSchemas: []string{"sales", "audit"},
+			excludeSchemas: []string{"audit"},
+			wantDecisions:  []string{"Singers: false: not in any of --include-schema", "audit.Logs: false: in schema audit, excluded by --exclude-schema", "reporting.Sales: false: not in any of --include-schema", "sales.Orders: true: all tables are targeted, in schema sales"},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, decisions := selectTables(all, tableSelection{includeSchemas: tt.includeSchemas, excludeSchemas: tt.excludeSchemas})
+
+			var gotDecisions []string
+			for _, d := range decisions {
+				gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+			}
+			if diff := cmp.Diff(tt.wantDecisions, gotDecisions); diff != "" {
+				t.Errorf("selectTables() decisions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectByExcludeRegexp(t *testing.T) {
+	all := []*tableSchema{{tableName: "ConfigFlags"}, {tableName: "LookupCountries"}, {tableName: "Singers"}, {tableName: "sch1.ConfigItems"}}
+
+	for _, tt := range []struct {
+		desc          string
+		sel           tableSelection
+		wantDecisions []string
+	}{
+		{
+			desc: "All tables",
+			sel:  tableSelection{excludeRegexps: compileRegexps([]string{"^Config.*", "^Lookup.*"})},
+			wantDecisions: []string{
+				"ConfigFlags: false: matched ^Config.*, excluded by --exclude-regex",
+				"LookupCountries: false: matched ^Lookup.*, excluded by --exclude-regex",
+				"Singers: true: all tables are targeted",
+				"sch1.ConfigItems: true: all tables are targeted",
+			},
+		},
+		{
+			desc: "Excluded even if listed in --tables",
+			sel:  tableSelection{targetTables: []string{"ConfigFlags", "Singers"}, excludeRegexps: compileRegexps([]string{"Config"})},
+			wantDecisions: []string{
+				"ConfigFlags: false: matched Config, excluded by --exclude-regex",
+				"LookupCountries: false: not listed in --tables",
+				"Singers: true: matched --tables",
+				"sch1.ConfigItems: false: matched Config, excluded by --exclude-regex",
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, decisions := selectTables(all, tt.sel)
+
+			var gotDecisions []string
+			for _, d := range decisions {
+				gotDecisions = append(gotDecisions, fmt.Sprintf("%s: %t: %s", d.tableName, d.included, d.reason))
+			}
+			if diff := cmp.Diff(tt.wantDecisions, gotDecisions); diff != "" {
+				t.Errorf("selectTables() decisions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
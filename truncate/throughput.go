@@ -0,0 +1,102 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Number of samples shown in a throughput graph, one sample per second.
+const throughputSamples = 20
+
+// sparkLevels are characters of a sparkline from the lowest to the highest.
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// throughput records rows and mutations deleted per second of a table, to graph them over time.
+type throughput struct {
+	mu        sync.Mutex
+	rows      []float64
+	mutations []float64
+
+	lastRows      uint64
+	lastMutations int64
+	lastAt        time.Time
+}
+
+// sample records the rates since the previous sample from the total rows and mutations deleted so far.
+func (t *throughput) sample(rows uint64, mutations int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.lastAt.IsZero() {
+		if secs := now.Sub(t.lastAt).Seconds(); secs > 0 {
+			var rowRate, mutationRate float64
+			// Row counts may decrease temporarily since they are counted with stale reads.
+			if rows > t.lastRows {
+				rowRate = float64(rows-t.lastRows) / secs
+			}
+			if mutations > t.lastMutations {
+				mutationRate = float64(mutations-t.lastMutations) / secs
+			}
+			t.rows = appendSample(t.rows, rowRate)
+			t.mutations = appendSample(t.mutations, mutationRate)
+		}
+	}
+	t.lastRows, t.lastMutations, t.lastAt = rows, mutations, now
+}
+
+func appendSample(samples []float64, v float64) []float64 {
+	samples = append(samples, v)
+	if len(samples) > throughputSamples {
+		samples = samples[len(samples)-throughputSamples:]
+	}
+	return samples
+}
+
+// String returns graphs of rows/s and mutations/s with their latest rates.
+// Mutations are shown only if commit statistics have reported any.
+func (t *throughput) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.rows) == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("%s %s rows/s", sparkline(t.rows), formatNumber(uint64(t.rows[len(t.rows)-1])))
+	if t.lastMutations > 0 {
+		s += fmt.Sprintf(" %s %s mutations/s", sparkline(t.mutations), formatNumber(uint64(t.mutations[len(t.mutations)-1])))
+	}
+	return s
+}
+
+// sparkline returns a graph of the values scaled to the maximum value.
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	graph := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if max > 0 {
+			level = int(v / max * float64(len(sparkLevels)-1))
+		}
+		graph[i] = sparkLevels[level]
+	}
+	return string(graph)
+}
@@ -0,0 +1,68 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSparkline(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		values []float64
+		want   string
+	}{
+		{desc: "Empty", values: nil, want: ""},
+		{desc: "All zero", values: []float64{0, 0, 0}, want: "▁▁▁"},
+		{desc: "Scaled to maximum", values: []float64{0, 50, 100, 25}, want: "▁▄█▂"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := sparkline(tt.values); got != tt.want {
+				t.Errorf("sparkline(%v) = %q, but want = %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	var tp throughput
+	now := time.Now()
+	if got := tp.String(); got != "" {
+		t.Errorf("String() = %q before samples, but want = %q", got, "")
+	}
+
+	tp.sample(0, 0, now)
+	tp.sample(1000, 0, now.Add(time.Second))
+	tp.sample(3000, 0, now.Add(2*time.Second))
+	if got, want := tp.String(), "▄█ 2,000 rows/s"; got != want {
+		t.Errorf("String() = %q, but want = %q", got, want)
+	}
+
+	// Mutations are shown once commit statistics have reported any.
+	tp.sample(3500, 1500, now.Add(4*time.Second))
+	if got, want := tp.String(), "▄█▁ 250 rows/s ▁▁█ 750 mutations/s"; got != want {
+		t.Errorf("String() = %q, but want = %q", got, want)
+	}
+
+	for i := 0; i < 2*throughputSamples; i++ {
+		tp.sample(3500, 1500, now.Add(time.Duration(5+i)*time.Second))
+	}
+	if got := len(tp.rows); got != throughputSamples {
+		t.Errorf("throughput keeps %d samples, but want = %d", got, throughputSamples)
+	}
+}
@@ -18,6 +18,9 @@ package truncate
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
 )
 
 // formatNumber formats the number with thousands separators.
@@ -40,3 +43,38 @@ func formatNumber(num uint64) string {
 	}
 	return fmt.Sprintf("%d", parts[len(parts)-1]) + s
 }
+
+// formatBytes formats the number of bytes in binary units.
+// e.g. 1536 => "1.5 KiB"
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA estimates the remaining time from the progress so far, assuming the rate stays the same.
+// e.g. 250 of 1000 rows in 1m => "ETA 3m0s". It returns "" until any progress is made.
+func formatETA(done, total uint64, elapsed time.Duration) string {
+	if done == 0 || done > total || elapsed <= 0 {
+		return ""
+	}
+	remaining := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+	return fmt.Sprintf("ETA %s", remaining.Truncate(time.Second))
+}
+
+// writeFileAtomic writes the data to a temporary file renamed to the path,
+// so that an interrupted write does not leave a broken file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
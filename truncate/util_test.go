@@ -16,7 +16,10 @@
 
 package truncate
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestFormatNumber(t *testing.T) {
 	for _, tt := range []struct {
@@ -38,3 +41,41 @@ func TestFormatNumber(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatBytes(t *testing.T) {
+	for _, tt := range []struct {
+		input uint64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024 * 1024, "3.0 TiB"},
+	} {
+		if got := formatBytes(tt.input); got != tt.want {
+			t.Errorf("formatBytes(%d) = %s, but want = %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	for _, tt := range []struct {
+		done    uint64
+		total   uint64
+		elapsed time.Duration
+		want    string
+	}{
+		{0, 1000, time.Minute, ""},
+		{250, 1000, time.Minute, "ETA 3m0s"},
+		{999, 1000, 10 * time.Second, "ETA 0s"},
+		{1000, 1000, time.Minute, "ETA 0s"},
+		{1001, 1000, time.Minute, ""},
+		{500, 1000, 0, ""},
+	} {
+		if got := formatETA(tt.done, tt.total, tt.elapsed); got != tt.want {
+			t.Errorf("formatETA(%d, %d, %s) = %q, but want = %q", tt.done, tt.total, tt.elapsed, got, tt.want)
+		}
+	}
+}
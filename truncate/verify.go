@@ -0,0 +1,118 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"cloud.google.com/go/spanner"
+)
+
+// VerifyMode is how to verify that rows have been deleted after a run.
+type VerifyMode string
+
+const (
+	VerifyFull   VerifyMode = "full"   // Count all rows remaining in each table.
+	VerifySample VerifyMode = "sample" // Probe random key ranges for residual rows, trading certainty for speed.
+)
+
+// Number of ranges the key space of a table is split into by VerifySample.
+const verifySampleRanges = 1000
+
+// keyRange is a range of INT64 keys from lo to hi. hi is included only if inclusive.
+type keyRange struct {
+	lo, hi    int64
+	inclusive bool
+}
+
+// splitKeyRange splits keys from min to max (inclusive) into at most n ranges of the same width.
+func splitKeyRange(min, max int64, n int) []keyRange {
+	// Use unsigned arithmetic not to overflow even if the keys span the whole INT64.
+	span := uint64(max) - uint64(min)
+	width := span/uint64(n) + 1
+	var ranges []keyRange
+	for lo := min; ; {
+		if offset := uint64(lo) - uint64(min); width > span || offset > span-width {
+			return append(ranges, keyRange{lo: lo, hi: max, inclusive: true})
+		}
+		hi := lo + int64(width)
+		ranges = append(ranges, keyRange{lo: lo, hi: hi})
+		lo = hi
+	}
+}
+
+// verifySample returns the number of rows remaining in the table estimated from sampleSize random ranges
+// of the first primary key column, and whether the number is estimated.
+// The number is exact if no rows remain, or if the table cannot be sampled since the first key column is not INT64
+// or it has a filter, in which case the rows are counted in full with a warning.
+func (d *deleter) verifySample(ctx context.Context, keyColumns []KeyColumn, sampleSize int, rnd *rand.Rand, out Output) (uint64, bool, error) {
+	// Ranges of keys cannot tell whether the remaining rows match the filter.
+	if d.filter != "" {
+		out.warnf("%s has a filter, so that its remaining rows are counted in full instead of sampled.", d.tableName)
+		remained, err := d.verify(ctx)
+		return remained, false, err
+	}
+	dialect := d.backend.Dialect()
+	exists, err := d.count(ctx, spanner.NewStatement(dialect.existsSQL(d.tableName)), 0)
+	if err != nil {
+		if isTableNotFound(err) {
+			d.markDropped()
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if exists == 0 {
+		return 0, false, nil
+	}
+	if len(keyColumns) == 0 || keyColumns[0].SpannerType != "INT64" {
+		out.warnf("The first primary key column of %s is not INT64, so that its remaining rows are counted in full instead of sampled.", d.tableName)
+		remained, err := d.verify(ctx)
+		return remained, false, err
+	}
+
+	column := keyColumns[0].Name
+	min, err := d.count(ctx, spanner.NewStatement(dialect.keyBoundSQL(d.tableName, column, "MIN")), 0)
+	if err != nil {
+		return 0, false, err
+	}
+	max, err := d.count(ctx, spanner.NewStatement(dialect.keyBoundSQL(d.tableName, column, "MAX")), 0)
+	if err != nil {
+		return 0, false, err
+	}
+	ranges := splitKeyRange(min, max, verifySampleRanges)
+	if sampleSize >= len(ranges) {
+		remained, err := d.verify(ctx)
+		return remained, false, err
+	}
+
+	var sampled uint64
+	for _, i := range rnd.Perm(len(ranges))[:sampleSize] {
+		n, err := d.count(ctx, spanner.NewStatement(dialect.countKeyRangeSQL(d.tableName, column, ranges[i])), 0)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to count rows in a key range of %s: %v", d.tableName, err)
+		}
+		sampled += uint64(n)
+	}
+	estimated := sampled * uint64(len(ranges)) / uint64(sampleSize)
+	if estimated == 0 {
+		// At least one row remains.
+		estimated = 1
+	}
+	return estimated, true, nil
+}
@@ -0,0 +1,144 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitKeyRange(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		min, max int64
+		n        int
+		want     []keyRange
+	}{
+		{
+			desc: "Single key",
+			min:  5,
+			max:  5,
+			n:    3,
+			want: []keyRange{{lo: 5, hi: 5, inclusive: true}},
+		},
+		{
+			desc: "Fewer keys than ranges",
+			min:  1,
+			max:  3,
+			n:    10,
+			want: []keyRange{{lo: 1, hi: 2}, {lo: 2, hi: 3}, {lo: 3, hi: 3, inclusive: true}},
+		},
+		{
+			desc: "More keys than ranges",
+			min:  -10,
+			max:  10,
+			n:    3,
+			want: []keyRange{{lo: -10, hi: -3}, {lo: -3, hi: 4}, {lo: 4, hi: 10, inclusive: true}},
+		},
+		{
+			desc: "Whole INT64",
+			min:  math.MinInt64,
+			max:  math.MaxInt64,
+			n:    2,
+			want: []keyRange{{lo: math.MinInt64, hi: 0}, {lo: 0, hi: math.MaxInt64, inclusive: true}},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := splitKeyRange(tt.min, tt.max, tt.n)
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(keyRange{})); diff != "" {
+				t.Errorf("splitKeyRange(%d, %d, %d) mismatch (-want +got):\n%s", tt.min, tt.max, tt.n, diff)
+			}
+		})
+	}
+}
+
+// sampleBackend returns the number of rows in each key range from 0 to 9999 where all keys have a row.
+type sampleBackend struct {
+	fakeBackend
+	empty bool
+}
+
+func (b *sampleBackend) Count(ctx context.Context, stmt spanner.Statement, staleness time.Duration, priority Priority) (int64, error) {
+	switch {
+	case b.empty:
+		return 0, nil
+	case strings.HasPrefix(stmt.SQL, "SELECT MIN("):
+		return 0, nil
+	case strings.HasPrefix(stmt.SQL, "SELECT MAX("):
+		return 9999, nil
+	case strings.Contains(stmt.SQL, " WHERE "):
+		// Every range has 10 keys since 10000 keys are split into 1000 ranges.
+		return 10, nil
+	}
+	return 1, nil
+}
+
+func TestVerifySample(t *testing.T) {
+	for _, tt := range []struct {
+		desc          string
+		empty         bool
+		keyType       string
+		wantRemained  uint64
+		wantEstimated bool
+		wantWarning   bool
+	}{
+		{
+			desc:          "Empty table",
+			empty:         true,
+			keyType:       "INT64",
+			wantRemained:  0,
+			wantEstimated: false,
+		},
+		{
+			desc:          "Rows remain",
+			keyType:       "INT64",
+			wantRemained:  10000,
+			wantEstimated: true,
+		},
+		{
+			desc:          "Key column which cannot be sampled",
+			keyType:       "STRING(36)",
+			wantRemained:  1,
+			wantEstimated: false,
+			wantWarning:   true,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			d := &deleter{tableName: "Singers", backend: &sampleBackend{empty: tt.empty}}
+			var buf bytes.Buffer
+			remained, estimated, err := d.verifySample(context.Background(), []KeyColumn{{Name: "SingerId", SpannerType: tt.keyType}}, 5, rand.New(rand.NewSource(1)), Output{Writer: &buf})
+			if err != nil {
+				t.Fatalf("verifySample() failed: %v", err)
+			}
+			if remained != tt.wantRemained || estimated != tt.wantEstimated {
+				t.Errorf("verifySample() = (%d, %t), but want = (%d, %t)", remained, estimated, tt.wantRemained, tt.wantEstimated)
+			}
+			// Falling back to a full count is warned, since it may take much longer than sampling.
+			if got := strings.Contains(buf.String(), "counted in full"); got != tt.wantWarning {
+				t.Errorf("verifySample() warned = %t, but want = %t: %q", got, tt.wantWarning, buf.String())
+			}
+		})
+	}
+}
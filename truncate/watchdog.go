@@ -0,0 +1,162 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StallAction is what the watchdog does when no progress is made within the stall timeout.
+type StallAction string
+
+const (
+	StallActionWarn  StallAction = "warn"  // Warn of the stall and keep waiting.
+	StallActionDump  StallAction = "dump"  // Warn of the stall and dump the statements in flight.
+	StallActionAbort StallAction = "abort" // Warn of the stall, dump the statements in flight and fail the run.
+)
+
+// Interval at which the watchdog checks the progress.
+var stallCheckInterval = 10 * time.Second
+
+// watchdog detects silent stalls of deletion, e.g. by lock contention or network issues,
+// long before the execution timeout fires.
+type watchdog struct {
+	timeout time.Duration
+	action  StallAction
+	out     Output
+	// idle returns true while no progress is expected, e.g. when the run is paused or outside the execution window.
+	idle func() bool
+
+	mu           sync.Mutex
+	tables       []*table
+	lastProgress uint64
+	lastAt       time.Time
+	nextWarnAt   time.Time
+	stalled      int32
+}
+
+// newWatchdog returns a watchdog which takes the action when no progress is made within timeout.
+func newWatchdog(timeout time.Duration, action StallAction, out Output, idle func() bool, now time.Time) *watchdog {
+	if action == "" {
+		action = StallActionWarn
+	}
+	return &watchdog{timeout: timeout, action: action, out: out, idle: idle, lastAt: now}
+}
+
+// watch adds tables whose progress is checked, e.g. tables added by re-planning. It is a no-op for a nil watchdog.
+func (w *watchdog) watch(tables ...*table) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tables = append(w.tables, tables...)
+}
+
+// progressOf returns a number which increases whenever a chunk or a table is completed.
+// Rows deleted by Partitioned DML are estimated by row counts, so they are regarded as progress too.
+func progressOf(tables []*table) uint64 {
+	var progress uint64
+	for _, t := range tables {
+		progress += t.deleter.deletedRows()
+		if t.deleter.isCompleted() {
+			progress++
+		}
+	}
+	return progress
+}
+
+// check returns how long no progress has been made and the tables in progress if the stall should be reported at now,
+// or 0 otherwise. Once reported, the stall is reported again every timeout while it lasts.
+func (w *watchdog) check(now time.Time) (time.Duration, []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if progress := progressOf(w.tables); progress != w.lastProgress || (w.idle != nil && w.idle() && !deleting(w.tables)) {
+		w.lastProgress, w.lastAt, w.nextWarnAt = progress, now, time.Time{}
+		return 0, nil
+	}
+	stalled := now.Sub(w.lastAt)
+	if stalled < w.timeout || now.Before(w.nextWarnAt) {
+		return 0, nil
+	}
+	w.nextWarnAt = now.Add(w.timeout)
+	return stalled, tablesInProgress(w.tables)
+}
+
+// deleting returns true if rows of any table are being deleted.
+func deleting(tables []*table) bool {
+	for _, t := range tables {
+		if t.deleter.currentStatus() == statusDeleting {
+			return true
+		}
+	}
+	return false
+}
+
+// start checks the progress of the tables periodically, and calls abort if the action is StallActionAbort.
+func (w *watchdog) start(ctx context.Context, workers *workerGroup, abort func()) {
+	workers.spawn("watchdog", func() {
+		ticker := time.NewTicker(stallCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			stalled, inProgress := w.check(time.Now())
+			if stalled == 0 {
+				continue
+			}
+			w.out.warnf("no chunk or table has been completed for %s; tables in progress: [%s]", stalled.Truncate(time.Second), strings.Join(inProgress, ", "))
+			if w.action == StallActionDump || w.action == StallActionAbort {
+				DumpInflightStatements(w.out.writer())
+			}
+			if w.action == StallActionAbort {
+				atomic.StoreInt32(&w.stalled, 1)
+				abort()
+				return
+			}
+		}
+	})
+}
+
+// aborted returns true if the watchdog has aborted the run. A nil watchdog never aborts.
+func (w *watchdog) aborted() bool {
+	return w != nil && atomic.LoadInt32(&w.stalled) == 1
+}
+
+// tablesInProgress returns the tables being deleted with their chunks, e.g. "Singers (chunk 3)".
+func tablesInProgress(tables []*table) []string {
+	var names []string
+	for _, t := range tables {
+		if s := t.deleter.currentStatus(); s != statusDeleting && s != statusCascadeDeleting {
+			continue
+		}
+		if chunk := atomic.LoadInt64(&t.deleter.chunk); chunk > 0 {
+			names = append(names, fmt.Sprintf("%s (chunk %d)", t.tableName, chunk))
+		} else {
+			names = append(names, t.tableName)
+		}
+	}
+	return names
+}
@@ -0,0 +1,110 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	singers := &table{tableName: "Singers", deleter: &deleter{tableName: "Singers", status: statusDeleting, totalRows: 100, remainedRows: 100}}
+	albums := &table{tableName: "Albums", deleter: &deleter{tableName: "Albums", status: statusWaiting}}
+	idle := false
+	w := newWatchdog(10*time.Minute, "", Output{}, func() bool { return idle }, start)
+	w.watch(singers, albums)
+	if w.action != StallActionWarn {
+		t.Errorf("newWatchdog() action = %q, but want = %q", w.action, StallActionWarn)
+	}
+
+	for _, step := range []struct {
+		desc     string
+		at       time.Duration
+		update   func()
+		want     time.Duration
+		wantWhat []string
+	}{
+		{desc: "Progress", at: time.Minute, update: func() { atomic.StoreInt64(&singers.deleter.keysDeleted, 10) }},
+		{desc: "Within the timeout", at: 10 * time.Minute},
+		{desc: "Stalled", at: 11 * time.Minute, want: 10 * time.Minute},
+		{desc: "Reported once per timeout", at: 15 * time.Minute},
+		{
+			desc:     "Reported again",
+			at:       21 * time.Minute,
+			update:   func() { atomic.StoreInt64(&singers.deleter.chunk, 3) },
+			want:     20 * time.Minute,
+			wantWhat: []string{"Singers (chunk 3)"},
+		},
+		{desc: "Completed table", at: 22 * time.Minute, update: func() { singers.deleter.status = statusCompleted }},
+		{desc: "Idle", at: 40 * time.Minute, update: func() { idle = true }},
+		{desc: "Idle is not a stall", at: 50 * time.Minute},
+		{desc: "Stalled after idle", at: 60 * time.Minute, update: func() { idle = false }, want: 10 * time.Minute},
+	} {
+		if step.update != nil {
+			step.update()
+		}
+		got, gotWhat := w.check(start.Add(step.at))
+		if got != step.want {
+			t.Errorf("%s: check() = %s, but want = %s", step.desc, got, step.want)
+		}
+		if step.wantWhat != nil {
+			if diff := cmp.Diff(step.wantWhat, gotWhat); diff != "" {
+				t.Errorf("%s: check() tables in progress mismatch (-want +got):\n%s", step.desc, diff)
+			}
+		}
+	}
+}
+
+func TestWatchdogIdleWhileDeleting(t *testing.T) {
+	start := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	singers := &table{tableName: "Singers", deleter: &deleter{tableName: "Singers", status: statusDeleting}}
+	// Pausing stops starting tables, but deletions already started must still make progress.
+	w := newWatchdog(time.Minute, StallActionAbort, Output{}, func() bool { return true }, start)
+	w.watch(singers)
+	if got, _ := w.check(start.Add(2 * time.Minute)); got != 2*time.Minute {
+		t.Errorf("check() = %s, but want = %s", got, 2*time.Minute)
+	}
+	if w.aborted() {
+		t.Errorf("aborted() = true before the watchdog starts")
+	}
+	var nilWatchdog *watchdog
+	nilWatchdog.watch(singers)
+	if nilWatchdog.aborted() {
+		t.Errorf("aborted() = true for a nil watchdog")
+	}
+}
+
+func TestWatchdogCheckWhileDeleting(t *testing.T) {
+	singers := &table{tableName: "Singers", deleter: &deleter{tableName: "Singers"}}
+	w := newWatchdog(time.Minute, StallActionWarn, Output{}, nil, time.Now())
+	w.watch(singers)
+	// Workers update the deleter while the watchdog checks it, which must not race.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		singers.deleter.markStarted()
+		singers.deleter.markGroupDeleted(10)
+	}()
+	for i := 0; i < 100; i++ {
+		w.check(time.Now())
+	}
+	<-done
+}
@@ -0,0 +1,91 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time window in which deletions are started, e.g. "22:00-05:00 Asia/Tokyo".
+type Window struct {
+	// Start and End are minutes since midnight. The window spans midnight if End is before Start.
+	Start, End int
+	Location   *time.Location
+}
+
+// ParseWindow parses a window in the form of "HH:MM-HH:MM [TIMEZONE]". The local timezone is used if omitted.
+func ParseWindow(s string) (*Window, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid window %q: must be in the form of HH:MM-HH:MM [TIMEZONE]", s)
+	}
+
+	w := &Window{Location: time.Local}
+	if len(fields) == 2 {
+		loc, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", s, err)
+		}
+		w.Location = loc
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid window %q: must be in the form of HH:MM-HH:MM [TIMEZONE]", s)
+	}
+	for i, b := range bounds {
+		t, err := time.Parse("15:04", b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", s, err)
+		}
+		if i == 0 {
+			w.Start = t.Hour()*60 + t.Minute()
+		} else {
+			w.End = t.Hour()*60 + t.Minute()
+		}
+	}
+	if w.Start == w.End {
+		return nil, fmt.Errorf("invalid window %q: start and end must differ", s)
+	}
+	return w, nil
+}
+
+func (w *Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", w.Start/60, w.Start%60, w.End/60, w.End%60, w.Location)
+}
+
+// contains returns true if the given time is inside the window.
+func (w *Window) contains(t time.Time) bool {
+	t = t.In(w.Location)
+	m := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return w.Start <= m && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// nextOpen returns the next time the window opens after the given time.
+func (w *Window) nextOpen(t time.Time) time.Time {
+	t = t.In(w.Location)
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.Start/60, w.Start%60, 0, 0, w.Location)
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
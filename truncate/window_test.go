@@ -0,0 +1,93 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{desc: "With timezone", s: "22:00-05:00 Asia/Tokyo", want: "22:00-05:00 Asia/Tokyo"},
+		{desc: "Without timezone", s: "01:30-02:45", want: "01:30-02:45 Local"},
+		{desc: "Unknown timezone", s: "22:00-05:00 Nowhere/Unknown", wantErr: true},
+		{desc: "Missing end", s: "22:00", wantErr: true},
+		{desc: "Invalid time", s: "25:00-05:00", wantErr: true},
+		{desc: "Empty window", s: "05:00-05:00", wantErr: true},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			w, err := ParseWindow(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseWindow(%q) succeeded, but want error", tt.s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWindow(%q) failed: %v", tt.s, err)
+			}
+			if got := w.String(); got != tt.want {
+				t.Errorf("ParseWindow(%q) = %s, but want = %s", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("timezone database is not available: %v", err)
+	}
+	overnight := &Window{Start: 22 * 60, End: 5 * 60, Location: tokyo}
+	daytime := &Window{Start: 9 * 60, End: 17 * 60, Location: tokyo}
+
+	for _, tt := range []struct {
+		desc   string
+		window *Window
+		t      time.Time
+		want   bool
+	}{
+		{desc: "Overnight, before midnight", window: overnight, t: time.Date(2020, 1, 1, 23, 0, 0, 0, tokyo), want: true},
+		{desc: "Overnight, after midnight", window: overnight, t: time.Date(2020, 1, 1, 4, 59, 0, 0, tokyo), want: true},
+		{desc: "Overnight, at end", window: overnight, t: time.Date(2020, 1, 1, 5, 0, 0, 0, tokyo), want: false},
+		{desc: "Overnight, daytime", window: overnight, t: time.Date(2020, 1, 1, 12, 0, 0, 0, tokyo), want: false},
+		{desc: "Overnight, other timezone", window: overnight, t: time.Date(2020, 1, 1, 14, 0, 0, 0, time.UTC), want: true},
+		{desc: "Daytime, inside", window: daytime, t: time.Date(2020, 1, 1, 9, 0, 0, 0, tokyo), want: true},
+		{desc: "Daytime, outside", window: daytime, t: time.Date(2020, 1, 1, 18, 0, 0, 0, tokyo), want: false},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := tt.window.contains(tt.t); got != tt.want {
+				t.Errorf("contains(%s) = %v, but want = %v", tt.t, got, tt.want)
+			}
+		})
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, tokyo)
+	if got, want := overnight.nextOpen(now), time.Date(2020, 1, 1, 22, 0, 0, 0, tokyo); !got.Equal(want) {
+		t.Errorf("nextOpen(%s) = %s, but want = %s", now, got, want)
+	}
+	now = time.Date(2020, 1, 1, 23, 0, 0, 0, tokyo)
+	if got, want := overnight.nextOpen(now), time.Date(2020, 1, 2, 22, 0, 0, 0, tokyo); !got.Equal(want) {
+		t.Errorf("nextOpen(%s) = %s, but want = %s", now, got, want)
+	}
+}
@@ -0,0 +1,140 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// leakCheckTimeout is how long workers are waited for to exit after a run returns.
+const leakCheckTimeout = 5 * time.Second
+
+// workerGroup accounts for goroutines spawned by a run, such as deletions, row count updaters and progress bars,
+// so that goroutines left behind by the run can be detected. A nil group spawns goroutines without accounting.
+type workerGroup struct {
+	mu     sync.Mutex
+	active map[string]int
+	done   chan struct{}
+
+	// panicErr is the first panic recovered in a worker.
+	panicErr *panicError
+	// onPanic is called with a panic recovered in a worker, if set.
+	onPanic func(*panicError)
+}
+
+func newWorkerGroup() *workerGroup {
+	return &workerGroup{active: map[string]int{}}
+}
+
+// spawn runs f in a new goroutine accounted by the name. A panic in f is recovered and reported to onPanic.
+func (g *workerGroup) spawn(name string, f func()) {
+	if g == nil {
+		go f()
+		return
+	}
+	g.mu.Lock()
+	g.active[name]++
+	g.mu.Unlock()
+	go func() {
+		defer g.exit(name)
+		defer func() {
+			if p := recover(); p != nil {
+				g.recovered(&panicError{worker: name, value: p, stack: debug.Stack()})
+			}
+		}()
+		f()
+	}()
+}
+
+// recovered records the panic and notifies onPanic.
+func (g *workerGroup) recovered(perr *panicError) {
+	g.mu.Lock()
+	if g.panicErr == nil {
+		g.panicErr = perr
+	}
+	onPanic := g.onPanic
+	g.mu.Unlock()
+	if onPanic != nil {
+		onPanic(perr)
+	}
+}
+
+// panicked returns the first panic recovered in a worker, or nil.
+func (g *workerGroup) panicked() *panicError {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.panicErr
+}
+
+// setOnPanic sets the function called with a panic recovered in a worker.
+func (g *workerGroup) setOnPanic(f func(*panicError)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onPanic = f
+}
+
+func (g *workerGroup) exit(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active[name]--; g.active[name] == 0 {
+		delete(g.active, name)
+	}
+	if len(g.active) == 0 && g.done != nil {
+		close(g.done)
+		g.done = nil
+	}
+}
+
+// running returns the names of the workers still running, with the number of them if more than one.
+func (g *workerGroup) running() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var names []string
+	for name, n := range g.active {
+		if n > 1 {
+			name = fmt.Sprintf("%s (%d)", name, n)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// wait waits until all workers exit or the timeout expires, and returns the workers still running.
+func (g *workerGroup) wait(timeout time.Duration) []string {
+	g.mu.Lock()
+	if len(g.active) == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.done == nil {
+		g.done = make(chan struct{})
+	}
+	done := g.done
+	g.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return g.running()
+	}
+}
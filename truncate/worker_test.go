@@ -0,0 +1,61 @@
+//
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package truncate
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWorkerGroup(t *testing.T) {
+	g := newWorkerGroup()
+	stop := make(chan struct{})
+	g.spawn("deletion of Singers", func() {})
+	g.spawn("row count updater of Singers", func() { <-stop })
+	g.spawn("row count updater of Singers", func() { <-stop })
+
+	want := []string{"row count updater of Singers (2)"}
+	if diff := cmp.Diff(want, g.wait(10*time.Millisecond)); diff != "" {
+		t.Errorf("wait() mismatch (-want +got):\n%s", diff)
+	}
+
+	close(stop)
+	if leaked := g.wait(time.Second); leaked != nil {
+		t.Errorf("wait() = %v, but want nil", leaked)
+	}
+	if leaked := g.wait(time.Second); leaked != nil {
+		t.Errorf("wait() after all workers exited = %v, but want nil", leaked)
+	}
+}
+
+func TestRunLeakCheck(t *testing.T) {
+	b := &fakeBackend{
+		tables: []TableInfo{
+			{Name: "Singers"},
+			{Name: "Albums", ParentName: "Singers", OnDeleteAction: "CASCADE"},
+			{Name: "Venues"},
+		},
+		rows: map[string]int64{"Singers": 10, "Albums": 20, "Venues": 30},
+	}
+	if err := Run(context.Background(), "p", "i", "d", WithBackend(b), WithQuiet(true), WithLeakCheck(true), WithOutput(Output{Writer: ioutil.Discard})); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+}